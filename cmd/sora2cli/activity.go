@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	activityLogName = "activity.log"
+	// maxActivityLogBytes is the size at which the log is moved to
+	// activity.log.1 and a new one is started.
+	maxActivityLogBytes = 5 << 20
+)
+
+// activityLevels orders the levels --level filters on.
+var activityLevels = []string{"info", "warn", "error"}
+
+// activityEvent is one line of the activity log, which records what happens
+// to jobs across every command so that unattended batch and queue runs can be
+// followed from another terminal with "sora2cli logs -f".
+type activityEvent struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	JobID   string    `json:"job_id,omitempty"`
+	Source  string    `json:"source,omitempty"`
+	Message string    `json:"message"`
+}
+
+func activityLogPath() (string, error) {
+	dir, err := resolveCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, activityLogName), nil
+}
+
+// logActivity appends event to the activity log. Each event is a single
+// write to a file opened for appending, so concurrent processes do not
+// interleave lines. Failures are ignored: the log is a debugging aid and
+// must never stop a render.
+func logActivity(event activityEvent) {
+	path, err := activityLogPath()
+	if err != nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	logEvent(event)
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return
+	}
+	if info, err := os.Stat(path); err == nil && info.Size() > maxActivityLogBytes {
+		os.Rename(path, path+".1")
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.Write(append(data, '\n'))
+}
+
+// logHistoryChange describes the difference between two states of a history
+// entry as activity events. created is set for entries that did not exist.
+func logHistoryChange(before historyEntry, after *historyEntry, created bool) {
+	event := func(level, format string, args ...any) {
+		logActivity(activityEvent{Level: level, JobID: after.JobID, Source: after.Source, Message: fmt.Sprintf(format, args...)})
+	}
+	if created {
+		if after.Source == "import" {
+			event("info", "imported from the API (%s)", after.Status)
+		} else {
+			event("info", "submitted %s %ds %s: %s", after.Model, after.Seconds, after.Size, truncateText(after.Prompt, 60))
+		}
+	}
+	if before.StartedAt.IsZero() && !after.StartedAt.IsZero() {
+		event("info", "started rendering after %s in the queue", after.StartedAt.Sub(after.CreatedAt).Round(time.Second))
+	}
+	// A job can be rejected at submission, so failures are logged once
+	// their error is known rather than on the status change.
+	switch {
+	case after.Status == "failed":
+		if after.Error != "" && (before.Status != "failed" || before.Error == "") {
+			event("error", "failed: %s", after.Error)
+		}
+	case created || before.Status == after.Status:
+	case after.Status == "cancelled":
+		event("warn", "cancelled")
+	default:
+		event("info", "status %s -> %s", before.Status, after.Status)
+	}
+	if after.OutputPath != "" && (after.OutputPath != before.OutputPath || after.SHA256 != before.SHA256) {
+		event("info", "downloaded to %s (%s)", after.OutputPath, formatBytes(after.OutputBytes))
+	}
+	if before.DeletedAt.IsZero() && !after.DeletedAt.IsZero() {
+		event("info", "remote copy deleted")
+	}
+	if !created && !slices.Equal(before.Tags, after.Tags) {
+		event("info", "tags: %s", dashIfEmpty(strings.Join(after.Tags, ", ")))
+	}
+	if !created && before.Note != after.Note {
+		event("info", "note: %s", dashIfEmpty(after.Note))
+	}
+	if after.Review != nil && after.Review != before.Review {
+		msg := fmt.Sprintf("review: %s by %s", after.Review.Status, after.Review.Reviewer)
+		if after.Review.Comment != "" {
+			msg += ": " + after.Review.Comment
+		}
+		event("info", "%s", msg)
+	}
+}
+
+type activityFilter struct {
+	JobID    string
+	MinLevel int
+}
+
+func activityLevelIndex(level string) int {
+	for i, name := range activityLevels {
+		if name == level {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f activityFilter) match(event activityEvent) bool {
+	if f.JobID != "" && event.JobID != f.JobID {
+		return false
+	}
+	return activityLevelIndex(event.Level) >= f.MinLevel
+}
+
+func printActivity(event activityEvent, jsonOutput bool) {
+	if jsonOutput {
+		emitJSON(event)
+		return
+	}
+	jobID := event.JobID
+	if jobID == "" {
+		jobID = "-"
+	}
+	fmt.Printf("%s %-5s %s [%s] %s\n", formatTimestamp(event.Time), strings.ToUpper(event.Level), jobID, event.Source, event.Message)
+}
+
+// readActivity decodes the complete lines in r that pass filter. It returns
+// how many bytes were consumed, so a trailing partial line is read again once
+// the writer has finished it.
+func readActivity(r io.Reader, filter activityFilter) ([]activityEvent, int64, error) {
+	var (
+		events   []activityEvent
+		consumed int64
+	)
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return events, consumed, nil
+		}
+		if err != nil {
+			return events, consumed, err
+		}
+		consumed += int64(len(line))
+		var event activityEvent
+		if json.Unmarshal(line, &event) != nil {
+			continue
+		}
+		if filter.match(event) {
+			events = append(events, event)
+		}
+	}
+}
+
+// followActivity prints events appended to the log until ctx is done. A log
+// that was rotated or truncated is picked up again from its start.
+func followActivity(ctx context.Context, path string, offset int64, filter activityFilter, jsonOutput bool) error {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	var current os.FileInfo
+	if info, err := os.Stat(path); err == nil {
+		current = info
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if current == nil || !os.SameFile(current, info) || info.Size() < offset {
+			offset = 0
+		}
+		current = info
+		if info.Size() == offset {
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return err
+		}
+		events, consumed, err := readActivity(f, filter)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		offset += consumed
+		for _, event := range events {
+			printActivity(event, jsonOutput)
+		}
+	}
+}
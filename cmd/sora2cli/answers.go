@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// wizardAnswers pre-supplies answers to the wizard's questions (--answers).
+// Questions it leaves out are asked as usual; without a terminal they fall
+// back to the defaults, except those that have none.
+type wizardAnswers struct {
+	// Action picks from the menu: create, remix or list.
+	Action string `yaml:"action"`
+
+	Model    string            `yaml:"model"`
+	Prompt   string            `yaml:"prompt"`
+	Template string            `yaml:"template"`
+	Vars     map[string]string `yaml:"vars"`
+	Enhance  bool              `yaml:"enhance"`
+	Seconds  int               `yaml:"seconds"`
+	Size     string            `yaml:"size"`
+	// VideoID is the video to remix.
+	VideoID string `yaml:"video_id"`
+
+	// Optional questions are pointers so that an empty answer, such as no
+	// references, is told apart from no answer.
+	References  *[]string `yaml:"references"`
+	Destination *string   `yaml:"destination"`
+	Ticket      *string   `yaml:"ticket"`
+	Tags        *[]string `yaml:"tags"`
+
+	// Confirm answers "Proceed?" with yes. Without a terminal it must be set.
+	Confirm bool `yaml:"confirm"`
+}
+
+var unknownAnswerPattern = regexp.MustCompile(`field (\S+) not found in type \S+`)
+
+// splitAnswersArg takes a leading --answers off args for the interactive
+// menu. The create and remix commands take their own --answers flag.
+func splitAnswersArg(args []string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", args, nil
+	}
+	var path string
+	rest := args
+	for _, prefix := range []string{"--answers", "-answers"} {
+		switch {
+		case args[0] == prefix:
+			if len(args) < 2 || args[1] == "" {
+				return "", nil, fmt.Errorf("%s needs a file", prefix)
+			}
+			path, rest = args[1], args[2:]
+		case strings.HasPrefix(args[0], prefix+"="):
+			path, rest = strings.TrimPrefix(args[0], prefix+"="), args[1:]
+		}
+	}
+	if path != "" && len(rest) > 0 {
+		return "", nil, errors.New("--answers before a command is for the menu only; create and remix take it as a flag")
+	}
+	return path, rest, nil
+}
+
+// loadWizardAnswers reads and checks an answers file for flow (create,
+// remix, or "" for the menu). Unknown keys, answers that do not apply to
+// the flow and invalid values are errors, as are, when headless, answers
+// that nobody would be there to give.
+func loadWizardAnswers(path string, cfg *resolvedConfig, flow string, headless bool) (*wizardAnswers, error) {
+	expanded, err := expandPath(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(expanded)
+	if err != nil {
+		return nil, err
+	}
+	var a wizardAnswers
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&a); err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			// "field x not found in type main.wizardAnswers" names Go types.
+			msgs := make([]string, len(typeErr.Errors))
+			for i, msg := range typeErr.Errors {
+				msgs[i] = unknownAnswerPattern.ReplaceAllString(msg, "unknown answer $1")
+			}
+			return nil, fmt.Errorf("%s: %s", path, strings.Join(msgs, "; "))
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := a.validate(cfg, flow, headless); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &a, nil
+}
+
+func (a *wizardAnswers) validate(cfg *resolvedConfig, flow string, headless bool) error {
+	a.Action = strings.ToLower(strings.TrimSpace(a.Action))
+	switch {
+	case flow != "" && a.Action != "" && a.Action != flow:
+		return fmt.Errorf("action: %s does not apply to the %s command", a.Action, flow)
+	case flow != "":
+	case a.Action == "create", a.Action == "remix", a.Action == "list":
+		flow = a.Action
+	case a.Action != "":
+		return fmt.Errorf("action: unknown action %q; use create, remix or list", a.Action)
+	case headless:
+		return errors.New("action: needed to pick from the menu without a terminal")
+	}
+
+	var misplaced []string
+	check := func(key string, set bool, flows ...string) {
+		if !set || flow == "" {
+			return
+		}
+		for _, f := range flows {
+			if f == flow {
+				return
+			}
+		}
+		misplaced = append(misplaced, key)
+	}
+	check("model", a.Model != "", "create")
+	check("template", a.Template != "", "create")
+	check("vars", len(a.Vars) > 0, "create")
+	check("enhance", a.Enhance, "create")
+	check("seconds", a.Seconds != 0, "create")
+	check("size", a.Size != "", "create")
+	check("references", a.References != nil, "create")
+	check("video_id", a.VideoID != "", "remix")
+	check("prompt", a.Prompt != "", "create", "remix")
+	check("destination", a.Destination != nil, "create", "remix")
+	check("ticket", a.Ticket != nil, "create", "remix")
+	check("tags", a.Tags != nil, "create", "remix")
+	check("confirm", a.Confirm, "create", "remix")
+	if len(misplaced) > 0 {
+		return fmt.Errorf("%s: not asked when the action is %s", strings.Join(misplaced, ", "), flow)
+	}
+
+	model, ok := findModelOption(cfg.Defaults.Model)
+	if !ok {
+		model = modelOptions[0]
+	}
+	if a.Model != "" {
+		if model, ok = findModelOption(a.Model); !ok {
+			return fmt.Errorf("model: unknown model %q; supported: %s", a.Model, strings.Join(modelNames(), ", "))
+		}
+		a.Model = model.Name
+	}
+	if a.Seconds != 0 && !isAllowedDuration(a.Seconds) {
+		return fmt.Errorf("seconds: unsupported duration %d; supported: %s", a.Seconds, joinInts(allowedDurations, ", "))
+	}
+	if a.Size != "" {
+		res, ok := findResolution(model, a.Size)
+		if !ok {
+			return fmt.Errorf("size: %s is not supported by %s", a.Size, model.Name)
+		}
+		a.Size = res.Value
+	}
+	if a.Prompt != "" && a.Template != "" {
+		return errors.New("prompt and template cannot both be given")
+	}
+	if len(a.Vars) > 0 && a.Template == "" {
+		return errors.New("vars: needs a template")
+	}
+	if a.Template != "" {
+		if err := a.validateTemplate(cfg, headless); err != nil {
+			return err
+		}
+	}
+	if a.References != nil {
+		for i, ref := range *a.References {
+			path, err := resolveReferencePath(ref)
+			if err != nil {
+				return fmt.Errorf("references: %w", err)
+			}
+			(*a.References)[i] = path
+		}
+	}
+	if a.Destination != nil {
+		dest := *a.Destination
+		if dest == "" {
+			dest = cfg.Defaults.Destination
+		}
+		expanded, err := prepareDestinationDirectory(dest)
+		if err != nil {
+			return fmt.Errorf("destination: %w", err)
+		}
+		*a.Destination = expanded
+	}
+	if a.Ticket != nil {
+		if err := validateTicketKey(*a.Ticket); err != nil {
+			return fmt.Errorf("ticket: %w", err)
+		}
+	}
+	if a.Tags != nil {
+		if err := validateTags(*a.Tags); err != nil {
+			return fmt.Errorf("tags: %w", err)
+		}
+	}
+
+	if headless {
+		var missing []string
+		switch flow {
+		case "create":
+			if a.Prompt == "" && a.Template == "" {
+				missing = append(missing, "prompt (or template)")
+			}
+		case "remix":
+			if a.VideoID == "" {
+				missing = append(missing, "video_id")
+			}
+			if a.Prompt == "" {
+				missing = append(missing, "prompt")
+			}
+		}
+		if (flow == "create" || flow == "remix") && !a.Confirm {
+			missing = append(missing, "confirm: true")
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("without a terminal these answers are needed: %s", strings.Join(missing, ", "))
+		}
+	}
+	return nil
+}
+
+// validateTemplate checks that the template exists and knows every variable
+// given. Headless, the variables without a default must all be given.
+func (a *wizardAnswers) validateTemplate(cfg *resolvedConfig, headless bool) error {
+	dir, err := cfg.templatesDir()
+	if err != nil {
+		return err
+	}
+	t, err := loadTemplate(dir, a.Template)
+	if err != nil {
+		return fmt.Errorf("template: %w", err)
+	}
+	known := make(map[string]bool)
+	var missing []string
+	for _, v := range t.variables() {
+		known[v.Name] = true
+		if _, ok := a.Vars[v.Name]; !ok && !v.HasDefault && headless {
+			missing = append(missing, v.Name)
+		}
+	}
+	var unknown []string
+	for name := range a.Vars {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	if len(unknown) > 0 {
+		return fmt.Errorf("vars: template %s has no variable %s", t.Name, strings.Join(unknown, ", "))
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("vars: template %s needs %s without a terminal", t.Name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// templateVars returns the answered variables in --var form.
+func (a *wizardAnswers) templateVars() []string {
+	names := make([]string, 0, len(a.Vars))
+	for name := range a.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	vars := make([]string, len(names))
+	for i, name := range names {
+		vars[i] = name + "=" + a.Vars[name]
+	}
+	return vars
+}
+
+// applyCreate fills in the create options the flags left open, and marks
+// the optional questions that were answered, even if with nothing.
+func (a *wizardAnswers) applyCreate(o *createOptions, answered map[wizardState]bool) {
+	if o.Model == "" {
+		o.Model = a.Model
+	}
+	if o.Prompt == "" && o.Template == "" {
+		o.Prompt, o.Template = a.Prompt, a.Template
+		if a.Template != "" {
+			o.Vars = a.templateVars()
+		}
+	}
+	o.Enhance = o.Enhance || a.Enhance
+	if o.Seconds == 0 {
+		o.Seconds = a.Seconds
+	}
+	if o.Size == "" {
+		o.Size = a.Size
+	}
+	if a.References != nil && len(o.References) == 0 {
+		o.References = *a.References
+		answered[wizardCreateReferences] = true
+	}
+	if a.Destination != nil && o.Destination == "" {
+		o.Destination = *a.Destination
+	}
+	if a.Ticket != nil && o.Ticket == "" {
+		o.Ticket = *a.Ticket
+		answered[wizardCreateTicket] = true
+	}
+	if a.Tags != nil && len(o.Tags) == 0 {
+		o.Tags = *a.Tags
+		answered[wizardCreateTags] = true
+	}
+	o.AssumeYes = o.AssumeYes || a.Confirm
+}
+
+// applyRemix is applyCreate for a remix.
+func (a *wizardAnswers) applyRemix(o *remixOptions, answered map[wizardState]bool) {
+	if o.VideoID == "" {
+		o.VideoID = a.VideoID
+	}
+	if o.Prompt == "" {
+		o.Prompt = a.Prompt
+	}
+	if a.Destination != nil && o.Destination == "" {
+		o.Destination = *a.Destination
+	}
+	if a.Ticket != nil && o.Ticket == "" {
+		o.Ticket = *a.Ticket
+		answered[wizardRemixTicket] = true
+	}
+	if a.Tags != nil && len(o.Tags) == 0 {
+		o.Tags = *a.Tags
+		answered[wizardRemixTags] = true
+	}
+	o.AssumeYes = o.AssumeYes || a.Confirm
+}
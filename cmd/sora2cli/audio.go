@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// audioFormats are what extract-audio writes: 24-bit PCM WAV, which keeps
+// everything the decoder gives for mixing, or MP3 for a quick listen.
+var audioFormats = []string{"wav", "mp3"}
+
+const defaultMP3Bitrate = "320k"
+
+// extractAudio writes the first audio track of the video at src to dst.
+// sampleRate zero keeps the original rate.
+func extractAudio(ctx context.Context, ffmpeg, format, src, dst string, sampleRate int, bitrate string) error {
+	ffmpeg, err := lookupFFmpeg(ffmpeg, "grade.ffmpeg")
+	if err != nil {
+		return err
+	}
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y", "-i", src, "-map", "0:a:0", "-vn", "-map_metadata", "0"}
+	if format == "mp3" {
+		if bitrate == "" {
+			bitrate = defaultMP3Bitrate
+		}
+		args = append(args, "-c:a", "libmp3lame", "-b:a", bitrate)
+	} else {
+		args = append(args, "-c:a", "pcm_s24le")
+	}
+	if sampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(sampleRate))
+	}
+	tmpPath := strings.TrimSuffix(dst, filepath.Ext(dst)) + ".partial" + filepath.Ext(dst)
+	if err := runTool(ctx, ffmpeg, append(args, tmpPath)...); err != nil {
+		os.Remove(tmpPath)
+		if strings.Contains(err.Error(), "matches no streams") {
+			return errors.New("the video has no audio track")
+		}
+		return err
+	}
+	return os.Rename(tmpPath, dst)
+}
+
+// runExtractAudioCommand saves the soundtrack of downloaded videos, given by
+// job ID or as files, for separate mixing.
+func runExtractAudioCommand(args []string) int {
+	fs := newCommandFlagSet("extract-audio")
+	format := fs.String("format", "wav", "audio format: "+strings.Join(audioFormats, " or "))
+	sampleRate := fs.Int("sample-rate", 0, "resample to this rate in Hz, e.g. 48000 (default: keep the original)")
+	bitrate := fs.String("bitrate", "", "MP3 bitrate (default "+defaultMP3Bitrate+")")
+	out := fs.String("out", "", "directory for the audio files (default: next to each video)")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli extract-audio [--format wav|mp3] [--sample-rate hz] [--out dir] <video-id|file>...")
+		return 2
+	}
+	if *format != "wav" && *format != "mp3" {
+		logError("unknown --format %q; use %s", *format, strings.Join(audioFormats, " or "))
+		return 2
+	}
+	if *sampleRate < 0 || *sampleRate > 384000 {
+		logError("--sample-rate must be between 1 and 384000")
+		return 2
+	}
+	if *bitrate != "" && (*format != "mp3" || !bitratePattern.MatchString(*bitrate)) {
+		logError("--bitrate takes an MP3 bitrate such as 192k")
+		return 2
+	}
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	outDir := *out
+	if outDir != "" {
+		if outDir, err = prepareDestinationDirectory(outDir); err != nil {
+			logError("%v", err)
+			return 1
+		}
+	}
+	state, err := loadHistory()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	failed := 0
+	for _, arg := range fs.Args() {
+		// An argument naming an existing file is a file; anything else is a
+		// job ID looked up in history.
+		src, jobID := arg, ""
+		if info, err := os.Stat(arg); err != nil || info.IsDir() {
+			entry := state.find(arg)
+			if entry == nil || entry.OutputPath == "" {
+				logError("%s: no such file and no downloaded video in history", arg)
+				failed++
+				continue
+			}
+			src, jobID = entry.OutputPath, entry.JobID
+		}
+		dst := strings.TrimSuffix(src, filepath.Ext(src)) + "." + *format
+		if outDir != "" {
+			dst = filepath.Join(outDir, filepath.Base(dst))
+		}
+		if err := extractAudio(ctx, cfg.Grade.FFmpeg, *format, src, dst, *sampleRate, *bitrate); err != nil {
+			logError("%s: %v", arg, err)
+			failed++
+			continue
+		}
+		logInfo("Saved audio to %s", dst)
+		if jobID != "" {
+			recordDerived(jobID, *format, dst)
+		}
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
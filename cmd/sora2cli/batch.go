@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+// jobSpec is one job as given on the command line, in a queue or as a line
+// of batch NDJSON.
+type jobSpec struct {
+	Prompt    string `json:"prompt"`
+	Model     string `json:"model,omitempty"`
+	Seconds   int    `json:"seconds,omitempty"`
+	Size      string `json:"size,omitempty"`
+	Reference string `json:"reference,omitempty"`
+	// References are further reference files, for jobs anchored on
+	// several visuals.
+	References []string `json:"references,omitempty"`
+	Ticket     string   `json:"ticket,omitempty"`
+}
+
+// resolve fills blank fields from defaults and checks the result against the
+// model catalogue. Reference paths are made absolute.
+func (s *jobSpec) resolve(defaults defaultsConfig) (modelOption, error) {
+	s.Prompt = strings.TrimSpace(s.Prompt)
+	if s.Prompt == "" {
+		return modelOption{}, fmt.Errorf("prompt is required")
+	}
+	if s.Model == "" {
+		s.Model = defaults.Model
+	}
+	if s.Seconds == 0 {
+		s.Seconds = defaults.Seconds
+	}
+	if s.Size == "" {
+		s.Size = defaults.Size
+	}
+	if err := validateTicketKey(s.Ticket); err != nil {
+		return modelOption{}, err
+	}
+
+	model, ok := findModelOption(s.Model)
+	if !ok {
+		return modelOption{}, fmt.Errorf("unknown model %q; supported: %s", s.Model, strings.Join(modelNames(), ", "))
+	}
+	s.Model = model.Name
+	if !isAllowedDuration(s.Seconds) {
+		return modelOption{}, fmt.Errorf("unsupported duration %d; supported: %s", s.Seconds, joinInts(allowedDurations, ", "))
+	}
+	if s.Size == "" {
+		s.Size = model.Resolutions[0].Value
+	}
+	if !sizeSupported([]modelOption{model}, s.Size) {
+		return modelOption{}, fmt.Errorf("size %s is not supported by %s", s.Size, model.Name)
+	}
+	if s.Reference != "" {
+		path, err := absReferencePath(s.Reference)
+		if err != nil {
+			return modelOption{}, err
+		}
+		s.Reference = path
+	}
+	for i, ref := range s.References {
+		path, err := absReferencePath(ref)
+		if err != nil {
+			return modelOption{}, err
+		}
+		s.References[i] = path
+	}
+	return model, nil
+}
+
+// createParams is the create request of a resolved spec.
+func (s jobSpec) createParams() sora.CreateParams {
+	return sora.CreateParams{
+		Prompt:          combinePrompts(s.Prompt),
+		Model:           s.Model,
+		Seconds:         strconv.Itoa(s.Seconds),
+		Size:            s.Size,
+		InputReference:  s.Reference,
+		InputReferences: s.References,
+	}
+}
+
+// relativeTo resolves a relative path against dir, leaving blank, absolute
+// and home-relative paths alone.
+func relativeTo(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) || strings.HasPrefix(path, "~") {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+func absReferencePath(ref string) (string, error) {
+	path, err := expandPath(ref)
+	if err == nil {
+		path, err = filepath.Abs(path)
+	}
+	if err == nil {
+		_, err = os.Stat(path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to access reference file: %w", err)
+	}
+	return path, nil
+}
+
+// renderJob submits one job, waits for it and downloads the result, plus any
+// extra outputs, into destination, keeping the history entry up to date under
+// source. onQueued, if set, is called with the job ID once the API has
+// accepted it. A job that a signal interrupts stays pending in history, so
+// recover can finish it, and the error wraps errInterrupted.
+func renderJob(ctx context.Context, client *sora.Client, spec jobSpec, destination string, extras extraOutputs, label, source string, onQueued func(jobID string)) (*sora.Video, string, error) {
+	jobCtx, cancel := context.WithTimeout(ctx, maxWaitDuration)
+	defer cancel()
+
+	params := spec.createParams()
+	params.OnUpload = uploadReporter(label + " ")
+	job, err := client.Create(jobCtx, params)
+	if err != nil && interrupted(ctx) {
+		return nil, "", fmt.Errorf("not submitted: %w", errInterrupted)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("create video job: %w", err)
+	}
+	logInfo("%s queued as %s", label, job.ID)
+	specHash, _ := spec.fingerprint()
+	recordJobHistory(job, source, func(e *historyEntry) {
+		e.Ticket = spec.Ticket
+		e.SpecHash = specHash
+		e.awaitDownload(destination)
+	})
+	defer watchJob(job.ID).unlock()
+	if onQueued != nil {
+		onQueued(job.ID)
+	}
+
+	jobID := job.ID
+	var tracker queueTracker
+	if job, err = client.Wait(jobCtx, jobID, func(job *sora.Video) {
+		logInfo("%s %s: %s", label, job.ID, formatProgress(job))
+		tracker.observe(job)
+	}); err != nil {
+		if interrupted(ctx) {
+			return job, "", fmt.Errorf("job %s left rendering: %w", jobID, errInterrupted)
+		}
+		markHistoryFailed(jobID, err)
+		return job, "", err
+	}
+
+	outputPath, err := extras.outputPath(destination, job, nil)
+	if err != nil {
+		markHistoryFailed(jobID, err)
+		return job, "", err
+	}
+	if err := client.DownloadFile(jobCtx, job.ID, outputPath); err != nil {
+		if interrupted(ctx) {
+			return job, "", fmt.Errorf("download of %s stopped: %w", jobID, errInterrupted)
+		}
+		err = fmt.Errorf("download video: %w", err)
+		markHistoryFailed(jobID, err)
+		return job, "", err
+	}
+	logInfo("%s saved to %s", label, outputPath)
+	downloadExtras(jobCtx, client, job, outputPath, extras)
+	markHistoryCompleted(job, source, outputPath)
+	return job, outputPath, nil
+}
+
+// resolveOutputDir expands dir, defaulting to the working directory, and
+// makes sure it exists.
+func resolveOutputDir(dir string) (string, error) {
+	if dir == "" {
+		return os.Getwd()
+	}
+	dir, err := expandPath(dir)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create destination directory: %w", err)
+	}
+	return dir, nil
+}
+
+type batchOptions struct {
+	Concurrency int
+	// Budget books every job against the spend caps and --budget.
+	Budget      *budgetGuard
+	Destination string
+	// Extras are the images and metadata saved next to each MP4.
+	Extras extraOutputs
+	// OffPeak, if set, holds every submission until the window is open.
+	OffPeak *offPeakWindow
+	// Status, if set, checks the status page before every submission.
+	Status *statusGate
+	// BaseDir resolves relative reference paths, so a prompts file can
+	// refer to images next to it.
+	BaseDir string
+}
+
+// batchLineResult is the outcome of one input line. It is printed per job
+// with --json and collected for the report at the end.
+type batchLineResult struct {
+	Line          int     `json:"line"`
+	Status        string  `json:"status"`
+	JobID         string  `json:"job_id,omitempty"`
+	OutputPath    string  `json:"output_path,omitempty"`
+	EstimatedCost float64 `json:"estimated_cost,omitempty"`
+	Error         string  `json:"error,omitempty"`
+}
+
+// runBatch reads job specs from r, one JSON object per line, and renders them
+// with at most opts.Concurrency jobs in flight. The next line is only read
+// once a worker is free, so a producer writing into a pipe is slowed down to
+// the rate the API sustains. Specs that would exceed the budget are skipped,
+// and reading stops once not even the cheapest job fits any more. Failed jobs
+// give their estimate back to the budget.
+func runBatch(ctx context.Context, client *sora.Client, cfg *resolvedConfig, r io.Reader, opts batchOptions) (runResult, []batchLineResult, error) {
+	var (
+		result runResult
+		lines  []batchLineResult
+	)
+
+	destination, err := resolveOutputDir(opts.Destination)
+	if err != nil {
+		return result, nil, err
+	}
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, opts.Concurrency)
+	)
+	record := func(line batchLineResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch line.Status {
+		case "completed":
+			result.Completed++
+			result.EstimatedCost += line.EstimatedCost
+			if info, err := os.Stat(line.OutputPath); err == nil {
+				result.OutputBytes += info.Size()
+			}
+		case "failed":
+			result.Failed++
+		case "skipped":
+			result.Skipped++
+		}
+		lines = append(lines, line)
+		emitJSON(line)
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return result, lines, ctx.Err()
+		}
+		if ctx.Err() != nil {
+			<-sem
+			wg.Wait()
+			return result, lines, ctx.Err()
+		}
+		if !scanner.Scan() {
+			<-sem
+			break
+		}
+		lineNo++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			<-sem
+			continue
+		}
+		label := fmt.Sprintf("[batch #%d]", lineNo)
+
+		var spec jobSpec
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&spec)
+		var model modelOption
+		if err == nil {
+			if opts.BaseDir != "" {
+				spec.Reference = relativeTo(opts.BaseDir, spec.Reference)
+				for i, ref := range spec.References {
+					spec.References[i] = relativeTo(opts.BaseDir, ref)
+				}
+			}
+			model, err = spec.resolve(cfg.Defaults)
+		}
+		if err != nil {
+			<-sem
+			logError("%s invalid: %v", label, err)
+			record(batchLineResult{Line: lineNo, Status: "failed", Error: err.Error()})
+			continue
+		}
+
+		cost := math.Round(model.RatePerSecond*float64(spec.Seconds)*100) / 100
+		if err := opts.Budget.reserve(label+" ", cost); err != nil {
+			<-sem
+			logWarn("%s skipped: %v", label, err)
+			record(batchLineResult{Line: lineNo, Status: "skipped", EstimatedCost: cost, Error: "over budget"})
+			if opts.Budget.exhausted() {
+				logWarn("budget exhausted; no longer reading input")
+				break
+			}
+			continue
+		}
+
+		if opts.OffPeak != nil {
+			if err := waitForOffPeak(ctx, *opts.OffPeak, label); err != nil {
+				<-sem
+				wg.Wait()
+				return result, lines, err
+			}
+		}
+		if err := opts.Status.wait(ctx, label); err != nil {
+			<-sem
+			wg.Wait()
+			return result, lines, err
+		}
+		wg.Add(1)
+		go func(lineNo int, spec jobSpec, cost float64, label string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer handleCrash()
+			line := batchLineResult{Line: lineNo, EstimatedCost: cost}
+			event := ticketEvent{
+				Ticket:        spec.Ticket,
+				Prompt:        spec.Prompt,
+				Model:         spec.Model,
+				Seconds:       spec.Seconds,
+				EstimatedCost: cost,
+			}
+			job, outputPath, err := renderJob(ctx, client, spec, destination, opts.Extras, label, "batch", func(jobID string) {
+				line.JobID = jobID
+			})
+			event.JobID = line.JobID
+			if errors.Is(err, errInterrupted) {
+				if line.JobID == "" {
+					opts.Budget.release(cost)
+				}
+				logWarn("%s interrupted: %v", label, err)
+				line.Status = "interrupted"
+				line.Error = err.Error()
+				record(line)
+				return
+			}
+			if err != nil {
+				if unbilled(line.JobID, job) {
+					opts.Budget.release(cost)
+				}
+				logError("%s failed: %v", label, err)
+				line.Status = "failed"
+				line.Error = err.Error()
+				event.Status = "failed"
+				event.Error = err.Error()
+				reportTicketOrWarn(cfg.Tickets, event)
+				notifyJobOrWarn(client, cfg.Notifications, event)
+				record(line)
+				return
+			}
+			line.Status = "completed"
+			line.OutputPath = outputPath
+			event.Status = "completed"
+			event.OutputPath = outputPath
+			reportTicketOrWarn(cfg.Tickets, event)
+			notifyJobOrWarn(client, cfg.Notifications, event)
+			asset := assetRecordFromJob(job, outputPath)
+			asset.Prompt = spec.Prompt
+			asset.Ticket = spec.Ticket
+			exportAssetOrWarn(cfg.DAM, cfg.Review, asset)
+			record(line)
+		}(lineNo, spec, cost, label)
+	}
+	wg.Wait()
+	sort.Slice(lines, func(i, j int) bool { return lines[i].Line < lines[j].Line })
+	if err := scanner.Err(); err != nil {
+		return result, lines, fmt.Errorf("read input: %w", err)
+	}
+	return result, lines, nil
+}
+
+// printBatchReport lists every input line with its outcome. The totals are
+// left to the run summary printed after it.
+func printBatchReport(w io.Writer, lines []batchLineResult) {
+	if len(lines) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LINE\tSTATUS\tJOB\tEST. COST\tDETAIL")
+	for _, line := range lines {
+		detail := line.OutputPath
+		if line.Error != "" {
+			detail = line.Error
+		}
+		jobID := line.JobID
+		if jobID == "" {
+			jobID = "-"
+		}
+		cost := "-"
+		if line.EstimatedCost > 0 {
+			cost = fmt.Sprintf("$%.2f", line.EstimatedCost)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", line.Line, line.Status, jobID, cost, detail)
+	}
+	tw.Flush()
+}
+
+func cheapestJobCost() float64 {
+	cheapest := 0.0
+	for i, model := range modelOptions {
+		cost := model.RatePerSecond * float64(allowedDurations[0])
+		if i == 0 || cost < cheapest {
+			cheapest = cost
+		}
+	}
+	return cheapest
+}
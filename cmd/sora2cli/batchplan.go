@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// runBatchPlanCommand shows what "batch apply" would do with a prompts file
+// without submitting anything.
+func runBatchPlanCommand(args []string) int {
+	fs := newCommandFlagSet("batch plan")
+	file := fs.String("file", "", "JSONL prompts file to compare with history")
+	jsonOutput := fs.Bool("json", false, "print the plan as JSON")
+	registerFormatFlag(fs, jsonOutput)
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if *file == "" || fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli batch plan --file prompts.jsonl [--json]")
+		return 2
+	}
+	if *jsonOutput {
+		enableJSONOutput()
+	}
+
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	path, err := expandPath(*file)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	defer f.Close()
+	plan, _, err := planBatch(f, cfg.Defaults, filepath.Dir(path))
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+
+	create, cost := plan.count(planCreate)
+	done, _ := plan.count(planDone)
+	running, _ := plan.count(planRunning)
+	emitJSON(map[string]any{
+		"lines":          plan.Lines,
+		"create":         create,
+		"estimated_cost": cost,
+		"done":           done,
+		"running":        running,
+	})
+	printBatchPlan(os.Stdout, plan)
+	if invalid, _ := plan.count(planInvalid); invalid > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Plan actions, from "batch plan". Only planCreate lines are rendered by
+// "batch apply".
+const (
+	planCreate    = "create"
+	planDone      = "done"
+	planRunning   = "running"
+	planDuplicate = "duplicate"
+	planInvalid   = "invalid"
+)
+
+// fingerprint identifies what a resolved spec renders: the prompt, model,
+// duration, size and the content of its references. The ticket is left out,
+// since it does not change the video. History entries carry the fingerprint
+// of the spec that created them, which is how plan recognises finished work.
+func (s jobSpec) fingerprint() (string, error) {
+	key := struct {
+		Prompt     string   `json:"prompt"`
+		Model      string   `json:"model"`
+		Seconds    int      `json:"seconds"`
+		Size       string   `json:"size"`
+		References []string `json:"references,omitempty"`
+	}{Prompt: combinePrompts(s.Prompt), Model: s.Model, Seconds: s.Seconds, Size: s.Size}
+	for _, path := range append([]string{s.Reference}, s.References...) {
+		if path == "" {
+			continue
+		}
+		_, sum, err := fileDigest(path)
+		if err != nil {
+			return "", err
+		}
+		key.References = append(key.References, sum)
+	}
+	data, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// batchPlanLine is what apply would do with one line of a prompts file.
+type batchPlanLine struct {
+	Line          int     `json:"line"`
+	Action        string  `json:"action"`
+	JobID         string  `json:"job_id,omitempty"`
+	EstimatedCost float64 `json:"estimated_cost,omitempty"`
+	Detail        string  `json:"detail,omitempty"`
+	Fingerprint   string  `json:"fingerprint,omitempty"`
+	// Spec is the resolved spec of a valid line.
+	Spec jobSpec `json:"-"`
+}
+
+type batchPlan struct {
+	Lines []batchPlanLine
+	// Text holds the original line of every planned spec, by line number.
+	Text map[int]string
+}
+
+func (p batchPlan) count(action string) (int, float64) {
+	n, cost := 0, 0.0
+	for _, line := range p.Lines {
+		if line.Action == action {
+			n++
+			cost += line.EstimatedCost
+		}
+	}
+	return n, cost
+}
+
+// specs returns the resolved specs of the lines planned for action, or of
+// every valid line if action is empty.
+func (p batchPlan) specs(action string) []jobSpec {
+	var specs []jobSpec
+	for _, line := range p.Lines {
+		if line.Action == action || (action == "" && line.Action != planInvalid) {
+			specs = append(specs, line.Spec)
+		}
+	}
+	return specs
+}
+
+// applyInput returns the prompts file with every line that is not to be
+// created blanked out, so batch reports keep the original line numbers.
+func (p batchPlan) applyInput(total int) string {
+	create := make(map[int]bool)
+	for _, line := range p.Lines {
+		if line.Action == planCreate {
+			create[line.Line] = true
+		}
+	}
+	var b strings.Builder
+	for n := 1; n <= total; n++ {
+		if create[n] {
+			b.WriteString(p.Text[n])
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// planBatch compares every spec in r with local history. A spec whose
+// fingerprint matches a completed job is done and one matching a job still
+// rendering is running; everything else, including specs whose earlier
+// attempts failed, is to be created. It returns the plan and the number of
+// lines read.
+func planBatch(r io.Reader, defaults defaultsConfig, baseDir string) (batchPlan, int, error) {
+	plan := batchPlan{Text: make(map[int]string)}
+	state, err := loadHistory()
+	if err != nil {
+		return plan, 0, fmt.Errorf("read history: %w", err)
+	}
+	latest := make(map[string]*historyEntry)
+	for _, entry := range state.Entries {
+		if entry.SpecHash == "" {
+			continue
+		}
+		if prev, ok := latest[entry.SpecHash]; !ok || planRank(entry) >= planRank(prev) {
+			latest[entry.SpecHash] = entry
+		}
+	}
+
+	seen := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		plan.Text[lineNo] = text
+		line := batchPlanLine{Line: lineNo}
+
+		var spec jobSpec
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&spec)
+		var model modelOption
+		if err == nil {
+			if baseDir != "" {
+				spec.Reference = relativeTo(baseDir, spec.Reference)
+				for i, ref := range spec.References {
+					spec.References[i] = relativeTo(baseDir, ref)
+				}
+			}
+			model, err = spec.resolve(defaults)
+		}
+		if err == nil {
+			line.Fingerprint, err = spec.fingerprint()
+		}
+		if err != nil {
+			line.Action = planInvalid
+			line.Detail = err.Error()
+			plan.Lines = append(plan.Lines, line)
+			continue
+		}
+		line.Spec = spec
+		line.EstimatedCost = math.Round(model.RatePerSecond*float64(spec.Seconds)*100) / 100
+
+		entry := latest[line.Fingerprint]
+		switch first, dup := seen[line.Fingerprint]; {
+		case dup:
+			line.Action = planDuplicate
+			line.Detail = fmt.Sprintf("same spec as line %d", first)
+		case entry != nil && entry.Status == "completed":
+			line.Action = planDone
+			line.JobID = entry.JobID
+			line.Detail = entry.OutputPath
+			if _, statErr := os.Stat(entry.OutputPath); entry.OutputPath != "" && statErr != nil {
+				line.Detail = "output missing: " + entry.OutputPath
+			}
+		case entry != nil && !historyStatusFinal(entry.Status):
+			line.Action = planRunning
+			line.JobID = entry.JobID
+			line.Detail = entry.Status
+		default:
+			line.Action = planCreate
+			if entry != nil {
+				line.Detail = fmt.Sprintf("previous attempt %s %s", entry.JobID, entry.Status)
+			}
+		}
+		seen[line.Fingerprint] = lineNo
+		plan.Lines = append(plan.Lines, line)
+	}
+	return plan, lineNo, scanner.Err()
+}
+
+// planRank orders history entries for the same spec: a completed job beats
+// one still rendering, which beats a failed one. Ties go to the later entry.
+func planRank(e *historyEntry) int {
+	switch {
+	case e.Status == "completed":
+		return 2
+	case !historyStatusFinal(e.Status):
+		return 1
+	}
+	return 0
+}
+
+func historyStatusFinal(status string) bool {
+	switch status {
+	case "completed", "failed", "cancelled", "canceled", "rejected", "expired":
+		return true
+	}
+	return false
+}
+
+func printBatchPlan(w io.Writer, plan batchPlan) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LINE\tACTION\tJOB\tEST. COST\tDETAIL")
+	for _, line := range plan.Lines {
+		jobID := line.JobID
+		if jobID == "" {
+			jobID = "-"
+		}
+		cost := "-"
+		if line.EstimatedCost > 0 {
+			cost = fmt.Sprintf("$%.2f", line.EstimatedCost)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", line.Line, line.Action, jobID, cost, line.Detail)
+	}
+	tw.Flush()
+
+	create, cost := plan.count(planCreate)
+	done, _ := plan.count(planDone)
+	running, _ := plan.count(planRunning)
+	fmt.Fprintf(w, "\nPlan: %d to create (est. $%.2f), %d already done, %d still rendering", create, cost, done, running)
+	if dup, _ := plan.count(planDuplicate); dup > 0 {
+		fmt.Fprintf(w, ", %d duplicate", dup)
+	}
+	if invalid, _ := plan.count(planInvalid); invalid > 0 {
+		fmt.Fprintf(w, ", %d invalid", invalid)
+	}
+	fmt.Fprintln(w, ".")
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+// budgetConfig caps the estimated spend. Spend is what history records for
+// jobs that have not failed or been cancelled, so it follows the estimates
+// rather than the invoice.
+type budgetConfig struct {
+	// Monthly caps the spend of the calendar month in USD; zero means no
+	// cap.
+	// SORA_BUDGET_MONTHLY is read too.
+	Monthly float64 `yaml:"monthly,omitempty" env:"SORA2_BUDGET_MONTHLY"`
+	// PerRun caps the spend of one command: a create, a batch or a queue
+	// run.
+	PerRun float64 `yaml:"per_run,omitempty" env:"SORA2_BUDGET_PER_RUN"`
+	// OnExceed is refuse (the default), which skips a job that would go
+	// over a cap, or warn, which submits it with a warning.
+	OnExceed string `yaml:"on_exceed,omitempty" env:"SORA2_BUDGET_ON_EXCEED"`
+}
+
+const (
+	budgetRefuse = "refuse"
+	budgetWarn   = "warn"
+)
+
+func validateBudgetConfig(b budgetConfig) []configIssue {
+	var issues []configIssue
+	if b.Monthly < 0 {
+		issues = append(issues, configIssue{Key: "budget.monthly", Message: "must not be negative"})
+	}
+	if b.PerRun < 0 {
+		issues = append(issues, configIssue{Key: "budget.per_run", Message: "must not be negative"})
+	}
+	switch b.OnExceed {
+	case "", budgetRefuse, budgetWarn:
+	default:
+		issues = append(issues, configIssue{Key: "budget.on_exceed", Message: fmt.Sprintf("unknown value %q; use refuse or warn", b.OnExceed)})
+	}
+	return issues
+}
+
+// monthlySpend adds up the estimated cost of the jobs history records for
+// the calendar month of now.
+func monthlySpend(now time.Time) (float64, error) {
+	state, err := loadHistory()
+	if err != nil {
+		return 0, err
+	}
+	year, month, _ := now.Date()
+	var spent float64
+	for _, entry := range state.Entries {
+		created := entry.CreatedAt.In(now.Location())
+		if created.Year() != year || created.Month() != month {
+			continue
+		}
+		if countsAsSpend(entry.Status) {
+			spent += entryCost(entry)
+		}
+	}
+	return spent, nil
+}
+
+// budgetGuard books the estimated cost of each job of one run against the
+// configured caps and an optional run limit (--budget). It is safe for
+// concurrent workers.
+type budgetGuard struct {
+	mu  sync.Mutex
+	cfg budgetConfig
+	// limit is the --budget of the run, which always refuses.
+	limit float64
+	// month is what was spent this month before the run started.
+	month float64
+	// run is what the run has booked so far.
+	run float64
+	// session is the active generation session, if any, and sessionSpent
+	// what it had spent before the run started.
+	session      *generationSession
+	sessionSpent float64
+}
+
+func newBudgetGuard(cfg budgetConfig, limit float64) *budgetGuard {
+	g := &budgetGuard{cfg: cfg, limit: limit}
+	if cfg.Monthly > 0 {
+		spent, err := monthlySpend(time.Now())
+		if err != nil {
+			logWarn("unable to read history for the monthly budget: %v", err)
+		}
+		g.month = spent
+	}
+	session, err := loadSession()
+	if err != nil {
+		logWarn("unable to read the active session: %v", err)
+	}
+	if session != nil {
+		g.session = session
+		if g.sessionSpent, err = session.spent(); err != nil {
+			logWarn("unable to read history for the session budget: %v", err)
+		}
+	}
+	return g
+}
+
+// reserve books cost, or returns why it would exceed a cap. A configured cap
+// set to warn only prints a warning, prefixed with label, and books anyway.
+func (g *budgetGuard) reserve(label string, cost float64) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.limit > 0 && g.run+cost > g.limit+1e-9 {
+		return fmt.Errorf("$%.2f would exceed the $%.2f budget ($%.2f committed)", cost, g.limit, g.run)
+	}
+	if err := g.overSession(cost); err != nil {
+		return err
+	}
+	if reason := g.overCap(cost); reason != "" {
+		if g.cfg.OnExceed != budgetWarn {
+			return errors.New(reason)
+		}
+		logWarn("%s%s", label, reason)
+	}
+	g.run += cost
+	return nil
+}
+
+// reserveBudget books cost for a create or remix. If it would exceed a cap
+// the reason is reported and errReported returned.
+func reserveBudget(g *budgetGuard, cost float64) error {
+	if err := g.reserve("", cost); err != nil {
+		err = fmt.Errorf("over budget: %w", err)
+		logError("%v", err)
+		emitJSONError(err, "")
+		return errReported
+	}
+	return nil
+}
+
+// overSession says why cost does not fit the active session, which always
+// refuses.
+func (g *budgetGuard) overSession(cost float64) error {
+	s := g.session
+	if s == nil {
+		return nil
+	}
+	if s.expired(time.Now()) {
+		return fmt.Errorf("session %s ran out of time at %s; run 'sora2cli session end' for the summary", s.Name, formatTimestamp(s.Until))
+	}
+	if s.Budget > 0 && g.sessionSpent+g.run+cost > s.Budget+1e-9 {
+		return fmt.Errorf("$%.2f would exceed the $%.2f budget of session %s ($%.2f spent)", cost, s.Budget, s.Name, g.sessionSpent+g.run)
+	}
+	return nil
+}
+
+func (g *budgetGuard) overCap(cost float64) string {
+	if g.cfg.PerRun > 0 && g.run+cost > g.cfg.PerRun+1e-9 {
+		return fmt.Sprintf("$%.2f would exceed the per-run budget of $%.2f ($%.2f committed)", cost, g.cfg.PerRun, g.run)
+	}
+	if g.cfg.Monthly > 0 && g.month+g.run+cost > g.cfg.Monthly+1e-9 {
+		return fmt.Sprintf("$%.2f would exceed the monthly budget of $%.2f ($%.2f spent this month)", cost, g.cfg.Monthly, g.month+g.run)
+	}
+	return ""
+}
+
+// release gives the estimate of a failed job back.
+func (g *budgetGuard) release(cost float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.run -= cost
+}
+
+// unbilled reports whether a job that ended in an error costs nothing: it was
+// never submitted, or the server failed or cancelled it. A job that rendered
+// and then failed to download is paid for.
+func unbilled(jobID string, job *sora.Video) bool {
+	return jobID == "" || job != nil && !countsAsSpend(job.Status)
+}
+
+// exhausted reports whether not even the cheapest job fits any more under a
+// cap that refuses.
+func (g *budgetGuard) exhausted() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	cheapest := cheapestJobCost() - 1e-9
+	if g.limit > 0 && g.limit-g.run < cheapest {
+		return true
+	}
+	if s := g.session; s != nil && (s.expired(time.Now()) || s.Budget > 0 && s.Budget-g.sessionSpent-g.run < cheapest) {
+		return true
+	}
+	if g.cfg.OnExceed == budgetWarn {
+		return false
+	}
+	return (g.cfg.PerRun > 0 && g.cfg.PerRun-g.run < cheapest) ||
+		(g.cfg.Monthly > 0 && g.cfg.Monthly-g.month-g.run < cheapest)
+}
+
+// status describes what is left of the configured caps, or "" without any.
+func (g *budgetGuard) status() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var parts []string
+	if g.cfg.Monthly > 0 {
+		parts = append(parts, fmt.Sprintf("$%.2f of $%.2f left this month", max(g.cfg.Monthly-g.month-g.run, 0), g.cfg.Monthly))
+	}
+	if g.cfg.PerRun > 0 {
+		parts = append(parts, fmt.Sprintf("$%.2f of $%.2f left for this run", max(g.cfg.PerRun-g.run, 0), g.cfg.PerRun))
+	}
+	if s := g.session; s != nil && s.Budget > 0 {
+		parts = append(parts, fmt.Sprintf("$%.2f of $%.2f left in session %s", max(s.Budget-g.sessionSpent-g.run, 0), s.Budget, s.Name))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "Budget: " + strings.Join(parts, ", ") + "."
+}
+
+// historyCost is the estimated cost history records for a job, or -1.
+func historyCost(jobID string) float64 {
+	state, err := loadHistory()
+	if err != nil {
+		return -1
+	}
+	if entry := state.find(jobID); entry != nil && entry.EstimatedCost > 0 {
+		return entry.EstimatedCost
+	}
+	return -1
+}
+
+// remixCost estimates a remix, which renders with the model and duration of
+// its source: from history when it records the source, otherwise from the
+// API. It returns -1 when neither knows the source.
+func remixCost(client *sora.Client, videoID string) float64 {
+	if cost := historyCost(videoID); cost >= 0 {
+		return cost
+	}
+	if state, err := loadHistory(); err == nil {
+		if entry := state.find(videoID); entry != nil && entry.Model != "" && entry.Seconds > 0 {
+			return jobCostEstimate(entry.Model, entry.Seconds)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	video, err := client.Get(ctx, videoID)
+	if err != nil {
+		logDebug("unable to look up %s for its cost: %v", videoID, err)
+		return -1
+	}
+	seconds, err := strconv.Atoi(video.Seconds)
+	if err != nil {
+		return -1
+	}
+	return jobCostEstimate(video.Model, seconds)
+}
+
+// maxRenderCost is the cost of the dearest render there is, which a job of
+// unknown cost is booked at so it cannot slip past a cap.
+func maxRenderCost() float64 {
+	var dearest float64
+	for _, model := range modelOptions {
+		dearest = max(dearest, model.RatePerSecond*float64(slices.Max(allowedDurations)))
+	}
+	return dearest
+}
+
+type budgetReport struct {
+	Month     string   `json:"month"`
+	Spent     float64  `json:"spent"`
+	Monthly   float64  `json:"monthly,omitempty"`
+	Remaining *float64 `json:"remaining,omitempty"`
+	PerRun    float64  `json:"per_run,omitempty"`
+	OnExceed  string   `json:"on_exceed"`
+}
+
+// runBudgetCommand shows this month's estimated spend against the caps.
+func runBudgetCommand(args []string) int {
+	fs := newCommandFlagSet("budget")
+	jsonOutput := fs.Bool("json", false, "print the report as JSON")
+	registerFormatFlag(fs, jsonOutput)
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		logError("unexpected argument %q", fs.Arg(0))
+		return 2
+	}
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	now := time.Now()
+	spent, err := monthlySpend(now)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	report := budgetReport{Month: now.Format("2006-01"), Spent: spent, Monthly: cfg.Budget.Monthly, PerRun: cfg.Budget.PerRun, OnExceed: cfg.Budget.OnExceed}
+	if report.OnExceed == "" {
+		report.OnExceed = budgetRefuse
+	}
+	if report.Monthly > 0 {
+		remaining := max(report.Monthly-spent, 0)
+		report.Remaining = &remaining
+	}
+	if *jsonOutput {
+		enableJSONOutput()
+		emitJSON(report)
+		return 0
+	}
+
+	fmt.Printf("Spent in %s: $%.2f (estimated)\n", now.Format("January 2006"), spent)
+	if report.Monthly > 0 {
+		fmt.Printf("Monthly budget: $%.2f, $%.2f left\n", report.Monthly, *report.Remaining)
+	} else {
+		fmt.Println("Monthly budget: none")
+	}
+	if report.PerRun > 0 {
+		fmt.Printf("Per-run budget: $%.2f\n", report.PerRun)
+	} else {
+		fmt.Println("Per-run budget: none")
+	}
+	if report.Monthly > 0 || report.PerRun > 0 {
+		fmt.Printf("Over budget: %s\n", report.OnExceed)
+	}
+	return 0
+}
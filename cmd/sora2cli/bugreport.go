@@ -0,0 +1,252 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+const (
+	lastFailedRequestName = "last-failed-request.json"
+	// bugReportLogLines is how much of the activity log a bug report
+	// includes by default.
+	bugReportLogLines = 200
+)
+
+// failedRequest is what is kept of the last request the API rejected, for
+// bug reports. Bodies are left out, as they hold prompts and images.
+type failedRequest struct {
+	Time       time.Time `json:"time"`
+	Command    string    `json:"command"`
+	BaseURL    string    `json:"base_url"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	StatusCode int       `json:"status_code"`
+	Message    string    `json:"message"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Attempts   int       `json:"attempts"`
+}
+
+// recordFailedRequest is the API client's OnError hook. Like the activity
+// log, it never gets in the way of the command.
+func recordFailedRequest(baseURL string) func(*sora.APIError) {
+	return func(apiErr *sora.APIError) {
+		dir, err := resolveCacheDir()
+		if err != nil {
+			return
+		}
+		command := ""
+		if len(os.Args) > 1 {
+			command = os.Args[1]
+		}
+		data, err := json.MarshalIndent(failedRequest{
+			Time:       time.Now().UTC(),
+			Command:    command,
+			BaseURL:    baseURL,
+			Method:     apiErr.Method,
+			Path:       apiErr.Path,
+			StatusCode: apiErr.StatusCode,
+			Message:    apiErr.Message,
+			RequestID:  apiErr.RequestID,
+			Attempts:   apiErr.Attempts,
+		}, "", "  ")
+		if err != nil || os.MkdirAll(dir, 0o700) != nil {
+			return
+		}
+		os.WriteFile(filepath.Join(dir, lastFailedRequestName), append(data, '\n'), 0o600)
+	}
+}
+
+// bugReportRedactor strips what must not leave the machine: every secret in
+// the configuration, anything that looks like an OpenAI key, and the home
+// directory.
+type bugReportRedactor struct {
+	home string
+}
+
+func newBugReportRedactor(cfg *resolvedConfig) bugReportRedactor {
+	rememberConfigSecrets(cfg.config)
+	home, _ := os.UserHomeDir()
+	return bugReportRedactor{home: home}
+}
+
+func (r bugReportRedactor) redact(text string) string {
+	text = redactCrashText(text)
+	text = importedKeyPattern.ReplaceAllString(text, "****")
+	if len(r.home) > 1 {
+		text = strings.ReplaceAll(text, r.home, "~")
+	}
+	return text
+}
+
+// bugReportSummary is report.txt: where the CLI runs and where it keeps its
+// files.
+func bugReportSummary(cfg *resolvedConfig, configPath string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "sora2cli bug report\n\n")
+	fmt.Fprintf(&b, "Time:      %s\n", time.Now().UTC().Format(time.RFC3339))
+	info := readBuildInfo()
+	fmt.Fprintf(&b, "Version:   %s (commit %s, built %s)\n", info.Version, firstNonEmpty(info.Commit, "unknown"), firstNonEmpty(info.BuildDate, "unknown"))
+	fmt.Fprintf(&b, "Go:        %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&b, "Container: %t\n", runningInContainer())
+	fmt.Fprintf(&b, "Terminal:  %t (TERM=%s)\n", stdinIsTerminal(), os.Getenv("TERM"))
+	fmt.Fprintf(&b, "Config:    %s\n", configPath)
+	if cfg.ProjectPath != "" {
+		fmt.Fprintf(&b, "Project:   %s\n", cfg.ProjectPath)
+	}
+	if dir, err := resolveDataDir(); err == nil {
+		fmt.Fprintf(&b, "Data:      %s\n", dir)
+	}
+	if dir, err := resolveCacheDir(); err == nil {
+		fmt.Fprintf(&b, "Cache:     %s\n", dir)
+	}
+	if state, err := loadHistory(); err != nil {
+		fmt.Fprintf(&b, "History:   unreadable: %v\n", err)
+	} else {
+		counts := make(map[string]int)
+		for _, entry := range state.Entries {
+			counts[entry.Status]++
+		}
+		statuses := make([]string, 0, len(counts))
+		for status, n := range counts {
+			statuses = append(statuses, fmt.Sprintf("%s %d", status, n))
+		}
+		sort.Strings(statuses)
+		fmt.Fprintf(&b, "History:   %d job(s) (%s)\n", len(state.Entries), strings.Join(statuses, ", "))
+	}
+	return b.String()
+}
+
+// bugReportConfig lists the effective configuration like config view
+// --resolved, with secrets replaced rather than shortened.
+func bugReportConfig(cfg *resolvedConfig) string {
+	var b bytes.Buffer
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	for _, entry := range flattenConfig(reflect.ValueOf(cfg.config), "") {
+		source := cfg.Sources[entry.Key]
+		if source == "" {
+			source = "default"
+		}
+		value := formatConfigValue(entry)
+		if entry.Secret {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t(%s)\n", entry.Key, value, source)
+	}
+	tw.Flush()
+	return b.String()
+}
+
+// bugReportFile is one text file in the bug report archive.
+type bugReportFile struct {
+	name, text string
+}
+
+// latestCrashReport returns the newest crash report in the cache directory.
+func latestCrashReport() string {
+	dir, err := resolveCacheDir()
+	if err != nil {
+		return ""
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "crash-*.txt"))
+	sort.Strings(matches)
+	if len(matches) == 0 {
+		return ""
+	}
+	return matches[len(matches)-1]
+}
+
+func runBugReportCommand(args []string) int {
+	fs := newCommandFlagSet("bug-report")
+	out := fs.String("out", "", "archive to write (default: sora2cli-bug-report-<time>.zip in the current directory)")
+	lines := fs.Int("lines", bugReportLogLines, "how many of the last activity log lines to include")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		logError("unexpected argument %q", fs.Arg(0))
+		return 2
+	}
+	if *lines < 0 {
+		logError("--lines must not be negative")
+		return 2
+	}
+	configPath, err := resolveConfigPath()
+	if err != nil {
+		logError("unable to locate config file: %v", err)
+		return 1
+	}
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	redactor := newBugReportRedactor(cfg)
+
+	files := []bugReportFile{
+		{"report.txt", bugReportSummary(cfg, configPath)},
+		{"config.txt", bugReportConfig(cfg)},
+	}
+	if *lines > 0 {
+		if log := recentActivityLines(*lines); len(log) > 0 {
+			files = append(files, bugReportFile{"activity.log", strings.Join(log, "\n") + "\n"})
+		}
+	}
+	if dir, err := resolveCacheDir(); err == nil {
+		if data, err := os.ReadFile(filepath.Join(dir, lastFailedRequestName)); err == nil {
+			files = append(files, bugReportFile{lastFailedRequestName, string(data)})
+		}
+	}
+	if path := latestCrashReport(); path != "" {
+		if data, err := os.ReadFile(path); err == nil {
+			files = append(files, bugReportFile{filepath.Base(path), string(data)})
+		}
+	}
+
+	path := *out
+	if path == "" {
+		path = fmt.Sprintf("sora2cli-bug-report-%s.zip", time.Now().Format("20060102-150405"))
+	}
+	if path, err = expandPath(path); err != nil {
+		logError("%v", err)
+		return 1
+	}
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, f := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: time.Now()})
+		if err == nil {
+			_, err = w.Write([]byte(redactor.redact(f.text)))
+		}
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+	}
+	if err := zw.Close(); err != nil {
+		logError("%v", err)
+		return 1
+	}
+	if err := os.WriteFile(path, archive.Bytes(), 0o600); err != nil {
+		logError("%v", err)
+		return 1
+	}
+
+	logInfo("Wrote %s with:", path)
+	for _, f := range files {
+		logInfo("  %s", f.name)
+	}
+	logInfo("Secrets, API keys and your home directory are removed. The activity log can still mention prompts and file names; look through the archive before attaching it to an issue.")
+	return 0
+}
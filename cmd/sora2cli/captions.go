@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+// captionsConfig controls translate-captions, which turns an SRT into one
+// SRT per target language with a chat model, using the same API key.
+type captionsConfig struct {
+	// Languages are BCP 47 tags such as es, de or pt-BR.
+	Languages []string `yaml:"languages,omitempty" env:"SORA2_CAPTION_LANGUAGES"`
+	// Model is the chat model (default gpt-4.1-mini).
+	Model string `yaml:"model,omitempty" env:"SORA2_CAPTIONS_MODEL"`
+}
+
+func (c captionsConfig) model() string {
+	if c.Model == "" {
+		return defaultEnhanceModel
+	}
+	return c.Model
+}
+
+var languageTagPattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+func validateCaptionsConfig(c captionsConfig) []configIssue {
+	var issues []configIssue
+	for i, lang := range c.Languages {
+		if !languageTagPattern.MatchString(lang) {
+			issues = append(issues, configIssue{Key: fmt.Sprintf("captions.languages[%d]", i), Message: fmt.Sprintf("%q is not a language tag such as es or pt-BR", lang)})
+		}
+	}
+	return issues
+}
+
+// srtCue is one numbered subtitle. Index and Timing are kept as written so
+// a translation lines up with the original exactly.
+type srtCue struct {
+	Index  string
+	Timing string
+	Text   string
+}
+
+func parseSRT(data string) ([]srtCue, error) {
+	data = strings.TrimPrefix(data, "\ufeff")
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	var cues []srtCue
+	for _, block := range regexp.MustCompile(`\n\s*\n`).Split(strings.TrimSpace(data), -1) {
+		lines := strings.Split(strings.TrimSpace(block), "\n")
+		if len(lines) < 2 || !strings.Contains(lines[1], "-->") {
+			return nil, fmt.Errorf("cue %d: expected an index and a timing line", len(cues)+1)
+		}
+		cues = append(cues, srtCue{Index: strings.TrimSpace(lines[0]), Timing: strings.TrimSpace(lines[1]), Text: strings.Join(lines[2:], "\n")})
+	}
+	if len(cues) == 0 {
+		return nil, errors.New("no cues")
+	}
+	return cues, nil
+}
+
+func formatSRT(cues []srtCue) string {
+	var b strings.Builder
+	for i, cue := range cues {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "%s\n%s\n%s\n", cue.Index, cue.Timing, cue.Text)
+	}
+	return b.String()
+}
+
+// captionChunk is how many cues go to the model at once; small enough for a
+// reply to stay well inside the output limit, large enough to keep context.
+const captionChunk = 60
+
+const captionInstructions = `You translate video subtitles into the language with the BCP 47 tag %s.
+You get a JSON array of subtitle texts in order. Reply with a JSON array of the same length holding the translation of each element, and nothing else.
+Keep line breaks within an element, keep names, keep each translation about as long as the original so it fits the same time on screen, and return an element unchanged when it has no words to translate.`
+
+// translateCues translates the cue texts into lang, keeping indexes and
+// timings.
+func translateCues(ctx context.Context, client *sora.Client, model, lang string, cues []srtCue) ([]srtCue, error) {
+	out := make([]srtCue, len(cues))
+	copy(out, cues)
+	for start := 0; start < len(cues); start += captionChunk {
+		end := min(start+captionChunk, len(cues))
+		texts := make([]string, 0, end-start)
+		for _, cue := range cues[start:end] {
+			texts = append(texts, cue.Text)
+		}
+		payload, err := json.Marshal(texts)
+		if err != nil {
+			return nil, err
+		}
+		reply, err := client.Chat(ctx, model, []sora.ChatMessage{
+			{Role: "system", Content: fmt.Sprintf(captionInstructions, lang)},
+			{Role: "user", Content: string(payload)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		// Models like to wrap JSON in a code fence.
+		reply = strings.TrimSpace(reply)
+		reply = strings.TrimPrefix(strings.TrimPrefix(reply, "```json"), "```")
+		reply = strings.TrimSpace(strings.TrimSuffix(reply, "```"))
+		var translated []string
+		if err := json.Unmarshal([]byte(reply), &translated); err != nil {
+			return nil, fmt.Errorf("cues %d-%d: the model did not reply with a JSON array", start+1, end)
+		}
+		if len(translated) != len(texts) {
+			return nil, fmt.Errorf("cues %d-%d: the model returned %d texts for %d", start+1, end, len(translated), len(texts))
+		}
+		for i, text := range translated {
+			out[start+i].Text = strings.TrimSpace(text)
+		}
+	}
+	return out, nil
+}
+
+// captionSource resolves a translate-captions argument: an SRT file, or a job
+// ID whose downloaded video has <id>.srt next to it.
+func captionSource(state historyState, arg string) (string, string, error) {
+	if info, err := os.Stat(arg); err == nil && !info.IsDir() {
+		return arg, "", nil
+	}
+	entry := state.find(arg)
+	if entry == nil || entry.OutputPath == "" {
+		return "", "", fmt.Errorf("no such file and no downloaded video in history")
+	}
+	path := strings.TrimSuffix(entry.OutputPath, filepath.Ext(entry.OutputPath)) + ".srt"
+	if _, err := os.Stat(path); err != nil {
+		return "", "", fmt.Errorf("no subtitles at %s", path)
+	}
+	return path, entry.JobID, nil
+}
+
+// runTranslateCaptionsCommand writes <name>.<lang>.srt next to each SRT for
+// every target language.
+func runTranslateCaptionsCommand(args []string) int {
+	fs := newCommandFlagSet("translate-captions")
+	var langs []string
+	fs.Func("lang", "target language tag such as es or pt-BR (repeatable or comma-separated; default captions.languages)", func(value string) error {
+		for _, lang := range strings.Split(value, ",") {
+			if lang = strings.TrimSpace(lang); lang != "" {
+				langs = append(langs, lang)
+			}
+		}
+		return nil
+	})
+	model := fs.String("model", "", "chat model (default captions.model or "+defaultEnhanceModel+")")
+	out := fs.String("out", "", "directory for the translations (default: next to each SRT)")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli translate-captions [--lang tag]... [--model name] [--out dir] <video-id|file.srt>...")
+		return 2
+	}
+	for _, lang := range langs {
+		if !languageTagPattern.MatchString(lang) {
+			logError("invalid --lang %q; use a language tag such as es or pt-BR", lang)
+			return 2
+		}
+	}
+	session, err := newAPISession(true)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	cfg := session.cfg
+	targets := langs
+	if len(targets) == 0 {
+		targets = cfg.Captions.Languages
+	}
+	if len(targets) == 0 {
+		logError("no target languages; pass --lang or set captions.languages")
+		return 2
+	}
+	if *model == "" {
+		*model = cfg.Captions.model()
+	}
+	outDir := *out
+	if outDir != "" {
+		if outDir, err = prepareDestinationDirectory(outDir); err != nil {
+			logError("%v", err)
+			return 1
+		}
+	}
+	state, err := loadHistory()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	failed := 0
+	for _, arg := range fs.Args() {
+		src, jobID, err := captionSource(state, arg)
+		if err != nil {
+			logError("%s: %v", arg, err)
+			failed++
+			continue
+		}
+		data, err := os.ReadFile(src)
+		if err != nil {
+			logError("%s: %v", arg, err)
+			failed++
+			continue
+		}
+		cues, err := parseSRT(string(data))
+		if err != nil {
+			logError("%s: %v", src, err)
+			failed++
+			continue
+		}
+		for _, lang := range targets {
+			logInfo("Translating %s into %s with %s...", filepath.Base(src), lang, *model)
+			translated, err := translateCues(ctx, session.client, *model, lang, cues)
+			if err != nil {
+				logError("%s (%s): %v", src, lang, err)
+				failed++
+				continue
+			}
+			dst := strings.TrimSuffix(src, filepath.Ext(src)) + "." + lang + ".srt"
+			if outDir != "" {
+				dst = filepath.Join(outDir, filepath.Base(dst))
+			}
+			if err := os.WriteFile(dst, []byte(formatSRT(translated)), 0o644); err != nil {
+				logError("%s: %v", dst, err)
+				failed++
+				continue
+			}
+			logInfo("Saved %d cues to %s", len(translated), dst)
+			if jobID != "" {
+				recordDerived(jobID, "srt."+lang, dst)
+			}
+		}
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
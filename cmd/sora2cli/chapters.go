@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+// defaultSceneThreshold is the number of differing hash bits, out of 64,
+// between neighbouring frames that marks a cut. Frames within one shot stay
+// well below it, unrelated footage sits around 32.
+const defaultSceneThreshold = 22
+
+func runChaptersCommand(args []string) int {
+	fs := newCommandFlagSet("chapters")
+	threshold := fs.Int("threshold", defaultSceneThreshold, "differing bits, out of 64, between neighbouring frames that mark a new scene")
+	vttOnly := fs.Bool("vtt-only", false, "only write the WebVTT file and leave the MP4 untouched")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli chapters [--threshold bits] [--vtt-only] <video-id>...")
+		return 2
+	}
+	if *threshold < 1 || *threshold > 64 {
+		logError("--threshold must be between 1 and 64")
+		return 2
+	}
+
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	state, err := loadHistory()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	var client *sora.Client
+	if cfg.APIKey != "" {
+		client = newAPIClient(cfg, cfg.APIKey)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	failed := 0
+	for _, jobID := range fs.Args() {
+		if err := writeVideoChapters(ctx, client, state.find(jobID), jobID, *threshold, !*vttOnly); err != nil {
+			logError("%s: %v", jobID, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func writeVideoChapters(ctx context.Context, client *sora.Client, entry *historyEntry, jobID string, threshold int, intoMP4 bool) error {
+	if entry == nil || entry.Status != "completed" || entry.OutputPath == "" {
+		return fmt.Errorf("no downloaded video in history")
+	}
+	if _, err := os.Stat(entry.OutputPath); err != nil {
+		return err
+	}
+	if entry.Seconds <= 0 {
+		return fmt.Errorf("duration unknown")
+	}
+	if len(entry.FrameHashes) == 0 {
+		hashes, err := hashVideoFrames(ctx, client, entry)
+		if err != nil {
+			return err
+		}
+		entry.FrameHashes = hashes
+		updateHistoryOrWarn(jobID, false, func(e *historyEntry) { e.FrameHashes = hashes })
+	}
+	chapters, err := detectScenes(entry.FrameHashes, time.Duration(entry.Seconds)*time.Second, threshold)
+	if err != nil {
+		return err
+	}
+
+	vttPath := strings.TrimSuffix(entry.OutputPath, ".mp4") + ".chapters.vtt"
+	if err := os.WriteFile(vttPath, []byte(formatWebVTTChapters(chapters, time.Duration(entry.Seconds)*time.Second)), 0o644); err != nil {
+		return err
+	}
+	logInfo("%s: %d scene(s); chapters written to %s", jobID, len(chapters), vttPath)
+	if !intoMP4 {
+		return nil
+	}
+	if err := writeMP4Chapters(entry.OutputPath, chapters); err != nil {
+		return fmt.Errorf("write chapters into %s: %w", entry.OutputPath, err)
+	}
+	logInfo("%s: chapter markers added to %s", jobID, entry.OutputPath)
+	// The file changed, so the recorded digest has to follow for gc to
+	// still trust the local copy.
+	if size, sum, err := fileDigest(entry.OutputPath); err == nil {
+		updateHistoryOrWarn(jobID, false, func(e *historyEntry) {
+			e.OutputBytes = size
+			e.SHA256 = sum
+		})
+	}
+	return nil
+}
+
+// detectScenes starts a chapter at every frame that differs from the one
+// before by more than threshold bits. The frames are spread evenly over the
+// duration, so a cut is placed at the first frame of the new scene.
+func detectScenes(hashes []string, duration time.Duration, threshold int) ([]mp4Chapter, error) {
+	values := make([]uint64, len(hashes))
+	for i, hash := range hashes {
+		v, err := strconv.ParseUint(hash, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid frame hash %q", hash)
+		}
+		values[i] = v
+	}
+	chapters := []mp4Chapter{{Start: 0, Title: "Scene 1"}}
+	for i := 1; i < len(values); i++ {
+		if bits.OnesCount64(values[i-1]^values[i]) <= threshold {
+			continue
+		}
+		start := (duration * time.Duration(i) / time.Duration(len(values))).Truncate(time.Millisecond)
+		chapters = append(chapters, mp4Chapter{Start: start, Title: fmt.Sprintf("Scene %d", len(chapters)+1)})
+	}
+	return chapters, nil
+}
+
+func formatWebVTTChapters(chapters []mp4Chapter, duration time.Duration) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for i, chapter := range chapters {
+		end := duration
+		if i+1 < len(chapters) {
+			end = chapters[i+1].Start
+		}
+		fmt.Fprintf(&b, "\n%d\n%s --> %s\n%s\n", i+1, formatVTTTime(chapter.Start), formatVTTTime(end), chapter.Title)
+	}
+	return b.String()
+}
+
+func formatVTTTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,2143 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+func runSubcommand(args []string) int {
+	if args[0] == "help" || isHelpArg(args[0]) {
+		return runHelpCommand(args[1:])
+	}
+	if isVersionArg(args[0]) {
+		return runVersionCommand(args[1:])
+	}
+	if spec, ok := findCommandSpec(args[0]); ok && spec.topLevel() {
+		if args[0] == "resume" {
+			return runWaitCommand(args[0], args[1:])
+		}
+		return spec.Run(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	fmt.Fprint(os.Stderr, commandUsage())
+	return 2
+}
+
+func runConfigCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli config <validate|view|get|set|unset|import> [flags]")
+		return 2
+	}
+	if isHelpArg(args[0]) {
+		return runHelpCommand([]string{"config"})
+	}
+	switch args[0] {
+	case "validate":
+		return runConfigValidate(args[1:])
+	case "view":
+		return runConfigView(args[1:])
+	case "get":
+		return runConfigGet(args[1:])
+	case "set":
+		return runConfigSet(args[1:])
+	case "unset":
+		return runConfigUnset(args[1:])
+	case "import":
+		return runConfigImport(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown config command %q\n", args[0])
+		fmt.Fprintln(os.Stderr, "usage: sora2cli config <validate|view|get|set|unset|import> [flags]")
+		return 2
+	}
+}
+
+type configFileFlags struct {
+	path    string
+	project bool
+}
+
+func newConfigFlagSet(name string) (*flag.FlagSet, *configFileFlags) {
+	fs := newCommandFlagSet("config " + name)
+	flags := &configFileFlags{}
+	fs.StringVar(&flags.path, "config", "", "path to the user config file (default $SORA2_CONFIG or the user config directory)")
+	fs.BoolVar(&flags.project, "project", false, "use the project config ("+projectConfigFileName+" found from the current directory upwards)")
+	return fs, flags
+}
+
+// targetPath returns the file a command reads or edits. With --project it is
+// the nearest project config, or a new one in the current directory.
+func (f *configFileFlags) targetPath() (string, error) {
+	if f.project {
+		if path := discoverProjectConfig(); path != "" {
+			return path, nil
+		}
+		cwd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(cwd, projectConfigFileName), nil
+	}
+	return f.userPath()
+}
+
+func (f *configFileFlags) userPath() (string, error) {
+	if f.path != "" {
+		return expandPath(f.path)
+	}
+	return resolveConfigPath()
+}
+
+func runConfigValidate(args []string) int {
+	fs, flags := newConfigFlagSet("validate")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+
+	var paths []string
+	if flags.project {
+		if path := discoverProjectConfig(); path != "" {
+			paths = append(paths, path)
+		}
+	} else {
+		userPath, err := flags.userPath()
+		if err != nil {
+			logError("unable to locate config file: %v", err)
+			return 1
+		}
+		paths = append(paths, userPath)
+		if path := discoverProjectConfig(); path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	status := 0
+	checked := 0
+	for _, path := range paths {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		checked++
+		issues, err := validateConfigFile(path)
+		if err != nil {
+			logError("%v", err)
+			status = 1
+			continue
+		}
+		if filepath.Base(path) == projectConfigFileName {
+			if doc, err := readConfigNode(path); err == nil && doc != nil {
+				issues = append(dropNonProjectKeys(doc), issues...)
+			}
+		}
+		if len(issues) == 0 {
+			fmt.Printf("%s is valid.\n", path)
+			continue
+		}
+		fmt.Printf("%s has %d problem(s):\n", path, len(issues))
+		for _, issue := range issues {
+			fmt.Printf("  %s\n", issue)
+		}
+		status = 1
+	}
+	if checked == 0 {
+		fmt.Println("No config files found; built-in defaults apply.")
+	}
+	return status
+}
+
+func runConfigView(args []string) int {
+	fs, flags := newConfigFlagSet("view")
+	resolvedFlag := fs.Bool("resolved", false, "print the effective configuration after applying project config and env overrides")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	path, err := flags.targetPath()
+	if *resolvedFlag {
+		path, err = flags.userPath()
+	}
+	if err != nil {
+		logError("unable to locate config file: %v", err)
+		return 1
+	}
+
+	if !*resolvedFlag {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("No config file at %s\n", path)
+			return 0
+		}
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+		fmt.Printf("# %s\n", path)
+		os.Stdout.Write(data)
+		return 0
+	}
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	fmt.Printf("# user config: %s\n", path)
+	if cfg.ProjectPath != "" {
+		fmt.Printf("# project config: %s\n", cfg.ProjectPath)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, entry := range flattenConfig(reflect.ValueOf(cfg.config), "") {
+		source := cfg.Sources[entry.Key]
+		if source == "" {
+			source = "default"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t(%s)\n", entry.Key, formatConfigValue(entry), source)
+	}
+	tw.Flush()
+	return 0
+}
+
+func runConfigGet(args []string) int {
+	fs, flags := newConfigFlagSet("get")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli config get <key>")
+		return 2
+	}
+	key := fs.Arg(0)
+	if _, err := lookupConfigType(key); err != nil {
+		logError("%v", err)
+		return 1
+	}
+	path, err := flags.userPath()
+	if err != nil {
+		logError("unable to locate config file: %v", err)
+		return 1
+	}
+	var cfg config
+	if flags.project {
+		cfg, err = readConfigFile(discoverProjectConfig())
+	} else {
+		var resolved *resolvedConfig
+		resolved, err = loadConfig(path)
+		if resolved != nil {
+			cfg = resolved.config
+		}
+	}
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	value, ok := configValueAt(reflect.ValueOf(cfg), key)
+	if !ok || value.IsZero() {
+		return 1
+	}
+	if value.Kind() == reflect.Struct || value.Kind() == reflect.Map {
+		for _, entry := range flattenConfig(reflect.ValueOf(cfg), "") {
+			if strings.HasPrefix(entry.Key, key+".") {
+				entry.Secret = false
+				fmt.Printf("%s=%s\n", entry.Key, formatConfigValue(entry))
+			}
+		}
+		return 0
+	}
+	fmt.Println(formatConfigValue(configEntry{Key: key, Value: value}))
+	return 0
+}
+
+func runConfigSet(args []string) int {
+	fs, flags := newConfigFlagSet("set")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli config set <key> <value>")
+		return 2
+	}
+	if flags.project && !isProjectConfigKey(fs.Arg(0)) {
+		logError("%s cannot be set in a project config; set it in the user config or the environment", fs.Arg(0))
+		return 2
+	}
+	path, err := flags.targetPath()
+	if err != nil {
+		logError("unable to locate config file: %v", err)
+		return 1
+	}
+	if err := setConfigFileValue(path, fs.Arg(0), fs.Arg(1)); err != nil {
+		logError("%v", err)
+		return 1
+	}
+	return 0
+}
+
+func runConfigUnset(args []string) int {
+	fs, flags := newConfigFlagSet("unset")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli config unset <key>")
+		return 2
+	}
+	path, err := flags.targetPath()
+	if err != nil {
+		logError("unable to locate config file: %v", err)
+		return 1
+	}
+	removed, err := unsetConfigFileValue(path, fs.Arg(0))
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	if !removed {
+		return 1
+	}
+	return 0
+}
+
+func loadCommandConfig() (*resolvedConfig, error) {
+	path, err := resolveConfigPath()
+	if err != nil {
+		return nil, fmt.Errorf("unable to locate config file: %w", err)
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	exportConfigEnv(cfg)
+	return cfg, nil
+}
+
+type apiSession struct {
+	cfg    *resolvedConfig
+	reader *bufio.Reader
+	client *sora.Client
+}
+
+// newAPISession loads configuration and credentials for a command that talks
+// to the API. Without an API key it prompts like the interactive menu does,
+// unless nonInteractive is set.
+func newAPISession(nonInteractive bool) (*apiSession, error) {
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(os.Stdin)
+	apiKey := cfg.APIKey
+	if apiKey == "" {
+		if nonInteractive || !stdinIsTerminal() {
+			return nil, errors.New("OPENAI_API_KEY not found in environment, OPENAI_API_KEY_FILE, .env or config file")
+		}
+		apiKey, reader = obtainAPIKey(reader, cfg, resolveEnvPath())
+	}
+	return &apiSession{cfg: cfg, reader: reader, client: newAPIClient(cfg, apiKey)}, nil
+}
+
+// newCommandSession is newAPISession, except that a dry run, which never
+// calls the API, does without an API key.
+func newCommandSession(nonInteractive, dryRun bool) (*apiSession, error) {
+	if !dryRun {
+		return newAPISession(nonInteractive)
+	}
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &apiSession{cfg: cfg, reader: bufio.NewReader(os.Stdin), client: newAPIClient(cfg, cfg.APIKey)}, nil
+}
+
+// loadCommandAnswers loads the --answers file of the create or remix
+// command, if any, and reports a bad one. Answers a headless command lacks
+// are left to the command's own checks, since flags may supply them.
+func loadCommandAnswers(path string, cfg *resolvedConfig, flow string) (*wizardAnswers, error) {
+	if path == "" {
+		return nil, nil
+	}
+	answers, err := loadWizardAnswers(path, cfg, flow, false)
+	if err != nil {
+		logError("%v", err)
+		emitJSONError(err, "")
+	}
+	return answers, err
+}
+
+func runCreateCommand(args []string) int {
+	fs := newCommandFlagSet("create")
+	registerMaxWaitFlag(fs)
+	var opts createOptions
+	fs.StringVar(&opts.Prompt, "prompt", "", "prompt describing the video")
+	fs.StringVar(&opts.Template, "template", "", "build the prompt from this prompt template (see sora2cli templates)")
+	fs.Var((*stringList)(&opts.Vars), "var", "template variable as name=value; repeat for several")
+	fs.BoolVar(&opts.Enhance, "enhance", false, "have a chat model expand the prompt and review the changes before submitting (on for every create with enhance.auto)")
+	fs.StringVar(&opts.Model, "model", "", "model to use (sora-2 or sora-2-pro)")
+	fs.IntVar(&opts.Seconds, "seconds", 0, "clip duration in seconds (4, 8 or 12)")
+	fs.StringVar(&opts.Size, "size", "", "output resolution, e.g. 1280x720")
+	fs.Var((*stringList)(&opts.References), "reference", "path to a reference image or video; repeat for several")
+	fs.StringVar(&opts.Destination, "out", "", "destination directory for the MP4")
+	fs.StringVar(&opts.Ticket, "ticket", "", "Jira/Linear issue key (or Jira project key) to update when the job finishes")
+	fs.Var((*stringList)(&opts.Tags), "tag", "label the job in history, e.g. campaign:q3; repeat for several")
+	opts.Extras.register(fs)
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "validate the inputs and print the request and its cost without calling the API")
+	fs.BoolVar(&opts.AssumeYes, "yes", false, "skip the confirmation prompt")
+	fs.BoolVar(&opts.NonInteractive, "non-interactive", false, "never prompt; fail when a required flag is missing (default when stdin is not a terminal)")
+	fs.BoolVar(&opts.Open, "open", false, "play the saved MP4 in the default player (without it, a terminal session asks)")
+	fs.BoolVar(&opts.Notify, "notify", false, "show a desktop notification when the job completes or fails (default: notifications.desktop)")
+	fs.StringVar(&opts.WebhookURL, "webhook-url", "", "POST the finished job as JSON to this URL (default: notifications.job_webhook.url)")
+	answersPath := fs.String("answers", "", "YAML file answering the wizard's questions; flags take precedence")
+	jsonOutput := fs.Bool("json", false, "print the result as JSON on stdout and progress on stderr")
+	registerFormatFlag(fs, jsonOutput)
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	defaultNonInteractive(fs, &opts.NonInteractive)
+	if *jsonOutput {
+		enableJSONOutput()
+	}
+	if fs.NArg() > 0 {
+		logError("unexpected argument %q", fs.Arg(0))
+		return 2
+	}
+	if opts.Template != "" && opts.Prompt != "" {
+		logError("--prompt and --template cannot be combined")
+		return 2
+	}
+	if len(opts.Vars) > 0 && opts.Template == "" {
+		logError("--var needs --template")
+		return 2
+	}
+	if err := validateTicketKey(opts.Ticket); err != nil {
+		logError("%v", err)
+		emitJSONError(err, "")
+		return 2
+	}
+	if err := validateWebhookURL(opts.WebhookURL); err != nil {
+		logError("%v", err)
+		emitJSONError(err, "")
+		return 2
+	}
+	if err := validateTags(opts.Tags); err != nil {
+		logError("%v", err)
+		emitJSONError(err, "")
+		return 2
+	}
+
+	session, err := newCommandSession(opts.NonInteractive, opts.DryRun)
+	if err != nil {
+		logError("%v", err)
+		emitJSONError(err, "")
+		return 1
+	}
+	if err := opts.Extras.validate(session.cfg); err != nil {
+		logError("%v", err)
+		emitJSONError(err, "")
+		return 2
+	}
+	answers, err := loadCommandAnswers(*answersPath, session.cfg, "create")
+	if err != nil {
+		return 2
+	}
+	if opts.NonInteractive {
+		if answers != nil {
+			answers.applyCreate(&opts, make(map[wizardState]bool))
+		}
+		return reportFlowError(executeCreate(session.reader, os.Stdout, session.client, session.cfg, opts))
+	}
+	// Questions the flags leave open are answered by the answers file or
+	// asked by the wizard.
+	w := newWizard(session.reader, os.Stdout, session.client, session.cfg)
+	w.standalone, w.answers = true, answers
+	w.run(w.startCreate(opts))
+	return w.exitStatus()
+}
+
+func runRemixCommand(args []string) int {
+	fs := newCommandFlagSet("remix")
+	registerMaxWaitFlag(fs)
+	var opts remixOptions
+	fs.StringVar(&opts.VideoID, "video-id", "", "ID of the completed video to remix")
+	fs.StringVar(&opts.Prompt, "prompt", "", "remix prompt describing the change")
+	fs.StringVar(&opts.Destination, "out", "", "destination directory for the MP4")
+	fs.StringVar(&opts.Ticket, "ticket", "", "Jira/Linear issue key (or Jira project key) to update when the job finishes")
+	fs.Var((*stringList)(&opts.Tags), "tag", "label the job in history, e.g. campaign:q3; repeat for several")
+	opts.Extras.register(fs)
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "validate the inputs and print the request and its cost without calling the API")
+	fs.BoolVar(&opts.AssumeYes, "yes", false, "skip the confirmation prompt")
+	fs.BoolVar(&opts.NonInteractive, "non-interactive", false, "never prompt; fail when a required flag is missing (default when stdin is not a terminal)")
+	fs.BoolVar(&opts.Open, "open", false, "play the saved MP4 in the default player (without it, a terminal session asks)")
+	fs.BoolVar(&opts.Notify, "notify", false, "show a desktop notification when the job completes or fails (default: notifications.desktop)")
+	fs.StringVar(&opts.WebhookURL, "webhook-url", "", "POST the finished job as JSON to this URL (default: notifications.job_webhook.url)")
+	fs.BoolVar(&opts.Session, "session", false, "offer to remix each result again and record the chain in <root>.lineage.json")
+	answersPath := fs.String("answers", "", "YAML file answering the wizard's questions; flags take precedence")
+	jsonOutput := fs.Bool("json", false, "print the result as JSON on stdout and progress on stderr")
+	registerFormatFlag(fs, jsonOutput)
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	defaultNonInteractive(fs, &opts.NonInteractive)
+	if *jsonOutput {
+		enableJSONOutput()
+	}
+	if fs.NArg() > 1 {
+		logError("unexpected argument %q", fs.Arg(1))
+		return 2
+	}
+	if opts.Session && (opts.NonInteractive || opts.DryRun || *jsonOutput) {
+		logError("--session is interactive and cannot be combined with --non-interactive, --dry-run or --json")
+		return 2
+	}
+	if fs.NArg() == 1 && opts.VideoID == "" {
+		opts.VideoID = fs.Arg(0)
+	}
+	if err := validateTicketKey(opts.Ticket); err != nil {
+		logError("%v", err)
+		emitJSONError(err, "")
+		return 2
+	}
+	if err := validateWebhookURL(opts.WebhookURL); err != nil {
+		logError("%v", err)
+		emitJSONError(err, "")
+		return 2
+	}
+	if err := validateTags(opts.Tags); err != nil {
+		logError("%v", err)
+		emitJSONError(err, "")
+		return 2
+	}
+
+	session, err := newCommandSession(opts.NonInteractive, opts.DryRun)
+	if err != nil {
+		logError("%v", err)
+		emitJSONError(err, "")
+		return 1
+	}
+	if err := opts.Extras.validate(session.cfg); err != nil {
+		logError("%v", err)
+		emitJSONError(err, "")
+		return 2
+	}
+	answers, err := loadCommandAnswers(*answersPath, session.cfg, "remix")
+	if err != nil {
+		return 2
+	}
+	if opts.NonInteractive {
+		if answers != nil {
+			answers.applyRemix(&opts, make(map[wizardState]bool))
+		}
+		return reportFlowError(executeRemix(session.reader, os.Stdout, session.client, session.cfg, opts))
+	}
+	w := newWizard(session.reader, os.Stdout, session.client, session.cfg)
+	w.standalone, w.answers = true, answers
+	w.run(w.startRemix(opts))
+	return w.exitStatus()
+}
+
+func runListCommand(args []string) int {
+	fs := newCommandFlagSet("list")
+	var opts listOptions
+	fs.IntVar(&opts.Limit, "limit", 0, "number of videos to list (1-100, default 20)")
+	fs.StringVar(&opts.Order, "order", "", "sort order: asc or desc (default desc)")
+	fs.StringVar(&opts.After, "after", "", "pagination cursor from a previous listing")
+	fs.StringVar(&opts.Review, "review", "", "only videos with this review status: "+strings.Join(reviewFilters, ", "))
+	fs.Var((*stringList)(&opts.Tags), "tag", "only videos tagged with this in history; repeat to require several")
+	fs.StringVar(&opts.Status, "status", "", "only videos with this status: "+strings.Join(listStatuses, ", "))
+	fs.StringVar(&opts.Model, "model", "", "only videos made with this model")
+	since := fs.String("since", "", "only videos created on or after this date (YYYY-MM-DD) or within this age, e.g. 7d")
+	until := fs.String("until", "", "only videos created on or before this date (YYYY-MM-DD) or before this age, e.g. 24h")
+	fs.StringVar(&opts.Contains, "contains", "", "only videos whose prompt contains this text (case-insensitive)")
+	fs.StringVar(&opts.Output, "output", listOutputTable, "how to show the videos: "+strings.Join(listOutputs, ", "))
+	fs.BoolVar(&opts.Watch, "watch", false, "refresh the table until Ctrl+C, highlighting videos whose status or progress changed")
+	fs.DurationVar(&opts.Interval, "interval", defaultWatchInterval, "with --watch, how often to refresh, e.g. 10s")
+	fs.BoolVar(&opts.NonInteractive, "non-interactive", false, "never prompt; use defaults for missing flags (default when stdin is not a terminal)")
+	jsonOutput := fs.Bool("json", false, "print the result as JSON on stdout and progress on stderr (same as --output json)")
+	registerFormatFlag(fs, jsonOutput)
+	fs.BoolVar(&displayUTC, "utc", false, "show timestamps in UTC instead of the local time zone")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	defaultNonInteractive(fs, &opts.NonInteractive)
+	opts.Output = strings.ToLower(opts.Output)
+	if err := validateListOutput(opts.Output); err != nil {
+		logError("%v", err)
+		return 2
+	}
+	if opts.Watch && (*jsonOutput || opts.Output == listOutputJSON || opts.Output == listOutputCSV) {
+		logError("--watch shows a table and cannot be combined with JSON or CSV output")
+		return 2
+	}
+	if opts.Watch && opts.Interval < time.Second {
+		logError("--interval must be at least 1s")
+		return 2
+	}
+	switch {
+	case *jsonOutput || opts.Output == listOutputJSON:
+		enableJSONOutput()
+	case opts.Output == listOutputCSV:
+		// The CSV is the only thing on stdout; messages go to stderr.
+		opts.csv = os.Stdout
+		os.Stdout = os.Stderr
+	}
+	usageErr := func(err error) int {
+		logError("%v", err)
+		emitJSONError(err, "")
+		return 2
+	}
+	if opts.Review != "" {
+		if err := validateReviewFilter(opts.Review); err != nil {
+			return usageErr(err)
+		}
+	}
+	if err := validateTags(opts.Tags); err != nil {
+		return usageErr(err)
+	}
+	if opts.Status != "" && !slices.Contains(listStatuses, opts.Status) {
+		return usageErr(fmt.Errorf("unknown status %q; use %s", opts.Status, strings.Join(listStatuses, ", ")))
+	}
+	now := time.Now()
+	var err error
+	if *since != "" {
+		if opts.Since, err = parseRelativeDate(*since, false, now); err != nil {
+			return usageErr(fmt.Errorf("--since: %w", err))
+		}
+	}
+	if *until != "" {
+		if opts.Until, err = parseRelativeDate(*until, true, now); err != nil {
+			return usageErr(fmt.Errorf("--until: %w", err))
+		}
+	}
+
+	session, err := newAPISession(opts.NonInteractive)
+	if err != nil {
+		logError("%v", err)
+		emitJSONError(err, "")
+		return 1
+	}
+	return reportFlowError(executeList(session.reader, os.Stdout, session.client, opts))
+}
+
+// stringList is a flag that may be given several times.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ", ") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// parseJobID parses a subcommand's flags and returns its single positional
+// video ID.
+func parseJobID(fs *flag.FlagSet, args []string) (string, bool) {
+	if err := parseCommandFlags(fs, args); err != nil {
+		return "", false
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: sora2cli %s [flags] <video-id>\n", fs.Name())
+		return "", false
+	}
+	return fs.Arg(0), true
+}
+
+func runGetCommand(args []string) int {
+	fs := newCommandFlagSet("get")
+	registerMaxWaitFlag(fs)
+	wait := fs.Bool("wait", false, "poll until the job completes or fails")
+	jsonOutput := fs.Bool("json", false, "print the job as JSON on stdout (an array for several IDs) and progress on stderr")
+	registerFormatFlag(fs, jsonOutput)
+	fs.BoolVar(&displayUTC, "utc", false, "show timestamps in UTC instead of the local time zone")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 || (*wait && fs.NArg() > 1) {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli get [--json] <video-id>...  or  sora2cli get --wait [--json] <video-id>")
+		return 2
+	}
+	if *jsonOutput {
+		enableJSONOutput()
+	}
+
+	session, err := newAPISession(false)
+	if err != nil {
+		logError("%v", err)
+		emitJSONError(err, "")
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), maxWaitDuration)
+	defer cancel()
+	if fs.NArg() > 1 {
+		return getJobs(ctx, session.client, fs.Args())
+	}
+	jobID := fs.Arg(0)
+
+	var job *sora.Video
+	if *wait {
+		job, err = waitForJobCompletion(ctx, session.client, jobID)
+	} else if job, err = session.client.Get(ctx, jobID); err != nil {
+		err = fmt.Errorf("failed to get video: %w", err)
+	}
+	if err != nil {
+		logError("%v", err)
+		emitJSONError(err, jobID)
+		return 1
+	}
+	printVideoJob(job)
+	emitJSON(job)
+	if job.Status == "failed" {
+		return 1
+	}
+	return 0
+}
+
+// bulkGetConcurrency bounds the parallel requests of a multi-ID get.
+const bulkGetConcurrency = 8
+
+// getJobs fetches several jobs concurrently. With --json they are emitted as
+// one array in the order given, with an error object in place of each job
+// that could not be fetched.
+func getJobs(ctx context.Context, client *sora.Client, jobIDs []string) int {
+	jobs := make([]*sora.Video, len(jobIDs))
+	errs := make([]error, len(jobIDs))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, bulkGetConcurrency)
+	for i, jobID := range jobIDs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, jobID string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer handleCrash()
+			jobs[i], errs[i] = client.Get(ctx, jobID)
+		}(i, jobID)
+	}
+	wg.Wait()
+
+	status := 0
+	results := make([]any, len(jobIDs))
+	for i, jobID := range jobIDs {
+		if i > 0 {
+			fmt.Println()
+		}
+		if errs[i] != nil {
+			err := fmt.Errorf("failed to get video %s: %w", jobID, errs[i])
+			logError("%v", err)
+			results[i] = map[string]jsonErrorBody{"error": {Message: err.Error(), JobID: jobID}}
+			status = 1
+			continue
+		}
+		printVideoJob(jobs[i])
+		results[i] = jobs[i]
+		if jobs[i].Status == "failed" {
+			status = 1
+		}
+	}
+	emitJSON(results)
+	return status
+}
+
+func runDownloadCommand(args []string) int {
+	fs := newCommandFlagSet("download")
+	registerMaxWaitFlag(fs)
+	out := fs.String("out", "", "destination directory (default: defaults.destination or the current directory)")
+	wait := fs.Bool("wait", false, "wait for the job to complete before downloading")
+	variantName := fs.String("variant", "video", "what to download: video, thumbnail or spritesheet")
+	var extras extraFlags
+	extras.register(fs)
+	jobID, ok := parseJobID(fs, args)
+	if !ok {
+		return 2
+	}
+	variant, err := sora.ParseVariant(*variantName)
+	if err != nil {
+		logError("%v", err)
+		return 2
+	}
+
+	session, err := newAPISession(false)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	if err := extras.validate(session.cfg); err != nil {
+		logError("%v", err)
+		return 2
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), maxWaitDuration)
+	defer cancel()
+
+	var job *sora.Video
+	if *wait {
+		job, err = waitForJobCompletion(ctx, session.client, jobID)
+	} else {
+		job, err = session.client.Get(ctx, jobID)
+		if err == nil && job.Status != "completed" {
+			err = fmt.Errorf("job is %s; only completed videos can be downloaded (use --wait to wait for it)", job.Status)
+		}
+	}
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+
+	destination := *out
+	if destination == "" {
+		destination = session.cfg.Defaults.Destination
+	}
+	destination, err = prepareDestinationDirectory(destination)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	outputs := extras.outputs(session.cfg)
+	if destination, err = outputs.outputDir(destination, job, historyTags(jobID)); err != nil {
+		logError("%v", err)
+		return 1
+	}
+	outputPath := filepath.Join(destination, variantFilename(job.ID, variant))
+	if err := session.client.DownloadVariantFile(ctx, job.ID, variant, outputPath); err != nil {
+		logError("failed to download %s: %v", variant, err)
+		return 1
+	}
+	if variant != sora.VariantVideo {
+		logInfo("Saved %s to %s", variant, outputPath)
+		return 0
+	}
+	logInfo("Video saved to %s", outputPath)
+	downloadExtras(ctx, session.client, job, outputPath, outputs)
+	markHistoryCompleted(job, "download", outputPath)
+	return 0
+}
+
+// runWaitCommand picks up a job created by an earlier session, for example
+// one that crashed mid-poll, and finishes the flow create would have run:
+// wait, download, report the ticket and export to the DAM.
+func runWaitCommand(name string, args []string) int {
+	fs := newCommandFlagSet(name)
+	registerMaxWaitFlag(fs)
+	out := fs.String("out", "", "destination directory (default: defaults.destination or the current directory)")
+	ticket := fs.String("ticket", "", "Jira/Linear issue key (or Jira project key) to update when the job finishes")
+	open := fs.Bool("open", false, "play the saved MP4 in the default player")
+	notify := fs.Bool("notify", false, "show a desktop notification when the job completes or fails (default: notifications.desktop)")
+	webhookURL := fs.String("webhook-url", "", "POST the finished job as JSON to this URL (default: notifications.job_webhook.url)")
+	jsonOutput := fs.Bool("json", false, "print the result as JSON on stdout and progress on stderr")
+	registerFormatFlag(fs, jsonOutput)
+	var extras extraFlags
+	extras.register(fs)
+	jobID, ok := parseJobID(fs, args)
+	if !ok {
+		return 2
+	}
+	if *jsonOutput {
+		enableJSONOutput()
+	}
+	if err := validateTicketKey(*ticket); err != nil {
+		logError("%v", err)
+		emitJSONError(err, jobID)
+		return 2
+	}
+	if err := validateWebhookURL(*webhookURL); err != nil {
+		logError("%v", err)
+		emitJSONError(err, jobID)
+		return 2
+	}
+
+	session, err := newAPISession(false)
+	if err != nil {
+		logError("%v", err)
+		emitJSONError(err, "")
+		return 1
+	}
+	if err := extras.validate(session.cfg); err != nil {
+		logError("%v", err)
+		emitJSONError(err, jobID)
+		return 2
+	}
+	destination := *out
+	if destination == "" {
+		destination = session.cfg.Defaults.Destination
+	}
+	destination, err = prepareDestinationDirectory(destination)
+	if err != nil {
+		logError("%v", err)
+		emitJSONError(err, jobID)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), maxWaitDuration)
+	defer cancel()
+
+	event := ticketEvent{Ticket: *ticket, JobID: jobID}
+	webhook := session.cfg.Notifications.JobWebhook.withURL(*webhookURL)
+	var job *sora.Video
+	fail := func(err error) int {
+		logError("%v", err)
+		event.Status = "failed"
+		event.Error = err.Error()
+		reportTicketOrWarn(session.cfg.Tickets, event)
+		notifyJobOrWarn(session.client, session.cfg.Notifications, event)
+		notifyDesktopOrWarn(*notify || session.cfg.Notifications.Desktop, event)
+		postJobWebhookOrWarn(webhook, event, job, nil)
+		emitJSONError(err, jobID)
+		return 1
+	}
+
+	job, err = session.client.Get(ctx, jobID)
+	if err != nil {
+		return fail(fmt.Errorf("failed to get video: %w", err))
+	}
+	defer watchJob(jobID).unlock()
+	recordJobHistory(job, "wait", func(e *historyEntry) {
+		if *ticket != "" {
+			e.Ticket = *ticket
+		}
+		if !job.Done() {
+			e.awaitDownload(destination)
+		}
+	})
+	event.Prompt = job.Prompt
+	event.Model = job.Model
+	if seconds, err := strconv.Atoi(job.Seconds); err == nil {
+		event.Seconds = seconds
+		event.EstimatedCost = jobCostEstimate(job.Model, seconds)
+	}
+	if !job.Done() {
+		logInfo("Resuming job %s: %s", job.ID, formatProgress(job))
+		if job, err = waitForJobCompletion(ctx, session.client, jobID); err != nil {
+			err = fmt.Errorf("generation failed: %w", err)
+			markHistoryFailed(jobID, err)
+			return fail(err)
+		}
+	} else if job.Status != "completed" {
+		return fail(fmt.Errorf("job %s already %s", job.ID, job.Status))
+	}
+
+	logInfo("Job completed. Downloading video...")
+	outputs := extras.outputs(session.cfg)
+	outputPath, err := outputs.outputPath(destination, job, historyTags(jobID))
+	if err != nil {
+		return fail(err)
+	}
+	if err := downloadJobFile(ctx, session.client, job.ID, outputPath); err != nil {
+		err = fmt.Errorf("failed to download video: %w", err)
+		markHistoryFailed(jobID, err)
+		return fail(err)
+	}
+	logInfo("Video saved to %s", outputPath)
+	variants := downloadExtras(ctx, session.client, job, outputPath, outputs)
+	markHistoryCompleted(job, "wait", outputPath)
+
+	event.Status = "completed"
+	event.OutputPath = outputPath
+	reportTicketOrWarn(session.cfg.Tickets, event)
+	notifyJobOrWarn(session.client, session.cfg.Notifications, event)
+	notifyDesktopOrWarn(*notify || session.cfg.Notifications.Desktop, event)
+	postJobWebhookOrWarn(webhook, event, job, variants)
+	record := assetRecordFromJob(job, outputPath)
+	record.Ticket = *ticket
+	exportAssetOrWarn(session.cfg.DAM, session.cfg.Review, record)
+	emitJSON(videoResult{Video: job, OutputPath: outputPath, Variants: variants})
+	offerToOpen(session.reader, os.Stdout, outputPath, *open, false)
+	return 0
+}
+
+// runCancelCommand stops a job that has not finished yet. The videos API has
+// no separate cancel endpoint; deleting an unfinished job cancels it.
+func runCancelCommand(args []string) int {
+	fs := newCommandFlagSet("cancel")
+	jobID, ok := parseJobID(fs, args)
+	if !ok {
+		return 2
+	}
+
+	session, err := newAPISession(false)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	job, err := session.client.Get(ctx, jobID)
+	if err != nil {
+		logError("failed to get video: %v", err)
+		return 1
+	}
+	if job.Status == "completed" || job.Status == "failed" {
+		logError("job %s already %s; use delete to remove it", job.ID, job.Status)
+		return 1
+	}
+	if err := session.client.Delete(ctx, job.ID); err != nil {
+		logError("failed to cancel job: %v", err)
+		return 1
+	}
+	logInfo("Cancelled job %s", job.ID)
+	updateHistoryOrWarn(job.ID, false, func(e *historyEntry) {
+		e.Status = "cancelled"
+		e.FinishedAt = time.Now()
+	})
+	return 0
+}
+
+func runDeleteCommand(args []string) int {
+	fs := newCommandFlagSet("delete")
+	assumeYes := fs.Bool("yes", false, "skip the confirmation prompt")
+	local := fs.Bool("local", false, "move the downloaded files and history records to the trash instead of deleting the videos on the server")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli delete [--yes] [--local] <video-id>...")
+		return 2
+	}
+	jobIDs := fs.Args()
+	if *local {
+		return runDeleteLocal(jobIDs, *assumeYes)
+	}
+
+	session, err := newAPISession(*assumeYes)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	label := "video " + jobIDs[0]
+	if len(jobIDs) > 1 {
+		label = fmt.Sprintf("%d videos (%s)", len(jobIDs), strings.Join(jobIDs, ", "))
+	}
+	if !*assumeYes && !promptConfirm(session.reader, os.Stdout, fmt.Sprintf("Delete %s? This cannot be undone", label)) {
+		logInfo("Aborted.")
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	failed := 0
+	for _, jobID := range jobIDs {
+		if err := session.client.Delete(ctx, jobID); err != nil {
+			logError("failed to delete video %s: %v", jobID, err)
+			failed++
+			continue
+		}
+		logInfo("Deleted video %s", jobID)
+		updateHistoryOrWarn(jobID, false, func(e *historyEntry) { e.DeletedAt = time.Now() })
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runDeleteLocal moves the local copies and records of jobIDs to the trash,
+// leaving the videos on the server alone.
+func runDeleteLocal(jobIDs []string, assumeYes bool) int {
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	keep, err := cfg.Trash.keep()
+	if err != nil {
+		logError("trash.keep: %v", err)
+		return 1
+	}
+	purgeExpiredTrash(keep)
+	label := "video " + jobIDs[0]
+	if len(jobIDs) > 1 {
+		label = fmt.Sprintf("%d videos (%s)", len(jobIDs), strings.Join(jobIDs, ", "))
+	}
+	if !assumeYes && !promptConfirm(bufio.NewReader(os.Stdin), os.Stdout, fmt.Sprintf("Move the local files and records of %s to the trash?", label)) {
+		logInfo("Aborted.")
+		return 1
+	}
+	record, err := trashJobs(jobIDs, "delete --local "+strings.Join(jobIDs, " "))
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	logInfo("Moved %s to the trash.", record.summary())
+	reportTrashed(record, keep)
+	return 0
+}
+
+// runAuditRemoteCommand reconciles local history with the account, for
+// videos made in the web UI or on another machine and for records whose video
+// has gone.
+func runAuditRemoteCommand(args []string) int {
+	fs := newCommandFlagSet("audit-remote")
+	importRemote := fs.Bool("import", false, "add remote videos without a local record to history")
+	jsonOutput := fs.Bool("json", false, "print the differences as JSON on stdout")
+	registerFormatFlag(fs, jsonOutput)
+	fs.BoolVar(&displayUTC, "utc", false, "show timestamps in UTC instead of the local time zone")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli audit-remote [--import] [--json]")
+		return 2
+	}
+	if *jsonOutput {
+		enableJSONOutput()
+	}
+
+	session, err := newAPISession(false)
+	if err != nil {
+		logError("%v", err)
+		emitJSONError(err, "")
+		return 1
+	}
+	state, err := loadHistory()
+	if err != nil {
+		logError("%v", err)
+		emitJSONError(err, "")
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	audit, err := auditHistory(ctx, session.client, state)
+	if err != nil {
+		err = fmt.Errorf("failed to list videos: %w", err)
+		logError("%v", err)
+		emitJSONError(err, "")
+		return 1
+	}
+
+	if len(audit.RemoteOnly) == 0 {
+		fmt.Println("Every video in the account has a local record.")
+	} else {
+		fmt.Printf("Remote videos with no local record (%d):\n", len(audit.RemoteOnly))
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tSTATUS\tCREATED\tMODEL\tPROMPT")
+		for _, video := range audit.RemoteOnly {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", video.ID, video.Status, formatUnixTimestamp(video.CreatedAt), video.Model, truncateText(video.Prompt, 50))
+		}
+		tw.Flush()
+	}
+	fmt.Println()
+	if len(audit.LocalOnly) == 0 {
+		fmt.Println("Every local record is still listed by the API.")
+	} else {
+		fmt.Printf("Local records missing from the API, likely expired or deleted elsewhere (%d):\n", len(audit.LocalOnly))
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tSTATUS\tCREATED\tSOURCE\tOUTPUT")
+		for _, entry := range audit.LocalOnly {
+			output := entry.OutputPath
+			if output == "" {
+				output = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", entry.JobID, entry.Status, formatTimestamp(entry.CreatedAt), entry.Source, output)
+		}
+		tw.Flush()
+	}
+
+	if *importRemote && len(audit.RemoteOnly) > 0 {
+		for i := range audit.RemoteOnly {
+			recordJobHistory(&audit.RemoteOnly[i], "import", nil)
+		}
+		logBlankLine()
+		logInfo("Imported %d video(s) into history.", len(audit.RemoteOnly))
+	}
+	emitJSON(audit)
+	return 0
+}
+
+// runGCCommand frees remote storage for videos that are safely on disk. Each
+// local copy is verified first; anything that fails verification is kept.
+func runGCCommand(args []string) int {
+	fs := newCommandFlagSet("gc")
+	olderThan := fs.Duration("older-than", 0, "only videos downloaded at least this long ago, e.g. 72h")
+	dryRun := fs.Bool("dry-run", false, "verify and list what would be deleted without deleting")
+	assumeYes := fs.Bool("yes", false, "skip the confirmation prompt")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli gc [--older-than duration] [--dry-run] [--yes]")
+		return 2
+	}
+
+	state, err := loadHistory()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	cutoff := time.Now().Add(-*olderThan)
+	var verified []*historyEntry
+	kept := 0
+	for _, entry := range state.Entries {
+		if entry.Status != "completed" || !entry.DeletedAt.IsZero() || entry.FinishedAt.After(cutoff) {
+			continue
+		}
+		if err := entry.verifyLocalCopy(); err != nil {
+			logInfo("Keeping %s: %v", entry.JobID, err)
+			kept++
+			continue
+		}
+		verified = append(verified, entry)
+	}
+	if len(verified) == 0 {
+		logInfo("Nothing to clean up.")
+		return 0
+	}
+
+	var total int64
+	for _, entry := range verified {
+		total += entry.OutputBytes
+	}
+	if *dryRun {
+		for _, entry := range verified {
+			logInfo("Would delete %s (local copy %s verified)", entry.JobID, entry.OutputPath)
+		}
+		logInfo("%d remote video(s), %s locally verified; %d kept.", len(verified), formatBytes(total), kept)
+		return 0
+	}
+
+	session, err := newAPISession(*assumeYes)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	if !*assumeYes && !promptConfirm(session.reader, os.Stdout, fmt.Sprintf("Delete the remote copies of %d verified video(s)? Local files are kept", len(verified))) {
+		logInfo("Aborted.")
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	failed := 0
+	for _, entry := range verified {
+		err := session.client.Delete(ctx, entry.JobID)
+		var apiErr *sora.APIError
+		if err != nil && !(errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound) {
+			logError("failed to delete video %s: %v", entry.JobID, err)
+			failed++
+			continue
+		}
+		logInfo("Deleted remote copy of %s", entry.JobID)
+		updateHistoryOrWarn(entry.JobID, false, func(e *historyEntry) { e.DeletedAt = time.Now() })
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runLogsCommand prints the tail of the activity log and, with -f, keeps
+// printing new events until interrupted.
+func runLogsCommand(args []string) int {
+	fs := newCommandFlagSet("logs")
+	follow := fs.Bool("f", false, "keep printing new events until interrupted")
+	lines := fs.Int("n", 20, "number of recent events to show first (0 for all)")
+	jobID := fs.String("job", "", "only show events for this job ID")
+	level := fs.String("level", "info", "minimum level to show: "+strings.Join(activityLevels, ", "))
+	jsonOutput := fs.Bool("json", false, "print events as JSON lines on stdout")
+	registerFormatFlag(fs, jsonOutput)
+	fs.BoolVar(&displayUTC, "utc", false, "show timestamps in UTC instead of the local time zone")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	minLevel := activityLevelIndex(*level)
+	if fs.NArg() > 0 || minLevel < 0 || *lines < 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli logs [-f] [-n lines] [--job id] [--level info|warn|error] [--json]")
+		return 2
+	}
+	if *jsonOutput {
+		enableJSONOutput()
+	}
+	filter := activityFilter{JobID: *jobID, MinLevel: minLevel}
+
+	path, err := activityLogPath()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	var (
+		events []activityEvent
+		offset int64
+	)
+	f, err := os.Open(path)
+	switch {
+	case err == nil:
+		events, offset, err = readActivity(f, filter)
+		f.Close()
+		if err != nil {
+			logError("read %s: %v", path, err)
+			return 1
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		logError("%v", err)
+		return 1
+	case !*follow:
+		logInfo("No activity recorded yet.")
+		return 0
+	}
+	if *lines > 0 && len(events) > *lines {
+		events = events[len(events)-*lines:]
+	}
+	for _, event := range events {
+		printActivity(event, *jsonOutput)
+	}
+	if !*follow {
+		return 0
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := followActivity(ctx, path, offset, filter, *jsonOutput); err != nil {
+		logError("%v", err)
+		return 1
+	}
+	return 0
+}
+
+func runExportCommand(args []string) int {
+	fs := newCommandFlagSet("export")
+	queueName := fs.String("queue", "", "export the completed items of a named queue")
+	all := fs.Bool("all", false, "with --queue, include items that were already exported")
+	csvPath := fs.String("csv", "", "write a CSV file (- for stdout) instead of posting to the DAM")
+	upload := fs.Bool("upload", false, "upload the MP4 files along with the metadata (default dam.upload_files)")
+	dir := fs.String("dir", "", "directory holding downloaded videos (default defaults.destination or the current directory)")
+	format := fs.String("format", "", "write an index of the whole library as csv or json instead")
+	out := fs.String("out", "", "with --format, file to write (default: stdout)")
+	includeLocal := fs.Bool("include-local", false, "with --format, also list jobs in history that the API no longer has")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if *format != "" {
+		if *queueName != "" || fs.NArg() > 0 || *csvPath != "" || *upload || *all {
+			logError("--format exports the whole library and cannot be combined with --queue, video IDs, --csv, --upload or --all")
+			return 2
+		}
+		return runLibraryExport(*format, *out, *dir, *includeLocal)
+	}
+	if *out != "" || *includeLocal {
+		logError("--out and --include-local need --format")
+		return 2
+	}
+	if (*queueName == "") == (fs.NArg() == 0) {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli export [flags] (--queue <name> | <video-id>... | --format csv|json [--out file])")
+		return 2
+	}
+
+	var (
+		cfg     *resolvedConfig
+		records []assetRecord
+		items   []*queueItem
+		store   *queueStore
+		err     error
+	)
+	if *queueName != "" {
+		if cfg, err = loadCommandConfig(); err != nil {
+			logError("%v", err)
+			return 1
+		}
+		if !isValidQueueName(*queueName) {
+			logError("invalid queue name %q", *queueName)
+			return 2
+		}
+		if store, err = openQueueStore(*queueName); err != nil {
+			logError("%v", err)
+			return 1
+		}
+		for _, item := range store.state.Items {
+			if item.Status != queueItemCompleted || (!item.ExportedAt.IsZero() && !*all) {
+				continue
+			}
+			if err := cfg.Review.checkApproved(item.JobID); err != nil {
+				logWarn("skipping item %d: %v", item.ID, err)
+				continue
+			}
+			items = append(items, item)
+			records = append(records, item.assetRecord(nil))
+		}
+	} else {
+		session, err := newAPISession(false)
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+		cfg = session.cfg
+		localDir := *dir
+		if localDir == "" {
+			localDir = cfg.Defaults.Destination
+		}
+		if localDir, err = expandPath(localDir); err != nil {
+			logError("%v", err)
+			return 1
+		}
+		state, err := loadHistory()
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		for _, jobID := range fs.Args() {
+			job, err := session.client.Get(ctx, jobID)
+			if err != nil {
+				logError("failed to get %s: %v", jobID, err)
+				return 1
+			}
+			if job.Status != "completed" {
+				logError("%s is %s; only completed renders can be exported", job.ID, job.Status)
+				return 1
+			}
+			if err := cfg.Review.checkApproved(job.ID); err != nil {
+				logError("%v", err)
+				return 1
+			}
+			// With defaults.layout the file sits in a dated folder, which
+			// history recorded.
+			outputPath := filepath.Join(localDir, job.ID+".mp4")
+			if _, err := os.Stat(outputPath); err != nil {
+				outputPath = ""
+				if entry := state.find(job.ID); entry != nil && entry.OutputPath != "" {
+					if _, err := os.Stat(entry.OutputPath); err == nil {
+						outputPath = entry.OutputPath
+					}
+				}
+			}
+			records = append(records, assetRecordFromJob(job, outputPath))
+		}
+	}
+
+	if len(records) == 0 {
+		logInfo("Nothing to export.")
+		return 0
+	}
+
+	if *csvPath != "" {
+		out := os.Stdout
+		if *csvPath != "-" {
+			file, err := os.Create(*csvPath)
+			if err != nil {
+				logError("%v", err)
+				return 1
+			}
+			defer file.Close()
+			out = file
+		}
+		if err := writeAssetCSV(out, cfg.DAM, records); err != nil {
+			logError("write CSV: %v", err)
+			return 1
+		}
+		if *csvPath != "-" {
+			logInfo("Wrote %d record(s) to %s", len(records), *csvPath)
+		}
+		return 0
+	}
+
+	client := &http.Client{Timeout: damUploadTimeout}
+	failed := 0
+	for i, record := range records {
+		ctx, cancel := context.WithTimeout(context.Background(), damUploadTimeout)
+		err := exportAsset(ctx, client, cfg.DAM, record, *upload || cfg.DAM.UploadFiles)
+		cancel()
+		if err != nil {
+			logError("%s: %v", record.JobID, err)
+			failed++
+			continue
+		}
+		logInfo("Registered %s in the DAM", record.JobID)
+		if store != nil {
+			if err := store.update(items[i], func(it *queueItem) { it.ExportedAt = time.Now() }); err != nil {
+				logWarn("unable to save queue state: %v", err)
+			}
+		}
+	}
+	if failed > 0 {
+		logInfo("%d of %d export(s) failed.", failed, len(records))
+		return 1
+	}
+	return 0
+}
+
+func runBatchCommand(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "plan":
+			return runBatchPlanCommand(args[1:])
+		case "apply":
+			return runBatchRun("batch apply", args[1:])
+		}
+	}
+	return runBatchRun("batch", args)
+}
+
+// runBatchRun renders a prompts file or stream. As "batch apply" it only
+// renders the lines the plan marks for creation, after confirmation.
+func runBatchRun(name string, args []string) int {
+	apply := name == "batch apply"
+	fs := newCommandFlagSet(name)
+	registerMaxWaitFlag(fs)
+	stdinNDJSON := fs.Bool("stdin-ndjson", false, "read job specs as NDJSON from stdin until it is closed")
+	file := fs.String("file", "", "read job specs from a JSONL prompts file")
+	concurrency := fs.Int("concurrency", 1, "maximum number of jobs in flight")
+	budget := fs.Float64("budget", 0, "stop submitting once the estimated spend would exceed this many USD (0 = no limit)")
+	out := fs.String("out", "", "destination directory (default: defaults.destination or the current directory)")
+	jsonOutput := fs.Bool("json", false, "print one JSON result per job on stdout and progress on stderr")
+	registerFormatFlag(fs, jsonOutput)
+	deferOffPeak := fs.Bool("defer-until-off-peak", false, "hold submissions until the configured off_peak window is open")
+	statusGateMode := fs.String("status-gate", "", "check the OpenAI status page before each submission: off, warn or hold (default status.gate)")
+	var extras extraFlags
+	extras.register(fs)
+	dryRun := fs.Bool("dry-run", false, "validate every spec and print the requests and their cost without calling the API")
+	var assumeYes *bool
+	if apply {
+		assumeYes = fs.Bool("yes", false, "skip the confirmation prompt")
+	}
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if apply && (*file == "" || *stdinNDJSON || fs.NArg() > 0) {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli batch apply --file prompts.jsonl [--yes] [--dry-run] [--concurrency n] [--budget usd] [--defer-until-off-peak] [--status-gate mode] [--out dir] [--json]")
+		return 2
+	}
+	if *stdinNDJSON == (*file != "") || fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli batch (--file prompts.jsonl | --stdin-ndjson) [--dry-run] [--concurrency n] [--budget usd] [--defer-until-off-peak] [--status-gate mode] [--out dir] [--json]")
+		return 2
+	}
+	if *concurrency < 1 {
+		logError("--concurrency must be at least 1")
+		return 2
+	}
+	if *budget < 0 {
+		logError("--budget must not be negative")
+		return 2
+	}
+	if *jsonOutput {
+		enableJSONOutput()
+	}
+
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	if err := extras.validate(cfg); err != nil {
+		logError("%v", err)
+		return 2
+	}
+	if cfg.APIKey == "" && !*dryRun {
+		logError("OPENAI_API_KEY not found in environment, OPENAI_API_KEY_FILE, .env or config file")
+		return 1
+	}
+	destination := *out
+	if destination == "" {
+		destination = cfg.Defaults.Destination
+	}
+
+	opts := batchOptions{
+		Concurrency: *concurrency,
+		Budget:      newBudgetGuard(cfg.Budget, *budget),
+		Destination: destination,
+		Extras:      extras.outputs(cfg),
+	}
+	if *deferOffPeak {
+		window, err := cfg.OffPeak.window()
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+		opts.OffPeak = &window
+	}
+	if opts.Status, err = newStatusGate(cfg.Status, *statusGateMode); err != nil {
+		logError("%v", err)
+		return 2
+	}
+	input := io.Reader(os.Stdin)
+	source := "stdin"
+	var specFile *os.File
+	if *file != "" {
+		path, err := expandPath(*file)
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+		defer f.Close()
+		input, specFile = f, f
+		source = path
+		opts.BaseDir = filepath.Dir(path)
+	}
+	if *dryRun {
+		return runBatchDryRun(newAPIClient(cfg, cfg.APIKey), cfg, input, opts.BaseDir, apply, opts.Budget)
+	}
+	if *file != "" {
+		// A second run of the same file would submit every line again, and
+		// a second apply would plan before the first one's jobs are recorded.
+		lock, err := lockBatchFile(source)
+		if err != nil {
+			logError("%s is already being rendered: %v", source, err)
+			return 1
+		}
+		defer lock.unlock()
+	}
+	if specFile != nil && !apply {
+		plan, _, err := planBatch(input, cfg.Defaults, opts.BaseDir)
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+		if !checkBatchStorage(cfg, plan.specs(""), false) {
+			return 1
+		}
+		if _, err := specFile.Seek(0, io.SeekStart); err != nil {
+			logError("%v", err)
+			return 1
+		}
+	}
+	if apply {
+		plan, total, err := planBatch(input, cfg.Defaults, opts.BaseDir)
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+		printBatchPlan(os.Stdout, plan)
+		if invalid, _ := plan.count(planInvalid); invalid > 0 {
+			logError("%s has %d invalid line(s); fix them before applying", source, invalid)
+			return 1
+		}
+		create, cost := plan.count(planCreate)
+		if create == 0 {
+			logInfo("Nothing to render.")
+			return 0
+		}
+		// The confirmation below is the one to answer a storage warning.
+		if !checkBatchStorage(cfg, plan.specs(planCreate), true) {
+			return 1
+		}
+		if !*assumeYes {
+			if !stdinIsTerminal() {
+				logError("confirmation needed; pass --yes to apply without a terminal")
+				return 1
+			}
+			if !promptConfirm(bufio.NewReader(os.Stdin), os.Stdout, fmt.Sprintf("Render %d job(s) for an estimated $%.2f?", create, cost)) {
+				logInfo("Aborted.")
+				return 1
+			}
+		}
+		input = strings.NewReader(plan.applyInput(total))
+	}
+
+	client := newAPIClient(cfg, cfg.APIKey)
+	logInfo("Reading job specs from %s (concurrency %d)", source, *concurrency)
+	started := time.Now()
+	ctx, stop := shutdownContext(context.Background())
+	defer stop()
+	result, lines, err := runBatch(ctx, client, cfg, input, opts)
+
+	summary := runSummary{
+		Source:        "Batch",
+		Jobs:          result.Completed + result.Failed,
+		Completed:     result.Completed,
+		Failed:        result.Failed,
+		Skipped:       result.Skipped,
+		EstimatedCost: result.EstimatedCost,
+		OutputBytes:   result.OutputBytes,
+		Budget:        opts.Budget.status(),
+		WallClock:     time.Since(started),
+	}
+	fmt.Println()
+	printBatchReport(os.Stdout, lines)
+	logInfo("%s", summary.text())
+	for _, notifyErr := range notifyRunSummary(context.Background(), cfg.Notifications, summary) {
+		logWarn("%v", notifyErr)
+	}
+	if interrupted(ctx) {
+		reportInterrupted(started)
+		return 130
+	}
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	if result.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// queueNameArg splits the queue name off args. A help flag in its place is
+// left in the remaining arguments, with an empty name, so the caller's flag
+// set prints the command's help.
+func queueNameArg(args []string, usage string) (string, []string, bool) {
+	if len(args) > 0 && isHelpArg(args[0]) {
+		return "", args, true
+	}
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprintln(os.Stderr, usage)
+		return "", nil, false
+	}
+	return args[0], args[1:], true
+}
+
+func isHelpArg(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+const queueUsage = "usage: sora2cli queue <list|add|show|approve|remove|run> [flags]"
+
+func runQueueCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, queueUsage)
+		return 2
+	}
+	if isHelpArg(args[0]) {
+		return runHelpCommand([]string{"queue"})
+	}
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	switch args[0] {
+	case "list":
+		return runQueueList(cfg)
+	case "add":
+		return runQueueAdd(cfg, args[1:])
+	case "show":
+		return runQueueShow(cfg, args[1:])
+	case "approve":
+		return runQueueApprove(cfg, args[1:])
+	case "remove":
+		return runQueueRemove(cfg, args[1:])
+	case "run":
+		return runQueueRun(cfg, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown queue command %q\n", args[0])
+		fmt.Fprintln(os.Stderr, queueUsage)
+		return 2
+	}
+}
+
+func runQueueList(cfg *resolvedConfig) int {
+	names := make(map[string]bool)
+	for name := range cfg.Queues {
+		names[name] = true
+	}
+	if dir, err := resolveDataDir(); err == nil {
+		matches, _ := filepath.Glob(filepath.Join(dir, "queues", "*.json"))
+		for _, match := range matches {
+			names[strings.TrimSuffix(filepath.Base(match), ".json")] = true
+		}
+	}
+	if len(names) == 0 {
+		logInfo("No queues configured. Add a queues section to the config file or run 'sora2cli queue add <name>'.")
+		return 0
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "QUEUE\tMODEL\tCONCURRENCY\tBUDGET\tAPPROVAL\tPENDING\tDONE\tFAILED\tSPENT")
+	for _, name := range sorted {
+		q, err := resolveQueue(cfg, name)
+		if err != nil {
+			continue
+		}
+		store, err := openQueueStore(name)
+		if err != nil {
+			logWarn("%v", err)
+			continue
+		}
+		counts := store.counts()
+		budget := "-"
+		if q.Budget > 0 {
+			budget = fmt.Sprintf("$%.2f", q.Budget)
+		}
+		approval := "no"
+		if q.RequireApproval {
+			approval = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%d\t%d\t%d\t$%.2f\n", name, q.Model, q.Concurrency, budget, approval,
+			counts[queueItemPending]+counts[queueItemApproved], counts[queueItemCompleted], counts[queueItemFailed], store.spent())
+	}
+	tw.Flush()
+	return 0
+}
+
+func runQueueAdd(cfg *resolvedConfig, args []string) int {
+	name, args, ok := queueNameArg(args, "usage: sora2cli queue add <name> --prompt <text> [--model m] [--seconds n] [--size WxH] [--reference path]")
+	if !ok {
+		return 2
+	}
+	var q queueSettings
+	if name != "" {
+		var err error
+		if q, err = resolveQueue(cfg, name); err != nil {
+			logError("%v", err)
+			return 1
+		}
+	}
+	fs := newCommandFlagSet("queue add")
+	prompt := fs.String("prompt", "", "prompt text (required)")
+	modelName := fs.String("model", q.Model, "model to use")
+	seconds := fs.Int("seconds", q.Seconds, "clip duration in seconds")
+	size := fs.String("size", q.Size, "output resolution, e.g. 1280x720")
+	var references stringList
+	fs.Var(&references, "reference", "optional reference image or video; repeat for several")
+	ticket := fs.String("ticket", "", "Jira/Linear issue key (or Jira project key) to update when the item finishes")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if err := validateTicketKey(*ticket); err != nil {
+		logError("%v", err)
+		return 2
+	}
+	if strings.TrimSpace(*prompt) == "" {
+		logError("--prompt is required")
+		return 2
+	}
+
+	spec := jobSpec{Prompt: *prompt, Model: *modelName, Seconds: *seconds, Size: *size, References: references, Ticket: *ticket}
+	model, err := spec.resolve(defaultsConfig{})
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+
+	store, err := openQueueStore(q.Name)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	item := &queueItem{
+		Prompt:        spec.Prompt,
+		Model:         spec.Model,
+		Seconds:       spec.Seconds,
+		Size:          spec.Size,
+		References:    spec.References,
+		Ticket:        spec.Ticket,
+		Status:        queueItemPending,
+		EstimatedCost: model.RatePerSecond * float64(spec.Seconds),
+		AddedAt:       time.Now(),
+	}
+	if err := store.add(item); err != nil {
+		logError("unable to save queue: %v", err)
+		return 1
+	}
+	logInfo("Added item #%d to queue %s (%s, %ds, %s, est. $%.2f)", item.ID, q.Name, item.Model, item.Seconds, item.Size, item.EstimatedCost)
+	if q.RequireApproval {
+		logInfo("Queue %s requires approval: run 'sora2cli queue approve %s %d' before it is submitted.", q.Name, q.Name, item.ID)
+	}
+	return 0
+}
+
+func runQueueShow(cfg *resolvedConfig, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli queue show <name>")
+		return 2
+	}
+	q, err := resolveQueue(cfg, args[0])
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	store, err := openQueueStore(q.Name)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	if len(store.state.Items) == 0 {
+		logInfo("Queue %s is empty.", q.Name)
+		return 0
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATUS\tMODEL\tSECONDS\tSIZE\tCOST\tJOB\tPROMPT")
+	for _, item := range store.state.Items {
+		jobID := item.JobID
+		if jobID == "" {
+			jobID = "-"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t$%.2f\t%s\t%s\n", item.ID, item.Status, item.Model, item.Seconds, item.Size, item.EstimatedCost, jobID, truncateText(item.Prompt, 50))
+	}
+	tw.Flush()
+	return 0
+}
+
+func parseQueueItemIDs(store *queueStore, args []string, all bool, match func(*queueItem) bool) ([]*queueItem, error) {
+	if all {
+		var items []*queueItem
+		for _, item := range store.state.Items {
+			if match(item) {
+				items = append(items, item)
+			}
+		}
+		return items, nil
+	}
+	var items []*queueItem
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid item id %q", arg)
+		}
+		item := store.find(id)
+		if item == nil {
+			return nil, fmt.Errorf("no item #%d in queue", id)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func runQueueApprove(cfg *resolvedConfig, args []string) int {
+	fs := newCommandFlagSet("queue approve")
+	all := fs.Bool("all", false, "approve every pending item")
+	name, args, ok := queueNameArg(args, "usage: sora2cli queue approve <name> [--all] [item-id...]")
+	if !ok {
+		return 2
+	}
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if !*all && fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli queue approve <name> [--all] [item-id...]")
+		return 2
+	}
+	q, err := resolveQueue(cfg, name)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	store, err := openQueueStore(q.Name)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	items, err := parseQueueItemIDs(store, fs.Args(), *all, func(item *queueItem) bool { return item.Status == queueItemPending })
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	approved := 0
+	err = store.modify(func() error {
+		for _, item := range items {
+			switch {
+			case store.find(item.ID) != item:
+				logWarn("item #%d is no longer in the queue", item.ID)
+			case item.Status != queueItemPending:
+				logWarn("item #%d is %s; only pending items can be approved", item.ID, item.Status)
+			default:
+				item.Status = queueItemApproved
+				approved++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logError("unable to save queue: %v", err)
+		return 1
+	}
+	logInfo("Approved %d item(s) in queue %s.", approved, q.Name)
+	return 0
+}
+
+func runQueueRemove(cfg *resolvedConfig, args []string) int {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli queue remove <name> <item-id...>")
+		return 2
+	}
+	q, err := resolveQueue(cfg, args[0])
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	store, err := openQueueStore(q.Name)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	items, err := parseQueueItemIDs(store, args[1:], false, nil)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	keep, err := cfg.Trash.keep()
+	if err != nil {
+		logError("trash.keep: %v", err)
+		return 1
+	}
+	purgeExpiredTrash(keep)
+	record := newTrashRecord("queue remove " + strings.Join(args, " "))
+	record.Queue = q.Name
+	defer func() {
+		if record.empty() {
+			return
+		}
+		if err := record.save(); err != nil {
+			logWarn("unable to keep the removed items for undo: %v", err)
+			return
+		}
+		reportTrashed(record, keep)
+	}()
+	for _, item := range items {
+		removed, err := store.remove(item.ID, func(it *queueItem) bool {
+			if it.Status == queueItemRunning {
+				return true
+			}
+			copied := *it
+			record.QueueItems = append(record.QueueItems, &copied)
+			return false
+		})
+		if err != nil {
+			logError("unable to save queue: %v", err)
+			return 1
+		}
+		if !removed {
+			if store.find(item.ID) == nil {
+				logWarn("item #%d is no longer in the queue", item.ID)
+			} else {
+				logWarn("item #%d is running; leaving it in place", item.ID)
+			}
+			continue
+		}
+		logInfo("Removed item #%d from queue %s.", item.ID, q.Name)
+	}
+	return 0
+}
+
+func runQueueRun(cfg *resolvedConfig, args []string) int {
+	name, args, ok := queueNameArg(args, "usage: sora2cli queue run <name> [--concurrency n] [--defer-until-off-peak] [--status-gate mode]")
+	if !ok {
+		return 2
+	}
+	var q queueSettings
+	if name != "" {
+		var err error
+		if q, err = resolveQueue(cfg, name); err != nil {
+			logError("%v", err)
+			return 1
+		}
+	}
+	fs := newCommandFlagSet("queue run")
+	registerMaxWaitFlag(fs)
+	concurrency := fs.Int("concurrency", q.Concurrency, "maximum number of jobs in flight (overrides the queue setting)")
+	deferOffPeak := fs.Bool("defer-until-off-peak", false, "hold submissions until the configured off_peak window is open")
+	statusGateMode := fs.String("status-gate", "", "check the OpenAI status page before each submission: off, warn or hold (default status.gate)")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli queue run <name> [--concurrency n] [--defer-until-off-peak] [--status-gate mode]")
+		return 2
+	}
+	if *deferOffPeak {
+		window, err := cfg.OffPeak.window()
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+		q.OffPeak = &window
+	}
+	if *statusGateMode != "" {
+		status, err := newStatusGate(cfg.Status, *statusGateMode)
+		if err != nil {
+			logError("%v", err)
+			return 2
+		}
+		q.Status = status
+	}
+	if *concurrency < 1 {
+		logError("--concurrency must be at least 1")
+		return 2
+	}
+	q.Concurrency = *concurrency
+	if cfg.APIKey == "" {
+		logError("OPENAI_API_KEY not found in environment, OPENAI_API_KEY_FILE, .env or config file")
+		return 1
+	}
+	lockPath, err := runLockPath("queue", q.Name)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	lock, err := tryLockFile(lockPath)
+	if err != nil {
+		logError("queue %s is already running: %v", q.Name, err)
+		return 1
+	}
+	defer lock.unlock()
+	store, err := openQueueStore(q.Name)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+
+	runnable := store.runnable(q.RequireApproval)
+	if len(runnable) == 0 {
+		counts := store.counts()
+		if q.RequireApproval && counts[queueItemPending] > 0 {
+			logInfo("Queue %s has %d item(s) awaiting approval.", q.Name, counts[queueItemPending])
+		} else {
+			logInfo("Queue %s has nothing to run.", q.Name)
+		}
+		return 0
+	}
+
+	client := newAPIClient(cfg, cfg.APIKey)
+	q.Spend = newBudgetGuard(cfg.Budget, 0)
+	logInfo("Running %d item(s) from queue %s (concurrency %d)", len(runnable), q.Name, q.Concurrency)
+	started := time.Now()
+	ctx, stop := shutdownContext(context.Background())
+	defer stop()
+	result, err := runQueue(ctx, client, q, store)
+
+	summary := runSummary{
+		Source:        "Queue " + q.Name,
+		Jobs:          result.Completed + result.Failed,
+		Completed:     result.Completed,
+		Failed:        result.Failed,
+		Skipped:       result.Skipped,
+		Remaining:     len(store.runnable(false)),
+		EstimatedCost: result.EstimatedCost,
+		OutputBytes:   result.OutputBytes,
+		Budget:        q.Spend.status(),
+		WallClock:     time.Since(started),
+	}
+	logInfo("%s", summary.text())
+	for _, notifyErr := range notifyRunSummary(context.Background(), cfg.Notifications, summary) {
+		logWarn("%v", notifyErr)
+	}
+	if interrupted(ctx) {
+		reportInterrupted(started)
+		return 130
+	}
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	if result.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func truncateText(text string, limit int) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	if len(runes) <= limit {
+		return string(runes)
+	}
+	return string(runes[:limit-3]) + "..."
+}
@@ -0,0 +1,1131 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	configDirName         = "sora2cli"
+	configFileName        = "config.yaml"
+	projectConfigFileName = ".sora2cli.yaml"
+)
+
+type config struct {
+	APIKey        string   `yaml:"api_key,omitempty" env:"OPENAI_API_KEY" secret:"true"`
+	BaseURL       string   `yaml:"base_url,omitempty" env:"OPENAI_BASE_URL"`
+	BaseURLs      []string `yaml:"base_urls,omitempty" env:"OPENAI_BASE_URLS"`
+	OrgID         string   `yaml:"org_id,omitempty" env:"OPENAI_ORG_ID"`
+	ProjectID     string   `yaml:"project_id,omitempty" env:"OPENAI_PROJECT_ID"`
+	ProgressScale string   `yaml:"progress_scale,omitempty" env:"SORA2_PROGRESS_SCALE"`
+	LogFormat     string   `yaml:"log_format,omitempty" env:"SORA2_LOG_FORMAT"`
+	// LogFile, if set, also receives every log record in the log format.
+	LogFile string `yaml:"log_file,omitempty" env:"SORA2_LOG_FILE"`
+	// LogLevel is the least severe level logged: debug, info (the default),
+	// warn or error.
+	LogLevel string `yaml:"log_level,omitempty" env:"SORA2_LOG_LEVEL"`
+	// TemplatesDir holds the prompt templates; by default templates/ in the
+	// config directory.
+	TemplatesDir string `yaml:"templates_dir,omitempty" env:"SORA2_TEMPLATES_DIR"`
+	// Profile names the entry of Profiles to use when no --profile is given.
+	Profile       string                   `yaml:"profile,omitempty" env:"SORA2_PROFILE"`
+	Profiles      map[string]profileConfig `yaml:"profiles,omitempty"`
+	Defaults      defaultsConfig           `yaml:"defaults,omitempty"`
+	Queues        map[string]queueConfig   `yaml:"queues,omitempty"`
+	Notifications notificationsConfig      `yaml:"notifications,omitempty"`
+	Tickets       ticketsConfig            `yaml:"tickets,omitempty"`
+	DAM           damConfig                `yaml:"dam,omitempty"`
+	Dedupe        dedupeConfig             `yaml:"dedupe,omitempty"`
+	OffPeak       offPeakConfig            `yaml:"off_peak,omitempty"`
+	Retry         retryConfig              `yaml:"retry,omitempty"`
+	Poll          pollConfig               `yaml:"poll,omitempty"`
+	Network       networkConfig            `yaml:"network,omitempty"`
+	Trim          trimConfig               `yaml:"trim,omitempty"`
+	Grade         gradeConfig              `yaml:"grade,omitempty"`
+	Interpolate   interpolateConfig        `yaml:"interpolate,omitempty"`
+	Encode        encodeConfig             `yaml:"encode,omitempty"`
+	Budget        budgetConfig             `yaml:"budget,omitempty"`
+	Storage       storageConfig            `yaml:"storage,omitempty"`
+	Enhance       enhanceConfig            `yaml:"enhance,omitempty"`
+	Captions      captionsConfig           `yaml:"captions,omitempty"`
+	Review        reviewConfig             `yaml:"review,omitempty"`
+	Share         shareConfig              `yaml:"share,omitempty"`
+	Server        serverConfig             `yaml:"server,omitempty"`
+	Trash         trashConfig              `yaml:"trash,omitempty"`
+	Status        statusConfig             `yaml:"status,omitempty"`
+}
+
+type queueConfig struct {
+	Model           string  `yaml:"model,omitempty"`
+	Seconds         int     `yaml:"seconds,omitempty"`
+	Size            string  `yaml:"size,omitempty"`
+	Destination     string  `yaml:"destination,omitempty"`
+	Concurrency     int     `yaml:"concurrency,omitempty"`
+	Budget          float64 `yaml:"budget,omitempty"`
+	RequireApproval bool    `yaml:"require_approval,omitempty"`
+}
+
+type defaultsConfig struct {
+	Model       string `yaml:"model,omitempty" env:"SORA2_MODEL"`
+	Seconds     int    `yaml:"seconds,omitempty" env:"SORA2_SECONDS"`
+	Size        string `yaml:"size,omitempty" env:"SORA2_SIZE"`
+	Destination string `yaml:"destination,omitempty" env:"SORA2_OUT_DIR"`
+	// Layout arranges the downloads in folders under the destination, e.g.
+	// {yyyy}/{mm}/{dd}/{project}.
+	Layout string `yaml:"layout,omitempty" env:"SORA2_OUT_LAYOUT"`
+	// Project fills in {project} for jobs without a project: tag.
+	Project string `yaml:"project,omitempty" env:"SORA2_PROJECT"`
+	// WithThumbnail and WithSpritesheet also fetch those images next to
+	// every downloaded MP4.
+	WithThumbnail   bool `yaml:"with_thumbnail,omitempty" env:"SORA2_WITH_THUMBNAIL"`
+	WithSpritesheet bool `yaml:"with_spritesheet,omitempty" env:"SORA2_WITH_SPRITESHEET"`
+	// WriteSidecar writes <id>.json with the job's metadata next to every
+	// downloaded MP4.
+	WriteSidecar bool `yaml:"write_sidecar,omitempty" env:"SORA2_WRITE_SIDECAR"`
+	// Container also saves every downloaded MP4 as mov, mkv or webm.
+	Container string `yaml:"container,omitempty" env:"SORA2_OUTPUT_CONTAINER"`
+}
+
+type resolvedConfig struct {
+	config
+	Path        string
+	ProjectPath string
+	Sources     map[string]string
+}
+
+type configIssue struct {
+	Key     string
+	Line    int
+	Message string
+}
+
+func (i configIssue) String() string {
+	var b strings.Builder
+	if i.Line > 0 {
+		fmt.Fprintf(&b, "line %d: ", i.Line)
+	}
+	if i.Key != "" {
+		fmt.Fprintf(&b, "%s: ", i.Key)
+	}
+	b.WriteString(i.Message)
+	return b.String()
+}
+
+func defaultConfig() config {
+	return config{
+		BaseURL: defaultBaseURL,
+		Defaults: defaultsConfig{
+			Model:   modelOptions[0].Name,
+			Seconds: defaultDurationSeconds,
+		},
+		Dedupe:  dedupeConfig{WindowMinutes: defaultDedupeWindowMinutes},
+		OffPeak: offPeakConfig{Start: "22:00", End: "06:00"},
+		Retry:   retryConfig{MaxAttempts: sora.DefaultMaxAttempts},
+	}
+}
+
+// xdgBaseDir returns the base directory named by the XDG variable env, or
+// fallback when it is unset. Relative values are ignored, as the XDG Base
+// Directory specification asks.
+func xdgBaseDir(env string, fallback func() (string, error)) (string, error) {
+	if dir := os.Getenv(env); dir != "" && filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	return fallback()
+}
+
+// resolveConfigDir is where config.yaml and the saved .env live:
+// $XDG_CONFIG_HOME/sora2cli, or the platform's user config directory.
+func resolveConfigDir() (string, error) {
+	dir, err := xdgBaseDir("XDG_CONFIG_HOME", os.UserConfigDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, configDirName), nil
+}
+
+// resolveDataDir is where history and queue state live:
+// $XDG_DATA_HOME/sora2cli, or ~/.local/share/sora2cli. On macOS and Windows,
+// which have no separate data directory, it is the config directory.
+func resolveDataDir() (string, error) {
+	dir, err := xdgBaseDir("XDG_DATA_HOME", func() (string, error) {
+		if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+			return os.UserConfigDir()
+		}
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, ".local", "share"), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, configDirName)
+	return migrateLegacyState(dir, historyFileName, "queues"), nil
+}
+
+// resolveCacheDir is where the activity log and crash reports go:
+// $XDG_CACHE_HOME/sora2cli, or the platform's user cache directory.
+func resolveCacheDir() (string, error) {
+	dir, err := xdgBaseDir("XDG_CACHE_HOME", os.UserCacheDir)
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, configDirName)
+	return migrateLegacyState(dir, activityLogName, activityLogName+".1"), nil
+}
+
+// migrateLegacyState moves the named files from the config directory, where
+// older versions kept all state, into dir the first time dir is used. If a
+// move fails, the config directory keeps being used so nothing is lost.
+func migrateLegacyState(dir string, names ...string) string {
+	legacy, err := resolveConfigDir()
+	if err != nil || legacy == dir {
+		return dir
+	}
+	if _, err := os.Stat(filepath.Join(dir, names[0])); err == nil {
+		return dir
+	}
+	if _, err := os.Stat(filepath.Join(legacy, names[0])); err != nil {
+		return dir
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return legacy
+	}
+	// names[0] goes last: until it has moved, the next run tries again.
+	for _, name := range append(names[1:], names[0]) {
+		from := filepath.Join(legacy, name)
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+		if err := os.Rename(from, filepath.Join(dir, name)); err != nil {
+			logWarn("unable to move %s to %s, still using %s: %v", from, dir, legacy, err)
+			return legacy
+		}
+	}
+	return dir
+}
+
+func resolveConfigPath() (string, error) {
+	if path := strings.TrimSpace(os.Getenv("SORA2_CONFIG")); path != "" {
+		return expandPath(path)
+	}
+	dir, err := resolveConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, configFileName), nil
+}
+
+func readConfigNode(path string) (*yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if doc.Kind == 0 {
+		return nil, nil
+	}
+	return &doc, nil
+}
+
+func findProjectConfig(start string) (string, bool) {
+	dir := start
+	for {
+		candidate := filepath.Join(dir, projectConfigFileName)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+func discoverProjectConfig() string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	path, _ := findProjectConfig(cwd)
+	return path
+}
+
+func readConfigFile(path string) (config, error) {
+	var cfg config
+	if path == "" {
+		return cfg, nil
+	}
+	doc, err := readConfigNode(path)
+	if err != nil || doc == nil {
+		return cfg, err
+	}
+	if err := doc.Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func loadConfig(path string) (*resolvedConfig, error) {
+	resolved := &resolvedConfig{
+		config:      defaultConfig(),
+		Path:        path,
+		ProjectPath: discoverProjectConfig(),
+		Sources:     make(map[string]string),
+	}
+
+	if err := overlayConfigFile(resolved, path, "user config"); err != nil {
+		return nil, err
+	}
+	if resolved.ProjectPath != "" {
+		if err := overlayConfigFile(resolved, resolved.ProjectPath, "project config"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnvOverrides(reflect.ValueOf(&resolved.config).Elem(), "", resolved.Sources); err != nil {
+		return nil, err
+	}
+	if err := applyProfile(resolved); err != nil {
+		return nil, err
+	}
+	applyContainerDefaults(resolved)
+	configureLogging(resolved)
+	rememberConfigSecrets(resolved.config)
+	return resolved, nil
+}
+
+// projectConfigKeys are the top-level keys a project config may set: the
+// defaults of its renders, its templates and how output looks. A
+// .sora2cli.yaml comes with whatever folder was cloned or unpacked, so it
+// cannot pick the account, where requests, tokens and files are sent, the
+// budget, or the programs the CLI runs.
+var projectConfigKeys = []string{"defaults", "templates_dir", "profile", "progress_scale", "log_format", "log_level", "poll", "dedupe"}
+
+func isProjectConfigKey(key string) bool {
+	top, _, _ := strings.Cut(key, ".")
+	return slices.Contains(projectConfigKeys, top)
+}
+
+// dropNonProjectKeys removes the keys a project config may not set from doc
+// and reports each one.
+func dropNonProjectKeys(doc *yaml.Node) []configIssue {
+	root := doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+	var issues []configIssue
+	kept := root.Content[:0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key := root.Content[i]
+		if !isProjectConfigKey(key.Value) {
+			issues = append(issues, configIssue{Key: key.Value, Line: key.Line, Message: "not allowed in a project config; set it in the user config or the environment"})
+			continue
+		}
+		kept = append(kept, key, root.Content[i+1])
+	}
+	root.Content = kept
+	return issues
+}
+
+func isRelativePath(path string) bool {
+	return path != "" && !filepath.IsAbs(path) && !strings.HasPrefix(path, "~")
+}
+
+func overlayConfigFile(resolved *resolvedConfig, path, source string) error {
+	doc, err := readConfigNode(path)
+	if err != nil || doc == nil {
+		return err
+	}
+	if source == "project config" {
+		for _, issue := range dropNonProjectKeys(doc) {
+			logWarn("ignoring %s in %s: %s", issue.Key, path, issue.Message)
+		}
+	}
+	var fromFile config
+	if err := doc.Decode(&fromFile); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	if err := doc.Decode(&resolved.config); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	// Relative destinations and template directories in a project file
+	// point into the project, not into whatever subdirectory the CLI
+	// happens to be started from.
+	if source == "project config" {
+		if dest := fromFile.Defaults.Destination; isRelativePath(dest) {
+			resolved.Defaults.Destination = filepath.Join(filepath.Dir(path), dest)
+		}
+		if dir := fromFile.TemplatesDir; isRelativePath(dir) {
+			resolved.TemplatesDir = filepath.Join(filepath.Dir(path), dir)
+		}
+	}
+	for _, entry := range flattenConfig(reflect.ValueOf(fromFile), "") {
+		resolved.Sources[entry.Key] = source
+	}
+	return nil
+}
+
+// configEnvAliases are further names read for a config key's environment
+// variable when it is not set, such as the SORA_ names of the original
+// requests.
+var configEnvAliases = map[string][]string{
+	"SORA2_BUDGET_MONTHLY": {"SORA_BUDGET_MONTHLY"},
+}
+
+func applyEnvOverrides(v reflect.Value, prefix string, sources map[string]string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := joinConfigKey(prefix, yamlFieldName(field))
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyEnvOverrides(v.Field(i), key, sources); err != nil {
+				return err
+			}
+			continue
+		}
+		envName := field.Tag.Get("env")
+		if envName == "" {
+			continue
+		}
+		raw, ok := os.LookupEnv(envName)
+		for _, alias := range configEnvAliases[envName] {
+			if ok && strings.TrimSpace(raw) != "" {
+				break
+			}
+			if raw, ok = os.LookupEnv(alias); ok && strings.TrimSpace(raw) != "" {
+				envName = alias
+			}
+		}
+		if !ok || strings.TrimSpace(raw) == "" {
+			// Secrets can also be read from a mounted file, the way
+			// Docker and Kubernetes secrets are usually provided.
+			fileEnv := envName + "_FILE"
+			path := strings.TrimSpace(os.Getenv(fileEnv))
+			if field.Tag.Get("secret") != "true" || path == "" {
+				continue
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("%s: %w", fileEnv, err)
+			}
+			raw, envName = string(data), fileEnv
+		}
+		if err := setConfigValue(v.Field(i), raw); err != nil {
+			return fmt.Errorf("%s: %w", envName, err)
+		}
+		sources[key] = "env " + envName
+	}
+	return nil
+}
+
+func setConfigValue(v reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", raw)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", raw)
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", raw)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", v.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+type configEntry struct {
+	Key    string
+	Value  reflect.Value
+	Secret bool
+}
+
+func flattenConfig(v reflect.Value, prefix string) []configEntry {
+	var entries []configEntry
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := joinConfigKey(prefix, yamlFieldName(field))
+		fv := v.Field(i)
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			entries = append(entries, flattenConfig(fv, key)...)
+		case reflect.Map:
+			keys := fv.MapKeys()
+			sort.Slice(keys, func(a, b int) bool { return keys[a].String() < keys[b].String() })
+			for _, mk := range keys {
+				elem := fv.MapIndex(mk)
+				entryKey := joinConfigKey(key, mk.String())
+				if elem.Kind() == reflect.Struct {
+					entries = append(entries, flattenConfig(elem, entryKey)...)
+				} else if !elem.IsZero() {
+					entries = append(entries, configEntry{Key: entryKey, Value: elem})
+				}
+			}
+		default:
+			if !fv.IsZero() {
+				entries = append(entries, configEntry{Key: key, Value: fv, Secret: field.Tag.Get("secret") == "true"})
+			}
+		}
+	}
+	return entries
+}
+
+func yamlFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name
+}
+
+func joinConfigKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func formatConfigValue(entry configEntry) string {
+	if entry.Secret {
+		return redactSecret(entry.Value.String())
+	}
+	if entry.Value.Kind() == reflect.Slice {
+		parts := make([]string, entry.Value.Len())
+		for i := range parts {
+			parts[i] = fmt.Sprint(entry.Value.Index(i).Interface())
+		}
+		return strings.Join(parts, ", ")
+	}
+	return fmt.Sprint(entry.Value.Interface())
+}
+
+func redactSecret(value string) string {
+	if len(value) <= 8 {
+		return "****"
+	}
+	return value[:3] + "..." + value[len(value)-4:]
+}
+
+func validateConfigFile(path string) ([]configIssue, error) {
+	doc, err := readConfigNode(path)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, nil
+	}
+
+	root := doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	issues := validateConfigNode(root, reflect.TypeOf(config{}), "")
+
+	var cfg config
+	if err := doc.Decode(&cfg); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return append(issues, configIssue{Message: err.Error()}), nil
+		}
+		// Type errors still leave the remaining fields decoded, so the
+		// value checks below can report further problems in the same run.
+		for _, msg := range typeErr.Errors {
+			issues = append(issues, configIssue{Message: msg})
+		}
+	}
+	return append(issues, validateConfigValues(cfg)...), nil
+}
+
+func validateConfigNode(node *yaml.Node, t reflect.Type, prefix string) []configIssue {
+	if node == nil {
+		return nil
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var issues []configIssue
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			if node.Tag == "!!null" {
+				return nil
+			}
+			return []configIssue{{Key: prefix, Line: node.Line, Message: "expected a mapping"}}
+		}
+		fields := make(map[string]reflect.Type)
+		var names []string
+		for i := 0; i < t.NumField(); i++ {
+			name := yamlFieldName(t.Field(i))
+			fields[name] = t.Field(i).Type
+			names = append(names, name)
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyNode, valueNode := node.Content[i], node.Content[i+1]
+			key := joinConfigKey(prefix, keyNode.Value)
+			fieldType, ok := fields[keyNode.Value]
+			if !ok {
+				msg := "unknown key"
+				if suggestion := suggestName(keyNode.Value, names); suggestion != "" {
+					msg += fmt.Sprintf(" (did you mean %q?)", joinConfigKey(prefix, suggestion))
+				}
+				issues = append(issues, configIssue{Key: key, Line: keyNode.Line, Message: msg})
+				continue
+			}
+			issues = append(issues, validateConfigNode(valueNode, fieldType, key)...)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			if node.Tag == "!!null" {
+				return nil
+			}
+			return []configIssue{{Key: prefix, Line: node.Line, Message: "expected a mapping"}}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := joinConfigKey(prefix, node.Content[i].Value)
+			issues = append(issues, validateConfigNode(node.Content[i+1], t.Elem(), key)...)
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			if node.Tag == "!!null" {
+				return nil
+			}
+			return []configIssue{{Key: prefix, Line: node.Line, Message: "expected a list"}}
+		}
+		for idx, item := range node.Content {
+			issues = append(issues, validateConfigNode(item, t.Elem(), fmt.Sprintf("%s[%d]", prefix, idx))...)
+		}
+	default:
+		if node.Kind != yaml.ScalarNode {
+			issues = append(issues, configIssue{Key: prefix, Line: node.Line, Message: "expected a single value"})
+		}
+	}
+	return issues
+}
+
+func validateConfigValues(cfg config) []configIssue {
+	var issues []configIssue
+
+	if cfg.BaseURL != "" && !isHTTPURL(cfg.BaseURL) {
+		issues = append(issues, configIssue{Key: "base_url", Message: fmt.Sprintf("%q is not an absolute http(s) URL", cfg.BaseURL)})
+	}
+	for i, raw := range cfg.BaseURLs {
+		if !isHTTPURL(raw) {
+			issues = append(issues, configIssue{Key: fmt.Sprintf("base_urls[%d]", i), Message: fmt.Sprintf("%q is not an absolute http(s) URL", raw)})
+		}
+	}
+
+	var model *modelOption
+	if cfg.Defaults.Model != "" {
+		if opt, ok := findModelOption(cfg.Defaults.Model); ok {
+			model = &opt
+		} else {
+			issues = append(issues, configIssue{Key: "defaults.model", Message: fmt.Sprintf("unknown model %q; supported: %s", cfg.Defaults.Model, strings.Join(modelNames(), ", "))})
+		}
+	}
+	if cfg.Defaults.Seconds != 0 && !isAllowedDuration(cfg.Defaults.Seconds) {
+		issues = append(issues, configIssue{Key: "defaults.seconds", Message: fmt.Sprintf("unsupported duration %d; supported: %s", cfg.Defaults.Seconds, joinInts(allowedDurations, ", "))})
+	}
+	if cfg.Defaults.Size != "" {
+		candidates := modelOptions
+		if model != nil {
+			candidates = []modelOption{*model}
+		}
+		if !sizeSupported(candidates, cfg.Defaults.Size) {
+			issues = append(issues, configIssue{Key: "defaults.size", Message: fmt.Sprintf("unsupported size %q", cfg.Defaults.Size)})
+		}
+	}
+	if cfg.Defaults.Container != "" {
+		if err := validateOutputContainer(cfg.Defaults.Container); err != nil {
+			issues = append(issues, configIssue{Key: "defaults.container", Message: err.Error()})
+		}
+	}
+	if err := validateLayout(cfg.Defaults.Layout); err != nil {
+		issues = append(issues, configIssue{Key: "defaults.layout", Message: err.Error()})
+	}
+	switch sora.ProgressScale(strings.ToLower(cfg.ProgressScale)) {
+	case "", sora.ProgressScaleAuto, sora.ProgressScaleFraction, sora.ProgressScalePercent:
+	default:
+		issues = append(issues, configIssue{Key: "progress_scale", Message: fmt.Sprintf("unknown scale %q; supported: auto, fraction, percent", cfg.ProgressScale)})
+	}
+	switch strings.ToLower(cfg.LogFormat) {
+	case "", "text", "json":
+	default:
+		issues = append(issues, configIssue{Key: "log_format", Message: fmt.Sprintf("unknown format %q; supported: text, json", cfg.LogFormat)})
+	}
+	if cfg.LogLevel != "" && logLevelIndex(strings.ToLower(cfg.LogLevel)) < 0 {
+		issues = append(issues, configIssue{Key: "log_level", Message: fmt.Sprintf("unknown level %q; supported: debug, info, warn, error", cfg.LogLevel)})
+	}
+	for name, channel := range map[string]notificationChannel{
+		"slack":   cfg.Notifications.Slack,
+		"discord": cfg.Notifications.Discord,
+		"webhook": cfg.Notifications.Webhook,
+	} {
+		if channel.URL != "" && !isHTTPURL(channel.URL) {
+			issues = append(issues, configIssue{Key: "notifications." + name + ".url", Message: "not an absolute http(s) URL"})
+		}
+	}
+	if cfg.Notifications.Webhook.Jobs {
+		issues = append(issues, configIssue{Key: "notifications.webhook.jobs", Message: "only slack and discord post per job; use notifications.job_webhook"})
+	}
+	if url := cfg.Notifications.JobWebhook.URL; url != "" && !isHTTPURL(url) {
+		issues = append(issues, configIssue{Key: "notifications.job_webhook.url", Message: "not an absolute http(s) URL"})
+	}
+
+	switch strings.ToLower(cfg.Tickets.Provider) {
+	case "", "jira", "linear":
+	default:
+		issues = append(issues, configIssue{Key: "tickets.provider", Message: fmt.Sprintf("unknown provider %q; supported: jira, linear", cfg.Tickets.Provider)})
+	}
+	if cfg.Tickets.Jira.BaseURL != "" && !isHTTPURL(cfg.Tickets.Jira.BaseURL) {
+		issues = append(issues, configIssue{Key: "tickets.jira.base_url", Message: "not an absolute http(s) URL"})
+	}
+
+	if cfg.DAM.URL != "" && !isHTTPURL(cfg.DAM.URL) {
+		issues = append(issues, configIssue{Key: "dam.url", Message: "not an absolute http(s) URL"})
+	}
+	issues = append(issues, validateDAMFields(cfg.DAM.Fields)...)
+	issues = append(issues, validateTrimConfig(cfg.Trim)...)
+	issues = append(issues, validateGradeConfig(cfg.Grade)...)
+	issues = append(issues, validateInterpolateConfig(cfg.Interpolate)...)
+	issues = append(issues, validateEncodeConfig(cfg.Encode)...)
+	issues = append(issues, validateBudgetConfig(cfg.Budget)...)
+	issues = append(issues, validateStorageConfig(cfg.Storage)...)
+	issues = append(issues, validateCaptionsConfig(cfg.Captions)...)
+	issues = append(issues, validateShareConfig(cfg.Share)...)
+	issues = append(issues, validateServerConfig(cfg.Server)...)
+	issues = append(issues, validateTrashConfig(cfg.Trash)...)
+	issues = append(issues, validateStatusConfig(cfg.Status)...)
+	if _, err := parseClock(cfg.OffPeak.Start); cfg.OffPeak.Start != "" && err != nil {
+		issues = append(issues, configIssue{Key: "off_peak.start", Message: err.Error()})
+	}
+	if _, err := parseClock(cfg.OffPeak.End); cfg.OffPeak.End != "" && err != nil {
+		issues = append(issues, configIssue{Key: "off_peak.end", Message: err.Error()})
+	}
+	if cfg.OffPeak.Start != "" && cfg.OffPeak.Start == cfg.OffPeak.End {
+		issues = append(issues, configIssue{Key: "off_peak", Message: "start and end must differ"})
+	}
+	if cfg.Dedupe.WindowMinutes < 0 {
+		issues = append(issues, configIssue{Key: "dedupe.window_minutes", Message: "must not be negative"})
+	}
+	if cfg.Retry.MaxAttempts < 0 {
+		issues = append(issues, configIssue{Key: "retry.max_attempts", Message: "must not be negative"})
+	}
+	issues = append(issues, validatePollConfig(cfg.Poll)...)
+	issues = append(issues, validateNetworkConfig(cfg.Network)...)
+
+	names := make([]string, 0, len(cfg.Queues))
+	for name := range cfg.Queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		issues = append(issues, validateQueueConfig(name, cfg.Queues[name])...)
+	}
+	for _, name := range profileNames(cfg.Profiles) {
+		profile, ok := cfg.Profiles[name]
+		if !ok {
+			continue
+		}
+		prefix := "profiles." + name
+		if profile.BaseURL != "" && !isHTTPURL(profile.BaseURL) {
+			issues = append(issues, configIssue{Key: prefix + ".base_url", Message: fmt.Sprintf("%q is not an absolute http(s) URL", profile.BaseURL)})
+		}
+		if _, ok := findModelOption(profile.Model); profile.Model != "" && !ok {
+			issues = append(issues, configIssue{Key: prefix + ".model", Message: fmt.Sprintf("unknown model %q; supported: %s", profile.Model, strings.Join(modelNames(), ", "))})
+		}
+	}
+	return issues
+}
+
+func validateQueueConfig(name string, q queueConfig) []configIssue {
+	prefix := "queues." + name
+	var issues []configIssue
+	if !isValidQueueName(name) {
+		issues = append(issues, configIssue{Key: prefix, Message: "queue names may only contain letters, digits, '-' and '_'"})
+	}
+	var model *modelOption
+	if q.Model != "" {
+		if opt, ok := findModelOption(q.Model); ok {
+			model = &opt
+		} else {
+			issues = append(issues, configIssue{Key: prefix + ".model", Message: fmt.Sprintf("unknown model %q; supported: %s", q.Model, strings.Join(modelNames(), ", "))})
+		}
+	}
+	if q.Seconds != 0 && !isAllowedDuration(q.Seconds) {
+		issues = append(issues, configIssue{Key: prefix + ".seconds", Message: fmt.Sprintf("unsupported duration %d; supported: %s", q.Seconds, joinInts(allowedDurations, ", "))})
+	}
+	if q.Size != "" {
+		candidates := modelOptions
+		if model != nil {
+			candidates = []modelOption{*model}
+		}
+		if !sizeSupported(candidates, q.Size) {
+			issues = append(issues, configIssue{Key: prefix + ".size", Message: fmt.Sprintf("unsupported size %q", q.Size)})
+		}
+	}
+	if q.Concurrency < 0 {
+		issues = append(issues, configIssue{Key: prefix + ".concurrency", Message: "must not be negative"})
+	}
+	if q.Budget < 0 {
+		issues = append(issues, configIssue{Key: prefix + ".budget", Message: "must not be negative"})
+	}
+	return issues
+}
+
+func isHTTPURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+func findModelOption(name string) (modelOption, bool) {
+	for _, opt := range modelOptions {
+		if strings.EqualFold(opt.Name, name) {
+			return opt, true
+		}
+	}
+	return modelOption{}, false
+}
+
+func modelNames() []string {
+	names := make([]string, len(modelOptions))
+	for i, opt := range modelOptions {
+		names[i] = opt.Name
+	}
+	return names
+}
+
+func isAllowedDuration(seconds int) bool {
+	for _, allowed := range allowedDurations {
+		if allowed == seconds {
+			return true
+		}
+	}
+	return false
+}
+
+func sizeSupported(models []modelOption, size string) bool {
+	for _, model := range models {
+		for _, res := range model.Resolutions {
+			if strings.EqualFold(res.Value, size) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func joinInts(values []int, sep string) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, sep)
+}
+
+func suggestName(input string, candidates []string) string {
+	best := ""
+	bestDistance := len(input)/2 + 2
+	for _, candidate := range candidates {
+		if d := levenshtein(strings.ToLower(input), strings.ToLower(candidate)); d < bestDistance {
+			best = candidate
+			bestDistance = d
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	curr := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		curr[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(br)]
+}
+
+func exportConfigEnv(cfg *resolvedConfig) {
+	// The request helpers read the organization and project headers from the
+	// environment, so values that only live in the config file are exported.
+	// A selected profile replaces what the environment already has.
+	for _, v := range []struct{ name, key, value string }{
+		{"OPENAI_ORG_ID", "org_id", cfg.OrgID},
+		{"OPENAI_PROJECT_ID", "project_id", cfg.ProjectID},
+	} {
+		if v.value == "" || os.Getenv(v.name) != "" && !strings.HasPrefix(cfg.Sources[v.key], "profile ") {
+			continue
+		}
+		if err := os.Setenv(v.name, v.value); err != nil {
+			logWarn("unable to set %s: %v", v.name, err)
+		}
+	}
+}
+
+func lookupConfigType(key string) (reflect.Type, error) {
+	t := reflect.TypeOf(config{})
+	prefix := ""
+	for _, segment := range strings.Split(key, ".") {
+		switch t.Kind() {
+		case reflect.Struct:
+			var names []string
+			var next reflect.Type
+			for i := 0; i < t.NumField(); i++ {
+				name := yamlFieldName(t.Field(i))
+				names = append(names, name)
+				if name == segment {
+					next = t.Field(i).Type
+				}
+			}
+			if next == nil {
+				msg := fmt.Sprintf("unknown config key %q", joinConfigKey(prefix, segment))
+				if suggestion := suggestName(segment, names); suggestion != "" {
+					msg += fmt.Sprintf(" (did you mean %q?)", joinConfigKey(prefix, suggestion))
+				}
+				return nil, errors.New(msg)
+			}
+			t = next
+		case reflect.Map:
+			if segment == "" {
+				return nil, fmt.Errorf("empty name in config key %q", key)
+			}
+			t = t.Elem()
+		default:
+			return nil, fmt.Errorf("%q is a value and has no key %q", prefix, segment)
+		}
+		prefix = joinConfigKey(prefix, segment)
+	}
+	return t, nil
+}
+
+func configValueAt(v reflect.Value, key string) (reflect.Value, bool) {
+	for _, segment := range strings.Split(key, ".") {
+		switch v.Kind() {
+		case reflect.Struct:
+			t := v.Type()
+			found := false
+			for i := 0; i < t.NumField(); i++ {
+				if yamlFieldName(t.Field(i)) == segment {
+					v = v.Field(i)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return reflect.Value{}, false
+			}
+		case reflect.Map:
+			v = v.MapIndex(reflect.ValueOf(segment))
+			if !v.IsValid() {
+				return reflect.Value{}, false
+			}
+		default:
+			return reflect.Value{}, false
+		}
+	}
+	return v, true
+}
+
+func configValueNode(t reflect.Type, raw string) (*yaml.Node, error) {
+	raw = strings.TrimSpace(raw)
+	probe := reflect.New(t).Elem()
+	if err := setConfigValue(probe, raw); err != nil {
+		return nil, err
+	}
+	switch t.Kind() {
+	case reflect.Slice:
+		seq := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for i := 0; i < probe.Len(); i++ {
+			seq.Content = append(seq.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: probe.Index(i).String()})
+		}
+		return seq, nil
+	case reflect.Int, reflect.Int64:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.FormatInt(probe.Int(), 10)}, nil
+	case reflect.Float64:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: strconv.FormatFloat(probe.Float(), 'f', -1, 64)}, nil
+	case reflect.Bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(probe.Bool())}, nil
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: raw}, nil
+	}
+}
+
+func setConfigFileValue(path, key, raw string) error {
+	t, err := lookupConfigType(key)
+	if err != nil {
+		return err
+	}
+	if k := t.Kind(); k == reflect.Struct || k == reflect.Map {
+		return fmt.Errorf("%q is a section; set one of its keys instead", key)
+	}
+	value, err := configValueNode(t, raw)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+
+	doc, err := readConfigNode(path)
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		doc = &yaml.Node{Kind: yaml.DocumentNode}
+	}
+	if len(doc.Content) == 0 {
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+
+	node := doc.Content[0]
+	segments := strings.Split(key, ".")
+	for i, segment := range segments {
+		if node.Kind != yaml.MappingNode {
+			return fmt.Errorf("%s is not a mapping in %s", strings.Join(segments[:i], "."), path)
+		}
+		last := i == len(segments)-1
+		var child *yaml.Node
+		for j := 0; j+1 < len(node.Content); j += 2 {
+			if node.Content[j].Value == segment {
+				child = node.Content[j+1]
+				if last {
+					value.HeadComment = child.HeadComment
+					value.LineComment = child.LineComment
+					node.Content[j+1] = value
+				}
+				break
+			}
+		}
+		if child == nil {
+			child = value
+			if !last {
+				child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: segment}, child)
+		}
+		node = child
+	}
+
+	return writeConfigNode(path, doc)
+}
+
+func unsetConfigFileValue(path, key string) (bool, error) {
+	if _, err := lookupConfigType(key); err != nil {
+		return false, err
+	}
+	doc, err := readConfigNode(path)
+	if err != nil || doc == nil || len(doc.Content) == 0 {
+		return false, err
+	}
+	if !removeConfigNode(doc.Content[0], strings.Split(key, ".")) {
+		return false, nil
+	}
+	return true, writeConfigNode(path, doc)
+}
+
+func removeConfigNode(node *yaml.Node, segments []string) bool {
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for j := 0; j+1 < len(node.Content); j += 2 {
+		if node.Content[j].Value != segments[0] {
+			continue
+		}
+		child := node.Content[j+1]
+		if len(segments) > 1 {
+			if !removeConfigNode(child, segments[1:]) {
+				return false
+			}
+			// Drop sections left empty so the file does not fill up with {}.
+			if len(child.Content) > 0 {
+				return true
+			}
+		}
+		node.Content = append(node.Content[:j], node.Content[j+2:]...)
+		return true
+	}
+	return false
+}
+
+func writeConfigNode(path string, doc *yaml.Node) error {
+	var cfg config
+	if err := doc.Decode(&cfg); err != nil {
+		return err
+	}
+	if issues := validateConfigValues(cfg); len(issues) > 0 {
+		return errors.New(issues[0].String())
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o600)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// containerDataDir is where renders go inside a container unless a
+// destination is configured. The image declares it as a volume.
+const containerDataDir = "/data"
+
+var (
+	containerOnce sync.Once
+	inContainer   bool
+)
+
+// runningInContainer reports whether the CLI runs in a container.
+// SORA2_CONTAINER forces the answer; otherwise the marker files of Docker and
+// Podman and the environment Kubernetes gives every pod are checked.
+func runningInContainer() bool {
+	containerOnce.Do(func() {
+		if raw := strings.TrimSpace(os.Getenv("SORA2_CONTAINER")); raw != "" {
+			inContainer, _ = strconv.ParseBool(raw)
+			return
+		}
+		if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+			inContainer = true
+			return
+		}
+		for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+			if _, err := os.Stat(marker); err == nil {
+				inContainer = true
+				return
+			}
+		}
+	})
+	return inContainer
+}
+
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// defaultNonInteractive turns on --non-interactive when stdin is not a
+// terminal and neither the flag nor its environment variable was given, so a
+// job without a TTY fails on a missing flag instead of waiting for input.
+func defaultNonInteractive(fs *flag.FlagSet, nonInteractive *bool) {
+	given := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "non-interactive" {
+			given = true
+		}
+	})
+	if !given && !stdinIsTerminal() {
+		*nonInteractive = true
+	}
+}
+
+// applyContainerDefaults fills settings that suit a container when nothing
+// else set them: renders go to /data if it exists, and activity is logged
+// to stderr as JSON.
+func applyContainerDefaults(resolved *resolvedConfig) {
+	if !runningInContainer() {
+		return
+	}
+	if resolved.Defaults.Destination == "" {
+		if info, err := os.Stat(containerDataDir); err == nil && info.IsDir() {
+			resolved.Defaults.Destination = containerDataDir
+			resolved.Sources["defaults.destination"] = "container default"
+		}
+	}
+	if resolved.LogFormat == "" {
+		resolved.LogFormat = "json"
+		resolved.Sources["log_format"] = "container default"
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// countsAsSpend reports whether a job of status is paid for. Failed and
+// cancelled jobs are not.
+func countsAsSpend(status string) bool {
+	switch strings.ToLower(status) {
+	case "failed", "cancelled":
+		return false
+	}
+	return true
+}
+
+// entryCost is the estimated cost of a recorded job. Jobs imported from the
+// API have no estimate, so theirs is worked out from the model's rate.
+func entryCost(e *historyEntry) float64 {
+	if e.EstimatedCost > 0 {
+		return e.EstimatedCost
+	}
+	return modelCost(e.Model, e.Seconds)
+}
+
+func modelCost(model string, seconds int) float64 {
+	opt, ok := findModelOption(model)
+	if !ok {
+		return 0
+	}
+	return opt.RatePerSecond * float64(seconds)
+}
+
+const (
+	costGroupModel = "model"
+	costGroupDay   = "day"
+	costGroupMonth = "month"
+)
+
+type costRow struct {
+	Group   string  `json:"group"`
+	Jobs    int     `json:"jobs"`
+	Seconds int     `json:"seconds"`
+	Cost    float64 `json:"cost"`
+}
+
+type costReport struct {
+	Since   string    `json:"since,omitempty"`
+	Until   string    `json:"until,omitempty"`
+	GroupBy string    `json:"group_by"`
+	Rows    []costRow `json:"rows"`
+	Total   costRow   `json:"total"`
+}
+
+// costJob is one paid job, from history or from the API's list.
+type costJob struct {
+	Model   string
+	Seconds int
+	Cost    float64
+	Created time.Time
+}
+
+func (j costJob) group(by string) string {
+	created := j.Created.Local()
+	if displayUTC {
+		created = j.Created.UTC()
+	}
+	switch by {
+	case costGroupDay:
+		return created.Format("2006-01-02")
+	case costGroupMonth:
+		return created.Format("2006-01")
+	}
+	if j.Model == "" {
+		return "unknown"
+	}
+	return j.Model
+}
+
+// buildCostReport adds up jobs created in [since, until) by group. A zero
+// bound is open.
+func buildCostReport(jobs []costJob, by string, since, until time.Time) costReport {
+	report := costReport{GroupBy: by, Rows: []costRow{}, Total: costRow{Group: "total"}}
+	rows := make(map[string]*costRow)
+	for _, job := range jobs {
+		if (!since.IsZero() && job.Created.Before(since)) || (!until.IsZero() && !job.Created.Before(until)) {
+			continue
+		}
+		key := job.group(by)
+		row := rows[key]
+		if row == nil {
+			row = &costRow{Group: key}
+			rows[key] = row
+		}
+		for _, r := range []*costRow{row, &report.Total} {
+			r.Jobs++
+			r.Seconds += job.Seconds
+			r.Cost += job.Cost
+		}
+	}
+	for _, row := range rows {
+		row.Cost = roundCents(row.Cost)
+		report.Rows = append(report.Rows, *row)
+	}
+	report.Total.Cost = roundCents(report.Total.Cost)
+	sort.Slice(report.Rows, func(i, j int) bool { return report.Rows[i].Group < report.Rows[j].Group })
+	return report
+}
+
+func roundCents(v float64) float64 {
+	return float64(int64(v*100+0.5)) / 100
+}
+
+func writeCostCSV(w io.Writer, report costReport) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{report.GroupBy, "jobs", "seconds", "cost_usd"}); err != nil {
+		return err
+	}
+	for _, row := range append(report.Rows, report.Total) {
+		if err := writer.Write([]string{row.Group, strconv.Itoa(row.Jobs), strconv.Itoa(row.Seconds), strconv.FormatFloat(row.Cost, 'f', 2, 64)}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// parseReportDate reads a --since or --until date in the display time zone.
+func parseReportDate(value string) (time.Time, error) {
+	loc := time.Local
+	if displayUTC {
+		loc = time.UTC
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q; use YYYY-MM-DD", value)
+	}
+	return t, nil
+}
+
+// parseRelativeDate reads a --since or --until value that is either a date
+// or an age such as 7d or 12h, counted back from now. With end, a date means
+// the end of that day, so --until includes it.
+func parseRelativeDate(value string, end bool, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	t, err := parseReportDate(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date or age %q; use YYYY-MM-DD or e.g. 7d, 12h", value)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// runCostCommand reports the estimated spend recorded in history, for
+// expenses and the like.
+func runCostCommand(args []string) int {
+	fs := newCommandFlagSet("cost")
+	since := fs.String("since", "", "only jobs created on or after this date (YYYY-MM-DD)")
+	until := fs.String("until", "", "only jobs created on or before this date (YYYY-MM-DD)")
+	groupBy := fs.String("group-by", costGroupModel, "group the jobs by model, day or month")
+	remote := fs.Bool("remote", false, "also count videos the API lists that history has no record of")
+	review := fs.String("review", "", "only jobs with this review status: "+strings.Join(reviewFilters, ", "))
+	csvPath := fs.String("csv", "", "write a CSV file (- for stdout) instead of the table")
+	jsonOutput := fs.Bool("json", false, "print the report as JSON")
+	registerFormatFlag(fs, jsonOutput)
+	fs.BoolVar(&displayUTC, "utc", false, "use UTC days instead of the local time zone")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli cost [--since YYYY-MM-DD] [--until YYYY-MM-DD] [--group-by model|day|month] [--remote] [--review status] [--csv file] [--json]")
+		return 2
+	}
+	if *review != "" {
+		if err := validateReviewFilter(*review); err != nil {
+			logError("%v", err)
+			return 2
+		}
+	}
+	switch *groupBy {
+	case costGroupModel, costGroupDay, costGroupMonth:
+	default:
+		logError("unknown --group-by %q; use model, day or month", *groupBy)
+		return 2
+	}
+	var from, to time.Time
+	var err error
+	if *since != "" {
+		if from, err = parseReportDate(*since); err != nil {
+			logError("--since: %v", err)
+			return 2
+		}
+	}
+	if *until != "" {
+		if to, err = parseReportDate(*until); err != nil {
+			logError("--until: %v", err)
+			return 2
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	if *jsonOutput {
+		enableJSONOutput()
+	}
+
+	state, err := loadHistory()
+	if err != nil {
+		logError("%v", err)
+		emitJSONError(err, "")
+		return 1
+	}
+	var jobs []costJob
+	for _, entry := range state.Entries {
+		if countsAsSpend(entry.Status) && reviewFilter(*review, entry) {
+			jobs = append(jobs, costJob{Model: entry.Model, Seconds: entry.Seconds, Cost: entryCost(entry), Created: entry.CreatedAt})
+		}
+	}
+	if *remote {
+		session, err := newAPISession(false)
+		if err != nil {
+			logError("%v", err)
+			emitJSONError(err, "")
+			return 1
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		audit, err := auditHistory(ctx, session.client, state)
+		if err != nil {
+			err = fmt.Errorf("failed to list videos: %w", err)
+			logError("%v", err)
+			emitJSONError(err, "")
+			return 1
+		}
+		for _, video := range audit.RemoteOnly {
+			if !countsAsSpend(video.Status) || !reviewFilter(*review, nil) {
+				continue
+			}
+			seconds, _ := strconv.Atoi(video.Seconds)
+			jobs = append(jobs, costJob{Model: video.Model, Seconds: seconds, Cost: modelCost(video.Model, seconds), Created: time.Unix(video.CreatedAt, 0)})
+		}
+	}
+
+	report := buildCostReport(jobs, *groupBy, from, to)
+	report.Since, report.Until = *since, *until
+	if *jsonOutput {
+		emitJSON(report)
+		return 0
+	}
+	if *csvPath != "" {
+		out := os.Stdout
+		if *csvPath != "-" {
+			file, err := os.Create(*csvPath)
+			if err != nil {
+				logError("%v", err)
+				return 1
+			}
+			defer file.Close()
+			out = file
+		}
+		if err := writeCostCSV(out, report); err != nil {
+			logError("write CSV: %v", err)
+			return 1
+		}
+		if *csvPath != "-" {
+			logInfo("Wrote %d row(s) to %s", len(report.Rows)+1, *csvPath)
+		}
+		return 0
+	}
+
+	if len(report.Rows) == 0 {
+		logInfo("No jobs in that period.")
+		return 0
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tJOBS\tSECONDS\tCOST\n", strings.ToUpper(*groupBy))
+	for _, row := range append(report.Rows, report.Total) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t$%.2f\n", row.Group, row.Jobs, row.Seconds, row.Cost)
+	}
+	tw.Flush()
+	fmt.Println("Costs are estimates from the per-second rates, not the invoice.")
+	return 0
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// crashLogLines is how much of the activity log goes into a crash report.
+const crashLogLines = 50
+
+var (
+	crashMu       sync.Mutex
+	crashReportMu sync.Mutex
+	// rawTerminal is the state to restore stdin to while it is in raw mode.
+	rawTerminal *term.State
+	// crashSecrets are removed from crash reports, which are meant to be
+	// attached to bug reports.
+	crashSecrets []string
+)
+
+// enterRawTerminal puts stdin into raw mode and remembers how to undo it,
+// so a crash never leaves the user's shell unusable.
+func enterRawTerminal() error {
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return err
+	}
+	crashMu.Lock()
+	rawTerminal = state
+	crashMu.Unlock()
+	return nil
+}
+
+func restoreTerminal() {
+	crashMu.Lock()
+	defer crashMu.Unlock()
+	if rawTerminal != nil {
+		term.Restore(int(os.Stdin.Fd()), rawTerminal)
+		rawTerminal = nil
+	}
+}
+
+func rememberSecret(value string) {
+	if len(value) < 4 {
+		return
+	}
+	crashMu.Lock()
+	defer crashMu.Unlock()
+	for _, known := range crashSecrets {
+		if known == value {
+			return
+		}
+	}
+	crashSecrets = append(crashSecrets, value)
+}
+
+// rememberConfigSecrets registers every value tagged secret in cfg.
+func rememberConfigSecrets(cfg config) {
+	for _, entry := range flattenConfig(reflect.ValueOf(cfg), "") {
+		if entry.Secret {
+			rememberSecret(entry.Value.String())
+		}
+	}
+}
+
+func redactCrashText(text string) string {
+	crashMu.Lock()
+	defer crashMu.Unlock()
+	for _, secret := range crashSecrets {
+		text = strings.ReplaceAll(text, secret, "****")
+	}
+	return text
+}
+
+// handleCrash must be deferred in main and, last, in every goroutine that
+// does real work, so it runs before the goroutine's other deferred calls. On
+// a panic it restores the terminal, waits for a history update in progress
+// to reach the disk, writes a crash report and exits with status 2 like an
+// unhandled panic would. Only the first of several panicking goroutines
+// reports; the others block until the process exits.
+func handleCrash() {
+	value := recover()
+	if value == nil {
+		return
+	}
+	crashReportMu.Lock()
+	stack := debug.Stack()
+	restoreTerminal()
+	settleHistory()
+
+	path, err := writeCrashReport(value, stack)
+	fmt.Fprintf(os.Stderr, "\nsora2cli crashed: %v\n", value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to write a crash report (%v); the stack trace follows.\n\n%s", err, redactCrashText(string(stack)))
+	} else {
+		fmt.Fprintf(os.Stderr, "A crash report was written to %s; please attach it when filing a bug.\n", path)
+	}
+	os.Exit(2)
+}
+
+// settleHistory waits briefly for another goroutine to finish writing
+// history. Every update is saved before the lock is released, so holding it
+// means the file on disk is complete.
+func settleHistory() {
+	deadline := time.Now().Add(2 * time.Second)
+	for !historyMu.TryLock() {
+		if time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Keep the lock so no worker starts a new write before the exit.
+}
+
+func writeCrashReport(value any, stack []byte) (string, error) {
+	dir, err := resolveCacheDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "sora2cli crash report\n\n")
+	fmt.Fprintf(&b, "Time:    %s\n", time.Now().UTC().Format(time.RFC3339))
+	info := readBuildInfo()
+	fmt.Fprintf(&b, "Version: %s (commit %s)\n", info.Version, firstNonEmpty(info.Commit, "unknown"))
+	fmt.Fprintf(&b, "Go:      %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&b, "Command: %s\n", strings.Join(os.Args, " "))
+	fmt.Fprintf(&b, "Panic:   %v\n\n", value)
+	fmt.Fprintf(&b, "Stack:\n%s\n", stack)
+	fmt.Fprintf(&b, "Recent activity:\n")
+	if lines := recentActivityLines(crashLogLines); len(lines) > 0 {
+		b.WriteString(strings.Join(lines, "\n"))
+		b.WriteString("\n")
+	} else {
+		b.WriteString("(none)\n")
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("crash-%s.txt", time.Now().Format("20060102-150405")))
+	if err := os.WriteFile(path, []byte(redactCrashText(b.String())), 0o600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// recentActivityLines returns the last n raw lines of the activity log.
+func recentActivityLines(n int) []string {
+	path, err := activityLogPath()
+	if err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// OpenAI credentials are recognised by their prefixes, whatever the file
+// around them looks like: a .env, the JSON or TOML config of another tool,
+// or text copied from the dashboard. Masked keys such as sk-...abcd are too
+// short to match.
+var (
+	importedKeyPattern     = regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}`)
+	importedOrgPattern     = regexp.MustCompile(`\borg-[A-Za-z0-9]{8,}\b`)
+	importedProjectPattern = regexp.MustCompile(`\bproj_[A-Za-z0-9]{8,}\b`)
+)
+
+// importedCredential is one config key config import can fill in, with the
+// distinct values found for it in the input.
+type importedCredential struct {
+	key    string
+	label  string
+	secret bool
+	values []string
+}
+
+func (c importedCredential) display(value string) string {
+	if c.secret {
+		return redactSecret(value)
+	}
+	return value
+}
+
+func scanCredentials(text string) []importedCredential {
+	found := func(pattern *regexp.Regexp) []string {
+		var values []string
+		seen := make(map[string]bool)
+		for _, value := range pattern.FindAllString(text, -1) {
+			if !seen[value] {
+				seen[value] = true
+				values = append(values, value)
+			}
+		}
+		return values
+	}
+	return []importedCredential{
+		{key: "api_key", label: "API key", secret: true, values: found(importedKeyPattern)},
+		{key: "org_id", label: "organization ID", values: found(importedOrgPattern)},
+		{key: "project_id", label: "project ID", values: found(importedProjectPattern)},
+	}
+}
+
+// chooseCredential returns the value to import for c. Several values are
+// only resolved by asking, in a terminal.
+func chooseCredential(reader *bufio.Reader, c importedCredential, interactive bool) (string, error) {
+	if len(c.values) <= 1 {
+		return strings.Join(c.values, ""), nil
+	}
+	if !interactive {
+		return "", fmt.Errorf("found %d different %ss; keep only the one to import in the input", len(c.values), c.label)
+	}
+	fmt.Printf("Found %d different %ss:\n", len(c.values), c.label)
+	for i, value := range c.values {
+		fmt.Printf("  %d) %s\n", i+1, c.display(value))
+	}
+	for {
+		fmt.Printf("Enter choice (1-%d): ", len(c.values))
+		input, err := reader.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("no %s chosen", c.label)
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(input)); err == nil && n >= 1 && n <= len(c.values) {
+			return c.values[n-1], nil
+		}
+		fmt.Println("Invalid choice.")
+	}
+}
+
+// runConfigImport copies the API key, organization ID and project ID from
+// another tool's config file or a pasted dashboard export into a config
+// file.
+func runConfigImport(args []string) int {
+	fs, flags := newConfigFlagSet("import")
+	assumeYes := fs.Bool("yes", false, "write the values without asking")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli config import [flags] [file|-]")
+		return 2
+	}
+	if flags.project {
+		logError("credentials cannot be imported into a project config; import them into the user config")
+		return 2
+	}
+	path, err := flags.targetPath()
+	if err != nil {
+		logError("unable to locate config file: %v", err)
+		return 1
+	}
+
+	source := fs.Arg(0)
+	interactive := stdinIsTerminal()
+	var input io.Reader = os.Stdin
+	switch {
+	case source != "" && source != "-":
+		expanded, err := expandPath(source)
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+		file, err := os.Open(expanded)
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+		defer file.Close()
+		input = file
+	case interactive:
+		fmt.Println("Paste the export or config file, then press Ctrl+D (Ctrl+Z and Enter on Windows):")
+	}
+	data, err := io.ReadAll(input)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	// Pasting ends the terminal's input, so answers are read from a fresh
+	// reader on the terminal.
+	reader := bufio.NewReader(os.Stdin)
+
+	var keys, values []string
+	for _, c := range scanCredentials(string(data)) {
+		value, err := chooseCredential(reader, c, interactive)
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+		if value == "" {
+			continue
+		}
+		keys = append(keys, c.key)
+		values = append(values, value)
+		fmt.Printf("  %s: %s\n", c.key, c.display(value))
+	}
+	if len(keys) == 0 {
+		logError("no API key (sk-...), organization ID (org-...) or project ID (proj_...) found in the input")
+		return 1
+	}
+	if !*assumeYes && interactive && !promptConfirm(reader, os.Stdout, fmt.Sprintf("Write these to %s?", path)) {
+		logInfo("Aborted.")
+		return 1
+	}
+	for i, key := range keys {
+		if err := setConfigFileValue(path, key, values[i]); err != nil {
+			logError("%v", err)
+			return 1
+		}
+	}
+	logInfo("Imported %s into %s", strings.Join(keys, ", "), path)
+	if os.Getenv("OPENAI_API_KEY") != "" {
+		logWarn("OPENAI_API_KEY is set in the environment and takes precedence over the config file.")
+	}
+	return 0
+}
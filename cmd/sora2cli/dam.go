@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+const damUploadTimeout = 10 * time.Minute
+
+var damPlaceholderPattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// damPlaceholders lists the values a field mapping can reference. Without a
+// mapping every one of them is sent under its own name.
+var damPlaceholders = []string{
+	"job_id",
+	"status",
+	"prompt",
+	"model",
+	"seconds",
+	"size",
+	"estimated_cost",
+	"file_name",
+	"output_path",
+	"created_at",
+	"completed_at",
+	"remixed_from",
+	"ticket",
+}
+
+type damConfig struct {
+	URL         string            `yaml:"url,omitempty"`
+	Token       string            `yaml:"token,omitempty" env:"DAM_API_TOKEN" secret:"true"`
+	UploadFiles bool              `yaml:"upload_files,omitempty"`
+	FileField   string            `yaml:"file_field,omitempty"`
+	Auto        bool              `yaml:"auto,omitempty"`
+	Fields      map[string]string `yaml:"fields,omitempty"`
+}
+
+// assetRecord is the metadata registered in the DAM for one completed render.
+type assetRecord struct {
+	JobID         string
+	Status        string
+	Prompt        string
+	Model         string
+	Seconds       int
+	Size          string
+	EstimatedCost float64
+	OutputPath    string
+	CreatedAt     time.Time
+	CompletedAt   time.Time
+	RemixedFrom   string
+	Ticket        string
+}
+
+func assetRecordFromJob(job *sora.Video, outputPath string) assetRecord {
+	record := assetRecord{
+		JobID:       job.ID,
+		Status:      job.Status,
+		Prompt:      job.Prompt,
+		Model:       job.Model,
+		Size:        job.Size,
+		OutputPath:  outputPath,
+		RemixedFrom: job.RemixedFromVideoID,
+	}
+	if seconds, err := strconv.Atoi(job.Seconds); err == nil {
+		record.Seconds = seconds
+		record.EstimatedCost = jobCostEstimate(job.Model, seconds)
+	}
+	if job.CreatedAt > 0 {
+		record.CreatedAt = time.Unix(job.CreatedAt, 0)
+	}
+	if job.CompletedAt > 0 {
+		record.CompletedAt = time.Unix(job.CompletedAt, 0)
+	}
+	return record
+}
+
+func (r assetRecord) placeholderValue(name string) string {
+	switch name {
+	case "job_id":
+		return r.JobID
+	case "status":
+		return r.Status
+	case "prompt":
+		return r.Prompt
+	case "model":
+		return r.Model
+	case "seconds":
+		if r.Seconds == 0 {
+			return ""
+		}
+		return strconv.Itoa(r.Seconds)
+	case "size":
+		return r.Size
+	case "estimated_cost":
+		if r.EstimatedCost == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(r.EstimatedCost, 'f', 2, 64)
+	case "file_name":
+		if r.OutputPath == "" {
+			return ""
+		}
+		return filepath.Base(r.OutputPath)
+	case "output_path":
+		return r.OutputPath
+	case "created_at":
+		return formatRecordTime(r.CreatedAt)
+	case "completed_at":
+		return formatRecordTime(r.CompletedAt)
+	case "remixed_from":
+		return r.RemixedFrom
+	case "ticket":
+		return r.Ticket
+	}
+	return ""
+}
+
+func formatRecordTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// damFields returns the mapping from DAM field name to template, falling back
+// to one field per placeholder when none is configured.
+func damFields(cfg damConfig) map[string]string {
+	if len(cfg.Fields) > 0 {
+		return cfg.Fields
+	}
+	fields := make(map[string]string, len(damPlaceholders))
+	for _, name := range damPlaceholders {
+		fields[name] = "{" + name + "}"
+	}
+	return fields
+}
+
+// mapFields expands every template in the field mapping for one record.
+func (r assetRecord) mapFields(fields map[string]string) map[string]string {
+	out := make(map[string]string, len(fields))
+	for field, template := range fields {
+		out[field] = damPlaceholderPattern.ReplaceAllStringFunc(template, func(match string) string {
+			return r.placeholderValue(match[1 : len(match)-1])
+		})
+	}
+	return out
+}
+
+func validateDAMFields(fields map[string]string) []configIssue {
+	var issues []configIssue
+	for field, template := range fields {
+		for _, match := range damPlaceholderPattern.FindAllStringSubmatch(template, -1) {
+			if !containsString(damPlaceholders, match[1]) {
+				msg := fmt.Sprintf("unknown placeholder {%s}", match[1])
+				if suggestion := suggestName(match[1], damPlaceholders); suggestion != "" {
+					msg += fmt.Sprintf("; did you mean {%s}?", suggestion)
+				}
+				issues = append(issues, configIssue{Key: "dam.fields." + field, Message: msg})
+			}
+		}
+	}
+	return issues
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// exportAsset registers one render with the DAM. The mapped fields are posted
+// as JSON, or with upload set, sent together with the MP4 as a multipart
+// request.
+func exportAsset(ctx context.Context, client *http.Client, cfg damConfig, record assetRecord, upload bool) error {
+	if cfg.URL == "" {
+		return errors.New("dam.url is not configured")
+	}
+	metadata, err := json.Marshal(record.mapFields(damFields(cfg)))
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader = bytes.NewReader(metadata)
+	contentType := "application/json"
+	if upload {
+		if record.OutputPath == "" {
+			return fmt.Errorf("no local file for %s to upload", record.JobID)
+		}
+		buf := &bytes.Buffer{}
+		if contentType, err = writeDAMUpload(buf, cfg, metadata, record.OutputPath); err != nil {
+			return err
+		}
+		body = buf
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, body)
+	if err != nil {
+		return err
+	}
+	if cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("DAM error (%d): %s", resp.StatusCode, readAPIError(resp.Body))
+	}
+	return nil
+}
+
+func writeDAMUpload(w io.Writer, cfg damConfig, metadata []byte, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	writer := multipart.NewWriter(w)
+	if err := writer.WriteField("metadata", string(metadata)); err != nil {
+		return "", err
+	}
+	fileField := cfg.FileField
+	if fileField == "" {
+		fileField = "file"
+	}
+	part, err := writer.CreateFormFile(fileField, filepath.Base(path))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return "", fmt.Errorf("copy %s: %w", path, err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return writer.FormDataContentType(), nil
+}
+
+// exportAssetOrWarn is used after a render completes when dam.auto is set.
+// Export problems are reported but never fail the render, and clips the
+// review workflow holds back are left for "sora2cli export".
+func exportAssetOrWarn(cfg damConfig, review reviewConfig, record assetRecord) bool {
+	if !cfg.Auto {
+		return false
+	}
+	if err := review.checkApproved(record.JobID); err != nil {
+		logWarn("not registering in the DAM: %v", err)
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), damUploadTimeout)
+	defer cancel()
+	client := &http.Client{Timeout: damUploadTimeout}
+	if err := exportAsset(ctx, client, cfg, record, cfg.UploadFiles); err != nil {
+		logWarn("unable to register %s in the DAM: %v", record.JobID, err)
+		return false
+	}
+	logInfo("Registered %s in the DAM", record.JobID)
+	return true
+}
+
+// writeAssetCSV writes one row per record using the DAM field mapping as the
+// columns, sorted by field name.
+func writeAssetCSV(w io.Writer, cfg damConfig, records []assetRecord) error {
+	fields := damFields(cfg)
+	columns := make([]string, 0, len(fields))
+	for field := range fields {
+		columns = append(columns, field)
+	}
+	sort.Strings(columns)
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+	for _, record := range records {
+		values := record.mapFields(fields)
+		row := make([]string, len(columns))
+		for i, column := range columns {
+			row[i] = values[column]
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
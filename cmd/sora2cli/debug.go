@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// debugSettings are the --debug and --debug-file given on the command line;
+// either one turns tracing on. SORA_DEBUG=1 (or SORA2_DEBUG) and
+// SORA2_DEBUG_FILE do the same from the environment.
+var debugSettings struct {
+	enabled bool
+	file    string
+}
+
+func registerDebugFlags(fs *flag.FlagSet) {
+	// Func flags leave the settings alone unless given, so a --debug from
+	// before the command survives the command's own flag set.
+	fs.BoolFunc("debug", "trace every API request and response to stderr, with the Authorization header redacted", func(value string) error {
+		enabled, err := strconv.ParseBool(value)
+		if enabled {
+			debugSettings.enabled = true
+		}
+		return err
+	})
+	fs.Func("debug-file", "append the --debug trace to `path` instead of stderr", func(path string) error {
+		debugSettings.enabled, debugSettings.file = true, path
+		return nil
+	})
+}
+
+// splitDebugArg takes a leading --debug off args, so it can also be given
+// before the command and for the interactive menu.
+func splitDebugArg(args []string) []string {
+	for len(args) > 0 && (args[0] == "--debug" || args[0] == "-debug") {
+		debugSettings.enabled = true
+		args = args[1:]
+	}
+	return args
+}
+
+// debugTrace opens where the trace goes, or returns nil when tracing is off.
+func debugTrace() io.Writer {
+	enabled := debugSettings.enabled
+	for _, name := range []string{"SORA_DEBUG", "SORA2_DEBUG"} {
+		if on, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv(name))); on {
+			enabled = true
+		}
+	}
+	path := debugSettings.file
+	if path == "" {
+		path = os.Getenv("SORA2_DEBUG_FILE")
+	}
+	if !enabled && path == "" {
+		if logEnabled("debug") {
+			return logLineWriter{level: "debug"}
+		}
+		return nil
+	}
+	if path == "" {
+		return os.Stderr
+	}
+	expanded, err := expandPath(path)
+	if err == nil {
+		var file *os.File
+		if file, err = os.OpenFile(expanded, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600); err == nil {
+			// The file stays open for the life of the process.
+			return file
+		}
+	}
+	logWarn("unable to open debug file %s, tracing to stderr: %v", path, err)
+	return os.Stderr
+}
+
+// debugTransport writes every request it sends and the response, or error,
+// it gets back to w: method, URL, headers, status, x-request-id and timing.
+// Bodies are left out; they can be whole videos. Secrets are masked.
+type debugTransport struct {
+	next http.RoundTripper
+	mu   sync.Mutex
+	w    io.Writer
+	seq  int
+}
+
+func newDebugTransport(next http.RoundTripper, w io.Writer) *debugTransport {
+	return &debugTransport{next: next, w: w}
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.seq++
+	seq := t.seq
+	t.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[debug #%d] %s --> %s %s\n", seq, time.Now().Format("15:04:05.000"), req.Method, redactCrashText(req.URL.String()))
+	writeDebugHeaders(&b, seq, req.Header)
+	if req.ContentLength > 0 {
+		fmt.Fprintf(&b, "[debug #%d]     (body %s)\n", seq, formatBytes(req.ContentLength))
+	}
+	t.write(b.String())
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	b.Reset()
+	if err != nil {
+		fmt.Fprintf(&b, "[debug #%d] %s <-- error after %s: %s\n", seq, time.Now().Format("15:04:05.000"), elapsed, redactCrashText(err.Error()))
+		t.write(b.String())
+		return nil, err
+	}
+	requestID := resp.Header.Get("X-Request-Id")
+	if requestID == "" {
+		requestID = "-"
+	}
+	fmt.Fprintf(&b, "[debug #%d] %s <-- %s in %s (x-request-id %s)\n", seq, time.Now().Format("15:04:05.000"), resp.Status, elapsed, requestID)
+	writeDebugHeaders(&b, seq, resp.Header)
+	t.write(b.String())
+	return resp, nil
+}
+
+func (t *debugTransport) write(text string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	io.WriteString(t.w, text)
+}
+
+// writeDebugHeaders lists headers sorted by name, with credentials masked.
+func writeDebugHeaders(b *strings.Builder, seq int, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			switch strings.ToLower(name) {
+			case "authorization", "proxy-authorization", "cookie", "set-cookie":
+				scheme, _, found := strings.Cut(value, " ")
+				if found {
+					value = scheme + " ****"
+				} else {
+					value = "****"
+				}
+			default:
+				value = redactCrashText(value)
+			}
+			fmt.Fprintf(b, "[debug #%d]     %s: %s\n", seq, name, value)
+		}
+	}
+}
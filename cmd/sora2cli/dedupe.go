@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+const defaultDedupeWindowMinutes = 15
+
+// dedupeConfig controls the check for identical jobs that are already
+// rendering. Everyone on a team shares the project's video list, so a job
+// submitted by a colleague is found as well.
+type dedupeConfig struct {
+	// WindowMinutes limits the search to jobs created this recently; 0
+	// disables the check.
+	WindowMinutes int `yaml:"window_minutes,omitempty" env:"SORA2_DEDUPE_WINDOW"`
+}
+
+// findInFlightDuplicate returns the most recent unfinished job created within
+// the window whose prompt, model, duration and size match params. Jobs with a
+// reference are never matched because the API does not report the reference.
+func findInFlightDuplicate(ctx context.Context, client *sora.Client, params sora.CreateParams, window time.Duration) (*sora.Video, error) {
+	if window <= 0 || len(params.References()) > 0 {
+		return nil, nil
+	}
+	cutoff := time.Now().Add(-window).Unix()
+	list := sora.ListParams{Limit: 100, Order: "desc"}
+	for {
+		page, err := client.List(ctx, list)
+		if err != nil {
+			return nil, err
+		}
+		for i := range page.Data {
+			video := &page.Data[i]
+			if video.CreatedAt < cutoff {
+				return nil, nil
+			}
+			if !video.Done() && sameJob(video, params) {
+				return video, nil
+			}
+		}
+		list.After = page.Cursor()
+		if !page.HasMore || list.After == "" {
+			return nil, nil
+		}
+	}
+}
+
+func sameJob(video *sora.Video, params sora.CreateParams) bool {
+	return video.RemixedFromVideoID == "" &&
+		strings.TrimSpace(video.Prompt) == params.Prompt &&
+		strings.EqualFold(video.Model, params.Model) &&
+		video.Seconds == params.Seconds &&
+		video.Size == params.Size
+}
+
+// reuseInFlightDuplicate looks for an identical job that is still rendering
+// and, if the user agrees, returns it so the caller can follow it instead of
+// paying for a second one. Without a terminal to ask, the match is only
+// reported and nil is returned.
+func reuseInFlightDuplicate(ctx context.Context, reader *bufio.Reader, out io.Writer, client *sora.Client, cfg *resolvedConfig, params sora.CreateParams, nonInteractive bool) *sora.Video {
+	window := time.Duration(cfg.Dedupe.WindowMinutes) * time.Minute
+	video, err := findInFlightDuplicate(ctx, client, params, window)
+	if err != nil {
+		logWarn("unable to check for duplicate jobs: %v", err)
+		return nil
+	}
+	if video == nil {
+		return nil
+	}
+	age := time.Since(time.Unix(video.CreatedAt, 0)).Round(time.Second)
+	logInfo("An identical job is already in flight: %s, %s, submitted %s ago", video.ID, formatProgress(video), age)
+	if nonInteractive {
+		logInfo("Submitting anyway; run 'sora2cli get %s --wait' to follow the existing job instead.", video.ID)
+		return nil
+	}
+	if !promptConfirm(reader, out, "Reuse it instead of submitting a new job?") {
+		return nil
+	}
+	logInfo("Following job %s", video.ID)
+	return video
+}
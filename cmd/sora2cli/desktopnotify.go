@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const desktopNotifyTimeout = 10 * time.Second
+
+// desktopNotifyScript shows a Windows toast. The title and body arrive in
+// the environment so that no quoting has to survive PowerShell.
+const desktopNotifyScript = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $xml.GetElementsByTagName('text')
+$text.Item(0).AppendChild($xml.CreateTextNode($env:SORA2_NOTIFY_TITLE)) | Out-Null
+$text.Item(1).AppendChild($xml.CreateTextNode($env:SORA2_NOTIFY_BODY)) | Out-Null
+$toast = [Windows.UI.Notifications.ToastNotification]::new($xml)
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('sora2cli').Show($toast)`
+
+// appleScriptString quotes s as an AppleScript string literal.
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// desktopNotifyCommand returns the command that shows a native notification:
+// osascript on macOS, a PowerShell toast on Windows and notify-send
+// elsewhere.
+func desktopNotifyCommand(ctx context.Context, title, body string) *exec.Cmd {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.CommandContext(ctx, "osascript", "-e",
+			"display notification "+appleScriptString(body)+" with title "+appleScriptString(title))
+	case "windows":
+		cmd := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", desktopNotifyScript)
+		cmd.Env = append(os.Environ(), "SORA2_NOTIFY_TITLE="+title, "SORA2_NOTIFY_BODY="+body)
+		return cmd
+	default:
+		return exec.CommandContext(ctx, "notify-send", "--app-name=sora2cli", title, body)
+	}
+}
+
+func sendDesktopNotification(title, body string) error {
+	if !desktopAvailable() {
+		return errors.New("no desktop session to notify")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), desktopNotifyTimeout)
+	defer cancel()
+	if out, err := desktopNotifyCommand(ctx, title, body).CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// notifyDesktopOrWarn announces a finished job on the desktop when --notify
+// or notifications.desktop asks for it. Events from before a job was
+// submitted are not announced; their error is on screen already.
+func notifyDesktopOrWarn(enabled bool, event ticketEvent) {
+	if !enabled || event.JobID == "" {
+		return
+	}
+	var title string
+	lines := []string{event.JobID}
+	if event.Prompt != "" {
+		lines[0] += ": " + truncateText(event.Prompt, 80)
+	}
+	switch event.Status {
+	case "completed":
+		title = "Video ready"
+		if event.OutputPath != "" {
+			lines = append(lines, "Saved to "+event.OutputPath)
+		}
+	default:
+		title = "Video failed"
+		if event.Error != "" {
+			lines = append(lines, truncateText(event.Error, 160))
+		}
+	}
+	if err := sendDesktopNotification(title, strings.Join(lines, "\n")); err != nil {
+		logWarn("unable to show a desktop notification: %v", err)
+	}
+}
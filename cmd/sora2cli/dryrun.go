@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+// dryRunRequest is the --json form of a request a dry run did not send.
+type dryRunRequest struct {
+	Line          int               `json:"line,omitempty"`
+	Method        string            `json:"method,omitempty"`
+	URL           string            `json:"url,omitempty"`
+	ContentType   string            `json:"content_type,omitempty"`
+	ContentLength int64             `json:"content_length,omitempty"`
+	Fields        map[string]string `json:"fields,omitempty"`
+	References    []dryRunReference `json:"references,omitempty"`
+	EstimatedCost float64           `json:"estimated_cost,omitempty"`
+	Error         string            `json:"error,omitempty"`
+}
+
+type dryRunReference struct {
+	Path     string `json:"path"`
+	MIMEType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+}
+
+func newDryRunRequest(plan *sora.RequestPlan, cost float64) dryRunRequest {
+	req := dryRunRequest{
+		Method:        plan.Method,
+		URL:           plan.URL,
+		ContentType:   plan.ContentType,
+		ContentLength: plan.ContentLength,
+		Fields:        make(map[string]string, len(plan.Fields)),
+		EstimatedCost: cost,
+	}
+	for _, field := range plan.Fields {
+		req.Fields[field.Name] = field.Value
+	}
+	for _, ref := range plan.References {
+		req.References = append(req.References, dryRunReference(ref))
+	}
+	return req
+}
+
+// printRequestPlan writes the request as it would go over the wire, minus
+// the credentials and the reference file contents.
+func printRequestPlan(w io.Writer, plan *sora.RequestPlan, indent string) {
+	fmt.Fprintf(w, "%s%s %s\n", indent, plan.Method, plan.URL)
+	fmt.Fprintf(w, "%sContent-Type: %s\n", indent, plan.ContentType)
+	fmt.Fprintf(w, "%sContent-Length: %d (%s)\n", indent, plan.ContentLength, formatBytes(plan.ContentLength))
+	for _, field := range plan.Fields {
+		fmt.Fprintf(w, "%s  %s: %s\n", indent, field.Name, field.Value)
+	}
+	for _, ref := range plan.References {
+		fmt.Fprintf(w, "%s  input_reference: %s (%s, %s)\n", indent, ref.Path, ref.MIMEType, formatBytes(ref.Size))
+	}
+}
+
+// reportDryRun prints the request a create or remix would have sent. A
+// negative cost is unknown.
+func reportDryRun(plan *sora.RequestPlan, err error, cost float64) bool {
+	if err != nil {
+		logError("%v", err)
+		emitJSONError(err, "")
+		return false
+	}
+	fmt.Println("Dry run; this request was not sent:")
+	printRequestPlan(os.Stdout, plan, "  ")
+	if cost >= 0 {
+		fmt.Printf("Estimated cost: $%.2f\n", cost)
+	} else {
+		fmt.Println("Estimated cost: unknown")
+	}
+	emitJSON(newDryRunRequest(plan, max(cost, 0)))
+	return true
+}
+
+// runBatchDryRun validates every spec of a batch and prints the create
+// request of each one that would be rendered: every valid line, or with
+// apply only those the plan creates.
+func runBatchDryRun(client *sora.Client, cfg *resolvedConfig, r io.Reader, baseDir string, apply bool, budget *budgetGuard) int {
+	plan, _, err := planBatch(r, cfg.Defaults, baseDir)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	if apply {
+		printBatchPlan(os.Stdout, plan)
+		fmt.Println()
+	}
+	requests, invalid, skipped := 0, 0, 0
+	var total float64
+	var specs []jobSpec
+	for _, line := range plan.Lines {
+		if line.Action != planInvalid && apply && line.Action != planCreate {
+			continue
+		}
+		var request *sora.RequestPlan
+		var err error
+		if line.Action == planInvalid {
+			err = errors.New(line.Detail)
+		} else {
+			request, err = client.PlanCreate(line.Spec.createParams())
+		}
+		if err != nil {
+			invalid++
+			logError("line %d: %v", line.Line, err)
+			emitJSON(dryRunRequest{Line: line.Line, Error: err.Error()})
+			continue
+		}
+		requests++
+		total += line.EstimatedCost
+		specs = append(specs, line.Spec)
+		fmt.Printf("Line %d ($%.2f):\n", line.Line, line.EstimatedCost)
+		printRequestPlan(os.Stdout, request, "  ")
+		if err := budget.reserve("  ", line.EstimatedCost); err != nil {
+			skipped++
+			fmt.Printf("  Would be skipped: %v\n", err)
+		}
+		result := newDryRunRequest(request, line.EstimatedCost)
+		result.Line = line.Line
+		emitJSON(result)
+	}
+	fmt.Printf("\nDry run: %d request(s) for an estimated $%.2f; nothing was sent.\n", requests, total)
+	if skipped > 0 {
+		fmt.Printf("%d of them would be skipped for the budget.\n", skipped)
+	}
+	if estimator, err := newOutputEstimator(); err == nil && requests > 0 {
+		bytes, guessed := estimator.total(specs)
+		fmt.Printf("Estimated output: %s\n", describeOutputEstimate(bytes, guessed, requests))
+		if limit, err := parseByteSize(cfg.Storage.BatchLimit); err == nil && bytes > limit {
+			fmt.Printf("That is over the storage.batch_limit of %s.\n", formatBytes(limit))
+		}
+	}
+	if status := budget.status(); status != "" {
+		fmt.Println(status)
+	}
+	if invalid > 0 {
+		logError("%d invalid line(s)", invalid)
+		return 1
+	}
+	return 0
+}
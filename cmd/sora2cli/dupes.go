@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+// spritesheetGrid is how many cells a spritesheet is cut into along each
+// side. The API lays the sheet out as a grid of frames; cutting every sheet
+// the same way lines up the frames of two videos without knowing the layout.
+const spritesheetGrid = 4
+
+const defaultDupeThreshold = 10
+
+func runDupesCommand(args []string) int {
+	fs := newCommandFlagSet("dupes")
+	threshold := fs.Int("threshold", defaultDupeThreshold, "largest average frame distance, in bits out of 64, that counts as a near duplicate")
+	offline := fs.Bool("offline", false, "only hash spritesheets saved next to the videos; never download them")
+	jsonOutput := fs.Bool("json", false, "print one JSON object per group of near duplicates on stdout")
+	registerFormatFlag(fs, jsonOutput)
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli dupes [--threshold bits] [--offline] [--json]")
+		return 2
+	}
+	if *threshold < 0 || *threshold > 64 {
+		logError("--threshold must be between 0 and 64")
+		return 2
+	}
+	if *jsonOutput {
+		enableJSONOutput()
+	}
+
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	state, err := loadHistory()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	var client *sora.Client
+	if !*offline && cfg.APIKey != "" {
+		client = newAPIClient(cfg, cfg.APIKey)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	var hashed []*historyEntry
+	unhashed := 0
+	for _, entry := range state.Entries {
+		if entry.Status != "completed" || entry.OutputPath == "" {
+			continue
+		}
+		if _, err := os.Stat(entry.OutputPath); err != nil {
+			continue
+		}
+		if len(entry.FrameHashes) == 0 {
+			hashes, err := hashVideoFrames(ctx, client, entry)
+			if err != nil {
+				logWarn("skipping %s: %v", entry.JobID, err)
+				unhashed++
+				continue
+			}
+			entry.FrameHashes = hashes
+			updateHistoryOrWarn(entry.JobID, false, func(e *historyEntry) { e.FrameHashes = hashes })
+		}
+		hashed = append(hashed, entry)
+	}
+
+	groups := groupNearDuplicates(hashed, float64(*threshold))
+	for _, group := range groups {
+		emitJSON(group)
+	}
+	if len(groups) == 0 {
+		fmt.Printf("No near duplicates among %d video(s).\n", len(hashed))
+	}
+	for i, group := range groups {
+		fmt.Printf("Group %d: %d videos, frame distance up to %.1f\n", i+1, len(group.Videos), group.Distance)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, video := range group.Videos {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", video.JobID, formatTimestamp(video.CreatedAt), video.OutputPath, truncateText(video.Prompt, 50))
+		}
+		tw.Flush()
+	}
+	if unhashed > 0 {
+		fmt.Printf("%d video(s) could not be hashed and were left out.\n", unhashed)
+	}
+	return 0
+}
+
+// hashVideoFrames returns the perceptual hashes of the frames in the video's
+// spritesheet, read from next to the MP4 or, with a client, downloaded.
+func hashVideoFrames(ctx context.Context, client *sora.Client, entry *historyEntry) ([]string, error) {
+	var sheet io.Reader
+	path := filepath.Join(filepath.Dir(entry.OutputPath), variantFilename(entry.JobID, sora.VariantSpritesheet))
+	if data, err := os.ReadFile(path); err == nil {
+		sheet = bytes.NewReader(data)
+	} else if client == nil || !entry.DeletedAt.IsZero() {
+		return nil, fmt.Errorf("no local spritesheet (%s)", path)
+	} else {
+		var buf bytes.Buffer
+		if err := client.DownloadVariant(ctx, entry.JobID, sora.VariantSpritesheet, &buf); err != nil {
+			return nil, fmt.Errorf("download spritesheet: %w", err)
+		}
+		sheet = &buf
+	}
+	img, _, err := image.Decode(sheet)
+	if err != nil {
+		return nil, fmt.Errorf("decode spritesheet: %w", err)
+	}
+	bounds := img.Bounds()
+	var hashes []string
+	for row := 0; row < spritesheetGrid; row++ {
+		for col := 0; col < spritesheetGrid; col++ {
+			cell := image.Rect(
+				bounds.Min.X+bounds.Dx()*col/spritesheetGrid,
+				bounds.Min.Y+bounds.Dy()*row/spritesheetGrid,
+				bounds.Min.X+bounds.Dx()*(col+1)/spritesheetGrid,
+				bounds.Min.Y+bounds.Dy()*(row+1)/spritesheetGrid,
+			)
+			hashes = append(hashes, fmt.Sprintf("%016x", perceptualHash(img, cell)))
+		}
+	}
+	return hashes, nil
+}
+
+// perceptualHash is the DCT hash of the cell of img: the cell is shrunk to
+// 32x32 grey levels, and each bit says whether one of the 63 lowest
+// frequencies (skipping the average) is above their median.
+func perceptualHash(img image.Image, cell image.Rectangle) uint64 {
+	const size = 32
+	var grey [size][size]float64
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			x0 := cell.Min.X + cell.Dx()*x/size
+			x1 := max(cell.Min.X+cell.Dx()*(x+1)/size, x0+1)
+			y0 := cell.Min.Y + cell.Dy()*y/size
+			y1 := max(cell.Min.Y+cell.Dy()*(y+1)/size, y0+1)
+			var sum float64
+			for py := y0; py < y1; py++ {
+				for px := x0; px < x1; px++ {
+					r, g, b, _ := img.At(px, py).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+				}
+			}
+			grey[y][x] = sum / float64((x1-x0)*(y1-y0))
+		}
+	}
+
+	var coeffs []float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			if u == 0 && v == 0 {
+				continue
+			}
+			var sum float64
+			for y := 0; y < size; y++ {
+				for x := 0; x < size; x++ {
+					sum += grey[y][x] *
+						math.Cos(float64(2*x+1)*float64(u)*math.Pi/(2*size)) *
+						math.Cos(float64(2*y+1)*float64(v)*math.Pi/(2*size))
+				}
+			}
+			coeffs = append(coeffs, sum)
+		}
+	}
+	sorted := append([]float64(nil), coeffs...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+	var hash uint64
+	for i, c := range coeffs {
+		if c > median {
+			hash |= 1 << i
+		}
+	}
+	return hash
+}
+
+// frameDistance is the average number of differing bits between the hashes
+// of matching frames, or -1 if the videos were hashed differently.
+func frameDistance(a, b []string) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return -1
+	}
+	total := 0
+	for i := range a {
+		x, errA := strconv.ParseUint(a[i], 16, 64)
+		y, errB := strconv.ParseUint(b[i], 16, 64)
+		if errA != nil || errB != nil {
+			return -1
+		}
+		total += bits.OnesCount64(x ^ y)
+	}
+	return float64(total) / float64(len(a))
+}
+
+type dupeVideo struct {
+	JobID      string    `json:"job_id"`
+	Prompt     string    `json:"prompt,omitempty"`
+	OutputPath string    `json:"output_path"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type dupeGroup struct {
+	// Distance is the largest distance between two linked videos of the
+	// group.
+	Distance float64     `json:"distance"`
+	Videos   []dupeVideo `json:"videos"`
+}
+
+// groupNearDuplicates links every pair of videos within threshold and
+// returns the connected groups, oldest video first.
+func groupNearDuplicates(entries []*historyEntry, threshold float64) []dupeGroup {
+	parent := make([]int, len(entries))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	distance := make(map[int]float64)
+	for i := range entries {
+		for j := i + 1; j < len(entries); j++ {
+			d := frameDistance(entries[i].FrameHashes, entries[j].FrameHashes)
+			if d < 0 || d > threshold {
+				continue
+			}
+			ri, rj := find(i), find(j)
+			parent[ri] = rj
+			distance[rj] = max(distance[rj], distance[ri], d)
+		}
+	}
+
+	members := make(map[int][]int)
+	for i := range entries {
+		root := find(i)
+		members[root] = append(members[root], i)
+	}
+	var groups []dupeGroup
+	for root, indexes := range members {
+		if len(indexes) < 2 {
+			continue
+		}
+		group := dupeGroup{Distance: distance[root]}
+		for _, i := range indexes {
+			e := entries[i]
+			group.Videos = append(group.Videos, dupeVideo{JobID: e.JobID, Prompt: e.Prompt, OutputPath: e.OutputPath, CreatedAt: e.CreatedAt})
+		}
+		sort.Slice(group.Videos, func(a, b int) bool {
+			va, vb := group.Videos[a], group.Videos[b]
+			if !va.CreatedAt.Equal(vb.CreatedAt) {
+				return va.CreatedAt.Before(vb.CreatedAt)
+			}
+			return va.JobID < vb.JobID
+		})
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(a, b int) bool {
+		va, vb := groups[a].Videos[0], groups[b].Videos[0]
+		if !va.CreatedAt.Equal(vb.CreatedAt) {
+			return va.CreatedAt.Before(vb.CreatedAt)
+		}
+		return va.JobID < vb.JobID
+	})
+	return groups
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// encodeConfig re-encodes downloaded MP4s for the platforms they are
+// published on. Each profile makes a derived copy next to the original,
+// which is left as it is.
+type encodeConfig struct {
+	// FFmpeg is the ffmpeg binary; by default grade.ffmpeg or the one on
+	// PATH.
+	FFmpeg string `yaml:"ffmpeg,omitempty"`
+	// Auto lists the profiles made for every download; otherwise only those
+	// given with --encode-profile.
+	Auto []string `yaml:"auto,omitempty" env:"SORA2_ENCODE_PROFILES"`
+	// Profiles adds profiles or changes the built-in ones: a field set here
+	// replaces the built-in value, the others are kept.
+	Profiles map[string]encodeProfile `yaml:"profiles,omitempty"`
+}
+
+// encodeProfile holds the ffmpeg settings of one target.
+type encodeProfile struct {
+	// VideoCodec is an ffmpeg encoder such as libx264, libx265 or prores_ks
+	// (default libx264).
+	VideoCodec string `yaml:"video_codec,omitempty"`
+	// VideoProfile is the encoder's profile, such as high for x264 or 3
+	// (HQ) for ProRes.
+	VideoProfile string `yaml:"video_profile,omitempty"`
+	PixelFormat  string `yaml:"pixel_format,omitempty"`
+	// VideoBitrate is the target bitrate, as in 8M; without it the copy is
+	// encoded at CRF quality.
+	VideoBitrate string `yaml:"video_bitrate,omitempty"`
+	// MaxBitrate caps the bitrate, with a buffer of the same size.
+	MaxBitrate string `yaml:"max_bitrate,omitempty"`
+	// CRF is the quality without a VideoBitrate (default 18).
+	CRF int `yaml:"crf,omitempty"`
+	// AudioCodec is an ffmpeg encoder such as aac or pcm_s24le (default
+	// aac), or none to drop the audio.
+	AudioCodec   string `yaml:"audio_codec,omitempty"`
+	AudioBitrate string `yaml:"audio_bitrate,omitempty"`
+	SampleRate   int    `yaml:"sample_rate,omitempty"`
+	// Size is the frame of the copy, as in 1080x1920. The video is fitted
+	// inside it with black bars, or cropped to fill it with fit: crop.
+	Size string `yaml:"size,omitempty"`
+	Fit  string `yaml:"fit,omitempty"`
+	// FPS changes the frame rate; zero keeps the original.
+	FPS int `yaml:"fps,omitempty"`
+	// Container is mp4 (the default), mov, mkv or webm, and sets the file
+	// extension.
+	Container string `yaml:"container,omitempty"`
+	// Args are passed to ffmpeg after the other output options.
+	Args []string `yaml:"args,omitempty"`
+}
+
+const (
+	encodeFitPad           = "pad"
+	encodeFitCrop          = "crop"
+	encodeAudioNone        = "none"
+	defaultEncodeCRF       = 18
+	defaultEncodeContainer = "mp4"
+)
+
+// builtinEncodeProfiles follow the platforms' published upload
+// recommendations.
+var builtinEncodeProfiles = map[string]encodeProfile{
+	"tiktok": {
+		VideoCodec: "libx264", VideoProfile: "high", PixelFormat: "yuv420p",
+		VideoBitrate: "8M", MaxBitrate: "10M",
+		AudioCodec: "aac", AudioBitrate: "192k", SampleRate: 44100,
+		Size: "1080x1920", FPS: 30, Container: "mp4",
+	},
+	"youtube": {
+		VideoCodec: "libx264", VideoProfile: "high", PixelFormat: "yuv420p",
+		CRF:        defaultEncodeCRF,
+		AudioCodec: "aac", AudioBitrate: "384k", SampleRate: 48000,
+		Container: "mp4",
+		Args:      []string{"-bf", "2", "-flags", "+cgop"},
+	},
+	"instagram": {
+		VideoCodec: "libx264", VideoProfile: "high", PixelFormat: "yuv420p",
+		VideoBitrate: "5M", MaxBitrate: "5M",
+		AudioCodec: "aac", AudioBitrate: "128k", SampleRate: 48000,
+		Size: "1080x1920", FPS: 30, Container: "mp4",
+	},
+	"broadcast-prores": {
+		VideoCodec: "prores_ks", VideoProfile: "3", PixelFormat: "yuv422p10le",
+		AudioCodec: "pcm_s24le", SampleRate: 48000,
+		Size: "1920x1080", Container: "mov",
+	},
+}
+
+var (
+	bitratePattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[kKmM]?$`)
+	framePattern   = regexp.MustCompile(`^([0-9]+)x([0-9]+)$`)
+)
+
+// overlay returns p with the fields set in o replacing its own.
+func (p encodeProfile) overlay(o encodeProfile) encodeProfile {
+	set := func(dst *string, src string) {
+		if src != "" {
+			*dst = src
+		}
+	}
+	set(&p.VideoCodec, o.VideoCodec)
+	set(&p.VideoProfile, o.VideoProfile)
+	set(&p.PixelFormat, o.PixelFormat)
+	set(&p.VideoBitrate, o.VideoBitrate)
+	set(&p.MaxBitrate, o.MaxBitrate)
+	set(&p.AudioCodec, o.AudioCodec)
+	set(&p.AudioBitrate, o.AudioBitrate)
+	set(&p.Size, o.Size)
+	set(&p.Fit, o.Fit)
+	set(&p.Container, o.Container)
+	if o.CRF != 0 {
+		p.CRF = o.CRF
+	}
+	if o.SampleRate != 0 {
+		p.SampleRate = o.SampleRate
+	}
+	if o.FPS != 0 {
+		p.FPS = o.FPS
+	}
+	if o.Args != nil {
+		p.Args = o.Args
+	}
+	return p
+}
+
+// profile returns the named profile with the config applied to the
+// built-in one of that name, if any.
+func (c encodeConfig) profile(name string) (encodeProfile, bool) {
+	builtin, isBuiltin := builtinEncodeProfiles[name]
+	configured, isConfigured := c.Profiles[name]
+	if !isBuiltin && !isConfigured {
+		return encodeProfile{}, false
+	}
+	return builtin.overlay(configured), true
+}
+
+// profileNames lists the built-in and configured profiles, sorted.
+func (c encodeConfig) profileNames() []string {
+	names := make([]string, 0, len(builtinEncodeProfiles)+len(c.Profiles))
+	for name := range builtinEncodeProfiles {
+		names = append(names, name)
+	}
+	for name := range c.Profiles {
+		if _, ok := builtinEncodeProfiles[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p encodeProfile) container() string {
+	if p.Container == "" {
+		return defaultEncodeContainer
+	}
+	return p.Container
+}
+
+func validateEncodeConfig(c encodeConfig) []configIssue {
+	var issues []configIssue
+	for _, name := range c.Auto {
+		if _, ok := c.profile(name); !ok {
+			issues = append(issues, configIssue{Key: "encode.auto", Message: fmt.Sprintf("unknown profile %q; available: %s", name, strings.Join(c.profileNames(), ", "))})
+		}
+	}
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prefix := "encode.profiles." + name
+		if !isValidQueueName(name) {
+			issues = append(issues, configIssue{Key: prefix, Message: "profile names may only contain letters, digits, '-' and '_'"})
+		}
+		profile, _ := c.profile(name)
+		issues = append(issues, profile.validate(prefix)...)
+	}
+	return issues
+}
+
+func (p encodeProfile) validate(prefix string) []configIssue {
+	var issues []configIssue
+	for key, value := range map[string]string{"video_bitrate": p.VideoBitrate, "max_bitrate": p.MaxBitrate, "audio_bitrate": p.AudioBitrate} {
+		if value != "" && !bitratePattern.MatchString(value) {
+			issues = append(issues, configIssue{Key: prefix + "." + key, Message: fmt.Sprintf("%q is not a bitrate such as 8M or 192k", value)})
+		}
+	}
+	if p.Size != "" {
+		if m := framePattern.FindStringSubmatch(p.Size); m == nil || m[1] == "0" || m[2] == "0" {
+			issues = append(issues, configIssue{Key: prefix + ".size", Message: fmt.Sprintf("%q is not a frame size such as 1080x1920", p.Size)})
+		}
+	}
+	switch p.Fit {
+	case "", encodeFitPad, encodeFitCrop:
+	default:
+		issues = append(issues, configIssue{Key: prefix + ".fit", Message: fmt.Sprintf("unknown fit %q; use pad or crop", p.Fit)})
+	}
+	switch p.container() {
+	case "mp4", "mov", "mkv", "webm":
+	default:
+		issues = append(issues, configIssue{Key: prefix + ".container", Message: fmt.Sprintf("unknown container %q; use mp4, mov, mkv or webm", p.Container)})
+	}
+	if p.CRF < 0 || p.CRF > 63 {
+		issues = append(issues, configIssue{Key: prefix + ".crf", Message: "must be between 0 and 63"})
+	}
+	if p.FPS < 0 || p.FPS > maxInterpolateFPS {
+		issues = append(issues, configIssue{Key: prefix + ".fps", Message: fmt.Sprintf("must be between 1 and %d", maxInterpolateFPS)})
+	}
+	if p.SampleRate < 0 {
+		issues = append(issues, configIssue{Key: prefix + ".sample_rate", Message: "must not be negative"})
+	}
+	sort.Slice(issues, func(i, j int) bool { return issues[i].Key < issues[j].Key })
+	return issues
+}
+
+// encodeTarget is a profile resolved for one run.
+type encodeTarget struct {
+	Name    string
+	FFmpeg  string
+	Profile encodeProfile
+}
+
+// encodeTargets resolves the named profiles, dropping repeats, with the
+// ffmpeg binary taken from grade when encode does not set one.
+func (c *resolvedConfig) encodeTargets(names []string) ([]encodeTarget, error) {
+	ffmpeg := c.Encode.FFmpeg
+	if ffmpeg == "" {
+		ffmpeg = c.Grade.FFmpeg
+	}
+	var targets []encodeTarget
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		profile, ok := c.Encode.profile(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown encode profile %q; available: %s", name, strings.Join(c.Encode.profileNames(), ", "))
+		}
+		targets = append(targets, encodeTarget{Name: name, FFmpeg: ffmpeg, Profile: profile})
+	}
+	return targets, nil
+}
+
+// outputPath names the copy, as in <id>.tiktok.mp4.
+func (t encodeTarget) outputPath(path string) string {
+	return strings.TrimSuffix(path, ".mp4") + "." + t.Name + "." + t.Profile.container()
+}
+
+// ffmpegArgs builds the command line that encodes src to dst.
+func (p encodeProfile) ffmpegArgs(src, dst string) []string {
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y", "-i", src, "-map", "0:v:0", "-map_metadata", "0"}
+	var filters []string
+	if m := framePattern.FindStringSubmatch(p.Size); m != nil {
+		w, h := m[1], m[2]
+		if p.Fit == encodeFitCrop {
+			filters = append(filters, fmt.Sprintf("scale=%s:%s:force_original_aspect_ratio=increase", w, h), fmt.Sprintf("crop=%s:%s", w, h))
+		} else {
+			filters = append(filters, fmt.Sprintf("scale=%s:%s:force_original_aspect_ratio=decrease", w, h), fmt.Sprintf("pad=%s:%s:(ow-iw)/2:(oh-ih)/2", w, h))
+		}
+		filters = append(filters, "setsar=1")
+	}
+	if p.FPS > 0 {
+		filters = append(filters, "fps="+strconv.Itoa(p.FPS))
+	}
+	if len(filters) > 0 {
+		args = append(args, "-vf", strings.Join(filters, ","))
+	}
+
+	codec := p.VideoCodec
+	if codec == "" {
+		codec = "libx264"
+	}
+	args = append(args, "-c:v", codec)
+	if p.VideoProfile != "" {
+		args = append(args, "-profile:v", p.VideoProfile)
+	}
+	if p.PixelFormat != "" {
+		args = append(args, "-pix_fmt", p.PixelFormat)
+	}
+	if codec == "libx264" || codec == "libx265" {
+		args = append(args, "-preset", "medium")
+	}
+	if p.VideoBitrate != "" {
+		args = append(args, "-b:v", p.VideoBitrate)
+	} else if codec != "prores_ks" {
+		crf := p.CRF
+		if crf == 0 {
+			crf = defaultEncodeCRF
+		}
+		args = append(args, "-crf", strconv.Itoa(crf))
+		if codec == "libvpx-vp9" {
+			// Without a zero bitrate libvpx treats the CRF as a ceiling.
+			args = append(args, "-b:v", "0")
+		}
+	}
+	if p.MaxBitrate != "" {
+		args = append(args, "-maxrate", p.MaxBitrate, "-bufsize", p.MaxBitrate)
+	}
+
+	if p.AudioCodec == encodeAudioNone {
+		args = append(args, "-an")
+	} else {
+		audio := p.AudioCodec
+		if audio == "" {
+			audio = "aac"
+		}
+		args = append(args, "-map", "0:a?", "-c:a", audio)
+		if p.AudioBitrate != "" {
+			args = append(args, "-b:a", p.AudioBitrate)
+		}
+		if p.SampleRate > 0 {
+			args = append(args, "-ar", strconv.Itoa(p.SampleRate))
+		}
+	}
+	if c := p.container(); c == "mp4" || c == "mov" {
+		args = append(args, "-movflags", "+faststart")
+	}
+	args = append(args, p.Args...)
+	return append(args, dst)
+}
+
+// encodeVideo writes the copy of the MP4 at path for target and returns
+// where it went. An existing copy is replaced.
+func encodeVideo(ctx context.Context, target encodeTarget, path string) (string, error) {
+	if issues := target.Profile.validate("encode.profiles." + target.Name); len(issues) > 0 {
+		return "", fmt.Errorf("%s: %s", issues[0].Key, issues[0].Message)
+	}
+	ffmpeg, err := lookupFFmpeg(target.FFmpeg, "encode.ffmpeg")
+	if err != nil {
+		return "", err
+	}
+	outPath := target.outputPath(path)
+	ext := "." + target.Profile.container()
+	tmpPath := strings.TrimSuffix(outPath, ext) + ".partial" + ext
+	if err := runTool(ctx, ffmpeg, target.Profile.ffmpegArgs(path, tmpPath)...); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return outPath, os.Rename(tmpPath, outPath)
+}
+
+// encodeDownload makes the copy of a fresh download for target and links it
+// from the job's history entry. A failure only warns: the video itself is
+// fine.
+func encodeDownload(ctx context.Context, target encodeTarget, jobID, path string) string {
+	logInfo("Encoding %s for %s...", jobID, target.Name)
+	outPath, err := encodeVideo(ctx, target, path)
+	if err != nil {
+		logWarn("unable to encode %s for %s: %v", jobID, target.Name, err)
+		return ""
+	}
+	logInfo("Saved %s to %s", target.Name, outPath)
+	recordDerived(jobID, target.Name, outPath)
+	return outPath
+}
+
+// describe summarises a profile for encode --list.
+func (p encodeProfile) describe() string {
+	codec := p.VideoCodec
+	if codec == "" {
+		codec = "libx264"
+	}
+	parts := []string{codec}
+	if p.VideoBitrate != "" {
+		parts = append(parts, p.VideoBitrate)
+	} else if codec != "prores_ks" {
+		crf := p.CRF
+		if crf == 0 {
+			crf = defaultEncodeCRF
+		}
+		parts = append(parts, fmt.Sprintf("crf %d", crf))
+	}
+	if p.Size != "" {
+		parts = append(parts, p.Size)
+	}
+	if p.FPS > 0 {
+		parts = append(parts, fmt.Sprintf("%dfps", p.FPS))
+	}
+	audio := p.AudioCodec
+	if audio == "" {
+		audio = "aac"
+	}
+	if p.AudioBitrate != "" {
+		audio += " " + p.AudioBitrate
+	}
+	parts = append(parts, audio, p.container())
+	return strings.Join(parts, ", ")
+}
+
+// runEncodeCommand encodes videos that are already downloaded.
+func runEncodeCommand(args []string) int {
+	fs := newCommandFlagSet("encode")
+	var names stringList
+	fs.Var(&names, "encode-profile", "profile to encode for; repeat for several (default: encode.auto)")
+	list := fs.Bool("list", false, "list the available profiles")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	if *list {
+		for _, name := range cfg.Encode.profileNames() {
+			profile, _ := cfg.Encode.profile(name)
+			origin := "built-in"
+			if _, ok := cfg.Encode.Profiles[name]; ok {
+				origin = "config"
+			}
+			fmt.Printf("%-18s %s (%s)\n", name, profile.describe(), origin)
+		}
+		return 0
+	}
+	if len(names) == 0 {
+		names = cfg.Encode.Auto
+	}
+	if fs.NArg() == 0 || len(names) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli encode --encode-profile name [--encode-profile name...] <video-id>...")
+		return 2
+	}
+	targets, err := cfg.encodeTargets(names)
+	if err != nil {
+		logError("%v", err)
+		return 2
+	}
+	state, err := loadHistory()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Hour)
+	defer cancel()
+	failed := 0
+	for _, jobID := range fs.Args() {
+		entry := state.find(jobID)
+		if entry == nil || entry.OutputPath == "" {
+			logError("%s: no downloaded video in history", jobID)
+			failed++
+			continue
+		}
+		if err := cfg.Review.approved(entry, jobID); err != nil {
+			logError("%v", err)
+			failed++
+			continue
+		}
+		for _, target := range targets {
+			outPath, err := encodeVideo(ctx, target, entry.OutputPath)
+			if err != nil {
+				logError("%s: %s: %v", jobID, target.Name, err)
+				failed++
+				continue
+			}
+			logInfo("Saved %s to %s", target.Name, outPath)
+			recordDerived(jobID, target.Name, outPath)
+		}
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
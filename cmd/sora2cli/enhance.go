@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+// enhanceConfig is the optional pass that has a chat model expand a short
+// prompt into a detailed one before it is rendered. It uses the same API key.
+type enhanceConfig struct {
+	// Model is the chat model (default gpt-4.1-mini).
+	Model string `yaml:"model,omitempty" env:"SORA2_ENHANCE_MODEL"`
+	// Instructions replace the built-in system prompt.
+	Instructions string `yaml:"instructions,omitempty"`
+	// Auto enhances every create; otherwise only with --enhance.
+	Auto bool `yaml:"auto,omitempty" env:"SORA2_ENHANCE"`
+}
+
+const defaultEnhanceModel = "gpt-4.1-mini"
+
+const defaultEnhanceInstructions = `You turn short video ideas into prompts for the Sora video model.
+Expand the idea into one cinematic paragraph of at most 120 words: subject and action, setting, camera framing and movement, lens, lighting, colour, mood and sound.
+Keep every element of the original idea and do not contradict it. Do not add on-screen text, logos, brands or real people that were not asked for.
+Reply with the prompt only, without quotes or commentary.`
+
+func (c enhanceConfig) model() string {
+	if c.Model == "" {
+		return defaultEnhanceModel
+	}
+	return c.Model
+}
+
+// enhancePrompt asks the chat model for an expanded version of prompt.
+func enhancePrompt(ctx context.Context, client *sora.Client, cfg enhanceConfig, prompt string) (string, error) {
+	instructions := cfg.Instructions
+	if instructions == "" {
+		instructions = defaultEnhanceInstructions
+	}
+	reply, err := client.Chat(ctx, cfg.model(), []sora.ChatMessage{
+		{Role: "system", Content: instructions},
+		{Role: "user", Content: prompt},
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(reply, "\"“” \n"), nil
+}
+
+// wordDiff marks the words removed from a as [-...-] and those added in b
+// as {+...+}.
+func wordDiff(a, b string) string {
+	x, y := strings.Fields(a), strings.Fields(b)
+	// lcs[i][j] is the length of the longest common run of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var out []string
+	var removed, added []string
+	flush := func() {
+		if len(removed) > 0 {
+			out = append(out, "[-"+strings.Join(removed, " ")+"-]")
+			removed = nil
+		}
+		if len(added) > 0 {
+			out = append(out, "{+"+strings.Join(added, " ")+"+}")
+			added = nil
+		}
+	}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			flush()
+			out = append(out, x[i])
+			i++
+			j++
+		case j < len(y) && (i == len(x) || lcs[i][j+1] >= lcs[i+1][j]):
+			added = append(added, y[j])
+			j++
+		default:
+			removed = append(removed, x[i])
+			i++
+		}
+	}
+	flush()
+	return strings.Join(out, " ")
+}
+
+// editText lets the user change text in $VISUAL or $EDITOR, or type a
+// replacement when neither is set.
+func editText(reader *bufio.Reader, out io.Writer, text string) (string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		return promptText(reader, out, "Prompt"), nil
+	}
+	file, err := os.CreateTemp("", "sora2cli-prompt-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(text + "\n"); err != nil {
+		file.Close()
+		return "", err
+	}
+	file.Close()
+	fields := strings.Fields(editor)
+	cmd := exec.Command(fields[0], append(fields[1:], file.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %w", fields[0], err)
+	}
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		return "", err
+	}
+	edited := strings.TrimSpace(string(data))
+	if edited == "" {
+		return "", fmt.Errorf("the prompt is empty")
+	}
+	return edited, nil
+}
+
+// reviewEnhancedPrompt runs the enhance pass for create and returns the
+// prompt to submit. Interactively the user accepts, edits or rejects the
+// expansion; otherwise it is taken as it is. A failed pass keeps the
+// original with a warning.
+func reviewEnhancedPrompt(reader *bufio.Reader, out io.Writer, client *sora.Client, cfg enhanceConfig, prompt string, interactive bool) string {
+	logInfo("Enhancing the prompt with %s...", cfg.model())
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	enhanced, err := enhancePrompt(ctx, client, cfg, prompt)
+	if err != nil {
+		logWarn("unable to enhance the prompt, keeping it as written: %v", err)
+		return prompt
+	}
+	fmt.Fprintln(out, "Changes:")
+	fmt.Fprintf(out, "  %s\n", wordDiff(prompt, enhanced))
+	if !interactive {
+		fmt.Fprintf(out, "Enhanced prompt: %s\n", enhanced)
+		return enhanced
+	}
+	for {
+		fmt.Fprint(out, "Use it? [a]ccept, [e]dit, [k]eep the original (default accept): ")
+		input, err := reader.ReadString('\n')
+		if err != nil {
+			logError("input error: %v", err)
+			return prompt
+		}
+		switch strings.ToLower(strings.TrimSpace(input)) {
+		case "", "a", "accept", "y", "yes":
+			return enhanced
+		case "k", "keep", "n", "no":
+			return prompt
+		case "e", "edit":
+			edited, err := editText(reader, out, enhanced)
+			if err != nil {
+				logError("%v", err)
+				continue
+			}
+			fmt.Fprintf(out, "Prompt: %s\n", edited)
+			return edited
+		default:
+			fmt.Fprintln(out, "Please respond with 'a', 'e' or 'k'.")
+		}
+	}
+}
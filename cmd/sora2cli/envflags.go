@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// flagEnvPrefix starts the environment variable of every long flag, so a
+// container can be configured without wrapping the command line.
+const flagEnvPrefix = "SORA2_"
+
+const defaultMaxWait = 30 * time.Minute
+
+// maxWaitDuration bounds how long a command waits for one job.
+var maxWaitDuration = defaultMaxWait
+
+// confirmFlags answer a command's confirmation prompt or allow it to
+// overwrite. They have no variable: one set for a container would also
+// confirm every delete, gc, recover and import run there.
+var confirmFlags = map[string]bool{"yes": true, "force": true}
+
+// flagEnvName returns the environment variable that sets a flag of spec, or
+// "" if it has none. Flags that override a config key share that key's
+// variable, so --out and defaults.destination are both SORA2_OUT_DIR. The
+// same flags on commands that do not use the defaults section, such as
+// queue add --model, have no variable, since they would otherwise override
+// the queue's own settings.
+func flagEnvName(spec commandSpec, name string) string {
+	if confirmFlags[name] {
+		return ""
+	}
+	if key, ok := flagConfigKeys[name]; ok {
+		if !spec.UsesDefaults {
+			return ""
+		}
+		return configEnvName(key)
+	}
+	if name == "config" {
+		return "SORA2_CONFIG"
+	}
+	if len(name) < 2 {
+		return ""
+	}
+	return flagEnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// parseCommandFlags parses args into fs and then fills every flag that was
+// not given on the command line from its environment variable. A flag always
+// wins over its variable, which in turn wins over the config files.
+func parseCommandFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	spec, _ := findCommandSpec(fs.Name())
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] {
+			return
+		}
+		env := flagEnvName(spec, f.Name)
+		if env == "" {
+			return
+		}
+		value := os.Getenv(env)
+		if value == "" {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s (--%s): %v", value, env, f.Name, setErr)
+		}
+	})
+	if err != nil {
+		logError("%v", err)
+	}
+	return err
+}
+
+// maxWait is a --max-wait value. It rejects durations that would time out
+// every job immediately.
+type maxWait struct{ d *time.Duration }
+
+func (m maxWait) String() string {
+	if m.d == nil {
+		return defaultMaxWait.String()
+	}
+	return m.d.String()
+}
+
+func (m maxWait) Set(value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	if d <= 0 {
+		return fmt.Errorf("must be positive")
+	}
+	*m.d = d
+	return nil
+}
+
+// registerMaxWaitFlag adds --max-wait to a command that waits for jobs.
+func registerMaxWaitFlag(fs *flag.FlagSet) {
+	fs.Var(maxWait{&maxWaitDuration}, "max-wait", "how long to wait for a job to finish before giving up, as a `duration` such as 45m or 2h")
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+const (
+	failoverThreshold    = 3
+	failoverProbeDelay   = 2 * time.Second
+	failoverProbeTimeout = 10 * time.Second
+	failbackInterval     = 5 * time.Minute
+	defaultBaseURL       = "https://api.openai.com"
+)
+
+// failoverTransport sends every request to the active base URL of an ordered
+// list. Callers always build URLs against the primary entry; the transport
+// rewrites them. After failoverThreshold consecutive failures to connect or
+// to answer in time, counted across requests, the next healthy base URL
+// becomes active, and the primary is probed again once failbackInterval has
+// elapsed.
+type failoverTransport struct {
+	next      http.RoundTripper
+	endpoints []string
+
+	mu         sync.Mutex
+	active     int
+	failures   int
+	switchedAt time.Time
+}
+
+// retryConfig controls how often a request that failed with a transient API
+// error (429, 500, 502, 503) is sent again before the command gives up.
+type retryConfig struct {
+	// MaxAttempts counts the first request; 1 disables retries.
+	MaxAttempts int `yaml:"max_attempts,omitempty" env:"SORA2_RETRY_MAX_ATTEMPTS"`
+}
+
+// defaultPollMaxInterval caps how far polling of an unchanged job backs off.
+const defaultPollMaxInterval = 30 * time.Second
+
+// pollConfig controls how often jobs are polled while they render. Polls
+// are conditional on the last ETag, and a job whose status and progress stay
+// the same is polled less and less often, which keeps the request volume of
+// a large batch down.
+type pollConfig struct {
+	// Interval is the time between polls (default 5s).
+	Interval string `yaml:"interval,omitempty" env:"SORA2_POLL_INTERVAL"`
+	// MaxInterval is how far the interval may grow while a job is unchanged
+	// (default 30s); set it to the interval to poll at a fixed rate.
+	MaxInterval string `yaml:"max_interval,omitempty" env:"SORA2_POLL_MAX_INTERVAL"`
+}
+
+func (c pollConfig) intervals() (interval, maxInterval time.Duration, err error) {
+	interval, maxInterval = sora.DefaultPollInterval, defaultPollMaxInterval
+	if c.Interval != "" {
+		if interval, err = time.ParseDuration(c.Interval); err != nil || interval < time.Second {
+			return 0, 0, fmt.Errorf("invalid interval %q; use a duration of at least 1s, e.g. 5s", c.Interval)
+		}
+	}
+	if c.MaxInterval != "" {
+		if maxInterval, err = time.ParseDuration(c.MaxInterval); err != nil {
+			return 0, 0, fmt.Errorf("invalid max_interval %q, e.g. 30s", c.MaxInterval)
+		}
+	}
+	if maxInterval < interval {
+		return 0, 0, fmt.Errorf("max_interval %s is shorter than the interval %s", maxInterval, interval)
+	}
+	return interval, maxInterval, nil
+}
+
+func validatePollConfig(c pollConfig) []configIssue {
+	if _, _, err := c.intervals(); err != nil {
+		return []configIssue{{Key: "poll", Message: err.Error()}}
+	}
+	return nil
+}
+
+func resolveBaseURLs(cfg *resolvedConfig) []string {
+	var baseURLs []string
+	for _, candidate := range cfg.BaseURLs {
+		candidate = strings.TrimRight(strings.TrimSpace(candidate), "/")
+		if candidate != "" {
+			baseURLs = append(baseURLs, candidate)
+		}
+	}
+	if len(baseURLs) > 0 {
+		return baseURLs
+	}
+	baseURL := strings.TrimRight(strings.TrimSpace(cfg.BaseURL), "/")
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+	return []string{baseURL}
+}
+
+func newFailoverTransport(next http.RoundTripper, endpoints []string) *failoverTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &failoverTransport{next: next, endpoints: endpoints}
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.maybeFailback(req)
+
+	for attempt := 0; ; attempt++ {
+		idx := t.current()
+		resp, err := t.next.RoundTrip(t.rewrite(req, idx, attempt > 0))
+		if err == nil {
+			t.succeeded(idx)
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+
+		failures := t.failed(idx)
+		logWarn("request to %s failed (%d/%d): %v", t.endpoints[idx], failures, failoverThreshold, err)
+		if !isReplayable(req) {
+			return nil, err
+		}
+		if failures < failoverThreshold {
+			if sleepErr := sleepContext(req.Context(), failoverProbeDelay); sleepErr != nil {
+				return nil, err
+			}
+			if t.probe(req, idx) {
+				// The base URL answers health checks, so this is not an
+				// outage; surface the error instead of failing over.
+				return nil, err
+			}
+			continue
+		}
+
+		nextIdx, ok := t.findHealthy(req, idx)
+		if !ok {
+			return nil, fmt.Errorf("all base URLs unreachable: %w", err)
+		}
+		t.switchTo(idx, nextIdx)
+	}
+}
+
+func (t *failoverTransport) current() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active
+}
+
+// failed counts a failure of the base URL at idx and returns how many there
+// have been in a row while it was active.
+func (t *failoverTransport) failed(idx int) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active == idx {
+		t.failures++
+	}
+	return t.failures
+}
+
+func (t *failoverTransport) succeeded(idx int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active == idx {
+		t.failures = 0
+	}
+}
+
+func (t *failoverTransport) switchTo(from, to int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active != from {
+		return
+	}
+	t.active = to
+	t.failures = 0
+	t.switchedAt = time.Now()
+	logWarn("failing over from %s to %s", t.endpoints[from], t.endpoints[to])
+}
+
+func (t *failoverTransport) maybeFailback(req *http.Request) {
+	t.mu.Lock()
+	active := t.active
+	due := active != 0 && time.Since(t.switchedAt) >= failbackInterval
+	if due {
+		// Push the next check out even if the probe fails so that a dead
+		// primary is not probed before every request.
+		t.switchedAt = time.Now()
+	}
+	t.mu.Unlock()
+	if !due || !t.probe(req, 0) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active == active {
+		t.active, t.failures = 0, 0
+		logInfo("Primary base URL %s is reachable again, switching back", t.endpoints[0])
+	}
+}
+
+func (t *failoverTransport) findHealthy(req *http.Request, failed int) (int, bool) {
+	for offset := 1; offset < len(t.endpoints); offset++ {
+		idx := (failed + offset) % len(t.endpoints)
+		if t.probe(req, idx) {
+			return idx, true
+		}
+	}
+	return 0, false
+}
+
+func (t *failoverTransport) probe(req *http.Request, idx int) bool {
+	ctx, cancel := context.WithTimeout(req.Context(), failoverProbeTimeout)
+	defer cancel()
+
+	probeReq, err := http.NewRequestWithContext(ctx, http.MethodGet, t.endpoints[idx]+"/v1/models", nil)
+	if err != nil {
+		return false
+	}
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		probeReq.Header.Set("Authorization", auth)
+	}
+	resp, err := t.next.RoundTrip(probeReq)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < http.StatusInternalServerError
+}
+
+func (t *failoverTransport) rewrite(req *http.Request, idx int, replay bool) *http.Request {
+	primary := t.endpoints[0]
+	original := req.URL.String()
+	if idx == 0 || !strings.HasPrefix(original, primary) {
+		return cloneWithBody(req, replay)
+	}
+	target, err := url.Parse(t.endpoints[idx] + strings.TrimPrefix(original, primary))
+	if err != nil {
+		return cloneWithBody(req, replay)
+	}
+	clone := cloneWithBody(req, replay)
+	clone.URL = target
+	clone.Host = target.Host
+	return clone
+}
+
+// cloneWithBody copies req for one attempt. The first attempt sends the
+// caller's body, which the transport underneath closes as RoundTrip must;
+// replays send a fresh copy from GetBody.
+func cloneWithBody(req *http.Request, replay bool) *http.Request {
+	clone := req.Clone(req.Context())
+	if replay && req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			clone.Body = body
+		}
+	}
+	return clone
+}
+
+func isReplayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
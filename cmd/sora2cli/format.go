@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// outputFormat is the encoding chosen with --format: json (the default),
+// nuon for Nushell, or psobject for PowerShell's ConvertFrom-Json.
+var outputFormat = "json"
+
+var outputFormats = []string{"json", "nuon", "psobject"}
+
+// registerFormatFlag adds --format next to a command's --json flag. Picking a
+// format turns structured output on.
+func registerFormatFlag(fs *flag.FlagSet, jsonOutput *bool) {
+	fs.Func("format", "structured output on stdout in `format`: "+strings.Join(outputFormats, ", ")+" (implies --json)", func(value string) error {
+		value = strings.ToLower(strings.TrimSpace(value))
+		for _, format := range outputFormats {
+			if value == format {
+				outputFormat = value
+				*jsonOutput = true
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown format %q", value)
+	})
+}
+
+// writeStructured writes v to w as one line in outputFormat.
+func writeStructured(w io.Writer, v any) error {
+	if outputFormat == "json" {
+		return json.NewEncoder(w).Encode(v)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var tree any
+	if err := decoder.Decode(&tree); err != nil {
+		return err
+	}
+	tree = convertTimestamps(tree, "")
+
+	var b bytes.Buffer
+	if outputFormat == "nuon" {
+		writeNUON(&b, tree)
+	} else {
+		encoder := json.NewEncoder(&b)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(tree); err != nil {
+			return err
+		}
+		b.Truncate(b.Len() - 1)
+	}
+	b.WriteByte('\n')
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+// isTimestampKey reports whether a field holds a point in time: the API's
+// created_at style Unix seconds and the RFC 3339 times of local records.
+func isTimestampKey(key string) bool {
+	return key == "time" || strings.HasSuffix(key, "_at")
+}
+
+// convertTimestamps replaces timestamp fields with time.Time values, or nil
+// for unset ones, so both shells receive real dates.
+func convertTimestamps(v any, key string) any {
+	switch value := v.(type) {
+	case map[string]any:
+		for k, elem := range value {
+			value[k] = convertTimestamps(elem, k)
+		}
+	case []any:
+		for i, elem := range value {
+			value[i] = convertTimestamps(elem, key)
+		}
+	case json.Number:
+		if isTimestampKey(key) {
+			if sec, err := value.Int64(); err == nil {
+				if sec <= 0 {
+					return nil
+				}
+				return time.Unix(sec, 0).UTC()
+			}
+		}
+	case string:
+		if isTimestampKey(key) {
+			if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+				if t.IsZero() {
+					return nil
+				}
+				return t
+			}
+		}
+	}
+	return v
+}
+
+// writeNUON renders a decoded JSON tree as Nushell object notation.
+func writeNUON(b *bytes.Buffer, v any) {
+	switch value := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		b.WriteString(strconv.FormatBool(value))
+	case json.Number:
+		b.WriteString(value.String())
+	case string:
+		writeNUONString(b, value)
+	case time.Time:
+		b.WriteString(value.Truncate(time.Second).Format("2006-01-02T15:04:05-07:00"))
+	case []any:
+		b.WriteByte('[')
+		for i, elem := range value {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeNUON(b, elem)
+		}
+		b.WriteByte(']')
+	case map[string]any:
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if isBareNUONKey(k) {
+				b.WriteString(k)
+			} else {
+				writeNUONString(b, k)
+			}
+			b.WriteString(": ")
+			writeNUON(b, value[k])
+		}
+		b.WriteByte('}')
+	}
+}
+
+func isBareNUONKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i, r := range key {
+		if r == '_' || unicode.IsLetter(r) || (i > 0 && (unicode.IsDigit(r) || r == '-')) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+func writeNUONString(b *bytes.Buffer, s string) {
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if unicode.IsControl(r) {
+				fmt.Fprintf(b, `\u{%x}`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+}
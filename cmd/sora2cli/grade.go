@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gradeConfig is the colour grade applied to downloaded MP4s with ffmpeg: a
+// .cube LUT, simple eq adjustments, or both, in that order.
+type gradeConfig struct {
+	// FFmpeg is the ffmpeg binary; by default the one on PATH.
+	FFmpeg string `yaml:"ffmpeg,omitempty" env:"SORA2_FFMPEG"`
+	LUT    string `yaml:"lut,omitempty" env:"SORA2_GRADE_LUT"`
+	// Brightness, Contrast, Saturation and Gamma are passed to ffmpeg's eq
+	// filter. Zero leaves a parameter at its neutral value.
+	Brightness float64 `yaml:"brightness,omitempty"`
+	Contrast   float64 `yaml:"contrast,omitempty"`
+	Saturation float64 `yaml:"saturation,omitempty"`
+	Gamma      float64 `yaml:"gamma,omitempty"`
+	// CRF is the x264 quality of the re-encode (default 16).
+	CRF int `yaml:"crf,omitempty"`
+	// Auto grades every download; otherwise only with --grade.
+	Auto bool `yaml:"auto,omitempty" env:"SORA2_GRADE"`
+	// KeepOriginal keeps the ungraded file as <id>.ungraded.mp4.
+	KeepOriginal bool `yaml:"keep_original,omitempty"`
+}
+
+const defaultGradeCRF = 16
+
+func (g gradeConfig) configured() bool {
+	return g.LUT != "" || g.Brightness != 0 || g.Contrast != 0 || g.Saturation != 0 || g.Gamma != 0
+}
+
+// filter returns the ffmpeg video filter chain of the grade.
+func (g gradeConfig) filter() (string, error) {
+	var filters []string
+	if g.LUT != "" {
+		path, err := expandPath(g.LUT)
+		if err != nil {
+			return "", err
+		}
+		if path, err = filepath.Abs(path); err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("grade.lut: %w", err)
+		}
+		filters = append(filters, "lut3d=file="+escapeFilterValue(path))
+	}
+	var eq []string
+	for _, param := range []struct {
+		name  string
+		value float64
+	}{{"brightness", g.Brightness}, {"contrast", g.Contrast}, {"saturation", g.Saturation}, {"gamma", g.Gamma}} {
+		if param.value != 0 {
+			eq = append(eq, param.name+"="+strconv.FormatFloat(param.value, 'f', -1, 64))
+		}
+	}
+	if len(eq) > 0 {
+		filters = append(filters, "eq="+strings.Join(eq, ":"))
+	}
+	return strings.Join(filters, ","), nil
+}
+
+// escapeFilterValue escapes an option value for ffmpeg's filter syntax and
+// then for the filtergraph around it, so paths with quotes, colons or
+// commas reach the filter intact.
+func escapeFilterValue(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(value)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(value)
+}
+
+// lookupFFmpeg finds the ffmpeg binary configured under key, or the one on
+// PATH.
+func lookupFFmpeg(configured, key string) (string, error) {
+	if configured == "" {
+		configured = "ffmpeg"
+	}
+	path, err := exec.LookPath(configured)
+	if err != nil {
+		return "", fmt.Errorf("%w; install ffmpeg or set %s", err, key)
+	}
+	return path, nil
+}
+
+// runTool runs an external program such as ffmpeg. Its error output is
+// kept for the error, since that is where these tools explain themselves.
+func runTool(ctx context.Context, path string, args ...string) error {
+	cmd := exec.CommandContext(ctx, path, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		name := strings.TrimSuffix(filepath.Base(path), ".exe")
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %v: %s", name, err, msg)
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// applyGrade re-encodes the MP4 at path with the grade, replacing it once
+// ffmpeg has succeeded. Audio, metadata and chapters are copied. If an
+// ungraded original was kept, it is graded instead of the current file.
+func applyGrade(ctx context.Context, grade gradeConfig, path string) error {
+	filter, err := grade.filter()
+	if err != nil {
+		return err
+	}
+	if filter == "" {
+		return fmt.Errorf("no grade configured; set grade.lut or the eq parameters")
+	}
+	ffmpeg, err := lookupFFmpeg(grade.FFmpeg, "grade.ffmpeg")
+	if err != nil {
+		return err
+	}
+	crf := grade.CRF
+	if crf == 0 {
+		crf = defaultGradeCRF
+	}
+
+	// Grading again starts from a kept original instead of stacking grades.
+	source := path
+	original := strings.TrimSuffix(path, ".mp4") + ".ungraded.mp4"
+	_, err = os.Stat(original)
+	haveOriginal := err == nil
+	if haveOriginal {
+		source = original
+	}
+
+	tmpPath := strings.TrimSuffix(path, ".mp4") + ".grading.mp4"
+	err = runTool(ctx, ffmpeg,
+		"-hide_banner", "-loglevel", "error", "-nostdin", "-y",
+		"-i", source,
+		"-vf", filter,
+		"-map", "0", "-map_metadata", "0",
+		"-c:v", "libx264", "-crf", strconv.Itoa(crf), "-preset", "medium", "-pix_fmt", "yuv420p",
+		"-c:a", "copy",
+		"-movflags", "+faststart",
+		tmpPath,
+	)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if grade.KeepOriginal && !haveOriginal {
+		if err := os.Rename(path, original); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// gradeDownload grades a fresh download. A failed grade leaves the ungraded
+// video in place with a warning, since the render has been paid for.
+func gradeDownload(ctx context.Context, grade gradeConfig, jobID, path string) {
+	logInfo("Grading %s...", jobID)
+	if err := applyGrade(ctx, grade, path); err != nil {
+		logWarn("unable to grade %s, the ungraded video is kept: %v", jobID, err)
+		return
+	}
+	logInfo("Graded %s", path)
+}
+
+func validateGradeConfig(g gradeConfig) []configIssue {
+	var issues []configIssue
+	if g.LUT != "" && !strings.EqualFold(filepath.Ext(g.LUT), ".cube") {
+		issues = append(issues, configIssue{Key: "grade.lut", Message: "expected a .cube file"})
+	}
+	for _, param := range []struct {
+		key      string
+		value    float64
+		min, max float64
+	}{
+		{"grade.brightness", g.Brightness, -1, 1},
+		{"grade.contrast", g.Contrast, -1000, 1000},
+		{"grade.saturation", g.Saturation, 0, 3},
+		{"grade.gamma", g.Gamma, 0.1, 10},
+	} {
+		if param.value != 0 && (param.value < param.min || param.value > param.max) {
+			issues = append(issues, configIssue{Key: param.key, Message: fmt.Sprintf("must be between %g and %g", param.min, param.max)})
+		}
+	}
+	if g.CRF < 0 || g.CRF > 51 {
+		issues = append(issues, configIssue{Key: "grade.crf", Message: "must be between 0 and 51"})
+	}
+	if g.Auto && !g.configured() {
+		issues = append(issues, configIssue{Key: "grade.auto", Message: "set grade.lut or an eq parameter to grade with"})
+	}
+	return issues
+}
+
+// runGradeCommand grades videos that are already downloaded.
+func runGradeCommand(args []string) int {
+	fs := newCommandFlagSet("grade")
+	lut := fs.String("lut", "", "apply this .cube LUT instead of grade.lut")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli grade [--lut file.cube] <video-id>...")
+		return 2
+	}
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	grade := cfg.Grade
+	if *lut != "" {
+		grade.LUT = *lut
+	}
+	if !grade.configured() {
+		logError("no grade configured; set grade.lut or the eq parameters, or pass --lut")
+		return 1
+	}
+	state, err := loadHistory()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	failed := 0
+	for _, jobID := range fs.Args() {
+		entry := state.find(jobID)
+		if entry == nil || entry.OutputPath == "" {
+			logError("%s: no downloaded video in history", jobID)
+			failed++
+			continue
+		}
+		if err := applyGrade(ctx, grade, entry.OutputPath); err != nil {
+			logError("%s: %v", jobID, err)
+			failed++
+			continue
+		}
+		logInfo("Graded %s", entry.OutputPath)
+		if size, sum, err := fileDigest(entry.OutputPath); err == nil {
+			updateHistoryOrWarn(jobID, false, func(e *historyEntry) {
+				e.OutputBytes = size
+				e.SHA256 = sum
+			})
+		}
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
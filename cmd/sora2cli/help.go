@@ -0,0 +1,583 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// commandSpec describes a subcommand for dispatch, "sora2cli help" and the
+// man page. The flags themselves are defined once, in the command's run
+// function, and read from its flag set when help is rendered.
+type commandSpec struct {
+	// Name is the command as typed; nested commands such as "queue run"
+	// only appear in help.
+	Name     string
+	Aliases  []string
+	Args     string
+	Summary  string
+	Examples []string
+	// Run executes the command with the arguments after its name.
+	Run func(args []string) int
+	// NoFlags marks commands without a flag set, which are not run to
+	// collect their flags.
+	NoFlags bool
+	// UsesDefaults marks commands whose --model, --out and similar flags
+	// fall back to the defaults section of the config.
+	UsesDefaults bool
+}
+
+var commandSpecs []commandSpec
+
+func init() {
+	commandSpecs = []commandSpec{
+		{Name: "create", Args: "[flags]", Summary: "generate a new video", Run: runCreateCommand, UsesDefaults: true, Examples: []string{
+			`sora2cli create --prompt "Timelapse of a city at dusk" --seconds 8 --size 1280x720 --out ./videos`,
+			`sora2cli create --prompt "Neon rain" --non-interactive --json | jq -r .output_path`,
+			`sora2cli create --prompt "Neon rain" --reference ref.png --dry-run`,
+			`sora2cli create --template product-demo --var product="sneakers"`,
+			`sora2cli create --prompt "a fox in snow" --enhance`,
+			`sora2cli create --answers answers.yaml`,
+		}},
+		{Name: "templates", Summary: "list the prompt templates and their variables", Run: runTemplatesCommand, Examples: []string{
+			`sora2cli templates`,
+		}},
+		{Name: "tag", Args: "[flags] <video-id> [tag...]", Summary: "add or remove tags and set a note on a job in history", Run: runTagCommand, Examples: []string{
+			`sora2cli tag video_123 client-acme hero-shot`,
+			`sora2cli tag --remove hero-shot --note "client picked the second take" video_123`,
+			`sora2cli list --tag client-acme --output wide`,
+		}},
+		{Name: "tags", Args: "[flags]", Summary: "list the tags in history with how many jobs carry each", Run: runTagsCommand},
+		{Name: "prompts", Args: "<add|list|show|rm> ...", Summary: "save, tag and reuse favourite prompts", Run: runPromptsCommand, NoFlags: true},
+		{Name: "prompts add", Args: "[flags] <prompt|->", Summary: "save a prompt to the library, read from stdin with -", Run: promptsSubcommand("add"), Examples: []string{
+			`sora2cli prompts add --name hero-dolly --tag product "Slow dolly-in on a sneaker on wet asphalt at night"`,
+			`sora2cli prompts add --tag brand - < prompt.txt`,
+		}},
+		{Name: "prompts list", Args: "[flags]", Summary: "list the saved prompts", Run: promptsSubcommand("list"), Examples: []string{
+			`sora2cli prompts list --tag product`,
+		}},
+		{Name: "prompts show", Args: "[flags] <name>", Summary: "print a saved prompt", Run: promptsSubcommand("show"), Examples: []string{
+			`sora2cli create --prompt "$(sora2cli prompts show hero-dolly)"`,
+		}},
+		{Name: "prompts rm", Args: "<name>...", Summary: "remove saved prompts", Run: promptsSubcommand("rm")},
+		{Name: "remix", Args: "[flags] [video-id]", Summary: "remix a completed video", Run: runRemixCommand, UsesDefaults: true, Examples: []string{
+			`sora2cli remix --video-id video_123 --prompt "Make it snow" --out ./videos`,
+			`sora2cli remix --session video_123`,
+			`sora2cli remix --video-id video_123 --prompt "Make it snow" --open`,
+		}},
+		{Name: "list", Args: "[flags]", Summary: "list recent videos", Run: runListCommand, Examples: []string{
+			`sora2cli list --limit 50 --order asc --after video_456`,
+			`sora2cli list --status completed --model sora-2-pro --since 7d --contains mountain`,
+			`sora2cli list --limit 100 --output wide`,
+			`sora2cli list --since 30d --output csv > videos.csv`,
+			`sora2cli list --watch --status in_progress --interval 10s`,
+		}},
+		{Name: "tui", Summary: "browse the library, follow active jobs and compose prompts full-screen", Run: runTUICommand},
+		{Name: "get", Args: "[flags] <video-id>...", Summary: "show the status of video jobs", Run: runGetCommand, Examples: []string{
+			`sora2cli get --wait video_123`,
+			`sora2cli get --json video_123 video_456`,
+		}},
+		{Name: "download", Args: "[flags] <video-id>", Summary: "download a completed video", Run: runDownloadCommand, UsesDefaults: true, Examples: []string{
+			`sora2cli download --out ./videos --with-thumbnail video_123`,
+			`sora2cli download --variant spritesheet video_123`,
+		}},
+		{Name: "wait", Aliases: []string{"resume"}, Args: "[flags] <video-id>", Summary: "resume polling and download of an earlier job", Run: func(args []string) int { return runWaitCommand("wait", args) }, UsesDefaults: true, Examples: []string{
+			`sora2cli wait --ticket VID-42 video_123`,
+			`sora2cli wait --notify video_123`,
+			`sora2cli wait --webhook-url https://bots.example.com/sora video_123`,
+		}},
+		{Name: "recover", Args: "[flags]", Summary: "finish jobs an earlier session submitted but never downloaded", Run: runRecoverCommand, Examples: []string{
+			`sora2cli recover --list`,
+			`sora2cli recover --yes`,
+		}},
+		{Name: "cancel", Args: "[flags] <video-id>", Summary: "cancel a queued or in-progress job", Run: runCancelCommand},
+		{Name: "delete", Args: "[flags] <video-id>...", Summary: "delete videos, or with --local their downloads and records", Run: runDeleteCommand, Examples: []string{
+			`sora2cli delete --yes video_123 video_456`,
+			`sora2cli delete --local video_123`,
+		}},
+		{Name: "undo", Args: "[flags] [trash-id]", Summary: "restore what the last delete --local or queue remove took away", Run: runUndoCommand, Examples: []string{
+			`sora2cli undo`,
+			`sora2cli undo --list`,
+		}},
+		{Name: "export", Args: "[flags] (--queue <name> | <video-id>... | --format csv|json)", Summary: "register completed renders in the DAM, write them to CSV or export the library index", Run: runExportCommand, Examples: []string{
+			`sora2cli export --queue drafts --csv assets.csv`,
+			`sora2cli export --format csv --out library.csv`,
+			`sora2cli export --format json --include-local | jq '.[] | select(.tags | index("hero"))'`,
+		}},
+		{Name: "export-zip", Args: "--out file.zip [flags] [video-id...]", Summary: "bundle downloaded videos, sidecars and a manifest into a zip archive", Run: runExportZipCommand, Examples: []string{
+			`sora2cli export-zip --tag campaign:q3 --out q3.zip`,
+			`sora2cli export-zip --review approved --since 2025-07-01 --derived --out delivery.zip`,
+		}},
+		{Name: "audit-remote", Args: "[flags]", Summary: "compare the API's videos with local history", Run: runAuditRemoteCommand, Examples: []string{
+			`sora2cli audit-remote --import`,
+		}},
+		{Name: "gc", Args: "[flags]", Summary: "delete remote copies of verified downloads", Run: runGCCommand, Examples: []string{
+			`sora2cli gc --older-than 72h --dry-run`,
+		}},
+		{Name: "dupes", Args: "[flags]", Summary: "find downloaded videos that look alike", Run: runDupesCommand, Examples: []string{
+			`sora2cli dupes --threshold 6`,
+		}},
+		{Name: "trim", Args: "[flags] <video-id>...", Summary: "cut downloaded videos to an in:out range via ffmpeg", Run: runTrimCommand, Examples: []string{
+			`sora2cli trim --range 0.5:3.8 video_123`,
+			`sora2cli trim --range 0.5: --mode smart video_123 video_456`,
+		}},
+		{Name: "grade", Args: "[flags] <video-id>...", Summary: "apply the configured colour grade to downloaded videos", Run: runGradeCommand, Examples: []string{
+			`sora2cli grade --lut ~/brand/house.cube video_123`,
+		}},
+		{Name: "interpolate", Args: "[flags] <video-id>...", Summary: "save a 60fps or slow-motion copy of downloaded videos", Run: runInterpolateCommand, Examples: []string{
+			`sora2cli interpolate video_123`,
+			`sora2cli interpolate --slowmo 4 --engine rife video_123`,
+		}},
+		{Name: "encode", Args: "[flags] <video-id>...", Summary: "save copies of downloaded videos encoded for a platform", Run: runEncodeCommand, Examples: []string{
+			`sora2cli encode --list`,
+			`sora2cli encode --encode-profile tiktok --encode-profile youtube video_123`,
+		}},
+		{Name: "extract-audio", Args: "[flags] <video-id|file>...", Summary: "save the soundtrack of videos as WAV or MP3", Run: runExtractAudioCommand, Examples: []string{
+			`sora2cli extract-audio video_123`,
+			`sora2cli extract-audio --format mp3 --sample-rate 44100 clip.mp4`,
+		}},
+		{Name: "poster", Args: "[flags] <video-id|file>", Summary: "pick a frame and save it as the poster image", Run: runPosterCommand, Examples: []string{
+			`sora2cli poster video_123`,
+			`sora2cli poster --at 2.5s --format png video_123`,
+		}},
+		{Name: "translate-captions", Args: "[flags] <video-id|file.srt>...", Summary: "translate SRT subtitles into other languages", Run: runTranslateCaptionsCommand, Examples: []string{
+			`sora2cli translate-captions --lang es --lang de video_123`,
+			`sora2cli translate-captions --out subs/ interview.srt`,
+		}},
+		{Name: "chapters", Args: "[flags] <video-id>...", Summary: "detect scenes and write chapter markers", Run: runChaptersCommand, Examples: []string{
+			`sora2cli chapters video_123`,
+		}},
+		{Name: "batch", Args: "(--file prompts.jsonl | --stdin-ndjson) [flags]", Summary: "render a prompts file or a stream of job specs", Run: runBatchCommand, UsesDefaults: true, Examples: []string{
+			`sora2cli batch --file prompts.jsonl --concurrency 2 --out ./renders`,
+			`producer | sora2cli batch --stdin-ndjson --budget 25 --json`,
+			`sora2cli batch --file prompts.jsonl --dry-run`,
+			`sora2cli batch --file nightly.jsonl --status-gate hold`,
+		}},
+		{Name: "batch plan", Args: "--file prompts.jsonl [flags]", Summary: "compare a prompts file with history: what is new, what is done, what it would cost", Run: batchSubcommand("plan"), Examples: []string{
+			`sora2cli batch plan --file nightly.jsonl`,
+		}},
+		{Name: "batch apply", Args: "--file prompts.jsonl [flags]", Summary: "render only the lines batch plan marks for creation", Run: batchSubcommand("apply"), UsesDefaults: true, Examples: []string{
+			`sora2cli batch apply --file nightly.jsonl --yes --concurrency 4`,
+		}},
+		{Name: "queue", Args: "<list|add|show|approve|remove|run> ...", Summary: "manage named local queues", Run: runQueueCommand, NoFlags: true},
+		{Name: "queue list", Summary: "list the configured queues and their items", Run: queueSubcommand("list"), NoFlags: true},
+		{Name: "queue add", Args: "<name> --prompt <text> [flags]", Summary: "add an item to a queue", Run: queueSubcommand("add"), Examples: []string{
+			`sora2cli queue add drafts --prompt "Paper boats in the rain" --seconds 4`,
+		}},
+		{Name: "queue show", Args: "<name>", Summary: "show a queue's items", Run: queueSubcommand("show"), NoFlags: true},
+		{Name: "queue approve", Args: "<name> [--all] [item-id...]", Summary: "approve pending items of a queue that requires approval", Run: queueSubcommand("approve")},
+		{Name: "queue remove", Args: "<name> <item-id>...", Summary: "remove items from a queue", Run: queueSubcommand("remove"), NoFlags: true},
+		{Name: "queue run", Args: "<name> [flags]", Summary: "submit, poll and download a queue's items", Run: queueSubcommand("run"), Examples: []string{
+			`sora2cli queue run drafts --concurrency 8 --defer-until-off-peak`,
+		}},
+		{Name: "k8s", Args: "render-job --batch <file> [flags]", Summary: "generate Kubernetes manifests that render on a cluster", Run: runK8sCommand, NoFlags: true},
+		{Name: "k8s render-job", Args: "--batch <file> [flags]", Summary: "print a ConfigMap and a Job (or CronJob) that render a batch file in the container image", Run: k8sSubcommand("render-job"), Examples: []string{
+			`sora2cli k8s render-job --batch jobs.yaml --image registry.example.com/sora2cli:1.4 | kubectl apply -f -`,
+			`sora2cli k8s render-job --batch prompts.jsonl --schedule "0 2 * * *" --concurrency 4 --budget 50 > nightly.yaml`,
+		}},
+		{Name: "review", Args: "<approve|reject|request|list> ...", Summary: "mark clips approved, rejected or pending review", Run: runReviewCommand, NoFlags: true},
+		{Name: "review approve", Args: "[flags] <video-id>...", Summary: "approve clips for export and encoding", Run: reviewSubcommand("approve"), Examples: []string{
+			`sora2cli review approve --comment "Final cut" video_123`,
+		}},
+		{Name: "review reject", Args: "[flags] <video-id>...", Summary: "reject clips so they are never exported or encoded", Run: reviewSubcommand("reject"), Examples: []string{
+			`sora2cli review reject --comment "Logo is warped" video_123`,
+		}},
+		{Name: "review request", Args: "[flags] <video-id>...", Summary: "mark clips as pending review", Run: reviewSubcommand("request")},
+		{Name: "review list", Args: "[flags]", Summary: "list clips awaiting review, or with a given status", Run: reviewSubcommand("list"), Examples: []string{
+			`sora2cli review list`,
+			`sora2cli review list --status rejected --json`,
+		}},
+		{Name: "share", Args: "[flags] <video-id>...", Summary: "print expiring links that stream downloaded videos from serve", Run: runShareCommand, Examples: []string{
+			`sora2cli share --expires 24h video_123`,
+		}},
+		{Name: "serve", Args: "[flags]", Summary: "stream downloaded videos to holders of share links and take jobs over a REST API", Run: runServeCommand, Examples: []string{
+			`sora2cli serve --listen :8080`,
+			`SORA2_SERVER_TOKEN=... sora2cli serve --queue renders`,
+		}},
+		{Name: "cost", Args: "[flags]", Summary: "report the estimated spend recorded in history", Run: runCostCommand, Examples: []string{
+			`sora2cli cost --since 2025-06-01 --group-by day`,
+			`sora2cli cost --group-by month --csv spend.csv`,
+		}},
+		{Name: "models", Args: "[flags] [model]", Summary: "compare the models' resolutions, durations, prices, queue times and uses", Run: runModelsCommand, Examples: []string{
+			`sora2cli models`,
+			`sora2cli models --json sora-2-pro`,
+		}},
+		{Name: "storage", Summary: "show the download size per second of video by model and resolution", Run: runStorageCommand},
+		{Name: "session", Args: "<start|status|end> ...", Summary: "time-box a generation session with its own budget and a wrap-up summary", Run: runSessionCommand, NoFlags: true},
+		{Name: "session start", Args: "[flags]", Summary: "start a session; jobs count against its budget until it ends or runs out of time", Run: sessionSubcommand("start"), Examples: []string{
+			`sora2cli session start --name "Acme ideation" --budget 20 --duration 2h`,
+		}},
+		{Name: "session status", Args: "[flags]", Summary: "show the active session's jobs, spend and time left", Run: sessionSubcommand("status")},
+		{Name: "session end", Args: "[flags]", Summary: "end the active session and print its summary of jobs, spend and outputs", Run: sessionSubcommand("end"), Examples: []string{
+			`sora2cli session end --json > acme-session.json`,
+		}},
+		{Name: "budget", Summary: "show this month's estimated spend against the budget caps", Run: runBudgetCommand, Examples: []string{
+			`sora2cli budget --json`,
+		}},
+		{Name: "logs", Args: "[flags]", Summary: "show or follow the activity log (-f)", Run: runLogsCommand, Examples: []string{
+			`sora2cli logs -f --level error`,
+		}},
+		{Name: "bug-report", Args: "[flags]", Summary: "bundle redacted diagnostics into a zip for a bug report", Run: runBugReportCommand, Examples: []string{
+			`sora2cli bug-report`,
+			`sora2cli bug-report --out report.zip --lines 500`,
+		}},
+		{Name: "version", Args: "[flags]", Summary: "print the version, commit, build date, Go version and API base URL", Run: runVersionCommand, Examples: []string{
+			`sora2cli version`,
+			`sora2cli version --json`,
+		}},
+		{Name: "config", Args: "<validate|view|get|set|unset|import> [flags]", Summary: "validate, view and edit configuration", Run: runConfigCommand, NoFlags: true},
+		{Name: "config validate", Args: "[flags]", Summary: "check config files for unknown keys and invalid values", Run: configSubcommand("validate")},
+		{Name: "config view", Args: "[flags]", Summary: "show the effective configuration and where each value comes from", Run: configSubcommand("view")},
+		{Name: "config get", Args: "[flags] <key>", Summary: "print one config value", Run: configSubcommand("get")},
+		{Name: "config set", Args: "[flags] <key> <value>", Summary: "set a value in a config file", Run: configSubcommand("set"), Examples: []string{
+			`sora2cli config set defaults.model sora-2-pro`,
+			`sora2cli config set --project defaults.destination ./renders`,
+		}},
+		{Name: "config unset", Args: "[flags] <key>", Summary: "remove a value from a config file", Run: configSubcommand("unset")},
+		{Name: "config import", Args: "[flags] [file|-]", Summary: "copy the API key, organization and project IDs from another tool's config or a pasted dashboard export", Run: configSubcommand("import"), Examples: []string{
+			`sora2cli config import ~/.codex/auth.json`,
+			`sora2cli config import other-project/.env`,
+			`sora2cli config import   # then paste and press Ctrl+D`,
+		}},
+	}
+}
+
+func queueSubcommand(name string) func([]string) int {
+	return func(args []string) int { return runQueueCommand(append([]string{name}, args...)) }
+}
+
+func batchSubcommand(name string) func([]string) int {
+	return func(args []string) int { return runBatchCommand(append([]string{name}, args...)) }
+}
+
+func reviewSubcommand(name string) func([]string) int {
+	return func(args []string) int { return runReviewCommand(append([]string{name}, args...)) }
+}
+
+func sessionSubcommand(name string) func([]string) int {
+	return func(args []string) int { return runSessionCommand(append([]string{name}, args...)) }
+}
+
+func k8sSubcommand(name string) func([]string) int {
+	return func(args []string) int { return runK8sCommand(append([]string{name}, args...)) }
+}
+
+func configSubcommand(name string) func([]string) int {
+	return func(args []string) int { return runConfigCommand(append([]string{name}, args...)) }
+}
+
+func findCommandSpec(name string) (commandSpec, bool) {
+	for _, spec := range commandSpecs {
+		if spec.Name == name {
+			return spec, true
+		}
+		for _, alias := range spec.Aliases {
+			if alias == name {
+				return spec, true
+			}
+		}
+	}
+	return commandSpec{}, false
+}
+
+func (s commandSpec) topLevel() bool {
+	return !strings.Contains(s.Name, " ")
+}
+
+func (s commandSpec) subcommands() []commandSpec {
+	var subs []commandSpec
+	for _, spec := range commandSpecs {
+		if strings.HasPrefix(spec.Name, s.Name+" ") {
+			subs = append(subs, spec)
+		}
+	}
+	return subs
+}
+
+// commandUsage is the overview printed by "sora2cli help".
+func commandUsage() string {
+	var b strings.Builder
+	b.WriteString("usage: sora2cli [command] [flags]\n\nRun without a command for the interactive menu.\n\nCommands:\n")
+	for _, spec := range commandSpecs {
+		if !spec.topLevel() {
+			continue
+		}
+		summary := spec.Summary
+		if len(spec.Aliases) > 0 {
+			summary += " (alias: " + strings.Join(spec.Aliases, ", ") + ")"
+		}
+		fmt.Fprintf(&b, "  %-14s%s\n", spec.Name, summary)
+	}
+	b.WriteString("  help          show help for a command, or the man page with --man\n")
+	b.WriteString("\nRun \"sora2cli help <command>\" for the flags of a command.\n")
+	return b.String()
+}
+
+// helpCapture collects a command's flag set instead of printing its usage
+// while help is rendered.
+var helpCapture struct {
+	active bool
+	fs     *flag.FlagSet
+}
+
+// newCommandFlagSet creates the flag set of a command. Its usage, shown for
+// -h and for invalid flags, is the command's full help.
+func newCommandFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	registerProfileFlag(fs)
+	registerDebugFlags(fs)
+	registerLogFlags(fs)
+	registerNetworkFlags(fs)
+	fs.Usage = func() {
+		if helpCapture.active {
+			helpCapture.fs = fs
+			return
+		}
+		if spec, ok := findCommandSpec(name); ok {
+			writeCommandHelp(fs.Output(), spec, fs)
+			return
+		}
+		fmt.Fprintf(fs.Output(), "usage of sora2cli %s:\n", name)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// commandFlags returns the flag set spec's command defines, or nil. The
+// command is run with -h, which every command handles before doing any work.
+func commandFlags(spec commandSpec) *flag.FlagSet {
+	if spec.NoFlags || spec.Run == nil {
+		return nil
+	}
+	helpCapture.active, helpCapture.fs = true, nil
+	defer func() { helpCapture.active = false }()
+	spec.Run([]string{"-h"})
+	return helpCapture.fs
+}
+
+// helpFlag is one flag as shown in help and the man page.
+type helpFlag struct {
+	Name, Type, Usage, Default, Config, Env string
+}
+
+// flagConfigKeys maps flags to the config keys they override when a
+// command uses the defaults section.
+var flagConfigKeys = map[string]string{
+	"model":            "defaults.model",
+	"seconds":          "defaults.seconds",
+	"size":             "defaults.size",
+	"out":              "defaults.destination",
+	"with-thumbnail":   "defaults.with_thumbnail",
+	"with-spritesheet": "defaults.with_spritesheet",
+	"sidecar":          "defaults.write_sidecar",
+	"trim":             "trim.range",
+	"grade":            "grade.auto",
+	"interpolate":      "interpolate.auto",
+	"encode-profile":   "encode.auto",
+	"container":        "defaults.container",
+	"enhance":          "enhance.auto",
+}
+
+func collectHelpFlags(spec commandSpec, fs *flag.FlagSet) []helpFlag {
+	var flags []helpFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		typeName, usage := flag.UnquoteUsage(f)
+		hf := helpFlag{Name: f.Name, Type: typeName, Usage: usage}
+		switch f.DefValue {
+		case "", "0", "false":
+		default:
+			hf.Default = f.DefValue
+		}
+		if key, ok := flagConfigKeys[f.Name]; ok && spec.UsesDefaults {
+			hf.Config = key
+		}
+		hf.Env = flagEnvName(spec, f.Name)
+		flags = append(flags, hf)
+	})
+	return flags
+}
+
+func flagDash(name string) string {
+	if len(name) == 1 {
+		return "-" + name
+	}
+	return "--" + name
+}
+
+func writeCommandHelp(w io.Writer, spec commandSpec, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "sora2cli %s - %s\n\nUsage:\n  %s\n", spec.Name, spec.Summary, strings.TrimSpace("sora2cli "+spec.Name+" "+spec.Args))
+	if len(spec.Aliases) > 0 {
+		fmt.Fprintf(w, "\nAliases: %s\n", strings.Join(spec.Aliases, ", "))
+	}
+	if subs := spec.subcommands(); len(subs) > 0 {
+		fmt.Fprintln(w, "\nCommands:")
+		width := 0
+		for _, sub := range subs {
+			width = max(width, len(sub.Name)-len(spec.Name)-1)
+		}
+		for _, sub := range subs {
+			fmt.Fprintf(w, "  %-*s  %s\n", width, strings.TrimPrefix(sub.Name, spec.Name+" "), sub.Summary)
+		}
+	}
+	if fs != nil {
+		fmt.Fprintln(w, "\nFlags:")
+		for _, f := range collectHelpFlags(spec, fs) {
+			fmt.Fprintf(w, "  %s\n", strings.TrimSpace(flagDash(f.Name)+" "+f.Type))
+			fmt.Fprintf(w, "      %s\n", f.Usage)
+			var notes []string
+			if f.Default != "" {
+				notes = append(notes, "default "+f.Default)
+			}
+			if f.Config != "" {
+				notes = append(notes, "config "+f.Config)
+			}
+			if f.Env != "" {
+				notes = append(notes, "env "+f.Env)
+			}
+			if len(notes) > 0 {
+				fmt.Fprintf(w, "      (%s)\n", strings.Join(notes, "; "))
+			}
+		}
+	}
+	if len(spec.Examples) > 0 {
+		fmt.Fprintln(w, "\nExamples:")
+		for _, example := range spec.Examples {
+			fmt.Fprintf(w, "  %s\n", example)
+		}
+	}
+}
+
+// configEnvName returns the environment variable that overrides the config
+// key, or "".
+func configEnvName(key string) string {
+	t := reflect.TypeOf(config{})
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		found := false
+		for j := 0; j < t.NumField(); j++ {
+			field := t.Field(j)
+			if yamlFieldName(field) != part {
+				continue
+			}
+			if i == len(parts)-1 {
+				return field.Tag.Get("env")
+			}
+			if field.Type.Kind() != reflect.Struct {
+				return ""
+			}
+			t, found = field.Type, true
+			break
+		}
+		if !found {
+			return ""
+		}
+	}
+	return ""
+}
+
+// configEnvVars lists every config key that has an environment variable.
+func configEnvVars(t reflect.Type, prefix string) [][2]string {
+	var vars [][2]string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := joinConfigKey(prefix, yamlFieldName(field))
+		if field.Type.Kind() == reflect.Struct {
+			vars = append(vars, configEnvVars(field.Type, key)...)
+			continue
+		}
+		if env := field.Tag.Get("env"); env != "" {
+			vars = append(vars, [2]string{env, key})
+		}
+	}
+	return vars
+}
+
+// runHelpCommand prints the overview, one command's help or, with --man, a
+// man page for all commands.
+func runHelpCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Print(commandUsage())
+		return 0
+	}
+	if args[0] == "--man" || args[0] == "-man" {
+		writeManPage(os.Stdout)
+		return 0
+	}
+	spec, ok := findCommandSpec(strings.Join(args, " "))
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", strings.Join(args, " "))
+		fmt.Fprint(os.Stderr, commandUsage())
+		return 2
+	}
+	writeCommandHelp(os.Stdout, spec, commandFlags(spec))
+	return 0
+}
+
+func manEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	s = strings.ReplaceAll(s, "-", `\-`)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+func writeManPage(w io.Writer) {
+	fmt.Fprintf(w, ".TH SORA2CLI 1 \"\" \"sora2cli %s\" \"User Commands\"\n", manEscape(cliVersion()))
+	fmt.Fprintln(w, ".SH NAME\nsora2cli \\- generate, remix and manage Sora videos from the command line")
+	fmt.Fprintln(w, ".SH SYNOPSIS\n.B sora2cli\n.RI [ command ] \" \" [ flags ]")
+	fmt.Fprintln(w, ".SH DESCRIPTION\nRun without a command for the interactive menu. Every action is also a subcommand with flags, suitable for scripts and CI.")
+	fmt.Fprintln(w, ".SH COMMANDS")
+	for _, spec := range commandSpecs {
+		fmt.Fprintf(w, ".SS \"%s\"\n", manEscape(spec.Name))
+		fmt.Fprintf(w, ".B sora2cli %s\n", manEscape(spec.Name))
+		if spec.Args != "" {
+			fmt.Fprintln(w, manEscape(spec.Args))
+		}
+		fmt.Fprintf(w, ".PP\n%s.\n", manEscape(spec.Summary))
+		if len(spec.Aliases) > 0 {
+			fmt.Fprintf(w, "Alias: %s.\n", manEscape(strings.Join(spec.Aliases, ", ")))
+		}
+		if fs := commandFlags(spec); fs != nil {
+			for _, f := range collectHelpFlags(spec, fs) {
+				if f.Type != "" {
+					fmt.Fprintf(w, ".TP\n.BI \"%s \" %s\n", manEscape(flagDash(f.Name)), f.Type)
+				} else {
+					fmt.Fprintf(w, ".TP\n.B %s\n", manEscape(flagDash(f.Name)))
+				}
+				fmt.Fprint(w, manEscape(f.Usage))
+				if f.Default != "" {
+					fmt.Fprintf(w, " (default %s)", manEscape(f.Default))
+				}
+				if f.Env != "" {
+					fmt.Fprintf(w, " (environment %s)", manEscape(f.Env))
+				}
+				fmt.Fprintln(w)
+			}
+		}
+		for _, example := range spec.Examples {
+			fmt.Fprintf(w, ".PP\n.nf\n.RS\n%s\n.RE\n.fi\n", manEscape(example))
+		}
+	}
+	fmt.Fprintln(w, ".SH ENVIRONMENT")
+	fmt.Fprintln(w, "Every long flag can be set with SORA2_ followed by its name in upper case with dashes as underscores, as listed with each flag above. A flag given on the command line wins over its variable, which wins over the project config, then the user config.")
+	vars := configEnvVars(reflect.TypeOf(config{}), "")
+	vars = append(vars, [2]string{"SORA2_CONFIG", "path of the user config file"})
+	sort.Slice(vars, func(i, j int) bool { return vars[i][0] < vars[j][0] })
+	for _, v := range vars {
+		description := v[1]
+		if !strings.Contains(description, " ") {
+			description = "overrides the config key " + description
+		}
+		fmt.Fprintf(w, ".TP\n.B %s\n%s\n", manEscape(v[0]), manEscape(description))
+	}
+	fmt.Fprintln(w, ".SH FILES")
+	fmt.Fprintf(w, ".TP\n.I %s\nuser config file, in the user config directory\n", manEscape(configDirName+"/"+configFileName))
+	fmt.Fprintf(w, ".TP\n.I %s\nproject config, found from the current directory upwards\n", manEscape(projectConfigFileName))
+	fmt.Fprintf(w, ".TP\n.I %s\nlocal job history, next to the user config\n", manEscape(configDirName+"/"+historyFileName))
+	fmt.Fprintf(w, ".TP\n.I %s\nactivity log followed by\n.B sora2cli logs \\-f\n", manEscape(configDirName+"/"+activityLogName))
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+const historyFileName = "history.json"
+
+// historyEntry is the local record of one job submitted or downloaded by this
+// machine. Source says how the job was started: create, remix, batch,
+// "queue <name>", wait (for jobs picked up by ID) or import.
+type historyEntry struct {
+	JobID       string `json:"job_id"`
+	Source      string `json:"source"`
+	Prompt      string `json:"prompt,omitempty"`
+	Model       string `json:"model,omitempty"`
+	Seconds     int    `json:"seconds,omitempty"`
+	Size        string `json:"size,omitempty"`
+	RemixedFrom string `json:"remixed_from,omitempty"`
+	Ticket      string `json:"ticket,omitempty"`
+	// Tags label the job, e.g. campaign:q3, and Note is free text about it;
+	// both are set at creation or later with the tag command.
+	Tags []string `json:"tags,omitempty"`
+	Note string   `json:"note,omitempty"`
+	// SpecHash is the fingerprint of the batch or queue spec that created
+	// the job; batch plan matches prompts files against it.
+	SpecHash      string  `json:"spec_hash,omitempty"`
+	Status        string  `json:"status"`
+	EstimatedCost float64 `json:"estimated_cost,omitempty"`
+	OutputPath    string  `json:"output_path,omitempty"`
+	OutputBytes   int64   `json:"output_bytes,omitempty"`
+	SHA256        string  `json:"sha256,omitempty"`
+	// FrameHashes are perceptual hashes of the frames in the spritesheet,
+	// filled in by dupes.
+	FrameHashes []string `json:"frame_hashes,omitempty"`
+	// Derived maps copies made from the download, such as "60fps" or
+	// "slowmo2x", to their paths.
+	Derived map[string]string `json:"derived,omitempty"`
+	// Pending is set while a job submitted from this machine waits to be
+	// downloaded into Destination; recover picks up the jobs a session left
+	// behind.
+	Pending     bool   `json:"pending,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	// Review is the latest decision of the local review workflow.
+	Review     *jobReview `json:"review,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  time.Time  `json:"started_at,omitzero"`
+	FinishedAt time.Time  `json:"finished_at,omitzero"`
+	DeletedAt  time.Time  `json:"deleted_at,omitzero"`
+}
+
+type historyState struct {
+	Entries []*historyEntry `json:"entries"`
+}
+
+// historyMu serialises updates from concurrent queue and batch workers, and
+// a lock file those of other processes. Each update re-reads the file under
+// both, so no write is lost.
+var historyMu sync.Mutex
+
+func historyPath() (string, error) {
+	dir, err := resolveDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, historyFileName), nil
+}
+
+func loadHistory() (historyState, error) {
+	var state historyState
+	path, err := historyPath()
+	if err != nil {
+		return state, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return state, nil
+}
+
+func saveHistory(state historyState) error {
+	path, err := historyPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+func (s *historyState) find(jobID string) *historyEntry {
+	for _, entry := range s.Entries {
+		if entry.JobID == jobID {
+			return entry
+		}
+	}
+	return nil
+}
+
+// historyTags returns the tags recorded for jobID, if any.
+func historyTags(jobID string) []string {
+	state, err := loadHistory()
+	if err != nil {
+		return nil
+	}
+	if entry := state.find(jobID); entry != nil {
+		return entry.Tags
+	}
+	return nil
+}
+
+// updateHistory applies fn to the entry for jobID and logs what changed to
+// the activity log. A missing entry is created first when create is set and
+// left alone otherwise.
+func updateHistory(jobID string, create bool, fn func(*historyEntry)) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	path, err := historyPath()
+	if err != nil {
+		return err
+	}
+	lock, err := lockFile(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+	state, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	entry := state.find(jobID)
+	created := entry == nil
+	if created {
+		if !create {
+			return nil
+		}
+		entry = &historyEntry{JobID: jobID, CreatedAt: time.Now()}
+		state.Entries = append(state.Entries, entry)
+	}
+	before := *entry
+	fn(entry)
+	if err := saveHistory(state); err != nil {
+		return err
+	}
+	logHistoryChange(before, entry, created)
+	return nil
+}
+
+// removeHistory takes the entries for jobIDs out of history and returns
+// them. IDs without an entry are ignored.
+func removeHistory(jobIDs []string) ([]*historyEntry, error) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	path, err := historyPath()
+	if err != nil {
+		return nil, err
+	}
+	lock, err := lockFile(path + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	defer lock.unlock()
+	state, err := loadHistory()
+	if err != nil {
+		return nil, err
+	}
+	remove := make(map[string]bool, len(jobIDs))
+	for _, id := range jobIDs {
+		remove[id] = true
+	}
+	var removed []*historyEntry
+	kept := state.Entries[:0]
+	for _, entry := range state.Entries {
+		if remove[entry.JobID] {
+			removed = append(removed, entry)
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	state.Entries = kept
+	if err := saveHistory(state); err != nil {
+		return nil, err
+	}
+	for _, entry := range removed {
+		logActivity(activityEvent{Level: "info", JobID: entry.JobID, Source: entry.Source, Message: "record moved to the trash"})
+	}
+	return removed, nil
+}
+
+// restoreHistory puts entries taken out by removeHistory back, in creation
+// order. Entries whose job has a record again are skipped and returned.
+func restoreHistory(entries []*historyEntry) ([]*historyEntry, error) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	path, err := historyPath()
+	if err != nil {
+		return nil, err
+	}
+	lock, err := lockFile(path + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	defer lock.unlock()
+	state, err := loadHistory()
+	if err != nil {
+		return nil, err
+	}
+	var skipped []*historyEntry
+	for _, entry := range entries {
+		if state.find(entry.JobID) != nil {
+			skipped = append(skipped, entry)
+			continue
+		}
+		state.Entries = append(state.Entries, entry)
+		logActivity(activityEvent{Level: "info", JobID: entry.JobID, Source: entry.Source, Message: "record restored from the trash"})
+	}
+	sort.SliceStable(state.Entries, func(i, j int) bool {
+		return state.Entries[i].CreatedAt.Before(state.Entries[j].CreatedAt)
+	})
+	return skipped, saveHistory(state)
+}
+
+func updateHistoryOrWarn(jobID string, create bool, fn func(*historyEntry)) {
+	if err := updateHistory(jobID, create, fn); err != nil {
+		logWarn("unable to update history: %v", err)
+	}
+}
+
+// applyJob copies what the API reports about a job into the entry.
+func (e *historyEntry) applyJob(job *sora.Video) {
+	e.Status = job.Status
+	if job.Prompt != "" && e.Prompt == "" {
+		e.Prompt = job.Prompt
+	}
+	if job.Model != "" {
+		e.Model = job.Model
+	}
+	if seconds, err := strconv.Atoi(job.Seconds); err == nil {
+		e.Seconds = seconds
+		e.EstimatedCost = jobCostEstimate(job.Model, seconds)
+	}
+	if job.Size != "" {
+		e.Size = job.Size
+	}
+	if job.RemixedFromVideoID != "" {
+		e.RemixedFrom = job.RemixedFromVideoID
+	}
+	if job.CreatedAt > 0 {
+		e.CreatedAt = time.Unix(job.CreatedAt, 0)
+	}
+	if job.Error != nil && job.Error.Message != "" {
+		e.Error = job.Error.Message
+	}
+	if job.Done() && job.Status != "completed" {
+		e.Pending = false
+	}
+}
+
+// recordJobHistory stores the job's current state. source is only used for
+// jobs without a record yet; fn, if set, adds what the API does not report.
+func recordJobHistory(job *sora.Video, source string, fn func(*historyEntry)) {
+	updateHistoryOrWarn(job.ID, true, func(e *historyEntry) {
+		if e.Source == "" {
+			e.Source = source
+		}
+		e.applyJob(job)
+		if fn != nil {
+			fn(e)
+		}
+	})
+}
+
+// markHistoryStarted notes when a job was first seen leaving the queue, which
+// feeds the queue time estimates.
+func markHistoryStarted(jobID string) {
+	updateHistoryOrWarn(jobID, false, func(e *historyEntry) {
+		if e.StartedAt.IsZero() {
+			e.StartedAt = time.Now()
+		}
+	})
+}
+
+// typicalQueueWait returns the median time recent jobs spent queued and how
+// many jobs it is based on. Jobs of the same model are preferred when there
+// are enough of them.
+func typicalQueueWait(model string) (time.Duration, int) {
+	state, err := loadHistory()
+	if err != nil {
+		return 0, 0
+	}
+	all, sameModel := recentQueueWaits(state, model, 20)
+	samples := all
+	if len(sameModel) >= 3 {
+		samples = sameModel
+	}
+	return medianDuration(samples), len(samples)
+}
+
+// recentQueueWaits returns how long the last jobs in history spent queued,
+// up to recent of them, and the subset of those of model.
+func recentQueueWaits(state historyState, model string, recent int) (all, sameModel []time.Duration) {
+	for i := len(state.Entries) - 1; i >= 0 && len(all) < recent; i-- {
+		entry := state.Entries[i]
+		if entry.StartedAt.IsZero() || entry.StartedAt.Before(entry.CreatedAt) {
+			continue
+		}
+		wait := entry.StartedAt.Sub(entry.CreatedAt)
+		all = append(all, wait)
+		if entry.Model == model {
+			sameModel = append(sameModel, wait)
+		}
+	}
+	return all, sameModel
+}
+
+func medianDuration(samples []time.Duration) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
+
+func markHistoryFailed(jobID string, err error) {
+	updateHistoryOrWarn(jobID, false, func(e *historyEntry) {
+		e.Status = "failed"
+		e.Error = err.Error()
+		e.FinishedAt = time.Now()
+	})
+}
+
+// markHistoryCompleted records a finished download together with the size
+// and checksum gc verifies before it deletes the remote copy.
+func markHistoryCompleted(job *sora.Video, source, outputPath string) {
+	size, sum, err := fileDigest(outputPath)
+	if err != nil {
+		logWarn("unable to checksum %s: %v", outputPath, err)
+	}
+	recordJobHistory(job, source, func(e *historyEntry) {
+		e.OutputPath = outputPath
+		e.OutputBytes = size
+		e.SHA256 = sum
+		e.Error = ""
+		e.Pending = false
+		e.FinishedAt = time.Now()
+	})
+}
+
+func fileDigest(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// verifyLocalCopy checks that the downloaded file still exists, is readable
+// and matches the size and checksum recorded at download time, so the remote
+// copy is never the only good one left when it is deleted.
+func (e *historyEntry) verifyLocalCopy() error {
+	if e.OutputPath == "" {
+		return errors.New("no local file recorded")
+	}
+	if e.SHA256 == "" || e.OutputBytes == 0 {
+		return fmt.Errorf("no checksum recorded; run 'sora2cli download %s' first", e.JobID)
+	}
+	info, err := os.Stat(e.OutputPath)
+	if err != nil {
+		return fmt.Errorf("local file missing: %w", err)
+	}
+	if info.Size() != e.OutputBytes {
+		return fmt.Errorf("%s is %d bytes, expected %d", e.OutputPath, info.Size(), e.OutputBytes)
+	}
+	_, sum, err := fileDigest(e.OutputPath)
+	if err != nil {
+		return fmt.Errorf("local file unreadable: %w", err)
+	}
+	if sum != e.SHA256 {
+		return fmt.Errorf("%s does not match the checksum recorded at download", e.OutputPath)
+	}
+	return nil
+}
+
+// historyAudit is the difference between the API listing and local history.
+type historyAudit struct {
+	// RemoteOnly holds videos the API knows about that were never recorded
+	// here, e.g. made in the web UI or on another machine.
+	RemoteOnly []sora.Video `json:"remote_only"`
+	// LocalOnly holds recorded jobs the API no longer lists, usually because
+	// they expired or were deleted elsewhere.
+	LocalOnly []historyEntry `json:"local_only"`
+}
+
+func auditHistory(ctx context.Context, client *sora.Client, state historyState) (historyAudit, error) {
+	audit := historyAudit{RemoteOnly: []sora.Video{}, LocalOnly: []historyEntry{}}
+	remote := make(map[string]bool)
+	params := sora.ListParams{Limit: 100}
+	for {
+		page, err := client.List(ctx, params)
+		if err != nil {
+			return audit, err
+		}
+		for _, video := range page.Data {
+			remote[video.ID] = true
+			if state.find(video.ID) == nil {
+				audit.RemoteOnly = append(audit.RemoteOnly, video)
+			}
+		}
+		params.After = page.Cursor()
+		if !page.HasMore || params.After == "" {
+			break
+		}
+	}
+	for _, entry := range state.Entries {
+		if !remote[entry.JobID] && entry.DeletedAt.IsZero() {
+			audit.LocalOnly = append(audit.LocalOnly, *entry)
+		}
+	}
+	return audit, nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// interpolateConfig makes a smoother or slower copy of each downloaded MP4
+// by synthesising frames between the rendered ones. The copy is a derived
+// artifact next to the original, which is left as it is.
+type interpolateConfig struct {
+	// Engine is ffmpeg, for its minterpolate filter (the default), or rife
+	// for an external rife-ncnn-vulkan binary, which is slower but cleaner
+	// on fast motion.
+	Engine string `yaml:"engine,omitempty" env:"SORA2_INTERPOLATE_ENGINE"`
+	// FFmpeg is the ffmpeg binary; by default grade.ffmpeg or the one on
+	// PATH. The rife engine needs it too, to split and join the frames.
+	FFmpeg string `yaml:"ffmpeg,omitempty"`
+	// RIFE is the rife-ncnn-vulkan binary; by default the one on PATH.
+	RIFE string `yaml:"rife,omitempty" env:"SORA2_RIFE"`
+	// FPS is the frame rate of the smooth copy (default 60).
+	FPS int `yaml:"fps,omitempty"`
+	// Slowmo, when set, makes a copy this many times slower at the
+	// original frame rate instead.
+	Slowmo int `yaml:"slowmo,omitempty"`
+	// CRF is the x264 quality of the copy (default 16).
+	CRF int `yaml:"crf,omitempty"`
+	// Auto interpolates every download; otherwise only with --interpolate.
+	Auto bool `yaml:"auto,omitempty" env:"SORA2_INTERPOLATE"`
+}
+
+const (
+	interpolateEngineFFmpeg = "ffmpeg"
+	interpolateEngineRIFE   = "rife"
+	defaultInterpolateFPS   = 60
+	maxInterpolateFPS       = 240
+	maxSlowmo               = 16
+)
+
+// interpolation returns the interpolate settings with the ffmpeg binary
+// taken from grade when it is not set of its own.
+func (c *resolvedConfig) interpolation() interpolateConfig {
+	interp := c.Interpolate
+	if interp.FFmpeg == "" {
+		interp.FFmpeg = c.Grade.FFmpeg
+	}
+	return interp
+}
+
+// variant names the derived copy, as in <id>.60fps.mp4 or <id>.slowmo2x.mp4.
+func (c interpolateConfig) variant() string {
+	if c.Slowmo > 1 {
+		return fmt.Sprintf("slowmo%dx", c.Slowmo)
+	}
+	return fmt.Sprintf("%dfps", c.fps())
+}
+
+func (c interpolateConfig) fps() int {
+	if c.FPS == 0 {
+		return defaultInterpolateFPS
+	}
+	return c.FPS
+}
+
+func validateInterpolateConfig(c interpolateConfig) []configIssue {
+	var issues []configIssue
+	switch c.Engine {
+	case "", interpolateEngineFFmpeg:
+	case interpolateEngineRIFE:
+		if c.Slowmo > 1 && c.Slowmo&(c.Slowmo-1) != 0 {
+			issues = append(issues, configIssue{Key: "interpolate.slowmo", Message: "must be a power of two with the rife engine"})
+		}
+	default:
+		issues = append(issues, configIssue{Key: "interpolate.engine", Message: fmt.Sprintf("unknown engine %q; use ffmpeg or rife", c.Engine)})
+	}
+	if c.FPS < 0 || c.FPS > maxInterpolateFPS {
+		issues = append(issues, configIssue{Key: "interpolate.fps", Message: fmt.Sprintf("must be between 1 and %d", maxInterpolateFPS)})
+	}
+	if c.Slowmo < 0 || c.Slowmo == 1 || c.Slowmo > maxSlowmo {
+		issues = append(issues, configIssue{Key: "interpolate.slowmo", Message: fmt.Sprintf("must be between 2 and %d", maxSlowmo)})
+	}
+	if c.CRF < 0 || c.CRF > 51 {
+		issues = append(issues, configIssue{Key: "interpolate.crf", Message: "must be between 0 and 51"})
+	}
+	return issues
+}
+
+// interpolateVideo writes the interpolated copy of the MP4 at path and
+// returns where it went. An existing copy is replaced.
+func interpolateVideo(ctx context.Context, interp interpolateConfig, path string) (string, error) {
+	if issues := validateInterpolateConfig(interp); len(issues) > 0 {
+		return "", fmt.Errorf("%s: %s", issues[0].Key, issues[0].Message)
+	}
+	ffmpeg, err := lookupFFmpeg(interp.FFmpeg, "interpolate.ffmpeg")
+	if err != nil {
+		return "", err
+	}
+	sourceRate, err := mp4FrameRate(path)
+	if err != nil {
+		return "", fmt.Errorf("read frame rate: %w", err)
+	}
+	if interp.Slowmo <= 1 && float64(interp.fps()) <= sourceRate+0.5 {
+		return "", fmt.Errorf("the video already runs at %s fps", formatRate(sourceRate))
+	}
+	crf := interp.CRF
+	if crf == 0 {
+		crf = defaultGradeCRF
+	}
+
+	outPath := strings.TrimSuffix(path, ".mp4") + "." + interp.variant() + ".mp4"
+	tmpPath := strings.TrimSuffix(outPath, ".mp4") + ".partial.mp4"
+	if interp.Engine == interpolateEngineRIFE {
+		err = interpolateWithRIFE(ctx, interp, ffmpeg, path, tmpPath, sourceRate, crf)
+	} else {
+		err = interpolateWithFFmpeg(ctx, interp, ffmpeg, path, tmpPath, sourceRate, crf)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return outPath, os.Rename(tmpPath, outPath)
+}
+
+// interpolateWithFFmpeg uses motion-compensated interpolation. Slow motion
+// stretches the timestamps first and fills the gaps back to the original
+// rate; its audio would be out of step, so it is dropped.
+func interpolateWithFFmpeg(ctx context.Context, interp interpolateConfig, ffmpeg, src, dst string, sourceRate float64, crf int) error {
+	const motion = ":mi_mode=mci:mc_mode=aobmc:me_mode=bidir:vsbmc=1"
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y", "-i", src}
+	if interp.Slowmo > 1 {
+		args = append(args, "-vf", fmt.Sprintf("setpts=%d*PTS,minterpolate=fps=%s%s", interp.Slowmo, formatRate(sourceRate), motion), "-an")
+	} else {
+		args = append(args, "-vf", fmt.Sprintf("minterpolate=fps=%d%s", interp.fps(), motion), "-c:a", "copy")
+	}
+	args = append(args, "-c:v", "libx264", "-crf", strconv.Itoa(crf), "-preset", "medium", "-pix_fmt", "yuv420p", "-movflags", "+faststart", dst)
+	return runTool(ctx, ffmpeg, args...)
+}
+
+// interpolateWithRIFE splits the video into frames, lets RIFE double them
+// as often as needed and joins the result. For slow motion the frames are
+// played at the original rate; otherwise at the doubled rate, brought down
+// to the target.
+func interpolateWithRIFE(ctx context.Context, interp interpolateConfig, ffmpeg, src, dst string, sourceRate float64, crf int) error {
+	rife := interp.RIFE
+	if rife == "" {
+		rife = "rife-ncnn-vulkan"
+	}
+	rife, err := exec.LookPath(rife)
+	if err != nil {
+		return fmt.Errorf("%w; install rife-ncnn-vulkan or set interpolate.rife", err)
+	}
+	var passes int
+	if interp.Slowmo > 1 {
+		passes = int(math.Round(math.Log2(float64(interp.Slowmo))))
+	} else {
+		passes = max(1, int(math.Ceil(math.Log2(float64(interp.fps())/sourceRate))))
+	}
+
+	work, err := os.MkdirTemp(filepath.Dir(dst), ".interpolate-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+	frames := filepath.Join(work, "0")
+	if err := os.Mkdir(frames, 0o700); err != nil {
+		return err
+	}
+	if err := runTool(ctx, ffmpeg, "-hide_banner", "-loglevel", "error", "-nostdin", "-i", src, "-vsync", "0", filepath.Join(frames, "%08d.png")); err != nil {
+		return err
+	}
+	for pass := 1; pass <= passes; pass++ {
+		next := filepath.Join(work, strconv.Itoa(pass))
+		if err := os.Mkdir(next, 0o700); err != nil {
+			return err
+		}
+		if err := runTool(ctx, rife, "-i", frames, "-o", next, "-f", "%08d.png"); err != nil {
+			return err
+		}
+		os.RemoveAll(frames)
+		frames = next
+	}
+
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y"}
+	if interp.Slowmo > 1 {
+		args = append(args, "-framerate", formatRate(sourceRate), "-i", filepath.Join(frames, "%08d.png"))
+	} else {
+		args = append(args,
+			"-framerate", formatRate(sourceRate*float64(int(1)<<passes)), "-i", filepath.Join(frames, "%08d.png"),
+			"-i", src, "-map", "0:v", "-map", "1:a?", "-c:a", "copy",
+			"-r", strconv.Itoa(interp.fps()))
+	}
+	args = append(args, "-c:v", "libx264", "-crf", strconv.Itoa(crf), "-preset", "medium", "-pix_fmt", "yuv420p", "-movflags", "+faststart", dst)
+	return runTool(ctx, ffmpeg, args...)
+}
+
+func formatRate(rate float64) string {
+	return strconv.FormatFloat(math.Round(rate*1000)/1000, 'f', -1, 64)
+}
+
+// interpolateDownload makes the interpolated copy of a fresh download and
+// links it from the job's history entry. A failure only warns: the video
+// itself is fine.
+func interpolateDownload(ctx context.Context, interp interpolateConfig, jobID, path string) string {
+	logInfo("Interpolating %s to %s...", jobID, interp.variant())
+	outPath, err := interpolateVideo(ctx, interp, path)
+	if err != nil {
+		logWarn("unable to interpolate %s: %v", jobID, err)
+		return ""
+	}
+	logInfo("Saved %s to %s", interp.variant(), outPath)
+	recordDerived(jobID, interp.variant(), outPath)
+	return outPath
+}
+
+func recordDerived(jobID, name, path string) {
+	updateHistoryOrWarn(jobID, true, func(e *historyEntry) {
+		if e.Derived == nil {
+			e.Derived = make(map[string]string)
+		}
+		e.Derived[name] = path
+	})
+}
+
+// runInterpolateCommand interpolates videos that are already downloaded.
+func runInterpolateCommand(args []string) int {
+	fs := newCommandFlagSet("interpolate")
+	fps := fs.Int("fps", 0, "frame rate of the smooth copy (default: interpolate.fps or 60)")
+	slowmo := fs.Int("slowmo", 0, "make a copy this many times slower instead")
+	engine := fs.String("engine", "", "ffmpeg or rife (default: interpolate.engine or ffmpeg)")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli interpolate [--fps n | --slowmo factor] [--engine ffmpeg|rife] <video-id>...")
+		return 2
+	}
+	if *fps != 0 && *slowmo != 0 {
+		logError("--fps and --slowmo cannot be combined")
+		return 2
+	}
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	interp := cfg.interpolation()
+	if *engine != "" {
+		interp.Engine = *engine
+	}
+	if *fps != 0 {
+		interp.FPS, interp.Slowmo = *fps, 0
+	}
+	if *slowmo != 0 {
+		interp.Slowmo = *slowmo
+	}
+	if issues := validateInterpolateConfig(interp); len(issues) > 0 {
+		logError("%s: %s", issues[0].Key, issues[0].Message)
+		return 2
+	}
+	state, err := loadHistory()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Hour)
+	defer cancel()
+	failed := 0
+	for _, jobID := range fs.Args() {
+		entry := state.find(jobID)
+		if entry == nil || entry.OutputPath == "" {
+			logError("%s: no downloaded video in history", jobID)
+			failed++
+			continue
+		}
+		outPath, err := interpolateVideo(ctx, interp, entry.OutputPath)
+		if err != nil {
+			logError("%s: %v", jobID, err)
+			failed++
+			continue
+		}
+		logInfo("Saved %s to %s", interp.variant(), outPath)
+		recordDerived(jobID, interp.variant(), outPath)
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+// jobWebhookSignatureHeader carries the HMAC-SHA256 of the request body,
+// as sha256=<hex>, when a secret is configured.
+const jobWebhookSignatureHeader = "X-Sora2cli-Signature-256"
+
+// jobWebhookConfig posts every job create, remix or wait finishes to a URL,
+// for bots and pipelines that act on it.
+type jobWebhookConfig struct {
+	URL string `yaml:"url,omitempty" env:"SORA2_WEBHOOK_URL" secret:"true"`
+	// Secret signs each request so the receiver can tell it came from
+	// here. It is only read from the config or the environment, never a
+	// flag, to keep it out of shell history.
+	Secret string `yaml:"secret,omitempty" env:"SORA2_WEBHOOK_SECRET" secret:"true"`
+}
+
+// withURL returns the config with --webhook-url, when given, in place of
+// the configured URL.
+func (c jobWebhookConfig) withURL(url string) jobWebhookConfig {
+	if url != "" {
+		c.URL = url
+	}
+	return c
+}
+
+func validateWebhookURL(url string) error {
+	if url != "" && !isHTTPURL(url) {
+		return fmt.Errorf("--webhook-url %q is not an absolute http(s) URL", url)
+	}
+	return nil
+}
+
+// jobWebhookPayload is the body of a job webhook: the final job object as
+// the API returned it and what the CLI did with it.
+type jobWebhookPayload struct {
+	Event         string            `json:"event"`
+	JobID         string            `json:"job_id"`
+	Status        string            `json:"status"`
+	Job           *sora.Video       `json:"job,omitempty"`
+	OutputPath    string            `json:"output_path,omitempty"`
+	Variants      map[string]string `json:"variants,omitempty"`
+	Prompt        string            `json:"prompt,omitempty"`
+	Ticket        string            `json:"ticket,omitempty"`
+	EstimatedCost float64           `json:"estimated_cost,omitempty"`
+	Error         string            `json:"error,omitempty"`
+	SentAt        time.Time         `json:"sent_at"`
+}
+
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func postJobWebhook(ctx context.Context, cfg jobWebhookConfig, payload jobWebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, notificationTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sora2cli/"+cliVersion())
+	if cfg.Secret != "" {
+		req.Header.Set(jobWebhookSignatureHeader, signWebhookBody(cfg.Secret, body))
+	}
+	resp, err := (&http.Client{Timeout: notificationTimeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, readAPIError(resp.Body))
+	}
+	return nil
+}
+
+// postJobWebhookOrWarn sends the outcome of a job to the job webhook, if one
+// is set. job is the last state of the job the CLI saw, if any. Like a
+// ticket update, a failed delivery is a warning and never fails the job.
+func postJobWebhookOrWarn(cfg jobWebhookConfig, event ticketEvent, job *sora.Video, variants map[string]string) {
+	if cfg.URL == "" || event.JobID == "" {
+		return
+	}
+	payload := jobWebhookPayload{
+		Event:         "job." + event.Status,
+		JobID:         event.JobID,
+		Status:        event.Status,
+		Job:           job,
+		OutputPath:    event.OutputPath,
+		Variants:      variants,
+		Prompt:        event.Prompt,
+		Ticket:        event.Ticket,
+		EstimatedCost: event.EstimatedCost,
+		Error:         event.Error,
+		SentAt:        time.Now().UTC(),
+	}
+	if err := postJobWebhook(context.Background(), cfg, payload); err != nil {
+		logWarn("unable to post the job webhook: %v", err)
+		return
+	}
+	logInfo("Posted the job webhook")
+}
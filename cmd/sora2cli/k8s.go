@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	k8sSecretDir  = "/var/run/secrets/sora2cli"
+	k8sJobsDir    = "/etc/sora2cli"
+	k8sJobsFile   = "jobs.jsonl"
+	k8sNonRootGID = 65532
+)
+
+// k8sForwardedKeys are the settings copied from the local config into the
+// manifest, so the cluster renders with the same endpoint and defaults.
+// Secrets stay in the cluster's Secret and the destination is always /data.
+var k8sForwardedKeys = []string{
+	"base_url",
+	"base_urls",
+	"org_id",
+	"project_id",
+	"progress_scale",
+	"defaults.model",
+	"defaults.seconds",
+	"defaults.size",
+	"defaults.with_thumbnail",
+	"defaults.with_spritesheet",
+	"defaults.write_sidecar",
+	"retry.max_attempts",
+}
+
+func runK8sCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli k8s render-job --batch <file> [flags]")
+		return 2
+	}
+	if isHelpArg(args[0]) {
+		return runHelpCommand([]string{"k8s"})
+	}
+	switch args[0] {
+	case "render-job":
+		return runK8sRenderJob(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown k8s command %q\n", args[0])
+		fmt.Fprintln(os.Stderr, "usage: sora2cli k8s render-job --batch <file> [flags]")
+		return 2
+	}
+}
+
+type renderJobOptions struct {
+	Name        string
+	Namespace   string
+	Image       string
+	Schedule    string
+	Secret      string
+	SecretKey   string
+	PVC         string
+	Concurrency int
+	Budget      float64
+}
+
+// runK8sRenderJob prints the manifests that run a batch file on a cluster: a
+// ConfigMap holding the job specs and a Job, or a CronJob with --schedule,
+// running "sora2cli batch apply" in the container image.
+func runK8sRenderJob(args []string) int {
+	fs := newCommandFlagSet("k8s render-job")
+	batch := fs.String("batch", "", "job specs to render: a JSONL prompts file, or a YAML list of specs (.yaml/.yml)")
+	var opts renderJobOptions
+	fs.StringVar(&opts.Name, "name", "sora2cli-render", "name of the Job or CronJob and prefix of its ConfigMap")
+	fs.StringVar(&opts.Namespace, "namespace", "", "namespace to put the resources in (default: the one kubectl applies to)")
+	fs.StringVar(&opts.Image, "image", "sora2cli:latest", "container image built from the repository's Dockerfile")
+	fs.StringVar(&opts.Schedule, "schedule", "", "cron schedule; emits a CronJob instead of a Job")
+	fs.StringVar(&opts.Secret, "secret", "openai-api-key", "Secret holding the OpenAI API key")
+	fs.StringVar(&opts.SecretKey, "secret-key", "api-key", "key of the API key within the Secret")
+	fs.StringVar(&opts.PVC, "pvc", "sora2cli-renders", "PersistentVolumeClaim mounted at /data for renders, history and logs")
+	fs.IntVar(&opts.Concurrency, "concurrency", 1, "maximum number of jobs in flight within the pod")
+	fs.Float64Var(&opts.Budget, "budget", 0, "stop submitting once the estimated spend would exceed this many USD (0 = no limit)")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if *batch == "" || fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli k8s render-job --batch <file> [flags]")
+		return 2
+	}
+	if opts.Concurrency < 1 {
+		logError("--concurrency must be at least 1")
+		return 2
+	}
+	if opts.Budget < 0 {
+		logError("--budget must not be negative")
+		return 2
+	}
+
+	path, err := expandPath(*batch)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	specs, err := readRenderSpecs(path)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	if len(specs) == 0 {
+		logError("%s contains no job specs", path)
+		return 1
+	}
+	var lines bytes.Buffer
+	for i, spec := range specs {
+		if spec.Reference != "" || len(spec.References) > 0 {
+			logWarn("spec %d uses reference files, which are not shipped to the cluster; they must exist in the container (for example under /data)", i+1)
+		}
+		check := spec
+		check.References = nil
+		check.Reference = ""
+		if _, err := check.resolve(defaultConfig().Defaults); err != nil {
+			logError("spec %d: %v", i+1, err)
+			return 1
+		}
+		data, err := json.Marshal(spec)
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+		lines.Write(data)
+		lines.WriteByte('\n')
+	}
+
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	for _, doc := range renderJobManifests(opts, lines.String(), forwardedConfigEnv(cfg)) {
+		if err := encoder.Encode(doc); err != nil {
+			logError("%v", err)
+			return 1
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		logError("%v", err)
+		return 1
+	}
+	return 0
+}
+
+// readRenderSpecs reads job specs from a JSONL prompts file, following the
+// same rules as batch, or from a YAML list when the file is .yaml or .yml.
+func readRenderSpecs(path string) ([]jobSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var specs []jobSpec
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&specs); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return specs, nil
+	}
+	var specs []jobSpec
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var spec jobSpec
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&spec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, scanner.Err()
+}
+
+// forwardedConfigEnv returns environment variables for the forwarded keys
+// that the local config or environment sets.
+func forwardedConfigEnv(cfg *resolvedConfig) []map[string]any {
+	values := make(map[string]string)
+	for _, entry := range flattenConfig(reflect.ValueOf(cfg.config), "") {
+		if _, ok := cfg.Sources[entry.Key]; ok && !entry.Secret {
+			values[entry.Key] = formatConfigValue(entry)
+		}
+	}
+	var env []map[string]any
+	for _, key := range k8sForwardedKeys {
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		if key == "base_urls" {
+			value = strings.ReplaceAll(value, ", ", ",")
+		}
+		env = append(env, map[string]any{"name": configEnvName(key), "value": value})
+	}
+	return env
+}
+
+func renderJobManifests(opts renderJobOptions, jobs string, env []map[string]any) []map[string]any {
+	labels := map[string]any{
+		"app.kubernetes.io/name":     "sora2cli",
+		"app.kubernetes.io/instance": opts.Name,
+	}
+	metadata := func(name string) map[string]any {
+		m := map[string]any{"name": name, "labels": labels}
+		if opts.Namespace != "" {
+			m["namespace"] = opts.Namespace
+		}
+		return m
+	}
+	configMap := opts.Name + "-jobs"
+
+	// apply skips specs that history on the volume already has, so a
+	// CronJob only pays for what changed since its last run.
+	args := []string{"batch", "apply", "--yes", "--file", k8sJobsDir + "/" + k8sJobsFile, "--out", containerDataDir, "--concurrency", strconv.Itoa(opts.Concurrency)}
+	if opts.Budget > 0 {
+		args = append(args, "--budget", strconv.FormatFloat(opts.Budget, 'f', -1, 64))
+	}
+	env = append([]map[string]any{
+		{"name": "OPENAI_API_KEY_FILE", "value": k8sSecretDir + "/" + opts.SecretKey},
+		{"name": "SORA2_CONTAINER", "value": "1"},
+		// History, the activity log and crash reports live on the volume,
+		// so they outlast the pod.
+		{"name": "XDG_CONFIG_HOME", "value": containerDataDir + "/.config"},
+		{"name": "XDG_DATA_HOME", "value": containerDataDir + "/.local/share"},
+		{"name": "XDG_CACHE_HOME", "value": containerDataDir + "/.cache"},
+	}, env...)
+
+	podSpec := map[string]any{
+		"restartPolicy":   "Never",
+		"securityContext": map[string]any{"fsGroup": k8sNonRootGID},
+		"containers": []any{map[string]any{
+			"name":  "sora2cli",
+			"image": opts.Image,
+			"args":  args,
+			"env":   env,
+			"volumeMounts": []any{
+				map[string]any{"name": "renders", "mountPath": containerDataDir},
+				map[string]any{"name": "jobs", "mountPath": k8sJobsDir, "readOnly": true},
+				map[string]any{"name": "api-key", "mountPath": k8sSecretDir, "readOnly": true},
+			},
+		}},
+		"volumes": []any{
+			map[string]any{"name": "renders", "persistentVolumeClaim": map[string]any{"claimName": opts.PVC}},
+			map[string]any{"name": "jobs", "configMap": map[string]any{"name": configMap}},
+			map[string]any{"name": "api-key", "secret": map[string]any{"secretName": opts.Secret}},
+		},
+	}
+	// A retried pod would submit every spec again, paying for renders that
+	// already finished, so failures are left for a person to look at.
+	jobSpec := map[string]any{
+		"backoffLimit": 0,
+		"template":     map[string]any{"metadata": map[string]any{"labels": labels}, "spec": podSpec},
+	}
+
+	docs := []map[string]any{{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   metadata(configMap),
+		"data":       map[string]any{k8sJobsFile: jobs},
+	}}
+	if opts.Schedule != "" {
+		return append(docs, map[string]any{
+			"apiVersion": "batch/v1",
+			"kind":       "CronJob",
+			"metadata":   metadata(opts.Name),
+			"spec": map[string]any{
+				"schedule":          opts.Schedule,
+				"concurrencyPolicy": "Forbid",
+				"jobTemplate":       map[string]any{"spec": jobSpec},
+			},
+		})
+	}
+	return append(docs, map[string]any{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata":   metadata(opts.Name),
+		"spec":       jobSpec,
+	})
+}
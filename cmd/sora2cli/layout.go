@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+// projectTagPrefix marks the tag that names a job's project, e.g.
+// project:launch.
+const projectTagPrefix = "project:"
+
+var layoutPlaceholder = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// layoutFields are the placeholders of defaults.layout.
+var layoutFields = []string{"yyyy", "mm", "dd", "project", "model"}
+
+func validateLayout(layout string) error {
+	if layout == "" {
+		return nil
+	}
+	if filepath.IsAbs(layout) || strings.HasPrefix(layout, "~") {
+		return fmt.Errorf("must be relative to the destination")
+	}
+	for _, segment := range strings.Split(filepath.ToSlash(layout), "/") {
+		if segment == ".." {
+			return fmt.Errorf("must stay inside the destination")
+		}
+	}
+	for _, match := range layoutPlaceholder.FindAllStringSubmatch(layout, -1) {
+		known := false
+		for _, field := range layoutFields {
+			known = known || match[1] == field
+		}
+		if !known {
+			return fmt.Errorf("unknown placeholder %s; use {%s}", match[0], strings.Join(layoutFields, "}, {"))
+		}
+	}
+	return nil
+}
+
+// jobProject returns the project of a job: the value of its project: tag,
+// else the configured one.
+func jobProject(tags []string, fallback string) string {
+	for _, tag := range tags {
+		if name, ok := strings.CutPrefix(tag, projectTagPrefix); ok && name != "" {
+			return name
+		}
+	}
+	return fallback
+}
+
+// configProject is the project defaults.project names, or else the folder
+// of the project config in use.
+func configProject(cfg *resolvedConfig) string {
+	if cfg.Defaults.Project != "" {
+		return cfg.Defaults.Project
+	}
+	if cfg.ProjectPath != "" {
+		return filepath.Base(filepath.Dir(cfg.ProjectPath))
+	}
+	return ""
+}
+
+// safePathSegment keeps a placeholder's value to a single folder name.
+func safePathSegment(value string) string {
+	return strings.Trim(strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '-'
+		}
+		return r
+	}, value), ". ")
+}
+
+// expandLayout fills in the layout for a job created at created. Folders
+// whose placeholders are all empty, such as {project} for a job without a
+// project, are left out.
+func expandLayout(layout string, created time.Time, project, model string) string {
+	values := map[string]string{
+		"yyyy":    created.Format("2006"),
+		"mm":      created.Format("01"),
+		"dd":      created.Format("02"),
+		"project": safePathSegment(project),
+		"model":   safePathSegment(model),
+	}
+	var segments []string
+	for _, segment := range strings.Split(filepath.ToSlash(layout), "/") {
+		segment = layoutPlaceholder.ReplaceAllStringFunc(segment, func(match string) string {
+			return values[match[1:len(match)-1]]
+		})
+		if segment = strings.TrimSpace(segment); segment != "" && segment != "." {
+			segments = append(segments, segment)
+		}
+	}
+	return filepath.Join(segments...)
+}
+
+// outputDir returns the folder under destination that the layout puts a
+// job's files in, and creates it.
+func (x extraOutputs) outputDir(destination string, job *sora.Video, tags []string) (string, error) {
+	if x.Layout == "" {
+		return destination, nil
+	}
+	if err := validateLayout(x.Layout); err != nil {
+		return "", fmt.Errorf("defaults.layout %s", err)
+	}
+	created := time.Now()
+	if job.CreatedAt > 0 {
+		created = time.Unix(job.CreatedAt, 0)
+	}
+	dir := filepath.Join(destination, expandLayout(x.Layout, created, jobProject(tags, x.Project), job.Model))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("unable to create destination directory: %w", err)
+	}
+	return dir, nil
+}
+
+// outputPath is where a job's MP4 is saved.
+func (x extraOutputs) outputPath(destination string, job *sora.Video, tags []string) (string, error) {
+	dir, err := x.outputDir(destination, job, tags)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, job.ID+".mp4"), nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+// Library index formats of export --format.
+var libraryFormats = []string{"csv", "json"}
+
+// libraryRecord is one video in the library index: the API's view of it
+// joined with what history knows about it on this machine.
+type libraryRecord struct {
+	ID            string            `json:"id"`
+	Status        string            `json:"status"`
+	Model         string            `json:"model,omitempty"`
+	Seconds       int               `json:"seconds,omitempty"`
+	Size          string            `json:"size,omitempty"`
+	CreatedAt     time.Time         `json:"created_at,omitzero"`
+	CompletedAt   time.Time         `json:"completed_at,omitzero"`
+	ExpiresAt     time.Time         `json:"expires_at,omitzero"`
+	RemixedFrom   string            `json:"remixed_from,omitempty"`
+	Prompt        string            `json:"prompt,omitempty"`
+	Error         string            `json:"error,omitempty"`
+	EstimatedCost float64           `json:"estimated_cost,omitempty"`
+	Source        string            `json:"source,omitempty"`
+	Ticket        string            `json:"ticket,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+	Note          string            `json:"note,omitempty"`
+	Review        string            `json:"review,omitempty"`
+	OutputPath    string            `json:"output_path,omitempty"`
+	OutputBytes   int64             `json:"output_bytes,omitempty"`
+	SHA256        string            `json:"sha256,omitempty"`
+	Derived       map[string]string `json:"derived,omitempty"`
+	// Remote is false for jobs in history that the API no longer lists,
+	// usually because they expired or were deleted.
+	Remote bool `json:"remote"`
+}
+
+func libraryRecordFromVideo(video *sora.Video) libraryRecord {
+	record := libraryRecord{
+		ID:          video.ID,
+		Status:      video.Status,
+		Model:       video.Model,
+		Size:        video.Size,
+		RemixedFrom: video.RemixedFromVideoID,
+		Prompt:      video.Prompt,
+		Remote:      true,
+	}
+	if seconds, err := strconv.Atoi(video.Seconds); err == nil {
+		record.Seconds = seconds
+		record.EstimatedCost = jobCostEstimate(video.Model, seconds)
+	}
+	if video.CreatedAt > 0 {
+		record.CreatedAt = time.Unix(video.CreatedAt, 0).UTC()
+	}
+	if video.CompletedAt > 0 {
+		record.CompletedAt = time.Unix(video.CompletedAt, 0).UTC()
+	}
+	if video.ExpiresAt > 0 {
+		record.ExpiresAt = time.Unix(video.ExpiresAt, 0).UTC()
+	}
+	if video.Error != nil {
+		record.Error = video.Error.Message
+	}
+	return record
+}
+
+func libraryRecordFromEntry(entry *historyEntry) libraryRecord {
+	record := libraryRecord{
+		ID:          entry.JobID,
+		Status:      entry.Status,
+		Model:       entry.Model,
+		Seconds:     entry.Seconds,
+		Size:        entry.Size,
+		CreatedAt:   entry.CreatedAt.UTC(),
+		RemixedFrom: entry.RemixedFrom,
+		Error:       entry.Error,
+	}
+	if entry.Seconds > 0 {
+		record.EstimatedCost = jobCostEstimate(entry.Model, entry.Seconds)
+	}
+	if entry.Status == "completed" {
+		record.CompletedAt = entry.FinishedAt.UTC()
+	}
+	return record
+}
+
+// addLocal fills in what history records about the job, and the file it was
+// downloaded to if that is still there. The API's prompt wins, as for list.
+func (r *libraryRecord) addLocal(entry *historyEntry, localDir string) {
+	if entry != nil {
+		r.Prompt = firstNonEmpty(r.Prompt, entry.Prompt)
+		if entry.EstimatedCost > 0 {
+			r.EstimatedCost = entry.EstimatedCost
+		}
+		r.Source, r.Ticket, r.Tags, r.Note = entry.Source, entry.Ticket, entry.Tags, entry.Note
+		if entry.Review != nil {
+			r.Review = entry.Review.Status
+		}
+		r.Derived = entry.Derived
+		if entry.OutputPath != "" {
+			if _, err := os.Stat(entry.OutputPath); err == nil {
+				r.OutputPath, r.OutputBytes, r.SHA256 = entry.OutputPath, entry.OutputBytes, entry.SHA256
+				return
+			}
+		}
+	}
+	path := filepath.Join(localDir, r.ID+".mp4")
+	if info, err := os.Stat(path); err == nil {
+		r.OutputPath, r.OutputBytes = path, info.Size()
+	}
+}
+
+// collectLibrary pages through every video the API lists and joins each
+// with history. With includeLocal the jobs history holds that the API no
+// longer lists follow, newest first.
+func collectLibrary(ctx context.Context, client *sora.Client, state historyState, localDir string, includeLocal bool) ([]libraryRecord, error) {
+	var records []libraryRecord
+	remote := make(map[string]bool)
+	params := sora.ListParams{Limit: 100}
+	for {
+		page, err := client.List(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		for i := range page.Data {
+			record := libraryRecordFromVideo(&page.Data[i])
+			record.addLocal(state.find(record.ID), localDir)
+			remote[record.ID] = true
+			records = append(records, record)
+		}
+		logDebug("export: fetched %d video(s)", len(records))
+		params.After = page.Cursor()
+		if !page.HasMore || params.After == "" {
+			break
+		}
+	}
+	if !includeLocal {
+		return records, nil
+	}
+	var local []libraryRecord
+	for _, entry := range state.Entries {
+		if remote[entry.JobID] {
+			continue
+		}
+		record := libraryRecordFromEntry(entry)
+		record.addLocal(entry, localDir)
+		local = append(local, record)
+	}
+	sort.SliceStable(local, func(i, j int) bool { return local[i].CreatedAt.After(local[j].CreatedAt) })
+	return append(records, local...), nil
+}
+
+func libraryTimestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// writeLibraryCSV writes one row per video, timestamps in RFC 3339 UTC, tags
+// joined by commas and derived files as name=path pairs joined by
+// semicolons.
+func writeLibraryCSV(w io.Writer, records []libraryRecord) error {
+	writer := csv.NewWriter(w)
+	header := []string{"id", "status", "model", "seconds", "size", "created_at", "completed_at", "expires_at", "remixed_from", "prompt", "error",
+		"estimated_cost", "source", "ticket", "tags", "note", "review", "output_path", "output_bytes", "sha256", "derived", "remote"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, r := range records {
+		seconds, cost, bytes := "", "", ""
+		if r.Seconds > 0 {
+			seconds = strconv.Itoa(r.Seconds)
+		}
+		if r.EstimatedCost > 0 {
+			cost = strconv.FormatFloat(r.EstimatedCost, 'f', 2, 64)
+		}
+		if r.OutputBytes > 0 {
+			bytes = strconv.FormatInt(r.OutputBytes, 10)
+		}
+		derived := make([]string, 0, len(r.Derived))
+		for name, path := range r.Derived {
+			derived = append(derived, name+"="+path)
+		}
+		sort.Strings(derived)
+		record := []string{r.ID, r.Status, r.Model, seconds, r.Size, libraryTimestamp(r.CreatedAt), libraryTimestamp(r.CompletedAt), libraryTimestamp(r.ExpiresAt),
+			r.RemixedFrom, r.Prompt, r.Error, cost, r.Source, r.Ticket, strings.Join(r.Tags, ","), r.Note, r.Review, r.OutputPath, bytes, r.SHA256,
+			strings.Join(derived, ";"), strconv.FormatBool(r.Remote)}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeLibraryJSON writes the records as one indented JSON array.
+func writeLibraryJSON(w io.Writer, records []libraryRecord) error {
+	if records == nil {
+		records = []libraryRecord{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+// runLibraryExport writes an index of the whole library, every video the API
+// lists with its prompt, tags, review and local file, for spreadsheets and
+// asset management systems. The file is only replaced once every page has
+// been fetched.
+func runLibraryExport(format, out, dir string, includeLocal bool) int {
+	format = strings.ToLower(strings.TrimSpace(format))
+	write := writeLibraryCSV
+	switch format {
+	case "csv":
+	case "json":
+		write = writeLibraryJSON
+	default:
+		logError("unknown format %q; use %s", format, strings.Join(libraryFormats, " or "))
+		return 2
+	}
+	session, err := newAPISession(false)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	localDir, err := expandPath(firstNonEmpty(dir, session.cfg.Defaults.Destination))
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	state, err := loadHistory()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	records, err := collectLibrary(ctx, session.client, state, localDir, includeLocal)
+	if err != nil {
+		logError("failed to list videos: %v", err)
+		return 1
+	}
+
+	if out == "" || out == "-" {
+		if err := write(os.Stdout, records); err != nil {
+			logError("write %s: %v", format, err)
+			return 1
+		}
+		return 0
+	}
+	path, err := expandPath(out)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	tmpPath := path + ".partial"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	err = write(file, records)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		logError("%v", err)
+		return 1
+	}
+	logInfo("Wrote %d video(s) to %s", len(records), path)
+	return 0
+}
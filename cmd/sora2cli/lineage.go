@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// remixLineage is the lineage file a remix session keeps next to its
+// videos, <root>.lineage.json. Steps hold every video remixed from the root
+// in the order they were made; RemixedFrom links each one to its source, so
+// branches taken from the same video share the file.
+type remixLineage struct {
+	Root      string        `json:"root"`
+	Steps     []lineageStep `json:"steps"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+type lineageStep struct {
+	VideoID     string    `json:"video_id"`
+	RemixedFrom string    `json:"remixed_from,omitempty"`
+	Prompt      string    `json:"prompt,omitempty"`
+	Model       string    `json:"model,omitempty"`
+	OutputPath  string    `json:"output_path,omitempty"`
+	CreatedAt   time.Time `json:"created_at,omitzero"`
+}
+
+// historyChain follows remixed_from links in history back from videoID and
+// returns the chain from the original video to videoID.
+func historyChain(state historyState, videoID string) []lineageStep {
+	var chain []lineageStep
+	seen := make(map[string]bool)
+	for id := videoID; id != "" && !seen[id]; {
+		seen[id] = true
+		step := lineageStep{VideoID: id}
+		if entry := state.find(id); entry != nil {
+			step.RemixedFrom = entry.RemixedFrom
+			step.Prompt = entry.Prompt
+			step.Model = entry.Model
+			step.OutputPath = entry.OutputPath
+			step.CreatedAt = entry.CreatedAt
+		}
+		chain = append(chain, step)
+		id = step.RemixedFrom
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+// recordLineage adds the chain leading to videoID to the lineage file in dir
+// and returns the file's path and the chain.
+func recordLineage(dir, videoID string) (string, []lineageStep, error) {
+	state, err := loadHistory()
+	if err != nil {
+		return "", nil, err
+	}
+	chain := historyChain(state, videoID)
+	path := filepath.Join(dir, chain[0].VideoID+".lineage.json")
+	lineage := remixLineage{Root: chain[0].VideoID}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &lineage); err != nil {
+			return "", nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return "", nil, err
+	}
+	for _, step := range chain {
+		found := false
+		for i := range lineage.Steps {
+			if lineage.Steps[i].VideoID == step.VideoID {
+				lineage.Steps[i], found = step, true
+				break
+			}
+		}
+		if !found {
+			lineage.Steps = append(lineage.Steps, step)
+		}
+	}
+	lineage.UpdatedAt = time.Now().UTC()
+	data, err = json.MarshalIndent(lineage, "", "  ")
+	if err != nil {
+		return "", nil, err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, append(data, '\n'), 0o644); err != nil {
+		return "", nil, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return "", nil, err
+	}
+	return path, chain, nil
+}
+
+func printLineage(chain []lineageStep) {
+	for i, step := range chain {
+		prompt := step.Prompt
+		if prompt == "" {
+			prompt = "(prompt unknown)"
+		}
+		fmt.Printf("  %d. %s  %s\n", i+1, step.VideoID, truncateText(prompt, 70))
+	}
+}
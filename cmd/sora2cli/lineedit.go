@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// promptHistoryFileName keeps the prompts typed in earlier sessions, one
+// JSON string per line, oldest first.
+const promptHistoryFileName = "history_prompts"
+
+// promptHistoryLimit bounds the saved prompts; older ones are dropped.
+const promptHistoryLimit = 500
+
+// lineHistory is what the up and down arrows recall at a line prompt. The
+// editor adds every line it reads, including each line of a multi-line
+// paste, so Add does nothing and readLine records whole entries itself.
+type lineHistory struct {
+	entries []string
+	// path is the file the entries are kept in, or "" for the session only.
+	path   string
+	loaded bool
+}
+
+// promptHistory is shared by every prompt question and kept across
+// sessions; fieldHistory holds other answers, such as video IDs, for this
+// session only.
+var (
+	promptHistory = &lineHistory{}
+	fieldHistory  = &lineHistory{loaded: true}
+)
+
+func (h *lineHistory) Add(string) {}
+
+func (h *lineHistory) Len() int { return len(h.entries) }
+
+// At returns the idx-th most recent entry. Line breaks are shown as spaces,
+// since the editor works on one line.
+func (h *lineHistory) At(idx int) string {
+	return strings.ReplaceAll(h.entries[len(h.entries)-1-idx], "\n", " ")
+}
+
+// load reads the saved prompts on first use.
+func (h *lineHistory) load() {
+	if h.loaded {
+		return
+	}
+	h.loaded = true
+	dir, err := resolveDataDir()
+	if err != nil {
+		return
+	}
+	h.path = filepath.Join(dir, promptHistoryFileName)
+	data, err := os.ReadFile(h.path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logWarn("unable to read prompt history: %v", err)
+		}
+		return
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var entry string
+		if len(line) > 0 && json.Unmarshal(line, &entry) == nil {
+			h.entries = append(h.entries, entry)
+		}
+	}
+	if len(h.entries) > promptHistoryLimit {
+		h.entries = h.entries[len(h.entries)-promptHistoryLimit:]
+		h.save(h.entries, os.O_TRUNC)
+	}
+}
+
+// record adds entry unless it repeats the most recent one.
+func (h *lineHistory) record(entry string) {
+	if strings.TrimSpace(entry) == "" || (len(h.entries) > 0 && h.entries[len(h.entries)-1] == entry) {
+		return
+	}
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > promptHistoryLimit {
+		h.entries = h.entries[len(h.entries)-promptHistoryLimit:]
+		h.save(h.entries, os.O_TRUNC)
+		return
+	}
+	h.save([]string{entry}, os.O_APPEND)
+}
+
+func (h *lineHistory) save(entries []string, mode int) {
+	if h.path == "" {
+		return
+	}
+	var b bytes.Buffer
+	for _, entry := range entries {
+		line, _ := json.Marshal(entry)
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	err := os.MkdirAll(filepath.Dir(h.path), 0o700)
+	if err == nil {
+		var file *os.File
+		if file, err = os.OpenFile(h.path, os.O_CREATE|os.O_WRONLY|mode, 0o600); err == nil {
+			_, err = file.Write(b.Bytes())
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	if err != nil {
+		logWarn("unable to save prompt history: %v", err)
+	}
+}
+
+// readLine shows prompt and reads one answer. At a terminal it runs a line
+// editor: the arrow keys, Home and End (or Ctrl+A and Ctrl+E) move the
+// cursor, Alt+Left and Alt+Right move by word, Ctrl+W, Ctrl+U and Ctrl+K
+// delete, and Up and Down recall history. A pasted block of several lines is
+// taken as one answer, with its line breaks, once Enter is pressed after it.
+// Raw mode also lifts the terminal's limit of 4096 bytes per line, which
+// long prompts would otherwise hit.
+func readLine(reader *bufio.Reader, out io.Writer, prompt string, history *lineHistory) (string, error) {
+	if !stdinIsTerminal() {
+		fmt.Fprint(out, prompt)
+		return readPlainLine(reader)
+	}
+	if err := enterRawTerminal(); err != nil {
+		fmt.Fprint(out, prompt)
+		return readPlainLine(reader)
+	}
+	defer restoreTerminal()
+
+	history.load()
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{reader, out}, prompt)
+	t.History = history
+	if width, height, err := term.GetSize(int(os.Stdin.Fd())); err == nil && width > 0 {
+		t.SetSize(width, height)
+	}
+	t.SetBracketedPasteMode(true)
+	defer t.SetBracketedPasteMode(false)
+
+	var lines []string
+	for {
+		line, err := t.ReadLine()
+		if err == io.EOF {
+			// Ctrl+C, or Ctrl+D on an empty line.
+			return "", errInterrupted
+		}
+		if err != nil && err != term.ErrPasteIndicator {
+			return "", err
+		}
+		lines = append(lines, line)
+		if err == nil {
+			break
+		}
+		// More of the paste may follow; an empty Enter ends it.
+		t.SetPrompt("... ")
+	}
+	if len(lines) > 1 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	answer := strings.Join(lines, "\n")
+	history.record(answer)
+	return answer, nil
+}
+
+// readPlainLine reads a line without editing, for piped input and
+// terminals that cannot enter raw mode.
+func readPlainLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+// List output formats. table is one aligned row per video; wide adds the
+// lineage, review, tags and prompt; csv has every column, for spreadsheets.
+const (
+	listOutputTable = "table"
+	listOutputWide  = "wide"
+	listOutputJSON  = "json"
+	listOutputCSV   = "csv"
+)
+
+var listOutputs = []string{listOutputTable, listOutputWide, listOutputJSON, listOutputCSV}
+
+// listRow is what the table and CSV show of a video. History fills in the
+// prompt when the API leaves it out.
+type listRow struct {
+	video  *sora.Video
+	prompt string
+	review string
+	tags   []string
+	note   string
+}
+
+func newListRows(videos []sora.Video, state historyState) []listRow {
+	rows := make([]listRow, len(videos))
+	for i := range videos {
+		row := listRow{video: &videos[i], prompt: videos[i].Prompt}
+		if entry := state.find(videos[i].ID); entry != nil {
+			if row.prompt == "" {
+				row.prompt = entry.Prompt
+			}
+			if entry.Review != nil {
+				row.review = entry.Review.Status
+			}
+			row.tags, row.note = entry.Tags, entry.Note
+		}
+		rows[i] = row
+	}
+	return rows
+}
+
+func (r listRow) progress() string {
+	if r.video.Progress <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f%%", r.video.ProgressPercent())
+}
+
+func (r listRow) errorMessage() string {
+	if r.video.Error == nil {
+		return ""
+	}
+	return r.video.Error.Message
+}
+
+func dashIfEmpty(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// printVideoTable writes the videos as an aligned table.
+func printVideoTable(w io.Writer, videos []sora.Video, state historyState, wide bool) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := "ID\tSTATUS\tMODEL\tSECONDS\tSIZE\tCREATED\tPROGRESS"
+	if wide {
+		header += "\tCOMPLETED\tEXPIRES\tREMIXED FROM\tREVIEW\tTAGS\tPROMPT\tERROR"
+	}
+	fmt.Fprintln(tw, header)
+	for _, row := range newListRows(videos, state) {
+		v := row.video
+		line := fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s", v.ID, v.Status, dashIfEmpty(v.Model), dashIfEmpty(v.Seconds), dashIfEmpty(v.Size), formatUnixTimestamp(v.CreatedAt), row.progress())
+		if wide {
+			line += fmt.Sprintf("\t%s\t%s\t%s\t%s\t%s\t%s\t%s", formatUnixTimestamp(v.CompletedAt), formatUnixTimestamp(v.ExpiresAt), dashIfEmpty(v.RemixedFromVideoID),
+				dashIfEmpty(row.review), dashIfEmpty(strings.Join(row.tags, ",")), dashIfEmpty(truncateText(row.prompt, 60)), dashIfEmpty(truncateText(row.errorMessage(), 60)))
+		}
+		fmt.Fprintln(tw, line)
+	}
+	tw.Flush()
+}
+
+func csvTimestamp(sec int64) string {
+	if sec <= 0 {
+		return ""
+	}
+	return time.Unix(sec, 0).UTC().Format(time.RFC3339)
+}
+
+// writeVideoCSV writes one row per video with every column, timestamps in
+// RFC 3339 UTC.
+func writeVideoCSV(w io.Writer, videos []sora.Video, state historyState) error {
+	writer := csv.NewWriter(w)
+	header := []string{"id", "status", "model", "seconds", "size", "created_at", "progress", "completed_at", "expires_at", "remixed_from", "review", "prompt", "error", "tags", "note"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, row := range newListRows(videos, state) {
+		v := row.video
+		progress := ""
+		if v.Progress > 0 {
+			progress = strconv.FormatFloat(v.ProgressPercent(), 'f', -1, 64)
+		}
+		record := []string{v.ID, v.Status, v.Model, v.Seconds, v.Size, csvTimestamp(v.CreatedAt), progress,
+			csvTimestamp(v.CompletedAt), csvTimestamp(v.ExpiresAt), v.RemixedFromVideoID, row.review, row.prompt, row.errorMessage(), strings.Join(row.tags, ","), row.note}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func validateListOutput(value string) error {
+	for _, format := range listOutputs {
+		if value == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown output format %q; use %s", value, strings.Join(listOutputs, ", "))
+}
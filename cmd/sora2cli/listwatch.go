@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+	"golang.org/x/term"
+)
+
+const defaultWatchInterval = 5 * time.Second
+
+// videoSnapshot is what watch mode compares between refreshes.
+type videoSnapshot struct {
+	status   string
+	progress string
+}
+
+func (s videoSnapshot) String() string {
+	if s.progress == "-" {
+		return s.status
+	}
+	return s.status + " " + s.progress
+}
+
+// watchList redraws the video table every interval until Ctrl+C, and
+// highlights the videos whose status or progress changed since the last
+// refresh, including new ones. A failed refresh keeps the last table and
+// tries again.
+func watchList(client *sora.Client, opts listOptions, limit int, order string) bool {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	highlight := term.IsTerminal(int(os.Stdout.Fd()))
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var previous map[string]videoSnapshot
+	for {
+		state, err := loadHistory()
+		if err != nil {
+			logError("%v", err)
+			return false
+		}
+		fetchCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+		list, err := listVideos(fetchCtx, client, state, opts, limit, order)
+		cancel()
+		switch {
+		case ctx.Err() != nil:
+			return true
+		case err != nil && previous == nil:
+			logError("failed to list videos: %v", err)
+			return false
+		case err != nil:
+			logWarn("refresh failed, retrying in %s: %v", interval, err)
+		default:
+			previous = drawWatchTable(list.Data, state, previous, opts.Output == listOutputWide, highlight, interval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return true
+		case <-ticker.C:
+		}
+	}
+}
+
+// drawWatchTable prints one refresh and returns the snapshot to compare the
+// next one against. On the first refresh nothing counts as changed.
+func drawWatchTable(videos []sora.Video, state historyState, previous map[string]videoSnapshot, wide, highlight bool, interval time.Duration) map[string]videoSnapshot {
+	current := make(map[string]videoSnapshot, len(videos))
+	changed := make(map[string]bool)
+	var changes []string
+	for _, row := range newListRows(videos, state) {
+		id := row.video.ID
+		snap := videoSnapshot{status: row.video.Status, progress: row.progress()}
+		current[id] = snap
+		if previous == nil {
+			continue
+		}
+		before, seen := previous[id]
+		switch {
+		case !seen:
+			changed[id] = true
+			changes = append(changes, fmt.Sprintf("%s: new, %s", id, snap))
+		case before != snap:
+			changed[id] = true
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", id, before, snap))
+		}
+	}
+
+	// Without a terminal to redraw, as when logging to a file, only a
+	// refresh that changed something is printed.
+	if !highlight && previous != nil && len(changes) == 0 {
+		return current
+	}
+	var table bytes.Buffer
+	printVideoTable(&table, videos, state, wide)
+	if highlight {
+		fmt.Print("\x1b[2J\x1b[H")
+	} else {
+		fmt.Println()
+	}
+	fmt.Printf("Every %s, updated %s. Press Ctrl+C to stop.\n\n", interval, formatTimestamp(time.Now()))
+	if len(videos) == 0 {
+		fmt.Println("No videos found.")
+		return current
+	}
+	// The table has a header line, then one line per video in order.
+	lines := strings.Split(strings.TrimSuffix(table.String(), "\n"), "\n")
+	for i, line := range lines {
+		if i > 0 && i <= len(videos) && changed[videos[i-1].ID] && highlight {
+			line = "\x1b[1;33m" + line + "\x1b[0m"
+		}
+		fmt.Println(line)
+	}
+	if len(changes) > 0 {
+		fmt.Println()
+		fmt.Println("Changed since the last refresh:")
+		for _, change := range changes {
+			fmt.Println("  " + change)
+		}
+	}
+	return current
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// errLocked means another process holds a lock that was not waited for.
+var errLocked = errors.New("locked by another process")
+
+// fileLock is an advisory lock on a file that coordinates sora2cli
+// processes sharing the data directory. The OS releases it when the process
+// exits, so a crash never leaves a stale lock behind.
+type fileLock struct {
+	file *os.File
+}
+
+func openLockFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+}
+
+// lockFile takes the lock at path, waiting for any other process to release
+// it. It guards short read-modify-write cycles on shared state files.
+func lockFile(path string) (*fileLock, error) {
+	file, err := openLockFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockHandle(file, true); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("lock %s: %w", path, err)
+	}
+	return &fileLock{file: file}, nil
+}
+
+// tryLockFile takes the lock at path without waiting and records this
+// process as its holder. If another process holds it, the error wraps
+// errLocked and names the holder.
+func tryLockFile(path string) (*fileLock, error) {
+	file, err := openLockFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockHandle(file, false); err != nil {
+		holder, _ := os.ReadFile(path)
+		file.Close()
+		if errors.Is(err, errLocked) && len(holder) > 0 {
+			return nil, fmt.Errorf("%w (%s)", errLocked, strings.TrimSpace(string(holder)))
+		}
+		return nil, fmt.Errorf("lock %s: %w", path, err)
+	}
+	if err := file.Truncate(0); err == nil {
+		fmt.Fprintf(file, "pid %d, %q since %s\n", os.Getpid(), strings.Join(os.Args[1:], " "), formatTimestamp(time.Now()))
+	}
+	return &fileLock{file: file}, nil
+}
+
+func (l *fileLock) unlock() {
+	if l == nil || l.file == nil {
+		return
+	}
+	unlockHandle(l.file)
+	l.file.Close()
+	l.file = nil
+}
+
+// runLockPath returns the lock file that keeps two processes from running
+// the same work, such as one queue, at once.
+func runLockPath(kind, name string) (string, error) {
+	dir, err := resolveDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "locks", kind+"-"+name+".lock"), nil
+}
+
+// lockBatchFile keeps other processes from rendering the prompts file at
+// path while this one does. Files are told apart by their absolute path.
+func lockBatchFile(path string) (*fileLock, error) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	sum := sha256.Sum256([]byte(path))
+	lockPath, err := runLockPath("batch", hex.EncodeToString(sum[:8]))
+	if err != nil {
+		return nil, err
+	}
+	return tryLockFile(lockPath)
+}
@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// Platforms without file locking run without coordination.
+func lockHandle(file *os.File, wait bool) error { return nil }
+
+func unlockHandle(file *os.File) {}
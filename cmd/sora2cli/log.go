@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevels orders the levels of log_level and --log-level.
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// logTextPrefix starts a warning or error printed as text, as it always has.
+var logTextPrefix = map[string]string{"debug": "DEBUG: ", "warn": "WARNING: ", "error": "ERROR: "}
+
+// logSettings are the --log-format, --log-file and --log-level given on the
+// command line, and logConfig the log_format, log_file and log_level of the
+// resolved config. A flag wins over the config.
+var (
+	logSettings struct{ format, file, level string }
+	logConfig   struct{ format, file, level string }
+)
+
+// logState guards the log file, which is opened on the first record and
+// stays open for the life of the process.
+var logState struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	failed bool
+}
+
+func registerLogFlags(fs *flag.FlagSet) {
+	fs.Func("log-format", "print status lines, warnings, errors and job events as `text` or json lines on stderr (default: log_format)", func(value string) error {
+		if value = strings.ToLower(value); value != "text" && value != "json" {
+			return fmt.Errorf("unknown format %q; supported: text, json", value)
+		}
+		logSettings.format = value
+		return nil
+	})
+	fs.Func("log-file", "also append every log record to `path`", func(path string) error {
+		logSettings.file = path
+		return nil
+	})
+	fs.Func("log-level", "least severe `level` logged: debug, info, warn or error (default: log_level or info)", func(value string) error {
+		if logLevelIndex(strings.ToLower(value)) < 0 {
+			return fmt.Errorf("unknown level %q; supported: debug, info, warn, error", value)
+		}
+		logSettings.level = strings.ToLower(value)
+		return nil
+	})
+}
+
+// configureLogging takes the log settings of the resolved config.
+func configureLogging(cfg *resolvedConfig) {
+	logConfig.format = strings.ToLower(cfg.LogFormat)
+	logConfig.file = cfg.LogFile
+	logConfig.level = strings.ToLower(cfg.LogLevel)
+}
+
+func logLevelIndex(level string) int {
+	for i, name := range logLevels {
+		if name == level {
+			return i
+		}
+	}
+	return -1
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func logJSON() bool {
+	return firstNonEmpty(logSettings.format, logConfig.format) == "json"
+}
+
+// logEnabled reports whether records of level are logged at all.
+func logEnabled(level string) bool {
+	least := logLevelIndex(firstNonEmpty(logSettings.level, logConfig.level, "info"))
+	return logLevelIndex(level) >= max(least, 0)
+}
+
+func logDebug(format string, args ...any) { logMessage("debug", fmt.Sprintf(format, args...)) }
+func logInfo(format string, args ...any)  { logMessage("info", fmt.Sprintf(format, args...)) }
+func logWarn(format string, args ...any)  { logMessage("warn", fmt.Sprintf(format, args...)) }
+func logError(format string, args ...any) { logMessage("error", fmt.Sprintf(format, args...)) }
+
+// logMessage prints a record and appends it to the log file. As text, info
+// records are the status lines of stdout and the rest go to stderr as
+// "WARNING: ..."; as JSON, every record is a line on stderr.
+func logMessage(level, message string) {
+	if !logEnabled(level) {
+		return
+	}
+	event := activityEvent{Time: time.Now(), Level: level, Message: message}
+	switch {
+	case logJSON():
+		writeLogJSON(event)
+	case level == "info":
+		fmt.Println(message)
+	default:
+		fmt.Fprintln(os.Stderr, logTextPrefix[level]+message)
+	}
+	appendLogFile(event)
+}
+
+// logBlankLine separates blocks of status lines as text. JSON logs have no
+// use for it.
+func logBlankLine() {
+	if !logJSON() {
+		fmt.Println()
+	}
+}
+
+// logEvent passes an activity event on to stderr, with log_format json, and
+// to the log file. In text mode the terminal already shows the progress the
+// event describes.
+func logEvent(event activityEvent) {
+	if !logEnabled(event.Level) {
+		return
+	}
+	if logJSON() {
+		writeLogJSON(event)
+	}
+	appendLogFile(event)
+}
+
+func writeLogJSON(event activityEvent) {
+	if line, err := marshalLogEvent(event); err == nil {
+		os.Stderr.Write(line)
+	}
+}
+
+// marshalLogEvent encodes event as one JSON line, leaving <, > and & alone
+// so traced URLs and arrows stay readable.
+func marshalLogEvent(event activityEvent) ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(event)
+	return b.Bytes(), err
+}
+
+// appendLogFile writes event to the log file in the log format. A file that
+// cannot be opened is reported once, after the lock is released so the
+// warning does not come back here, and then left alone: logging must never
+// stop a render.
+func appendLogFile(event activityEvent) {
+	path := firstNonEmpty(logSettings.file, logConfig.file)
+	if path == "" {
+		return
+	}
+	var line []byte
+	if logJSON() {
+		var err error
+		if line, err = marshalLogEvent(event); err != nil {
+			return
+		}
+	} else {
+		text := event.Time.UTC().Format(time.RFC3339) + " " + strings.ToUpper(event.Level)
+		if event.JobID != "" {
+			text += " " + event.JobID
+		}
+		line = []byte(text + " " + event.Message + "\n")
+	}
+
+	var openErr error
+	logState.mu.Lock()
+	if logState.path != path {
+		if logState.file != nil {
+			logState.file.Close()
+		}
+		logState.path, logState.file, logState.failed = path, nil, false
+		expanded, err := expandPath(path)
+		if err == nil {
+			logState.file, err = os.OpenFile(expanded, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		}
+		if err != nil {
+			logState.failed, openErr = true, err
+		}
+	}
+	if !logState.failed {
+		logState.file.Write(line)
+	}
+	logState.mu.Unlock()
+	if openErr != nil {
+		logWarn("unable to open log file %s: %v", path, openErr)
+	}
+}
+
+// logLineWriter turns each line written to it into a record of level, so the
+// --debug trace can join the log at --log-level debug.
+type logLineWriter struct{ level string }
+
+func (w logLineWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		logMessage(w.level, line)
+	}
+	return len(p), nil
+}
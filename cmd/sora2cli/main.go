@@ -4,36 +4,33 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"mime"
-	"mime/multipart"
 	"net/http"
-	"net/textproto"
-	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 	"unicode/utf8"
 
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
 	"golang.org/x/term"
 )
 
 const (
 	defaultDurationSeconds = 4
-	pollInterval           = 5 * time.Second
-	maxWaitDuration        = 30 * time.Minute
-	videosPath             = "/v1/videos"
 	envFileName            = ".env"
 )
 
 type resolutionOption struct {
-	Label string
-	Value string
+	Label string `json:"label"`
+	Value string `json:"size"`
 }
 
 type modelOption struct {
@@ -63,397 +60,1067 @@ var modelOptions = []modelOption{
 	},
 }
 
-var (
-	supportedReferenceMIMEs = []string{
-		"image/jpeg",
-		"image/png",
-		"image/webp",
-		"video/mp4",
-	}
-	referenceMIMECandidates = map[string]string{
-		"image/jpeg":  "image/jpeg",
-		"image/jpg":   "image/jpeg",
-		"image/pjpeg": "image/jpeg",
-		"image/png":   "image/png",
-		"image/x-png": "image/png",
-		"image/webp":  "image/webp",
-		"video/mp4":   "video/mp4",
-	}
-)
+var allowedDurations = []int{4, 8, 12}
 
-type jobAction int
+func main() {
+	defer handleCrash()
 
-const (
-	jobActionCreate jobAction = iota
-	jobActionRemix
-	jobActionList
-)
+	envPath := resolveEnvPath()
+	if err := loadEnvFile(envPath); err != nil {
+		logWarn("unable to load %s: %v", envPath, err)
+	}
+
+	args, err := splitProfileArg(os.Args[1:])
+	if err != nil {
+		logError("%v", err)
+		os.Exit(2)
+	}
+	args = splitDebugArg(args)
+	answersPath, args, err := splitAnswersArg(args)
+	if err != nil {
+		logError("%v", err)
+		os.Exit(2)
+	}
+	if len(args) > 0 {
+		os.Exit(runSubcommand(args))
+	}
+	headless := !stdinIsTerminal()
+	if headless && answersPath == "" {
+		// The menu needs someone to answer it; without a terminal, as in a
+		// container, there is nobody.
+		fmt.Fprint(os.Stderr, "stdin is not a terminal, so the interactive menu is unavailable; run a command instead.\n\n"+commandUsage())
+		os.Exit(2)
+	}
 
-func main() {
 	fmt.Println("Sora-2 Video Generator")
 	fmt.Println("========================")
 
-	envPath := resolveEnvPath()
-	if err := loadEnvFile(envPath); err != nil {
-		fmt.Printf("WARNING: unable to load %s: %v\n", envPath, err)
+	configPath, err := resolveConfigPath()
+	if err != nil {
+		exitError("unable to locate config file: %v", err)
+	}
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		exitError("%v", err)
+	}
+	if cfg.ProjectPath != "" {
+		logInfo("Using project config %s", cfg.ProjectPath)
+	}
+	if cfg.Profile != "" {
+		logInfo("Using profile %s", cfg.Profile)
+	}
+	exportConfigEnv(cfg)
+
+	var answers *wizardAnswers
+	if answersPath != "" {
+		if answers, err = loadWizardAnswers(answersPath, cfg, "", headless); err != nil {
+			logError("%v", err)
+			os.Exit(2)
+		}
+	}
+	if headless && cfg.APIKey == "" {
+		exitError("OPENAI_API_KEY is not set, and without a terminal it cannot be asked for")
 	}
 
 	reader := bufio.NewReader(os.Stdin)
+	apiKey, reader := obtainAPIKey(reader, cfg, envPath)
 
-	apiKey := strings.TrimSpace(os.Getenv("OPENAI_API_KEY"))
-	if apiKey == "" {
-		fmt.Println("OPENAI_API_KEY not found in environment or .env")
-		for {
-			var err error
-			apiKey, err = promptAPIKey()
-			if err != nil {
-				fmt.Printf("Input error: %v\n", err)
-				continue
-			}
-			apiKey = strings.TrimSpace(apiKey)
-			if apiKey == "" {
-				fmt.Println("API key cannot be empty.")
-				continue
-			}
-			break
+	client := newAPIClient(cfg, apiKey)
+
+	w := newWizard(reader, os.Stdout, client, cfg)
+	w.answers, w.headless = answers, headless
+	if headless {
+		// Nobody can answer a resume question, nor resume later.
+		w.run(wizardMenu)
+		if status := w.exitStatus(); status != 0 {
+			os.Exit(status)
 		}
-		if err := os.Setenv("OPENAI_API_KEY", apiKey); err != nil {
-			fmt.Printf("WARNING: unable to set OPENAI_API_KEY: %v\n", err)
+		return
+	}
+	offerRecovery(reader, client, cfg)
+	w.run(w.resume())
+}
+
+func obtainAPIKey(reader *bufio.Reader, cfg *resolvedConfig, envPath string) (string, *bufio.Reader) {
+	apiKey := cfg.APIKey
+	if apiKey != "" {
+		return apiKey, reader
+	}
+	fmt.Println("OPENAI_API_KEY not found in environment or .env")
+	fmt.Println("(To reuse the key of another tool, run sora2cli config import with its config file.)")
+	for {
+		var err error
+		apiKey, err = promptAPIKey()
+		if err != nil {
+			logError("input error: %v", err)
+			continue
 		}
-		reader = bufio.NewReader(os.Stdin)
-		if promptConfirm(reader, "Save API key to .env for future runs?") {
-			if err := upsertEnvValue(envPath, "OPENAI_API_KEY", apiKey); err != nil {
-				fmt.Printf("WARNING: unable to write %s: %v\n", envPath, err)
-			} else {
-				fmt.Printf("Saved API key to %s\n", envPath)
-			}
+		apiKey = strings.TrimSpace(apiKey)
+		if apiKey == "" {
+			fmt.Println("API key cannot be empty.")
+			continue
+		}
+		break
+	}
+	if err := os.Setenv("OPENAI_API_KEY", apiKey); err != nil {
+		logWarn("unable to set OPENAI_API_KEY: %v", err)
+	}
+	reader = bufio.NewReader(os.Stdin)
+	if promptConfirm(reader, os.Stdout, "Save API key to .env for future runs?") {
+		if err := upsertEnvValue(envPath, "OPENAI_API_KEY", apiKey); err != nil {
+			logWarn("unable to write %s: %v", envPath, err)
+		} else {
+			logInfo("Saved API key to %s", envPath)
 		}
 	}
+	return apiKey, reader
+}
 
-	baseURL := strings.TrimSpace(os.Getenv("OPENAI_BASE_URL"))
-	if baseURL == "" {
-		baseURL = "https://api.openai.com"
+func newAPIClient(cfg *resolvedConfig, apiKey string) *sora.Client {
+	rememberSecret(apiKey)
+	baseURLs := resolveBaseURLs(cfg)
+	client := sora.NewClient(apiKey)
+	client.HTTPClient = &http.Client{}
+	transport, err := apiTransport(cfg.Network)
+	if err != nil {
+		exitError("network: %v", err)
+	}
+	client.HTTPClient.Transport = transport
+	if trace := debugTrace(); trace != nil {
+		// Inside the failover, so each attempt shows the URL it went to.
+		transport = newDebugTransport(transport, trace)
+		client.HTTPClient.Transport = transport
+	}
+	client.BaseURL = baseURLs[0]
+	client.Organization = cfg.OrgID
+	client.Project = cfg.ProjectID
+	client.ProgressScale = sora.ProgressScale(strings.ToLower(cfg.ProgressScale))
+	client.MaxAttempts = cfg.Retry.MaxAttempts
+	if client.MaxAttempts <= 0 {
+		client.MaxAttempts = sora.DefaultMaxAttempts
+	}
+	interval, maxInterval, err := cfg.Poll.intervals()
+	if err != nil {
+		logWarn("poll: %v; using the defaults", err)
+		interval, maxInterval, _ = pollConfig{}.intervals()
 	}
+	client.PollInterval, client.MaxPollInterval = interval, maxInterval
+	client.OnError = recordFailedRequest(client.BaseURL)
+	client.OnRetry = func(err *sora.APIError, attempt int, wait time.Duration) {
+		logWarn("%v; retrying in %s (attempt %d/%d)", err, wait.Round(100*time.Millisecond), attempt+1, client.MaxAttempts)
+	}
+	if len(baseURLs) > 1 {
+		client.HTTPClient.Transport = newFailoverTransport(transport, baseURLs)
+		logInfo("Base URL failover enabled: %s", strings.Join(baseURLs, " -> "))
+	}
+	return client
+}
 
-	httpClient := &http.Client{Timeout: 60 * time.Second}
+type createOptions struct {
+	Model    string
+	Prompt   string
+	Template string
+	Vars     []string
+	Enhance  bool
+	// Enhanced is set once the prompt has been enhanced and reviewed.
+	Enhanced       bool
+	Seconds        int
+	Size           string
+	References     []string
+	Destination    string
+	Ticket         string
+	Tags           []string
+	Extras         extraFlags
+	DryRun         bool
+	AssumeYes      bool
+	NonInteractive bool
+	// Open plays the video in the default player once it is saved.
+	Open bool
+	// Notify shows a desktop notification when the job finishes.
+	Notify bool
+	// WebhookURL receives the finished job, in place of
+	// notifications.job_webhook.url.
+	WebhookURL string
+}
 
-	for {
-		action := promptJobAction(reader)
-		var continueLoop bool
-		switch action {
-		case jobActionCreate:
-			continueLoop = runCreateFlow(reader, httpClient, baseURL, apiKey)
-		case jobActionRemix:
-			continueLoop = runRemixFlow(reader, httpClient, baseURL, apiKey)
-		case jobActionList:
-			continueLoop = runListFlow(reader, httpClient, baseURL, apiKey)
-		default:
-			continue
-		}
-		if !continueLoop {
-			return
+type remixOptions struct {
+	VideoID        string
+	Prompt         string
+	Destination    string
+	Ticket         string
+	Tags           []string
+	Extras         extraFlags
+	DryRun         bool
+	AssumeYes      bool
+	NonInteractive bool
+	// Session offers to remix each result again and records the chain in
+	// a lineage file.
+	Session    bool
+	Open       bool
+	Notify     bool
+	WebhookURL string
+}
+
+type listOptions struct {
+	Limit          int
+	Order          string
+	After          string
+	NonInteractive bool
+	// Review keeps the videos with this review status in local history,
+	// and Tags those carrying every one of these tags there.
+	Review string
+	Tags   []string
+	// The API only pages through videos, so these filters are applied to
+	// each page, and further pages are fetched until Limit videos match.
+	Status   string
+	Model    string
+	Since    time.Time
+	Until    time.Time
+	Contains string
+	// Output is how the videos are shown: table (the default) or wide.
+	// JSON goes through emitJSON; for CSV, csv is where it is written.
+	Output string
+	csv    io.Writer
+	// Watch redraws the table every Interval until interrupted.
+	Watch    bool
+	Interval time.Duration
+}
+
+// listStatuses are the job statuses --status accepts.
+var listStatuses = []string{"queued", "in_progress", "completed", "failed"}
+
+func (o listOptions) filtered() bool {
+	return o.Review != "" || len(o.Tags) > 0 || o.Status != "" || o.Model != "" || !o.Since.IsZero() || !o.Until.IsZero() || o.Contains != ""
+}
+
+// matches reports whether video passes the filters. entry is its history
+// record, or nil; its prompt stands in when the API omits one.
+func (o listOptions) matches(video *sora.Video, entry *historyEntry) bool {
+	created := time.Unix(video.CreatedAt, 0)
+	switch {
+	case o.Status != "" && video.Status != o.Status,
+		o.Model != "" && !strings.EqualFold(video.Model, o.Model),
+		!o.Since.IsZero() && created.Before(o.Since),
+		!o.Until.IsZero() && !created.Before(o.Until),
+		!reviewFilter(o.Review, entry),
+		len(o.Tags) > 0 && (entry == nil || !entry.hasTags(o.Tags)):
+		return false
+	}
+	if o.Contains != "" {
+		prompt := video.Prompt
+		if prompt == "" && entry != nil {
+			prompt = entry.Prompt
 		}
-		fmt.Println()
+		return strings.Contains(strings.ToLower(prompt), strings.ToLower(o.Contains))
 	}
+	return true
 }
 
-func promptJobAction(reader *bufio.Reader) jobAction {
-	for {
-		fmt.Println("Select action:")
-		fmt.Println("  1) Create a new video")
-		fmt.Println("  2) Remix an existing video")
-		fmt.Println("  3) List recent videos")
-		fmt.Print("Enter choice (1-3): ")
-		input, err := reader.ReadString('\n')
+// pastRange reports whether a listing in order has moved beyond the date
+// range, so no later page can match.
+func (o listOptions) pastRange(video *sora.Video, order string) bool {
+	created := time.Unix(video.CreatedAt, 0)
+	if order == "asc" {
+		return !o.Until.IsZero() && !created.Before(o.Until)
+	}
+	return !o.Since.IsZero() && created.Before(o.Since)
+}
+
+func executeCreate(reader *bufio.Reader, out io.Writer, client *sora.Client, cfg *resolvedConfig, opts createOptions) error {
+	defaults := cfg.Defaults
+	var model modelOption
+	switch {
+	case opts.Model != "":
+		var ok bool
+		if model, ok = findModelOption(opts.Model); !ok {
+			return usageErrorf("unknown model %q; supported: %s", opts.Model, strings.Join(modelNames(), ", "))
+		}
+	default:
+		var ok bool
+		if model, ok = findModelOption(defaults.Model); !ok {
+			model = modelOptions[0]
+		}
+	}
+
+	prompt := strings.TrimSpace(opts.Prompt)
+	if opts.Template != "" {
+		vars, err := parseTemplateVars(opts.Vars)
 		if err != nil {
-			fmt.Printf("Input error: %v\n", err)
-			continue
+			return usageErrorf("%v", err)
 		}
-		input = strings.TrimSpace(input)
-		switch strings.ToLower(input) {
-		case "", "1", "create", "new", "c":
-			return jobActionCreate
-		case "2", "remix", "r":
-			return jobActionRemix
-		case "3", "list", "l":
-			return jobActionList
-		default:
-			fmt.Println("Invalid selection, please try again.")
+		if prompt, err = renderTemplate(reader, out, cfg, opts.Template, vars, opts.NonInteractive); err != nil {
+			return usageErrorf("%v", err)
+		}
+		logInfo("Prompt: %s", prompt)
+	}
+	if prompt == "" {
+		return usageErrorf("--prompt or --template is required with --non-interactive")
+	}
+	if (opts.Enhance || cfg.Enhance.Auto) && !opts.Enhanced {
+		if opts.DryRun {
+			logInfo("Dry run; the prompt is not enhanced.")
+		} else {
+			prompt = reviewEnhancedPrompt(reader, out, client, cfg.Enhance, prompt, !opts.NonInteractive && !opts.AssumeYes)
 		}
 	}
-}
 
-func runCreateFlow(reader *bufio.Reader, httpClient *http.Client, baseURL, apiKey string) bool {
-	model := promptModel(reader)
-	prompt := promptRequired(reader, "Prompt")
+	var secondsInt int
+	switch {
+	case opts.Seconds != 0:
+		if !isAllowedDuration(opts.Seconds) {
+			return usageErrorf("unsupported duration %d; supported: %s", opts.Seconds, joinInts(allowedDurations, ", "))
+		}
+		secondsInt = opts.Seconds
+	default:
+		secondsInt = defaults.Seconds
+		if !isAllowedDuration(secondsInt) {
+			secondsInt = defaultDurationSeconds
+		}
+	}
+	seconds := strconv.Itoa(secondsInt)
 
-	seconds, secondsInt := promptDuration(reader, defaultDurationSeconds)
-	selectedResolution := promptResolutionSelection(reader, model.Resolutions)
+	var selectedResolution resolutionOption
+	switch {
+	case opts.Size != "":
+		res, ok := findResolution(model, opts.Size)
+		if !ok {
+			return usageErrorf("size %s is not supported by %s", opts.Size, model.Name)
+		}
+		selectedResolution = res
+	default:
+		selectedResolution = model.Resolutions[0]
+		if res, ok := findResolution(model, defaults.Size); ok {
+			selectedResolution = res
+		}
+	}
 	size := selectedResolution.Value
-	referencePath := promptOptional(reader, "Path to reference image (optional)")
 
-	var expandedReferencePath string
-	if referencePath != "" {
-		var err error
-		expandedReferencePath, err = expandPath(referencePath)
+	var referencePaths []string
+	for _, ref := range opts.References {
+		path, err := resolveReferencePath(ref)
 		if err != nil {
-			fmt.Printf("ERROR: %v\n", err)
-			os.Exit(1)
-		}
-		if _, err = os.Stat(expandedReferencePath); err != nil {
-			fmt.Printf("ERROR: unable to access reference file: %v\n", err)
-			os.Exit(1)
+			logError("%v", err)
+			emitJSONError(err, "")
+			return errReported
 		}
+		referencePaths = append(referencePaths, path)
 	}
 
-	expandedDest := promptDestinationDirectory(reader)
+	expandedDest, ok := resolveDestination(defaults, opts.Destination)
+	if !ok {
+		return errReported
+	}
+	ticket := strings.TrimSpace(opts.Ticket)
 
-	fmt.Println()
-	fmt.Println("Configuration summary:")
-	fmt.Printf("  Action: Create new video\n")
-	fmt.Printf("  Model: %s\n", model.Name)
-	fmt.Printf("  Duration: %d seconds\n", secondsInt)
-	fmt.Printf("  Resolution: %s\n", selectedResolution.Label)
-	if expandedReferencePath != "" {
-		fmt.Printf("  Reference image: %s\n", expandedReferencePath)
+	logBlankLine()
+	logInfo("Configuration summary:")
+	logInfo("  Action: Create new video")
+	logInfo("  Model: %s", model.Name)
+	logInfo("  Duration: %d seconds", secondsInt)
+	logInfo("  Resolution: %s", selectedResolution.Label)
+	for _, path := range referencePaths {
+		logInfo("  Reference image: %s", path)
+	}
+	logInfo("  Destination: %s (filename will match job ID)", expandedDest)
+	if ticket != "" {
+		logInfo("  Ticket: %s", ticket)
+	}
+	if len(opts.Tags) > 0 {
+		logInfo("  Tags: %s", strings.Join(opts.Tags, ", "))
 	}
-	fmt.Printf("  Destination: %s (filename will match job ID)\n", expandedDest)
 	estimatedCost := model.RatePerSecond * float64(secondsInt)
-	fmt.Printf("  Estimated cost: $%.2f (%ds @ $%.2f/s)\n", estimatedCost, secondsInt, model.RatePerSecond)
-	fmt.Println()
+	logInfo("  Estimated cost: $%.2f (%ds @ $%.2f/s)", estimatedCost, secondsInt, model.RatePerSecond)
+	budget := newBudgetGuard(cfg.Budget, 0)
+	if status := budget.status(); status != "" {
+		logInfo("  %s", status)
+	}
+	logBlankLine()
 
-	if !promptConfirm(reader, "Proceed with generation?") {
-		fmt.Println("Aborted by user.")
-		return false
+	params := sora.CreateParams{
+		Prompt:          combinePrompts(prompt),
+		Model:           model.Name,
+		Seconds:         seconds,
+		Size:            size,
+		InputReferences: referencePaths,
+	}
+	// A dry run shows the plan even when the budget would refuse it.
+	if opts.DryRun {
+		plan, err := client.PlanCreate(params)
+		if !reportDryRun(plan, err, estimatedCost) {
+			return errReported
+		}
+		return reserveBudget(budget, estimatedCost)
+	}
+	if err := reserveBudget(budget, estimatedCost); err != nil {
+		return err
+	}
+
+	if !opts.AssumeYes && !opts.NonInteractive && !promptConfirm(reader, out, "Proceed with generation?") {
+		logInfo("Aborted by user.")
+		return errReported
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), maxWaitDuration)
-	fmt.Println()
-	fmt.Println("Submitting generation request...")
+	logBlankLine()
+	logInfo("Submitting generation request...")
+
+	event := ticketEvent{
+		Ticket:        ticket,
+		Prompt:        prompt,
+		Model:         model.Name,
+		Seconds:       secondsInt,
+		EstimatedCost: estimatedCost,
+	}
+	webhook := cfg.Notifications.JobWebhook.withURL(opts.WebhookURL)
+	var job *sora.Video
+	fail := func(err error) error {
+		logError("%v", err)
+		event.Status = "failed"
+		event.Error = err.Error()
+		reportTicketOrWarn(cfg.Tickets, event)
+		notifyJobOrWarn(client, cfg.Notifications, event)
+		notifyDesktopOrWarn(opts.Notify || cfg.Notifications.Desktop, event)
+		postJobWebhookOrWarn(webhook, event, job, nil)
+		if event.JobID != "" {
+			markHistoryFailed(event.JobID, err)
+		}
+		emitJSONError(err, event.JobID)
+		return errReported
+	}
+
+	params.OnUpload = uploadReporter("")
+	job = reuseInFlightDuplicate(ctx, reader, out, client, cfg, params, opts.NonInteractive)
+	if job == nil {
+		var err error
+		job, err = client.Create(ctx, params)
+		if err != nil {
+			cancel()
+			return fail(fmt.Errorf("failed to create video job: %w", err))
+		}
+		logInfo("Job queued with ID: %s", job.ID)
+	}
+	event.JobID = job.ID
+	recordJobHistory(job, "create", func(e *historyEntry) {
+		e.Ticket = ticket
+		e.Tags = opts.Tags
+		e.awaitDownload(expandedDest)
+	})
+	defer watchJob(job.ID).unlock()
 
-	job, err := createVideoJob(ctx, httpClient, baseURL, apiKey, combinePrompts(prompt), model.Name, seconds, size, expandedReferencePath)
+	extras := opts.Extras.outputs(cfg)
+	outputPath, err := extras.outputPath(expandedDest, job, opts.Tags)
 	if err != nil {
 		cancel()
-		fmt.Printf("ERROR: failed to create video job: %v\n", err)
-		os.Exit(1)
+		return fail(err)
 	}
 
-	fmt.Printf("Job queued with ID: %s\n", job.ID)
-	outputPath := filepath.Join(expandedDest, job.ID+".mp4")
-
-	job, err = waitForJobCompletion(ctx, httpClient, baseURL, apiKey, job.ID)
+	job, err = waitForJobCompletion(ctx, client, job.ID)
 	if err != nil {
 		cancel()
-		fmt.Printf("ERROR: generation failed: %v\n", err)
-		os.Exit(1)
+		return fail(fmt.Errorf("generation failed: %w", err))
 	}
 
-	fmt.Println("Job completed. Downloading video...")
+	logInfo("Job completed. Downloading video...")
 
-	if err = downloadVideoContent(ctx, httpClient, baseURL, apiKey, job.ID, outputPath); err != nil {
+	if err = downloadJobFile(ctx, client, job.ID, outputPath); err != nil {
 		cancel()
-		fmt.Printf("ERROR: failed to download video: %v\n", err)
-		os.Exit(1)
+		return fail(fmt.Errorf("failed to download video: %w", err))
 	}
+
+	logInfo("Video saved to %s", outputPath)
+	variants := downloadExtras(ctx, client, job, outputPath, extras)
 	cancel()
+	markHistoryCompleted(job, "create", outputPath)
+	event.Status = "completed"
+	event.OutputPath = outputPath
+	reportTicketOrWarn(cfg.Tickets, event)
+	notifyJobOrWarn(client, cfg.Notifications, event)
+	notifyDesktopOrWarn(opts.Notify || cfg.Notifications.Desktop, event)
+	postJobWebhookOrWarn(webhook, event, job, variants)
+	record := assetRecordFromJob(job, outputPath)
+	record.Prompt = prompt
+	record.Ticket = ticket
+	exportAssetOrWarn(cfg.DAM, cfg.Review, record)
+	emitJSON(videoResult{Video: job, OutputPath: outputPath, Variants: variants})
+	offerToOpen(reader, out, outputPath, opts.Open, !opts.NonInteractive && !opts.AssumeYes)
+	return nil
+}
 
-	fmt.Printf("Video saved to %s\n", outputPath)
+// minReportedUpload is the request size from which upload progress is shown;
+// below it the upload is over before a report would help.
+const minReportedUpload = 8 << 20
 
-	if !promptConfirm(reader, "Generate another video?") {
-		fmt.Println("Done.")
-		return false
+// uploadReporter returns a sora.CreateParams.OnUpload callback that prints
+// the upload's progress in steps of 10%, each line starting with prefix.
+func uploadReporter(prefix string) func(sent, total int64) {
+	lastStep := 0
+	return func(sent, total int64) {
+		if total < minReportedUpload {
+			return
+		}
+		step := int(sent * 10 / total)
+		if step < lastStep {
+			// The request is being retried.
+			logInfo("%sRestarting upload", prefix)
+			lastStep = step
+		}
+		if step == lastStep {
+			return
+		}
+		lastStep = step
+		logInfo("%sUploading: %d%% (%s of %s)", prefix, sent*100/total, formatBytes(sent), formatBytes(total))
 	}
-	return true
 }
 
-func runRemixFlow(reader *bufio.Reader, httpClient *http.Client, baseURL, apiKey string) bool {
-	originalVideoID := promptRequired(reader, "Existing video ID to remix")
-	remixPrompt := promptRequired(reader, "Remix prompt (describe the change)")
-	expandedDest := promptDestinationDirectory(reader)
-
-	fmt.Println()
-	fmt.Println("Configuration summary:")
-	fmt.Printf("  Action: Remix existing video\n")
-	fmt.Printf("  Source video ID: %s\n", originalVideoID)
-	fmt.Printf("  Remix prompt: %s\n", remixPrompt)
-	fmt.Printf("  Destination: %s (filename will match job ID)\n", expandedDest)
-	fmt.Println()
-
-	if !promptConfirm(reader, "Proceed with remix generation?") {
-		fmt.Println("Aborted by user.")
-		return false
+func executeRemix(reader *bufio.Reader, out io.Writer, client *sora.Client, cfg *resolvedConfig, opts remixOptions) error {
+	for {
+		job, outputPath, err := remixOnce(reader, out, client, cfg, &opts)
+		if err != nil || job == nil || !opts.Session {
+			return err
+		}
+		path, chain, err := recordLineage(filepath.Dir(outputPath), job.ID)
+		if err != nil {
+			logWarn("unable to record the lineage: %v", err)
+		}
+		logBlankLine()
+		if !promptConfirm(reader, out, "Remix this result again?") {
+			if err == nil {
+				logInfo("Lineage recorded in %s:", path)
+				printLineage(chain)
+			}
+			return nil
+		}
+		// The next step remixes this result into the same directory under
+		// the same ticket.
+		opts.VideoID = job.ID
+		opts.Prompt = promptText(reader, out, "Remix prompt (describe the change)")
 	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), maxWaitDuration)
-	fmt.Println()
-	fmt.Println("Submitting remix request...")
+// remixOnce submits one remix and downloads it. It returns the finished job
+// and its path, or a nil job after a dry run. The resolved destination is
+// stored in opts so a session can reuse it.
+func remixOnce(reader *bufio.Reader, out io.Writer, client *sora.Client, cfg *resolvedConfig, opts *remixOptions) (*sora.Video, string, error) {
+	originalVideoID := strings.TrimSpace(opts.VideoID)
+	if originalVideoID == "" {
+		return nil, "", usageErrorf("a video ID is required with --non-interactive")
+	}
+	remixPrompt := strings.TrimSpace(opts.Prompt)
+	if remixPrompt == "" {
+		return nil, "", usageErrorf("--prompt is required with --non-interactive")
+	}
+	expandedDest, ok := resolveDestination(cfg.Defaults, opts.Destination)
+	if !ok {
+		return nil, "", errReported
+	}
+	ticket := strings.TrimSpace(opts.Ticket)
+	opts.Destination = expandedDest
 
-	job, err := createRemixJob(ctx, httpClient, baseURL, apiKey, originalVideoID, combinePrompts(remixPrompt))
-	if err != nil {
-		cancel()
-		fmt.Printf("ERROR: failed to create remix job: %v\n", err)
-		os.Exit(1)
+	logBlankLine()
+	logInfo("Configuration summary:")
+	logInfo("  Action: Remix existing video")
+	logInfo("  Source video ID: %s", originalVideoID)
+	logInfo("  Remix prompt: %s", remixPrompt)
+	logInfo("  Destination: %s (filename will match job ID)", expandedDest)
+	if ticket != "" {
+		logInfo("  Ticket: %s", ticket)
+	}
+	if len(opts.Tags) > 0 {
+		logInfo("  Tags: %s", strings.Join(opts.Tags, ", "))
+	}
+	// A remix renders as long as its source. One whose source cannot be
+	// looked up is booked at the dearest render.
+	cost := remixCost(client, originalVideoID)
+	if cost >= 0 {
+		logInfo("  Estimated cost: $%.2f", cost)
+	}
+	budget := newBudgetGuard(cfg.Budget, 0)
+	if status := budget.status(); status != "" {
+		logInfo("  %s", status)
+	}
+	logBlankLine()
+	reserved := cost
+	if reserved < 0 {
+		reserved = maxRenderCost()
+	}
+	if opts.DryRun {
+		plan, err := client.PlanRemix(originalVideoID, combinePrompts(remixPrompt))
+		if !reportDryRun(plan, err, cost) {
+			return nil, "", errReported
+		}
+		return nil, "", reserveBudget(budget, reserved)
+	}
+	if err := reserveBudget(budget, reserved); err != nil {
+		return nil, "", err
 	}
 
-	fmt.Printf("Remix job queued with ID: %s\n", job.ID)
-	outputPath := filepath.Join(expandedDest, job.ID+".mp4")
+	if !opts.AssumeYes && !opts.NonInteractive && !promptConfirm(reader, out, "Proceed with remix generation?") {
+		logInfo("Aborted by user.")
+		return nil, "", errReported
+	}
 
-	job, err = waitForJobCompletion(ctx, httpClient, baseURL, apiKey, job.ID)
+	ctx, cancel := context.WithTimeout(context.Background(), maxWaitDuration)
+	logBlankLine()
+	logInfo("Submitting remix request...")
+
+	event := ticketEvent{Ticket: ticket, Prompt: remixPrompt}
+	webhook := cfg.Notifications.JobWebhook.withURL(opts.WebhookURL)
+	var job *sora.Video
+	fail := func(err error) (*sora.Video, string, error) {
+		logError("%v", err)
+		event.Status = "failed"
+		event.Error = err.Error()
+		reportTicketOrWarn(cfg.Tickets, event)
+		notifyJobOrWarn(client, cfg.Notifications, event)
+		notifyDesktopOrWarn(opts.Notify || cfg.Notifications.Desktop, event)
+		postJobWebhookOrWarn(webhook, event, job, nil)
+		if event.JobID != "" {
+			markHistoryFailed(event.JobID, err)
+		}
+		emitJSONError(err, event.JobID)
+		return nil, "", errReported
+	}
+
+	job, err := client.Remix(ctx, originalVideoID, combinePrompts(remixPrompt))
+	if err != nil {
+		cancel()
+		return fail(fmt.Errorf("failed to create remix job: %w", err))
+	}
+	event.JobID = job.ID
+	recordJobHistory(job, "remix", func(e *historyEntry) {
+		e.Prompt = remixPrompt
+		e.RemixedFrom = originalVideoID
+		e.Ticket = ticket
+		e.Tags = opts.Tags
+		e.awaitDownload(expandedDest)
+	})
+	defer watchJob(job.ID).unlock()
+
+	logInfo("Remix job queued with ID: %s", job.ID)
+	extras := opts.Extras.outputs(cfg)
+	outputPath, err := extras.outputPath(expandedDest, job, opts.Tags)
 	if err != nil {
 		cancel()
-		fmt.Printf("ERROR: remix failed: %v\n", err)
-		os.Exit(1)
+		return fail(err)
 	}
 
-	fmt.Println("Remix completed. Downloading video...")
-
-	if err = downloadVideoContent(ctx, httpClient, baseURL, apiKey, job.ID, outputPath); err != nil {
+	job, err = waitForJobCompletion(ctx, client, job.ID)
+	if err != nil {
 		cancel()
-		fmt.Printf("ERROR: failed to download remix video: %v\n", err)
-		os.Exit(1)
+		return fail(fmt.Errorf("remix failed: %w", err))
 	}
-	cancel()
 
-	fmt.Printf("Remixed video saved to %s\n", outputPath)
+	logInfo("Remix completed. Downloading video...")
 
-	if !promptConfirm(reader, "Perform another action?") {
-		fmt.Println("Done.")
-		return false
+	if err = downloadJobFile(ctx, client, job.ID, outputPath); err != nil {
+		cancel()
+		return fail(fmt.Errorf("failed to download remix video: %w", err))
 	}
-	return true
+
+	logInfo("Remixed video saved to %s", outputPath)
+	variants := downloadExtras(ctx, client, job, outputPath, extras)
+	cancel()
+	markHistoryCompleted(job, "remix", outputPath)
+	event.Status = "completed"
+	event.OutputPath = outputPath
+	event.Model = job.Model
+	if secondsInt, convErr := strconv.Atoi(job.Seconds); convErr == nil {
+		event.Seconds = secondsInt
+		event.EstimatedCost = jobCostEstimate(job.Model, secondsInt)
+	}
+	reportTicketOrWarn(cfg.Tickets, event)
+	notifyJobOrWarn(client, cfg.Notifications, event)
+	notifyDesktopOrWarn(opts.Notify || cfg.Notifications.Desktop, event)
+	postJobWebhookOrWarn(webhook, event, job, variants)
+	record := assetRecordFromJob(job, outputPath)
+	record.Prompt = remixPrompt
+	record.Ticket = ticket
+	exportAssetOrWarn(cfg.DAM, cfg.Review, record)
+	emitJSON(videoResult{Video: job, OutputPath: outputPath, Variants: variants})
+	offerToOpen(reader, out, outputPath, opts.Open, !opts.NonInteractive && !opts.AssumeYes)
+	return job, outputPath, nil
 }
 
-func runListFlow(reader *bufio.Reader, httpClient *http.Client, baseURL, apiKey string) bool {
+func executeList(reader *bufio.Reader, out io.Writer, client *sora.Client, opts listOptions) error {
 	limit := 20
-	for {
-		input := promptOptional(reader, "Number of videos to list (1-100, leave blank for 20)")
-		input = strings.TrimSpace(input)
-		if input == "" {
+	switch {
+	case opts.Limit != 0:
+		if opts.Limit < 0 || opts.Limit > 100 {
+			return usageErrorf("--limit must be between 1 and 100")
+		}
+		limit = opts.Limit
+	case opts.NonInteractive || opts.Watch:
+	default:
+		for {
+			input := promptOptional(reader, out, "Number of videos to list (1-100, leave blank for 20)")
+			input = strings.TrimSpace(input)
+			if input == "" {
+				break
+			}
+			value, err := strconv.Atoi(input)
+			if err != nil || value <= 0 || value > 100 {
+				fmt.Fprintln(out, "Please enter a whole number between 1 and 100, or leave blank for 20.")
+				continue
+			}
+			limit = value
 			break
 		}
-		value, err := strconv.Atoi(input)
-		if err != nil || value <= 0 || value > 100 {
-			fmt.Println("Please enter a whole number between 1 and 100, or leave blank for 20.")
-			continue
-		}
-		limit = value
-		break
 	}
 
 	order := "desc"
-	for {
-		input := promptOptional(reader, "Sort order (asc/desc, leave blank for desc)")
-		input = strings.TrimSpace(strings.ToLower(input))
-		if input == "" {
-			break
-		}
-		if input == "asc" || input == "desc" {
-			order = input
-			break
+	switch {
+	case opts.Order != "":
+		order = strings.ToLower(opts.Order)
+		if order != "asc" && order != "desc" {
+			return usageErrorf("--order must be 'asc' or 'desc'")
+		}
+	case opts.NonInteractive || opts.Watch:
+	default:
+		for {
+			input := promptOptional(reader, out, "Sort order (asc/desc, leave blank for desc)")
+			input = strings.TrimSpace(strings.ToLower(input))
+			if input == "" {
+				break
+			}
+			if input == "asc" || input == "desc" {
+				order = input
+				break
+			}
+			fmt.Fprintln(out, "Please enter 'asc', 'desc', or leave blank.")
 		}
-		fmt.Println("Please enter 'asc', 'desc', or leave blank.")
+	}
+	if !opts.NonInteractive && !opts.Watch && !opts.filtered() {
+		opts.Contains = strings.TrimSpace(promptOptional(reader, out, "Only videos whose prompt contains (leave blank for all)"))
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-	defer cancel()
-
-	fmt.Println()
-	fmt.Println("Fetching videos...")
-	list, err := listVideoJobs(ctx, httpClient, baseURL, apiKey, limit, "", order)
+	state, err := loadHistory()
 	if err != nil {
-		fmt.Printf("ERROR: failed to list videos: %v\n", err)
-		return promptConfirm(reader, "Try another action?")
+		logError("%v", err)
+		emitJSONError(err, "")
+		return errReported
+	}
+	if opts.Watch {
+		return reported(watchList(client, opts, limit, order))
 	}
+	// pages holds the cursor each page shown so far started after, so the
+	// interactive browser can step back.
+	pages := []string{opts.After}
+	for {
+		opts.After = pages[len(pages)-1]
+		logBlankLine()
+		logInfo("Fetching videos...")
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		list, err := listVideos(ctx, client, state, opts, limit, order)
+		cancel()
+		if err != nil {
+			logError("failed to list videos: %v", err)
+			emitJSONError(fmt.Errorf("failed to list videos: %w", err), "")
+			return errReported
+		}
+		emitJSON(list)
 
-	if len(list.Data) == 0 {
-		fmt.Println("No videos found.")
-	} else {
-		fmt.Println()
-		fmt.Printf("Showing %d video(s):\n", len(list.Data))
-		fmt.Println("----------------------------------------")
-		for _, job := range list.Data {
-			created := "(unknown)"
-			if job.CreatedAt > 0 {
-				created = time.Unix(job.CreatedAt, 0).Format(time.RFC3339)
-			}
-			fmt.Printf("ID: %s\n", job.ID)
-			fmt.Printf("  Status: %s\n", job.Status)
-			if job.Model != "" {
-				fmt.Printf("  Model: %s\n", job.Model)
+		switch {
+		case opts.csv != nil:
+			if err := writeVideoCSV(opts.csv, list.Data, state); err != nil {
+				logError("%v", err)
+				return errReported
 			}
-			if job.Seconds != "" {
-				fmt.Printf("  Duration: %s seconds\n", job.Seconds)
+		case len(list.Data) == 0:
+			logInfo("No videos found.")
+		default:
+			logBlankLine()
+			if len(pages) > 1 {
+				logInfo("Page %d, showing %d video(s):", len(pages), len(list.Data))
+			} else {
+				logInfo("Showing %d video(s):", len(list.Data))
 			}
-			if job.Size != "" {
-				fmt.Printf("  Size: %s\n", job.Size)
+			printVideoTable(out, list.Data, state, opts.Output == listOutputWide)
+		}
+		nextCursor := list.Cursor()
+		if opts.NonInteractive || jsonStdout != nil || opts.csv != nil {
+			if nextCursor != "" {
+				logInfo("More videos available. Use the 'after' cursor to continue pagination.")
+				logInfo("Next cursor: %s", nextCursor)
 			}
-			fmt.Printf("  Created: %s\n", created)
-			progress := normalizeProgress(job.Progress)
-			if progress > 0 && progress <= 100 {
-				fmt.Printf("  Progress: %.0f%%\n", progress)
+			return nil
+		}
+		if nextCursor == "" && len(pages) == 1 {
+			return nil
+		}
+
+		var choices []string
+		if nextCursor != "" {
+			choices = append(choices, "[n]ext page")
+		}
+		if len(pages) > 1 {
+			choices = append(choices, "[p]rev")
+		}
+		choices = append(choices, "[q]uit")
+		for {
+			input := strings.ToLower(strings.TrimSpace(promptOptional(reader, out, strings.Join(choices, " / "))))
+			switch {
+			case (input == "n" || input == "next") && nextCursor != "":
+				pages = append(pages, nextCursor)
+			case (input == "p" || input == "prev") && len(pages) > 1:
+				pages = pages[:len(pages)-1]
+			case input == "" || input == "q" || input == "quit":
+				return nil
+			default:
+				fmt.Fprintln(out, "Please choose one of "+strings.Join(choices, ", ")+".")
+				continue
 			}
-			fmt.Println("----------------------------------------")
+			break
 		}
-		nextCursor := list.Next
-		if nextCursor == "" {
-			nextCursor = list.NextCursor
+	}
+}
+
+// listVideos returns up to limit videos starting after opts.After. With
+// filters it keeps fetching pages until limit videos match, the date range is
+// passed or the account has no more; the cursor then points after the last
+// video examined.
+func listVideos(ctx context.Context, client *sora.Client, state historyState, opts listOptions, limit int, order string) (*sora.VideoList, error) {
+	if !opts.filtered() {
+		return client.List(ctx, sora.ListParams{Limit: limit, After: opts.After, Order: order})
+	}
+	result := &sora.VideoList{Object: "list", Data: []sora.Video{}}
+	params := sora.ListParams{Limit: 100, After: opts.After, Order: order}
+	for {
+		page, err := client.List(ctx, params)
+		if err != nil {
+			return nil, err
 		}
-		if list.HasMore || nextCursor != "" {
-			fmt.Println("More videos available. Use the 'after' cursor to continue pagination.")
-			if nextCursor != "" {
-				fmt.Printf("Next cursor: %s\n", nextCursor)
+		for i := range page.Data {
+			video := &page.Data[i]
+			if opts.pastRange(video, order) {
+				return result, nil
+			}
+			if !opts.matches(video, state.find(video.ID)) {
+				continue
+			}
+			result.Data = append(result.Data, *video)
+			if len(result.Data) == limit {
+				if i < len(page.Data)-1 || page.HasMore {
+					result.HasMore = true
+					result.NextCursor = video.ID
+				}
+				return result, nil
 			}
 		}
+		params.After = page.Cursor()
+		if !page.HasMore || params.After == "" {
+			return result, nil
+		}
 	}
+}
 
-	if !promptConfirm(reader, "Perform another action?") {
-		fmt.Println("Done.")
-		return false
+func printVideoJob(job *sora.Video) {
+	fmt.Printf("ID: %s\n", job.ID)
+	fmt.Printf("  Status: %s\n", job.Status)
+	if job.Model != "" {
+		fmt.Printf("  Model: %s\n", job.Model)
+	}
+	if job.Seconds != "" {
+		fmt.Printf("  Duration: %s seconds\n", job.Seconds)
+	}
+	if job.Size != "" {
+		fmt.Printf("  Size: %s\n", job.Size)
+	}
+	if job.RemixedFromVideoID != "" {
+		fmt.Printf("  Remixed from: %s\n", job.RemixedFromVideoID)
+	}
+	fmt.Printf("  Created: %s\n", formatUnixTimestamp(job.CreatedAt))
+	if job.CompletedAt > 0 {
+		fmt.Printf("  Completed: %s\n", formatUnixTimestamp(job.CompletedAt))
+	}
+	if job.ExpiresAt > 0 {
+		fmt.Printf("  Expires: %s\n", formatUnixTimestamp(job.ExpiresAt))
+	}
+	if job.Progress > 0 {
+		fmt.Printf("  Progress: %.0f%% (raw %g)\n", job.ProgressPercent(), job.Progress)
+	}
+	if job.Error != nil && job.Error.Message != "" {
+		fmt.Printf("  Error: %s\n", job.Error.Message)
 	}
-	return true
 }
 
-func promptDestinationDirectory(reader *bufio.Reader) string {
-	destinationDir := promptOptional(reader, "Destination directory for the video (leave blank to use current directory)")
-	destinationDir = strings.TrimSpace(destinationDir)
+func findResolution(model modelOption, value string) (resolutionOption, bool) {
+	for _, opt := range model.Resolutions {
+		if strings.EqualFold(opt.Value, value) || strings.EqualFold(opt.Label, value) {
+			return opt, true
+		}
+	}
+	return resolutionOption{}, false
+}
+
+// usageError is a problem with the options a create, remix or list flow was
+// given. The flow leaves it to the caller to report: a command exits with
+// status 2, the menu offers another try.
+type usageError struct{ message string }
+
+func (e *usageError) Error() string { return e.message }
+
+func usageErrorf(format string, args ...any) error {
+	return &usageError{message: fmt.Sprintf(format, args...)}
+}
+
+// errReported is returned by a flow for a failure it has already reported.
+var errReported = errors.New("reported")
+
+// reported turns the result of a step that reports its own failure into a
+// flow's error.
+func reported(ok bool) error {
+	if !ok {
+		return errReported
+	}
+	return nil
+}
+
+// reportFlowError reports a usage error returned by a flow and returns the
+// command's exit status for err.
+func reportFlowError(err error) int {
+	var usage *usageError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &usage):
+		logError("%v", err)
+		emitJSONError(err, "")
+		return 2
+	default:
+		return 1
+	}
+}
+
+func exitError(format string, args ...any) {
+	logError(format, args...)
+	emitJSONError(fmt.Errorf(format, args...), "")
+	os.Exit(1)
+}
 
-	var expandedDest string
-	var err error
+// resolveDestination returns the directory a render is saved to, the one
+// given or else the default. One that cannot be used fails the command; the
+// wizard asks again instead (see promptDestinationDirectory).
+func resolveDestination(defaults defaultsConfig, flagValue string) (string, bool) {
+	dest := flagValue
+	if dest == "" {
+		dest = defaults.Destination
+	}
+	expandedDest, err := prepareDestinationDirectory(dest)
+	if err != nil {
+		logError("%v", err)
+		emitJSONError(err, "")
+		return "", false
+	}
+	return expandedDest, true
+}
+
+func promptDestinationDirectory(reader *bufio.Reader, out io.Writer, defaultDir string) string {
+	label := "Destination directory for the video (leave blank to use current directory)"
+	if defaultDir != "" {
+		label = fmt.Sprintf("Destination directory for the video (leave blank for %s)", defaultDir)
+	}
+	for {
+		destinationDir := promptOptional(reader, out, label)
+		destinationDir = strings.TrimSpace(destinationDir)
+		if destinationDir == "" {
+			destinationDir = defaultDir
+		}
+		expandedDest, err := prepareDestinationDirectory(destinationDir)
+		if err == nil {
+			return expandedDest
+		}
+		logError("%v", err)
+	}
+}
+
+func prepareDestinationDirectory(destinationDir string) (string, error) {
 	if destinationDir == "" {
-		expandedDest, err = os.Getwd()
+		expandedDest, err := os.Getwd()
 		if err != nil {
-			fmt.Printf("ERROR: unable to determine current directory: %v\n", err)
-			os.Exit(1)
+			return "", fmt.Errorf("unable to determine current directory: %w", err)
 		}
-		return expandedDest
+		return expandedDest, nil
 	}
-	expandedDest, err = expandPath(destinationDir)
+	expandedDest, err := expandPath(destinationDir)
 	if err != nil {
-		fmt.Printf("ERROR: %v\n", err)
-		os.Exit(1)
+		return "", err
 	}
 	if err = os.MkdirAll(expandedDest, 0o755); err != nil {
-		fmt.Printf("ERROR: unable to create destination directory: %v\n", err)
-		os.Exit(1)
+		return "", fmt.Errorf("unable to create destination directory: %w", err)
 	}
-	return expandedDest
+	return expandedDest, nil
 }
 
-func promptModel(reader *bufio.Reader) modelOption {
+// promptReferencePaths asks for optional reference images, one at a time,
+// until the answer is blank. Paths that cannot be read are asked for again.
+func promptReferencePaths(reader *bufio.Reader, out io.Writer) []string {
+	var paths []string
+	label := "Path to reference image (optional)"
 	for {
-		fmt.Println("Select model:")
+		input := promptOptional(reader, out, label)
+		if input == "" {
+			return paths
+		}
+		path, err := resolveReferencePath(input)
+		if err != nil {
+			logError("%v", err)
+			continue
+		}
+		paths = append(paths, path)
+		label = "Path to another reference image (leave blank when done)"
+	}
+}
+
+func resolveReferencePath(referencePath string) (string, error) {
+	expanded, err := expandPath(referencePath)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(expanded)
+	if err != nil {
+		return "", fmt.Errorf("unable to access reference file: %w", err)
+	}
+	defer file.Close()
+	// Catch an unsupported type now rather than when the job is submitted.
+	if _, err = sora.DetectReferenceMIME(file); err != nil {
+		return "", fmt.Errorf("%s: %w", referencePath, err)
+	}
+	return expanded, nil
+}
+
+func promptModel(reader *bufio.Reader, out io.Writer, defaultName string) modelOption {
+	defaultIdx := 0
+	for i, opt := range modelOptions {
+		if strings.EqualFold(opt.Name, defaultName) {
+			defaultIdx = i
+			break
+		}
+	}
+	for {
+		fmt.Fprintln(out, "Select model:")
 		for i, opt := range modelOptions {
-			fmt.Printf("  %d) %s ($%.2f per second)\n", i+1, opt.Name, opt.RatePerSecond)
+			marker := ""
+			if i == defaultIdx {
+				marker = " (default)"
+			}
+			fmt.Fprintf(out, "  %d) %s ($%.2f per second)%s\n", i+1, opt.Name, opt.RatePerSecond, marker)
 		}
-		fmt.Printf("Enter choice (1-%d): ", len(modelOptions))
+		fmt.Fprintf(out, "Enter choice (1-%d, ? to compare them): ", len(modelOptions))
 		input, err := reader.ReadString('\n')
 		if err != nil {
-			fmt.Printf("Input error: %v\n", err)
+			logError("input error: %v", err)
 			continue
 		}
 		input = strings.TrimSpace(input)
 		if input == "" {
-			return modelOptions[0]
+			return modelOptions[defaultIdx]
+		}
+		if input == "?" {
+			state, _ := loadHistory()
+			fmt.Fprintln(out)
+			explainModels(out, modelReports(state, defaultName))
+			fmt.Fprintln(out)
+			continue
 		}
 		if idx, convErr := strconv.Atoi(input); convErr == nil {
 			if idx >= 1 && idx <= len(modelOptions) {
@@ -465,111 +1132,7 @@ func promptModel(reader *bufio.Reader) modelOption {
 				return opt
 			}
 		}
-		fmt.Println("Invalid selection, please try again.")
-	}
-}
-
-func readLongLine(reader *bufio.Reader) (string, error) {
-	// Check if stdin is a terminal
-	if !term.IsTerminal(int(os.Stdin.Fd())) {
-		// Not a terminal, use normal read
-		line, err := reader.ReadBytes('\n')
-		if err != nil {
-			return "", err
-		}
-		if len(line) > 0 && line[len(line)-1] == '\n' {
-			line = line[:len(line)-1]
-		}
-		if len(line) > 0 && line[len(line)-1] == '\r' {
-			line = line[:len(line)-1]
-		}
-		return string(line), nil
-	}
-
-	// For terminal, temporarily disable canonical mode to allow long input
-	// Save current terminal state
-	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
-	if err != nil {
-		// If raw mode fails, fall back to normal read
-		line, err := reader.ReadBytes('\n')
-		if err != nil {
-			return "", err
-		}
-		if len(line) > 0 && line[len(line)-1] == '\n' {
-			line = line[:len(line)-1]
-		}
-		if len(line) > 0 && line[len(line)-1] == '\r' {
-			line = line[:len(line)-1]
-		}
-		return string(line), nil
-	}
-	defer term.Restore(int(os.Stdin.Fd()), oldState) // Restore terminal state
-
-	// Read in raw mode - this bypasses terminal line buffer limits
-	var result []byte
-	var pending []byte
-	buf := make([]byte, 8192) // Read in 8KB chunks
-	for {
-		n, readErr := os.Stdin.Read(buf)
-		if n > 0 {
-			pending = append(pending, buf[:n]...)
-			for len(pending) > 0 {
-				b := pending[0]
-				// Handle Enter/Return (both \n and \r)
-				if b == '\n' {
-					fmt.Print("\r\n")
-					return string(result), nil
-				}
-				if b == '\r' {
-					fmt.Print("\r\n")
-					return string(result), nil
-				}
-				// Handle Ctrl+C
-				if b == 3 { // ETX
-					fmt.Print("\n")
-					return "", errors.New("interrupted")
-				}
-				// Handle backspace/delete
-				if b == 127 || b == 8 { // DEL or BS
-					pending = pending[1:]
-					if len(result) > 0 {
-						result = truncateLastRune(result)
-						fmt.Print("\b \b")
-					}
-					continue
-				}
-				// Ignore other control characters except tab
-				if b < 32 && b != '\t' {
-					pending = pending[1:]
-					continue
-				}
-				if !utf8.FullRune(pending) {
-					break
-				}
-				r, size := utf8.DecodeRune(pending)
-				chunk := pending[:size]
-				pending = pending[size:]
-				if r == utf8.RuneError && size == 1 {
-					continue
-				}
-				fmt.Print(string(chunk))
-				result = append(result, chunk...)
-			}
-		}
-		if readErr == io.EOF {
-			if len(result) > 0 {
-				fmt.Print("\n")
-				return string(result), nil
-			}
-			return "", readErr
-		}
-		if readErr != nil {
-			if len(result) > 0 {
-				fmt.Print("\n")
-				return string(result), nil
-			}
-			return "", readErr
-		}
+		fmt.Fprintln(out, "Invalid selection, please try again.")
 	}
 }
 
@@ -587,35 +1150,70 @@ func truncateLastRune(b []byte) []byte {
 	return b[:i]
 }
 
-func promptRequired(reader *bufio.Reader, label string) string {
+func promptRequired(reader *bufio.Reader, out io.Writer, label string) string {
+	return promptRequiredWith(reader, out, label, fieldHistory)
+}
+
+// promptText asks for a video prompt. Up and Down recall the prompts of
+// this and earlier sessions.
+func promptText(reader *bufio.Reader, out io.Writer, label string) string {
+	return promptRequiredWith(reader, out, label, promptHistory)
+}
+
+func promptRequiredWith(reader *bufio.Reader, out io.Writer, label string, history *lineHistory) string {
 	for {
-		fmt.Printf("%s: ", label)
-		input, err := readLongLine(reader)
+		input, err := readLine(reader, out, label+": ", history)
 		if err != nil {
-			fmt.Printf("Input error: %v\n", err)
+			logError("input error: %v", err)
 			continue
 		}
 		value := strings.TrimSpace(input)
 		if value == "" {
-			fmt.Println("Value required.")
+			fmt.Fprintln(out, "Value required.")
 			continue
 		}
 		return value
 	}
 }
 
-func promptOptional(reader *bufio.Reader, label string) string {
-	fmt.Printf("%s: ", label)
+// promptTemplatedPrompt asks for the prompt, offering the saved prompts and
+// then the prompt templates first when there are any.
+func promptTemplatedPrompt(reader *bufio.Reader, out io.Writer, cfg *resolvedConfig) string {
+	if prompt := promptLibraryChoice(reader, out); prompt != "" {
+		fmt.Fprintf(out, "Prompt: %s\n", prompt)
+		return prompt
+	}
+	if dir, err := cfg.templatesDir(); err == nil {
+		templates, err := listTemplates(dir)
+		if err != nil {
+			logWarn("unable to read prompt templates: %v", err)
+		}
+		if len(templates) > 0 {
+			if t := promptTemplateChoice(reader, out, templates); t != nil {
+				prompt, err := fillTemplate(reader, out, *t, map[string]string{}, false)
+				if err == nil {
+					fmt.Fprintf(out, "Prompt: %s\n", prompt)
+					return prompt
+				}
+				logError("%v", err)
+			}
+		}
+	}
+	return promptText(reader, out, "Prompt")
+}
+
+func promptOptional(reader *bufio.Reader, out io.Writer, label string) string {
+	fmt.Fprintf(out, "%s: ", label)
 	input, err := reader.ReadString('\n')
 	if err != nil {
-		fmt.Printf("Input error: %v\n", err)
+		logError("input error: %v", err)
 		return ""
 	}
 	return strings.TrimSpace(input)
 }
 
-func promptDuration(reader *bufio.Reader, defaultSeconds int) (string, int) {
-	allowedSeconds := []int{4, 8, 12}
+func promptDuration(reader *bufio.Reader, out io.Writer, defaultSeconds int) (string, int) {
+	allowedSeconds := allowedDurations
 	defaultIdx := 0
 	for i, sec := range allowedSeconds {
 		if sec == defaultSeconds {
@@ -624,18 +1222,18 @@ func promptDuration(reader *bufio.Reader, defaultSeconds int) (string, int) {
 		}
 	}
 	for {
-		fmt.Println("Select clip duration:")
+		fmt.Fprintln(out, "Select clip duration:")
 		for i, sec := range allowedSeconds {
 			marker := ""
 			if i == defaultIdx {
 				marker = " (default)"
 			}
-			fmt.Printf("  %d) %d seconds%s\n", i+1, sec, marker)
+			fmt.Fprintf(out, "  %d) %d seconds%s\n", i+1, sec, marker)
 		}
-		fmt.Printf("Enter choice (1-%d): ", len(allowedSeconds))
+		fmt.Fprintf(out, "Enter choice (1-%d): ", len(allowedSeconds))
 		input, err := reader.ReadString('\n')
 		if err != nil {
-			fmt.Printf("Input error: %v\n", err)
+			logError("input error: %v", err)
 			continue
 		}
 		input = strings.TrimSpace(input)
@@ -654,25 +1252,36 @@ func promptDuration(reader *bufio.Reader, defaultSeconds int) (string, int) {
 				return strconv.Itoa(sec), sec
 			}
 		}
-		fmt.Println("Invalid selection, please try again.")
+		fmt.Fprintln(out, "Invalid selection, please try again.")
 	}
 }
 
-func promptResolutionSelection(reader *bufio.Reader, options []resolutionOption) resolutionOption {
+func promptResolutionSelection(reader *bufio.Reader, out io.Writer, options []resolutionOption, defaultValue string) resolutionOption {
+	defaultIdx := 0
+	for i, opt := range options {
+		if strings.EqualFold(opt.Value, defaultValue) {
+			defaultIdx = i
+			break
+		}
+	}
 	for {
-		fmt.Println("Select output resolution:")
+		fmt.Fprintln(out, "Select output resolution:")
 		for i, opt := range options {
-			fmt.Printf("  %d) %s\n", i+1, opt.Label)
+			marker := ""
+			if i == defaultIdx {
+				marker = " (default)"
+			}
+			fmt.Fprintf(out, "  %d) %s%s\n", i+1, opt.Label, marker)
 		}
-		fmt.Printf("Enter choice (1-%d): ", len(options))
+		fmt.Fprintf(out, "Enter choice (1-%d): ", len(options))
 		input, err := reader.ReadString('\n')
 		if err != nil {
-			fmt.Printf("Input error: %v\n", err)
+			logError("input error: %v", err)
 			continue
 		}
 		input = strings.TrimSpace(input)
 		if input == "" {
-			return options[0]
+			return options[defaultIdx]
 		}
 		if idx, convErr := strconv.Atoi(input); convErr == nil {
 			if idx >= 1 && idx <= len(options) {
@@ -684,16 +1293,16 @@ func promptResolutionSelection(reader *bufio.Reader, options []resolutionOption)
 				return opt
 			}
 		}
-		fmt.Println("Invalid selection, please try again.")
+		fmt.Fprintln(out, "Invalid selection, please try again.")
 	}
 }
 
-func promptConfirm(reader *bufio.Reader, label string) bool {
+func promptConfirm(reader *bufio.Reader, out io.Writer, label string) bool {
 	for {
-		fmt.Printf("%s [y/N]: ", label)
+		fmt.Fprintf(out, "%s [y/N]: ", label)
 		input, err := reader.ReadString('\n')
 		if err != nil {
-			fmt.Printf("Input error: %v\n", err)
+			logError("input error: %v", err)
 			continue
 		}
 		value := strings.ToLower(strings.TrimSpace(input))
@@ -703,7 +1312,7 @@ func promptConfirm(reader *bufio.Reader, label string) bool {
 		case "n", "no", "":
 			return false
 		default:
-			fmt.Println("Please respond with 'y' or 'n'.")
+			fmt.Fprintln(out, "Please respond with 'y' or 'n'.")
 		}
 	}
 }
@@ -741,21 +1350,31 @@ func promptAPIKey() (string, error) {
 	return strings.TrimSpace(input), nil
 }
 
+// resolveEnvPath finds the .env file: next to the binary, in the current
+// directory, then in the config directory. When there is none, the config
+// directory is where a new one is saved.
 func resolveEnvPath() string {
-	// First, try to find .env next to the binary
+	var candidates []string
 	if execPath, err := os.Executable(); err == nil {
-		execDir := filepath.Dir(execPath)
-		envPath := filepath.Join(execDir, envFileName)
-		if _, err := os.Stat(envPath); err == nil {
-			return envPath
+		candidates = append(candidates, filepath.Join(filepath.Dir(execPath), envFileName))
+	}
+	if cwd, err := os.Getwd(); err == nil {
+		candidates = append(candidates, filepath.Join(cwd, envFileName))
+	}
+	configEnv := ""
+	if dir, err := resolveConfigDir(); err == nil {
+		configEnv = filepath.Join(dir, envFileName)
+		candidates = append(candidates, configEnv)
+	}
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			return path
 		}
 	}
-	// Fallback to current working directory
-	cwd, err := os.Getwd()
-	if err != nil {
-		return envFileName
+	if configEnv != "" {
+		return configEnv
 	}
-	return filepath.Join(cwd, envFileName)
+	return envFileName
 }
 
 func loadEnvFile(path string) error {
@@ -842,6 +1461,9 @@ func upsertEnvValue(path, key, value string) error {
 	if !strings.HasSuffix(content, "\n") {
 		content += "\n"
 	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
 	return os.WriteFile(path, []byte(content), 0o600)
 }
 
@@ -849,389 +1471,344 @@ func combinePrompts(prompt string) string {
 	return strings.TrimSpace(prompt)
 }
 
-func createVideoJob(ctx context.Context, client *http.Client, baseURL, apiKey, prompt, model, seconds, size, referencePath string) (*videoJob, error) {
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-
-	if err := writer.WriteField("prompt", prompt); err != nil {
-		return nil, err
-	}
-	if model != "" {
-		if err := writer.WriteField("model", model); err != nil {
-			return nil, err
-		}
-	}
-	if seconds != "" {
-		if err := writer.WriteField("seconds", seconds); err != nil {
-			return nil, err
-		}
-	}
-	if size != "" {
-		if err := writer.WriteField("size", size); err != nil {
-			return nil, err
-		}
-	}
+// waitForJobCompletion polls a job and prints its progress. Pressing Ctrl+C
+// asks whether to cancel the job on the server, so an unwanted render stops
+// costing money; answering no resumes polling, and a second Ctrl+C leaves the
+// job running and exits, as does SIGTERM.
+func waitForJobCompletion(ctx context.Context, client *sora.Client, jobID string) (*sora.Video, error) {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupts)
+
+	type waitResult struct {
+		job *sora.Video
+		err error
+	}
+	var tracker queueTracker
+	notices := time.NewTicker(queueNoticeInterval)
+	defer notices.Stop()
+	terminated := false
+	for {
+		waitCtx, stop := context.WithCancel(ctx)
+		done := make(chan waitResult, 1)
+		go func() {
+			defer handleCrash()
+			job, err := client.Wait(waitCtx, jobID, func(job *sora.Video) {
+				logInfo("Status: %s", formatProgress(job))
+				if tracker.observe(job) {
+					logInfo("%s", tracker.describe())
+				}
+			})
+			done <- waitResult{job, err}
+		}()
 
-	if referencePath != "" {
-		file, err := os.Open(referencePath)
-		if err != nil {
-			return nil, fmt.Errorf("open reference: %w", err)
+	waiting:
+		for {
+			select {
+			case result := <-done:
+				stop()
+				return result.job, result.err
+			case <-notices.C:
+				if notice := tracker.describe(); notice != "" {
+					logInfo("%s", notice)
+				}
+			case sig := <-interrupts:
+				stop()
+				<-done
+				terminated = sig == syscall.SIGTERM
+				break waiting
+			}
 		}
-		defer file.Close()
 
-		mimeType, err := detectReferenceMIME(file)
-		if err != nil {
-			return nil, fmt.Errorf("reference file: %w", err)
-		}
-		if _, err := file.Seek(0, io.SeekStart); err != nil {
-			return nil, fmt.Errorf("rewind reference: %w", err)
+		fmt.Println()
+		if terminated || !term.IsTerminal(int(os.Stdin.Fd())) {
+			exitDetached(jobID)
+		}
+		answered := make(chan struct{})
+		go func() {
+			select {
+			case <-interrupts:
+				fmt.Println()
+				exitDetached(jobID)
+			case <-answered:
+			}
+		}()
+		confirmed := promptConfirm(bufio.NewReader(os.Stdin), os.Stdout, fmt.Sprintf("Cancel job %s? (Ctrl+C again to stop waiting and leave it running)", jobID))
+		close(answered)
+		if !confirmed {
+			logInfo("Still waiting...")
+			continue
 		}
 
-		header := make(textproto.MIMEHeader)
-		header.Set("Content-Disposition", fmt.Sprintf("form-data; name=%q; filename=%q", "input_reference", filepath.Base(referencePath)))
-		header.Set("Content-Type", mimeType)
-		part, err := writer.CreatePart(header)
+		cancelCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := client.Delete(cancelCtx, jobID)
+		cancel()
 		if err != nil {
-			return nil, err
-		}
-		if _, err = io.Copy(part, file); err != nil {
-			return nil, fmt.Errorf("copy reference: %w", err)
+			return nil, fmt.Errorf("cancel job %s: %w", jobID, err)
 		}
+		logInfo("Cancelled job %s", jobID)
+		return nil, fmt.Errorf("job %s cancelled", jobID)
 	}
-
-	if err := writer.Close(); err != nil {
-		return nil, err
-	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+videosPath, body)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("Accept", "application/json")
-
-	if org := strings.TrimSpace(os.Getenv("OPENAI_ORG_ID")); org != "" {
-		req.Header.Set("OpenAI-Organization", org)
-	}
-	if project := strings.TrimSpace(os.Getenv("OPENAI_PROJECT_ID")); project != "" {
-		req.Header.Set("OpenAI-Project", project)
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 300 {
-		apiErr := readAPIError(resp.Body)
-		return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, apiErr)
-	}
-
-	var job videoJob
-	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
-		return nil, err
-	}
-	if job.ID == "" {
-		return nil, errors.New("response missing job ID")
-	}
-	return &job, nil
 }
 
-func detectReferenceMIME(file *os.File) (string, error) {
-	buf := make([]byte, 512)
-	n, err := file.Read(buf)
-	if err != nil && err != io.EOF {
-		return "", fmt.Errorf("read reference header: %w", err)
-	}
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return "", fmt.Errorf("rewind reference header: %w", err)
-	}
-
-	if n > 0 {
-		if mimeType, ok := canonicalizeReferenceMIME(http.DetectContentType(buf[:n])); ok {
-			return mimeType, nil
-		}
-	}
+// extraFlags are the --with-thumbnail and --with-spritesheet flags. Either
+// one can also be switched on for every download in defaults.
+type extraFlags struct {
+	Thumbnail   bool
+	Spritesheet bool
+	Sidecar     bool
+	Grade       bool
+	Interpolate bool
+	Encode      []string
+	Container   string
+	Trim        string
+}
 
-	ext := strings.ToLower(filepath.Ext(file.Name()))
-	if ext != "" {
-		if mimeType := mime.TypeByExtension(ext); mimeType != "" {
-			if canonical, ok := canonicalizeReferenceMIME(mimeType); ok {
-				return canonical, nil
+func (f *extraFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&f.Thumbnail, "with-thumbnail", false, "also save the thumbnail image next to the MP4")
+	fs.BoolVar(&f.Spritesheet, "with-spritesheet", false, "also save the spritesheet image next to the MP4")
+	fs.BoolVar(&f.Sidecar, "sidecar", false, "also write <id>.json with the job's metadata next to the MP4")
+	fs.BoolVar(&f.Grade, "grade", false, "apply the configured colour grade to the MP4 (on for every download with grade.auto)")
+	fs.BoolVar(&f.Interpolate, "interpolate", false, "also save a frame-interpolated copy of the MP4 (on for every download with interpolate.auto)")
+	fs.Func("encode-profile", "also save a copy encoded with this profile, such as tiktok or youtube; repeat or separate with commas for several (added to encode.auto)", func(value string) error {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				f.Encode = append(f.Encode, name)
 			}
 		}
-	}
-
-	return "", fmt.Errorf("unsupported reference file type; supported types: %s", strings.Join(supportedReferenceMIMEs, ", "))
-}
-
-func canonicalizeReferenceMIME(mimeType string) (string, bool) {
-	mimeType = strings.TrimSpace(strings.ToLower(mimeType))
-	if mimeType == "" {
-		return "", false
-	}
-	if idx := strings.Index(mimeType, ";"); idx != -1 {
-		mimeType = mimeType[:idx]
-	}
-	canonical, ok := referenceMIMECandidates[mimeType]
-	return canonical, ok
+		return nil
+	})
+	fs.StringVar(&f.Container, "container", "", "also save the MP4 as mov, mkv or webm (default: defaults.container)")
+	fs.StringVar(&f.Trim, "trim", "", "cut the MP4 to in:out seconds, e.g. 0.5:3.8 (default: trim.range)")
 }
 
-func createRemixJob(ctx context.Context, client *http.Client, baseURL, apiKey, videoID, prompt string) (*videoJob, error) {
-	payload := map[string]string{"prompt": prompt}
-	body := &bytes.Buffer{}
-	if err := json.NewEncoder(body).Encode(payload); err != nil {
-		return nil, err
-	}
-
-	url := fmt.Sprintf("%s%s/%s/remix", baseURL, videosPath, videoID)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-
-	if org := strings.TrimSpace(os.Getenv("OPENAI_ORG_ID")); org != "" {
-		req.Header.Set("OpenAI-Organization", org)
+// validate reports an unknown --encode-profile or --container, or a bad
+// defaults.layout, before anything is rendered.
+func (f extraFlags) validate(cfg *resolvedConfig) error {
+	if err := validateLayout(cfg.Defaults.Layout); err != nil {
+		return fmt.Errorf("defaults.layout %s", err)
 	}
-	if project := strings.TrimSpace(os.Getenv("OPENAI_PROJECT_ID")); project != "" {
-		req.Header.Set("OpenAI-Project", project)
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	if f.Container != "" {
+		if err := validateOutputContainer(f.Container); err != nil {
+			return err
+		}
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 300 {
-		apiErr := readAPIError(resp.Body)
-		return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, apiErr)
+	if f.Trim != "" {
+		if _, err := parseTrimRange(f.Trim); err != nil {
+			return fmt.Errorf("--trim: %w", err)
+		}
 	}
+	_, err := cfg.encodeTargets(f.Encode)
+	return err
+}
 
-	var job videoJob
-	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
-		return nil, err
-	}
-	if job.ID == "" {
-		return nil, errors.New("response missing job ID")
-	}
-	return &job, nil
+// extraOutputs is what is saved next to each downloaded MP4.
+type extraOutputs struct {
+	Variants []sora.Variant
+	Sidecar  bool
+	// Trim, if set, cuts the MP4 to TrimRange before anything else.
+	Trim      *trimConfig
+	TrimRange trimRange
+	// Grade, if set, is applied to the MP4 before anything but the trim.
+	Grade *gradeConfig
+	// Interpolate, if set, makes an interpolated copy of the graded MP4.
+	Interpolate *interpolateConfig
+	// Encode makes a copy of the graded MP4 for each profile, once Review
+	// allows it.
+	Encode []encodeTarget
+	Review reviewConfig
+	// Container, unless empty or mp4, is what the final MP4 is also saved
+	// as, with FFmpeg.
+	Container string
+	FFmpeg    string
+	// Layout arranges the downloads in folders under the destination, such
+	// as {yyyy}/{mm}/{dd}/{project}; Project fills in {project} for jobs
+	// without a project: tag.
+	Layout  string
+	Project string
 }
 
-func listVideoJobs(ctx context.Context, client *http.Client, baseURL, apiKey string, limit int, after, order string) (*videoListResponse, error) {
-	endpoint, err := url.Parse(baseURL + videosPath)
-	if err != nil {
-		return nil, err
+// outputs combines the flags with the configured defaults.
+func (f extraFlags) outputs(cfg *resolvedConfig) extraOutputs {
+	defaults := cfg.Defaults
+	var extras extraOutputs
+	trim := cfg.trimming()
+	if f.Trim != "" {
+		trim.Range = f.Trim
 	}
-	query := endpoint.Query()
-	if limit > 0 {
-		query.Set("limit", strconv.Itoa(limit))
+	if trim.Range != "" {
+		if r, err := parseTrimRange(trim.Range); err != nil {
+			logWarn("%v", err)
+		} else {
+			extras.Trim, extras.TrimRange = &trim, r
+		}
 	}
-	if after != "" {
-		query.Set("after", after)
+	if f.Grade || cfg.Grade.Auto {
+		grade := cfg.Grade
+		extras.Grade = &grade
 	}
-	if order != "" {
-		query.Set("order", order)
+	if f.Interpolate || cfg.Interpolate.Auto {
+		interp := cfg.interpolation()
+		extras.Interpolate = &interp
 	}
-	endpoint.RawQuery = query.Encode()
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
-	if err != nil {
-		return nil, err
+	if names := append(append([]string(nil), cfg.Encode.Auto...), f.Encode...); len(names) > 0 {
+		targets, err := cfg.encodeTargets(names)
+		if err != nil {
+			logWarn("%v", err)
+		}
+		extras.Encode = targets
+		extras.Review = cfg.Review
 	}
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Accept", "application/json")
-
-	if org := strings.TrimSpace(os.Getenv("OPENAI_ORG_ID")); org != "" {
-		req.Header.Set("OpenAI-Organization", org)
+	if f.Thumbnail || defaults.WithThumbnail {
+		extras.Variants = append(extras.Variants, sora.VariantThumbnail)
 	}
-	if project := strings.TrimSpace(os.Getenv("OPENAI_PROJECT_ID")); project != "" {
-		req.Header.Set("OpenAI-Project", project)
+	if f.Spritesheet || defaults.WithSpritesheet {
+		extras.Variants = append(extras.Variants, sora.VariantSpritesheet)
 	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	extras.Sidecar = f.Sidecar || defaults.WriteSidecar
+	container := f.Container
+	if container == "" {
+		container = defaults.Container
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 300 {
-		apiErr := readAPIError(resp.Body)
-		return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, apiErr)
+	if container != "mp4" {
+		extras.Container = container
 	}
+	extras.FFmpeg = cfg.Grade.FFmpeg
+	extras.Layout = defaults.Layout
+	extras.Project = configProject(cfg)
+	return extras
+}
 
-	var list videoListResponse
-	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
-		return nil, err
+// variantFilename names a download after its job: the MP4 is <id>.mp4 and the
+// images <id>_<variant> with their own extension.
+func variantFilename(jobID string, variant sora.Variant) string {
+	if variant == sora.VariantVideo {
+		return jobID + variant.Extension()
 	}
-	return &list, nil
+	return jobID + "_" + string(variant) + variant.Extension()
 }
 
-func waitForJobCompletion(ctx context.Context, client *http.Client, baseURL, apiKey, jobID string) (*videoJob, error) {
-	ticker := time.NewTicker(pollInterval)
-	defer ticker.Stop()
-
-	var lastStatus string
-	var lastProgress float64 = -1
-
-	for {
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		case <-ticker.C:
-			job, err := getVideoJob(ctx, client, baseURL, apiKey, jobID)
-			if err != nil {
-				return nil, err
-			}
-			progress := normalizeProgress(job.Progress)
-			if job.Status != lastStatus || progress != lastProgress {
-				fmt.Printf("Status: %s (%.0f%%)\n", job.Status, progress)
-				lastStatus = job.Status
-				lastProgress = progress
-			}
-
-			switch strings.ToLower(job.Status) {
-			case "completed":
-				return job, nil
-			case "failed", "canceled", "cancelled", "rejected", "expired":
-				if job.Error != nil {
-					return nil, fmt.Errorf("job %s: %s", job.Status, job.Error.Message)
+// downloadExtras saves the extra outputs for job next to its MP4 at
+// outputPath and returns their paths by variant name, with the sidecar under
+// "metadata". They are a convenience, so failures are only warnings.
+func downloadExtras(ctx context.Context, client *sora.Client, job *sora.Video, outputPath string, extras extraOutputs) map[string]string {
+	if extras.Trim != nil {
+		trimDownload(ctx, *extras.Trim, extras.TrimRange, job.ID, outputPath)
+	}
+	if extras.Grade != nil {
+		gradeDownload(ctx, *extras.Grade, job.ID, outputPath)
+	}
+	saved := make(map[string]string)
+	if extras.Interpolate != nil {
+		if path := interpolateDownload(ctx, *extras.Interpolate, job.ID, outputPath); path != "" {
+			saved[extras.Interpolate.variant()] = path
+		}
+	}
+	if len(extras.Encode) > 0 {
+		if err := extras.Review.checkApproved(job.ID); err != nil {
+			logWarn("not encoding with profiles: %v", err)
+		} else {
+			for _, target := range extras.Encode {
+				if path := encodeDownload(ctx, target, job.ID, outputPath); path != "" {
+					saved[target.Name] = path
 				}
-				return nil, fmt.Errorf("job %s", job.Status)
 			}
 		}
 	}
-}
-
-func getVideoJob(ctx context.Context, client *http.Client, baseURL, apiKey, jobID string) (*videoJob, error) {
-	url := fmt.Sprintf("%s%s/%s", baseURL, videosPath, jobID)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, err
+	if extras.Container != "" {
+		if path := remuxDownload(ctx, extras.FFmpeg, extras.Container, job.ID, outputPath); path != "" {
+			saved[extras.Container] = path
+		}
 	}
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	dir := filepath.Dir(outputPath)
+	for _, variant := range extras.Variants {
+		path := filepath.Join(dir, variantFilename(job.ID, variant))
+		if err := client.DownloadVariantFile(ctx, job.ID, variant, path); err != nil {
+			logWarn("unable to download %s for %s: %v", variant, job.ID, err)
+			continue
+		}
+		logInfo("Saved %s to %s", variant, path)
+		saved[string(variant)] = path
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 300 {
-		apiErr := readAPIError(resp.Body)
-		return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, apiErr)
+	if extras.Sidecar {
+		path, err := writeSidecar(job, outputPath)
+		if err != nil {
+			logWarn("unable to write metadata for %s: %v", job.ID, err)
+		} else {
+			logInfo("Saved metadata to %s", path)
+			saved["metadata"] = path
+		}
 	}
-
-	var job videoJob
-	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
-		return nil, err
+	if len(saved) == 0 {
+		return nil
 	}
-	return &job, nil
+	return saved
 }
 
-func downloadVideoContent(ctx context.Context, client *http.Client, baseURL, apiKey, jobID, outputPath string) error {
-	url := fmt.Sprintf("%s%s/%s/content", baseURL, videosPath, jobID)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Accept", "video/mp4")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 300 {
-		apiErr := readAPIError(resp.Body)
-		return fmt.Errorf("API error (%d): %s", resp.StatusCode, apiErr)
-	}
+// queueNoticeInterval is how often a queued job's wait is reported.
+const queueNoticeInterval = 30 * time.Second
 
-	tmpPath := outputPath + ".tmp"
-	outFile, err := os.Create(tmpPath)
-	if err != nil {
-		return err
-	}
-
-	if _, err = io.Copy(outFile, resp.Body); err != nil {
-		outFile.Close()
-		os.Remove(tmpPath)
-		return err
-	}
-
-	if err = outFile.Close(); err != nil {
-		os.Remove(tmpPath)
-		return err
-	}
-
-	if err = os.Rename(tmpPath, outputPath); err != nil {
-		os.Remove(tmpPath)
-		return err
-	}
-	return nil
+// queueTracker follows a job while it waits to start. It records the start in
+// history, which feeds the estimates for later jobs.
+type queueTracker struct {
+	mu      sync.Mutex
+	queued  *sora.Video
+	started bool
 }
 
-func normalizeProgress(progress float64) float64 {
-	if progress <= 1 && progress >= 0 {
-		return progress * 100
-	}
-	return progress
+// observe takes a status update and reports whether the job has just entered
+// the queue.
+func (t *queueTracker) observe(job *sora.Video) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if strings.EqualFold(job.Status, "queued") {
+		entered := t.queued == nil
+		t.queued = job
+		return entered
+	}
+	t.queued = nil
+	if !t.started && !job.Done() {
+		t.started = true
+		markHistoryStarted(job.ID)
+	}
+	return false
 }
 
-func readAPIError(body io.Reader) string {
-	data, err := io.ReadAll(body)
-	if err != nil {
-		return err.Error()
-	}
-	trimmed := strings.TrimSpace(string(data))
-	if trimmed == "" {
-		return "unknown error"
-	}
-	var parsed map[string]any
-	if err := json.Unmarshal(data, &parsed); err == nil {
-		if errBlock, ok := parsed["error"].(map[string]any); ok {
-			if msg, ok := errBlock["message"].(string); ok && msg != "" {
-				return msg
-			}
-		}
+// describe returns how long the job has been queued and when it is likely to
+// start, or "" once it is no longer queued.
+func (t *queueTracker) describe() string {
+	t.mu.Lock()
+	job := t.queued
+	t.mu.Unlock()
+	if job == nil || job.CreatedAt <= 0 {
+		return ""
 	}
-	return trimmed
+	created := time.Unix(job.CreatedAt, 0)
+	elapsed := time.Since(created).Round(time.Second)
+	notice := fmt.Sprintf("Queued for %s", elapsed)
+	typical, samples := typicalQueueWait(job.Model)
+	switch {
+	case samples == 0:
+	case typical > elapsed:
+		notice += fmt.Sprintf("; recent jobs started after %s (median of %d), so expect it around %s", typical.Round(time.Second), samples, formatTimestamp(created.Add(typical)))
+	default:
+		notice += fmt.Sprintf("; longer than the usual %s (median of %d recent jobs)", typical.Round(time.Second), samples)
+	}
+	return notice
 }
 
-type videoJob struct {
-	ID                 string         `json:"id"`
-	Object             string         `json:"object"`
-	Model              string         `json:"model"`
-	Status             string         `json:"status"`
-	Progress           float64        `json:"progress"`
-	CreatedAt          int64          `json:"created_at"`
-	CompletedAt        int64          `json:"completed_at"`
-	ExpiresAt          int64          `json:"expires_at"`
-	Size               string         `json:"size"`
-	Seconds            string         `json:"seconds"`
-	Quality            string         `json:"quality"`
-	RemixedFromVideoID string         `json:"remixed_from_video_id"`
-	Error              *videoJobError `json:"error"`
-}
-
-type videoJobError struct {
-	Message string `json:"message"`
-	Type    string `json:"type"`
-	Code    string `json:"code"`
+// formatProgress describes a job's state for progress lines. A queued job
+// has not started, so no percentage is shown for it.
+func formatProgress(job *sora.Video) string {
+	if strings.EqualFold(job.Status, "queued") {
+		return job.Status
+	}
+	return fmt.Sprintf("%s (%.0f%%)", job.Status, job.ProgressPercent())
 }
 
-type videoListResponse struct {
-	Object     string     `json:"object"`
-	Data       []videoJob `json:"data"`
-	HasMore    bool       `json:"has_more"`
-	Next       string     `json:"next"`
-	NextCursor string     `json:"next_cursor"`
+// exitDetached stops the process after an interrupt while the job carries on
+// rendering on the server.
+func exitDetached(jobID string) {
+	logInfo("Stopped waiting; job %s is still running. Resume with 'sora2cli wait %s'.", jobID, jobID)
+	os.Exit(130)
 }
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// modelGuides says what each model is for, so that new users need not find
+// out by paying for the wrong one.
+var modelGuides = map[string]struct {
+	summary  string
+	useCases []string
+}{
+	"sora-2": {
+		summary: "Fast and inexpensive; for exploring ideas before committing to a final render.",
+		useCases: []string{
+			"drafts and prompt iteration",
+			"storyboards and animatics",
+			"social clips where speed beats polish",
+		},
+	},
+	"sora-2-pro": {
+		summary: "Higher fidelity and steadier detail at three times the price, with larger resolutions; for final output.",
+		useCases: []string{
+			"client deliverables and ads",
+			"hero shots and product close-ups",
+			"1792x1024 and 1024x1792 masters for large screens",
+		},
+	},
+}
+
+// queueSamplesPerModel is how many of a model's recent jobs its typical
+// queue time is based on.
+const queueSamplesPerModel = 20
+
+// modelReport is one model in the models command's output.
+type modelReport struct {
+	Name          string             `json:"name"`
+	Default       bool               `json:"default"`
+	Summary       string             `json:"summary,omitempty"`
+	UseCases      []string           `json:"use_cases,omitempty"`
+	RatePerSecond float64            `json:"rate_per_second"`
+	Resolutions   []resolutionOption `json:"resolutions"`
+	// Prices maps each duration in seconds to the estimated cost of a clip.
+	Prices map[int]float64 `json:"prices"`
+	// QueueWait is the median time the model's recent jobs spent queued,
+	// from history; QueueSamples is how many jobs that is.
+	QueueWait    time.Duration `json:"-"`
+	QueueSeconds float64       `json:"queue_wait_seconds,omitempty"`
+	QueueSamples int           `json:"queue_samples"`
+}
+
+func modelReports(state historyState, defaultModel string) []modelReport {
+	reports := make([]modelReport, 0, len(modelOptions))
+	for _, opt := range modelOptions {
+		guide := modelGuides[opt.Name]
+		report := modelReport{
+			Name:          opt.Name,
+			Default:       strings.EqualFold(opt.Name, defaultModel),
+			Summary:       guide.summary,
+			UseCases:      guide.useCases,
+			RatePerSecond: opt.RatePerSecond,
+			Resolutions:   opt.Resolutions,
+			Prices:        make(map[int]float64, len(allowedDurations)),
+		}
+		for _, seconds := range allowedDurations {
+			report.Prices[seconds] = math.Round(opt.RatePerSecond*float64(seconds)*100) / 100
+		}
+		_, waits := recentQueueWaits(state, opt.Name, len(state.Entries))
+		waits = waits[:min(len(waits), queueSamplesPerModel)]
+		report.QueueWait = medianDuration(waits).Round(time.Second)
+		report.QueueSeconds = report.QueueWait.Seconds()
+		report.QueueSamples = len(waits)
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// explainModels writes what each model offers and costs, and how long its
+// jobs have waited in the queue here.
+func explainModels(w io.Writer, reports []modelReport) {
+	for i, report := range reports {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		marker := ""
+		if report.Default {
+			marker = " (default)"
+		}
+		fmt.Fprintf(w, "%s%s\n", report.Name, marker)
+		if report.Summary != "" {
+			fmt.Fprintf(w, "  %s\n", report.Summary)
+		}
+		labels := make([]string, len(report.Resolutions))
+		for j, res := range report.Resolutions {
+			labels[j] = res.Label
+		}
+		fmt.Fprintf(w, "  Resolutions: %s\n", strings.Join(labels, ", "))
+		prices := make([]string, len(allowedDurations))
+		for j, seconds := range allowedDurations {
+			prices[j] = fmt.Sprintf("%ds $%.2f", seconds, report.Prices[seconds])
+		}
+		fmt.Fprintf(w, "  Durations and prices: %s ($%.2f per second)\n", strings.Join(prices, ", "), report.RatePerSecond)
+		if report.QueueSamples > 0 {
+			fmt.Fprintf(w, "  Typical queue time: %s (median of %d recent job(s))\n", report.QueueWait, report.QueueSamples)
+		} else {
+			fmt.Fprintln(w, "  Typical queue time: unknown; no job of this model in history yet")
+		}
+		if len(report.UseCases) > 0 {
+			fmt.Fprintf(w, "  Good for: %s\n", strings.Join(report.UseCases, "; "))
+		}
+	}
+}
+
+func runModelsCommand(args []string) int {
+	fs := newCommandFlagSet("models")
+	jsonOutput := fs.Bool("json", false, "print the models as JSON")
+	registerFormatFlag(fs, jsonOutput)
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		logError("unexpected argument %q", fs.Arg(1))
+		return 2
+	}
+	if name := fs.Arg(0); name != "" {
+		if _, ok := findModelOption(name); !ok {
+			logError("unknown model %q; supported: %s", name, strings.Join(modelNames(), ", "))
+			return 2
+		}
+	}
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	state, err := loadHistory()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	reports := modelReports(state, cfg.Defaults.Model)
+	if name := fs.Arg(0); name != "" {
+		for _, report := range reports {
+			if strings.EqualFold(report.Name, name) {
+				reports = []modelReport{report}
+				break
+			}
+		}
+	}
+	if *jsonOutput {
+		enableJSONOutput()
+		emitJSON(reports)
+		return 0
+	}
+	explainModels(os.Stdout, reports)
+	return 0
+}
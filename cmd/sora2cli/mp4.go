@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// mp4Box is a box of an MP4 file: its type and where it sits.
+type mp4Box struct {
+	Type   string
+	Offset int64
+	Header int64
+	Size   int64
+}
+
+// readMP4Boxes lists the boxes in r between start and end.
+func readMP4Boxes(r io.ReaderAt, start, end int64) ([]mp4Box, error) {
+	var boxes []mp4Box
+	for offset := start; offset < end; {
+		var header [16]byte
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return nil, fmt.Errorf("read box at %d: %w", offset, err)
+		}
+		box := mp4Box{Type: string(header[4:8]), Offset: offset, Header: 8, Size: int64(binary.BigEndian.Uint32(header[:4]))}
+		switch box.Size {
+		case 0:
+			box.Size = end - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, fmt.Errorf("read box at %d: %w", offset, err)
+			}
+			box.Header = 16
+			box.Size = int64(binary.BigEndian.Uint64(header[8:16]))
+		}
+		if box.Size < box.Header || offset+box.Size > end {
+			return nil, fmt.Errorf("malformed %q box at %d", box.Type, offset)
+		}
+		boxes = append(boxes, box)
+		offset += box.Size
+	}
+	return boxes, nil
+}
+
+type mp4Chapter struct {
+	Start time.Duration
+	Title string
+}
+
+// writeMP4Chapters stores chapters in the MP4 at path as a Nero chapter list
+// (moov/udta/chpl), which players such as mpv and VLC and tools such as
+// ffmpeg read. An existing list is replaced. When the movie box comes before
+// the media data, the chunk offsets are moved by the size change. The file is
+// rewritten through a temporary file.
+func writeMP4Chapters(path string, chapters []mp4Chapter) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	top, err := readMP4Boxes(f, 0, info.Size())
+	if err != nil {
+		return err
+	}
+	moovIndex := -1
+	for i, box := range top {
+		switch box.Type {
+		case "moov":
+			moovIndex = i
+		case "moof":
+			return errors.New("fragmented MP4 files are not supported")
+		}
+	}
+	if moovIndex < 0 {
+		return errors.New("no movie box; not an MP4 file")
+	}
+	moovBox := top[moovIndex]
+	moov := make([]byte, moovBox.Size)
+	if _, err := f.ReadAt(moov, moovBox.Offset); err != nil {
+		return err
+	}
+
+	newMoov, err := replaceChapterBox(moov[moovBox.Header:], chapters)
+	if err != nil {
+		return err
+	}
+	newMoov = wrapMP4Box("moov", newMoov)
+	if delta := int64(len(newMoov)) - moovBox.Size; delta != 0 {
+		// Offsets past the old movie box move with everything after it.
+		if err := shiftChunkOffsets(newMoov[8:], moovBox.Offset, delta); err != nil {
+			return err
+		}
+	}
+
+	tmpPath := path + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, io.NewSectionReader(f, 0, moovBox.Offset))
+	if err == nil {
+		_, err = out.Write(newMoov)
+	}
+	if err == nil {
+		end := moovBox.Offset + moovBox.Size
+		_, err = io.Copy(out, io.NewSectionReader(f, end, info.Size()-end))
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// replaceChapterBox returns the payload of the movie box with its chpl box
+// replaced by one holding chapters.
+func replaceChapterBox(moov []byte, chapters []mp4Chapter) ([]byte, error) {
+	chpl, err := chapterListBox(chapters)
+	if err != nil {
+		return nil, err
+	}
+	children, err := readMP4Boxes(bytes.NewReader(moov), 0, int64(len(moov)))
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	hasUdta := false
+	for _, child := range children {
+		data := moov[child.Offset : child.Offset+child.Size]
+		if child.Type != "udta" {
+			out.Write(data)
+			continue
+		}
+		hasUdta = true
+		payload := data[child.Header:]
+		entries, err := readMP4Boxes(bytes.NewReader(payload), 0, int64(len(payload)))
+		if err != nil {
+			return nil, err
+		}
+		var udta bytes.Buffer
+		for _, entry := range entries {
+			if entry.Type != "chpl" {
+				udta.Write(payload[entry.Offset : entry.Offset+entry.Size])
+			}
+		}
+		udta.Write(chpl)
+		out.Write(wrapMP4Box("udta", udta.Bytes()))
+	}
+	if !hasUdta {
+		out.Write(wrapMP4Box("udta", chpl))
+	}
+	return out.Bytes(), nil
+}
+
+// chapterListBox encodes chapters as a version 1 chpl box: start times in
+// units of 100ns and titles of up to 255 bytes.
+func chapterListBox(chapters []mp4Chapter) ([]byte, error) {
+	if len(chapters) > math.MaxUint8 {
+		return nil, fmt.Errorf("too many chapters (%d); at most %d fit", len(chapters), math.MaxUint8)
+	}
+	var b bytes.Buffer
+	b.Write([]byte{1, 0, 0, 0})
+	b.Write([]byte{0, 0, 0, 0})
+	b.WriteByte(byte(len(chapters)))
+	for _, chapter := range chapters {
+		binary.Write(&b, binary.BigEndian, uint64(chapter.Start/100))
+		title := chapter.Title
+		if len(title) > math.MaxUint8 {
+			title = string(truncateLastRune([]byte(title[:math.MaxUint8])))
+		}
+		b.WriteByte(byte(len(title)))
+		b.WriteString(title)
+	}
+	return wrapMP4Box("chpl", b.Bytes()), nil
+}
+
+func wrapMP4Box(boxType string, payload []byte) []byte {
+	out := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(out, uint32(8+len(payload)))
+	copy(out[4:], boxType)
+	return append(out, payload...)
+}
+
+// shiftChunkOffsets adds delta to every chunk offset in the stco and co64
+// boxes under data that points past from.
+func shiftChunkOffsets(data []byte, from, delta int64) error {
+	boxes, err := readMP4Boxes(bytes.NewReader(data), 0, int64(len(data)))
+	if err != nil {
+		return err
+	}
+	for _, box := range boxes {
+		payload := data[box.Offset+box.Header : box.Offset+box.Size]
+		switch box.Type {
+		case "trak", "mdia", "minf", "stbl":
+			if err := shiftChunkOffsets(payload, from, delta); err != nil {
+				return err
+			}
+		case "stco", "co64":
+			width := 4
+			if box.Type == "co64" {
+				width = 8
+			}
+			if len(payload) < 8 {
+				return fmt.Errorf("malformed %s box", box.Type)
+			}
+			count := int(binary.BigEndian.Uint32(payload[4:8]))
+			if len(payload) < 8+count*width {
+				return fmt.Errorf("malformed %s box", box.Type)
+			}
+			for i := 0; i < count; i++ {
+				entry := payload[8+i*width:]
+				if width == 8 {
+					if offset := int64(binary.BigEndian.Uint64(entry)); offset > from {
+						binary.BigEndian.PutUint64(entry, uint64(offset+delta))
+					}
+					continue
+				}
+				offset := int64(binary.BigEndian.Uint32(entry))
+				if offset <= from {
+					continue
+				}
+				if offset+delta > math.MaxUint32 {
+					return errors.New("chunk offsets would overflow; the file needs 64-bit offsets")
+				}
+				binary.BigEndian.PutUint32(entry, uint32(offset+delta))
+			}
+		}
+	}
+	return nil
+}
+
+// mp4FrameRate returns the average frame rate of the first video track of
+// the MP4 at path: its sample count over its duration.
+func mp4FrameRate(path string) (float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	top, err := readMP4Boxes(f, 0, info.Size())
+	if err != nil {
+		return 0, err
+	}
+	for _, box := range top {
+		if box.Type != "moov" {
+			continue
+		}
+		moov := make([]byte, box.Size-box.Header)
+		if _, err := f.ReadAt(moov, box.Offset+box.Header); err != nil {
+			return 0, err
+		}
+		tracks, err := readMP4Boxes(bytes.NewReader(moov), 0, int64(len(moov)))
+		if err != nil {
+			return 0, err
+		}
+		for _, trak := range tracks {
+			if trak.Type != "trak" {
+				continue
+			}
+			rate, ok, err := videoTrackFrameRate(moov[trak.Offset+trak.Header : trak.Offset+trak.Size])
+			if err != nil || ok {
+				return rate, err
+			}
+		}
+	}
+	return 0, errors.New("no video track")
+}
+
+// videoTrackFrameRate reads the frame rate of a trak box's payload. ok is
+// false for tracks other than video.
+func videoTrackFrameRate(trak []byte) (rate float64, ok bool, err error) {
+	mdia, err := findMP4Box(trak, "mdia")
+	if mdia == nil || err != nil {
+		return 0, false, err
+	}
+	hdlr, err := findMP4Box(mdia, "hdlr")
+	if hdlr == nil || err != nil || len(hdlr) < 12 || string(hdlr[8:12]) != "vide" {
+		return 0, false, err
+	}
+	mdhd, err := findMP4Box(mdia, "mdhd")
+	if err != nil {
+		return 0, false, err
+	}
+	var timescale uint32
+	var duration uint64
+	switch {
+	case len(mdhd) >= 32 && mdhd[0] == 1:
+		timescale = binary.BigEndian.Uint32(mdhd[20:24])
+		duration = binary.BigEndian.Uint64(mdhd[24:32])
+	case len(mdhd) >= 20 && mdhd[0] == 0:
+		timescale = binary.BigEndian.Uint32(mdhd[12:16])
+		duration = uint64(binary.BigEndian.Uint32(mdhd[16:20]))
+	default:
+		return 0, false, errors.New("malformed mdhd box")
+	}
+	stsz, err := findMP4Box(mdia, "minf", "stbl", "stsz")
+	if err != nil {
+		return 0, false, err
+	}
+	if len(stsz) < 12 {
+		return 0, false, errors.New("malformed stsz box")
+	}
+	samples := binary.BigEndian.Uint32(stsz[8:12])
+	if timescale == 0 || duration == 0 || samples == 0 {
+		return 0, false, errors.New("video track has no frames")
+	}
+	return float64(samples) * float64(timescale) / float64(duration), true, nil
+}
+
+// findMP4Box returns the payload of the box at path below data, or nil if
+// there is none.
+func findMP4Box(data []byte, path ...string) ([]byte, error) {
+	for _, boxType := range path {
+		boxes, err := readMP4Boxes(bytes.NewReader(data), 0, int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		var next []byte
+		for _, box := range boxes {
+			if box.Type == boxType {
+				next = data[box.Offset+box.Header : box.Offset+box.Size]
+				break
+			}
+		}
+		if next == nil {
+			return nil, nil
+		}
+		data = next
+	}
+	return data, nil
+}
+
+// mp4Duration returns the duration of the MP4 at path from its movie header.
+func mp4Duration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	top, err := readMP4Boxes(f, 0, info.Size())
+	if err != nil {
+		return 0, err
+	}
+	for _, box := range top {
+		if box.Type != "moov" {
+			continue
+		}
+		moov := make([]byte, box.Size-box.Header)
+		if _, err := f.ReadAt(moov, box.Offset+box.Header); err != nil {
+			return 0, err
+		}
+		mvhd, err := findMP4Box(moov, "mvhd")
+		if err != nil {
+			return 0, err
+		}
+		var timescale uint32
+		var duration uint64
+		switch {
+		case len(mvhd) >= 32 && mvhd[0] == 1:
+			timescale = binary.BigEndian.Uint32(mvhd[20:24])
+			duration = binary.BigEndian.Uint64(mvhd[24:32])
+		case len(mvhd) >= 20 && mvhd[0] == 0:
+			timescale = binary.BigEndian.Uint32(mvhd[12:16])
+			duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+		default:
+			return 0, errors.New("malformed mvhd box")
+		}
+		if timescale == 0 || duration == 0 {
+			return 0, errors.New("the video has no duration")
+		}
+		return time.Duration(float64(duration) / float64(timescale) * float64(time.Second)), nil
+	}
+	return 0, errors.New("no moov box")
+}
+
+// mp4Keyframes returns the presentation times of the sync samples of the
+// first video track of the MP4 at path, in order. It returns nil when the
+// track has no sync sample table, meaning every frame is a keyframe.
+func mp4Keyframes(path string) ([]time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	top, err := readMP4Boxes(f, 0, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	for _, box := range top {
+		if box.Type != "moov" {
+			continue
+		}
+		moov := make([]byte, box.Size-box.Header)
+		if _, err := f.ReadAt(moov, box.Offset+box.Header); err != nil {
+			return nil, err
+		}
+		tracks, err := readMP4Boxes(bytes.NewReader(moov), 0, int64(len(moov)))
+		if err != nil {
+			return nil, err
+		}
+		for _, trak := range tracks {
+			if trak.Type != "trak" {
+				continue
+			}
+			times, ok, err := videoTrackKeyframes(moov[trak.Offset+trak.Header : trak.Offset+trak.Size])
+			if err != nil || ok {
+				return times, err
+			}
+		}
+	}
+	return nil, errors.New("no video track")
+}
+
+// videoTrackKeyframes reads the keyframe times of a trak box's payload from
+// its time-to-sample and sync sample tables. ok is false for tracks other
+// than video.
+func videoTrackKeyframes(trak []byte) (times []time.Duration, ok bool, err error) {
+	mdia, err := findMP4Box(trak, "mdia")
+	if mdia == nil || err != nil {
+		return nil, false, err
+	}
+	hdlr, err := findMP4Box(mdia, "hdlr")
+	if hdlr == nil || err != nil || len(hdlr) < 12 || string(hdlr[8:12]) != "vide" {
+		return nil, false, err
+	}
+	mdhd, err := findMP4Box(mdia, "mdhd")
+	if err != nil {
+		return nil, false, err
+	}
+	var timescale uint32
+	switch {
+	case len(mdhd) >= 32 && mdhd[0] == 1:
+		timescale = binary.BigEndian.Uint32(mdhd[20:24])
+	case len(mdhd) >= 20 && mdhd[0] == 0:
+		timescale = binary.BigEndian.Uint32(mdhd[12:16])
+	default:
+		return nil, false, errors.New("malformed mdhd box")
+	}
+	if timescale == 0 {
+		return nil, false, errors.New("video track has no timescale")
+	}
+	stss, err := findMP4Box(mdia, "minf", "stbl", "stss")
+	if err != nil || stss == nil {
+		return nil, true, err
+	}
+	stts, err := findMP4Box(mdia, "minf", "stbl", "stts")
+	if err != nil {
+		return nil, false, err
+	}
+	if len(stts) < 8 || len(stss) < 8 {
+		return nil, false, errors.New("malformed sample table")
+	}
+	// Decode times of every sample, 1-based as stss numbers them.
+	var starts []uint64
+	var t uint64
+	entries := binary.BigEndian.Uint32(stts[4:8])
+	if uint64(len(stts)) < 8+8*uint64(entries) {
+		return nil, false, errors.New("malformed stts box")
+	}
+	for i := uint32(0); i < entries; i++ {
+		count := binary.BigEndian.Uint32(stts[8+8*i:])
+		delta := uint64(binary.BigEndian.Uint32(stts[12+8*i:]))
+		for j := uint32(0); j < count; j++ {
+			starts = append(starts, t)
+			t += delta
+		}
+	}
+	syncs := binary.BigEndian.Uint32(stss[4:8])
+	if uint64(len(stss)) < 8+4*uint64(syncs) {
+		return nil, false, errors.New("malformed stss box")
+	}
+	times = make([]time.Duration, 0, syncs)
+	for i := uint32(0); i < syncs; i++ {
+		sample := binary.BigEndian.Uint32(stss[8+4*i:])
+		if sample == 0 || int(sample) > len(starts) {
+			return nil, false, errors.New("malformed stss box")
+		}
+		times = append(times, time.Duration(float64(starts[sample-1])/float64(timescale)*float64(time.Second)))
+	}
+	return times, true, nil
+}
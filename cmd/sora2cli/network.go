@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// networkConfig routes API requests on networks where api.openai.com cannot
+// be reached directly. Without a proxy here, HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY from the environment apply.
+type networkConfig struct {
+	// Proxy is an http, https, socks5 or socks5h URL, with credentials if the
+	// proxy needs them, that every API request goes through in place of the
+	// environment's proxies.
+	Proxy string `yaml:"proxy,omitempty" env:"SORA2_PROXY" secret:"true"`
+	// CABundle is a PEM file of certificates trusted besides the system ones,
+	// such as the root of a TLS-inspecting proxy.
+	CABundle string `yaml:"ca_bundle,omitempty" env:"SORA2_CA_BUNDLE"`
+	// ClientCert and ClientKey are PEM files of the certificate and key sent
+	// to servers that ask for one.
+	ClientCert string `yaml:"client_cert,omitempty" env:"SORA2_CLIENT_CERT"`
+	ClientKey  string `yaml:"client_key,omitempty" env:"SORA2_CLIENT_KEY"`
+}
+
+// networkSettings is the --proxy given on the command line, which wins over
+// network.proxy.
+var networkSettings struct{ proxy string }
+
+func registerNetworkFlags(fs *flag.FlagSet) {
+	fs.Func("proxy", "send API requests through the proxy at `url` (http, https, socks5 or socks5h; default: network.proxy or HTTPS_PROXY)", func(value string) error {
+		if _, err := parseProxyURL(value); err != nil {
+			return err
+		}
+		rememberSecret(value)
+		networkSettings.proxy = value
+		return nil
+	})
+}
+
+func parseProxyURL(value string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(value))
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q; use e.g. http://proxy:3128 or socks5://proxy:1080", redactProxy(value))
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q; supported: http, https, socks5, socks5h", u.Scheme)
+	}
+	return u, nil
+}
+
+// redactProxy hides the password of a proxy URL, for messages.
+func redactProxy(value string) string {
+	if u, err := url.Parse(value); err == nil {
+		return u.Redacted()
+	}
+	return value
+}
+
+func validateNetworkConfig(c networkConfig) []configIssue {
+	var issues []configIssue
+	if c.Proxy != "" {
+		if _, err := parseProxyURL(c.Proxy); err != nil {
+			issues = append(issues, configIssue{Key: "network.proxy", Message: err.Error()})
+		}
+	}
+	if (c.ClientCert == "") != (c.ClientKey == "") {
+		issues = append(issues, configIssue{Key: "network", Message: "client_cert and client_key must be set together"})
+	}
+	return issues
+}
+
+// apiResponseTimeout is how long one attempt waits for the response headers
+// once its request has been written. It is per attempt, so uploads and
+// downloads may take as long as they need and a server that stops answering
+// counts as a failure towards failover.
+const apiResponseTimeout = 30 * time.Second
+
+// apiTransport returns the transport for API requests: http.DefaultTransport
+// with apiResponseTimeout, plus any configured proxy, CA bundle or client
+// certificate.
+func apiTransport(c networkConfig) (http.RoundTripper, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = apiResponseTimeout
+	proxy := firstNonEmpty(networkSettings.proxy, c.Proxy)
+	if proxy == "" && c.CABundle == "" && c.ClientCert == "" && c.ClientKey == "" {
+		return transport, nil
+	}
+	if issues := validateNetworkConfig(networkConfig{ClientCert: c.ClientCert, ClientKey: c.ClientKey}); len(issues) > 0 {
+		return nil, errors.New(issues[0].Message)
+	}
+	if proxy != "" {
+		u, err := parseProxyURL(proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(u)
+		logDebug("API requests go through proxy %s", u.Redacted())
+	}
+	if c.CABundle == "" && c.ClientCert == "" {
+		return transport, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		path, err := expandPath(c.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA bundle %s holds no PEM certificates", c.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.ClientCert != "" {
+		certPath, err := expandPath(c.ClientCert)
+		if err != nil {
+			return nil, err
+		}
+		keyPath, err := expandPath(c.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
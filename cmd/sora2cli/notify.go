@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+const notificationTimeout = 15 * time.Second
+
+type notificationsConfig struct {
+	Slack   notificationChannel `yaml:"slack,omitempty"`
+	Discord notificationChannel `yaml:"discord,omitempty"`
+	Webhook notificationChannel `yaml:"webhook,omitempty"`
+	// Desktop shows a native notification when a create, remix or wait
+	// job finishes, as --notify does for one run.
+	Desktop bool `yaml:"desktop,omitempty" env:"SORA2_NOTIFY_DESKTOP"`
+	// JobWebhook receives every job create, remix or wait finishes, as
+	// --webhook-url does for one run.
+	JobWebhook jobWebhookConfig `yaml:"job_webhook,omitempty"`
+}
+
+type notificationChannel struct {
+	URL     string `yaml:"url,omitempty" secret:"true"`
+	Summary bool   `yaml:"summary,omitempty"`
+	// Jobs posts a message for every job that completes or fails, with
+	// its thumbnail where the channel can take a file. Only slack and
+	// discord have it; the generic webhook is job_webhook.
+	Jobs bool `yaml:"jobs,omitempty"`
+}
+
+type runSummary struct {
+	Source        string  `json:"source"`
+	Jobs          int     `json:"jobs"`
+	Completed     int     `json:"completed"`
+	Failed        int     `json:"failed"`
+	Skipped       int     `json:"skipped"`
+	Remaining     int     `json:"remaining"`
+	EstimatedCost float64 `json:"estimated_cost"`
+	OutputBytes   int64   `json:"output_bytes"`
+	// Budget is what is left of the configured spend caps, if any.
+	Budget    string        `json:"budget,omitempty"`
+	WallClock time.Duration `json:"-"`
+}
+
+func (s runSummary) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s finished: %d job(s), %d completed, %d failed", s.Source, s.Jobs, s.Completed, s.Failed)
+	if s.Skipped > 0 {
+		fmt.Fprintf(&b, ", %d skipped", s.Skipped)
+	}
+	fmt.Fprintf(&b, ". Est. cost $%.2f, output %s, wall-clock %s.", s.EstimatedCost, formatBytes(s.OutputBytes), s.WallClock.Round(time.Second))
+	if s.Remaining > 0 {
+		fmt.Fprintf(&b, " %d item(s) still waiting.", s.Remaining)
+	}
+	if s.Budget != "" {
+		b.WriteString(" " + s.Budget)
+	}
+	return b.String()
+}
+
+// notifyRunSummary posts one summary to every channel that opted in. Failures
+// are reported to the caller but never abort the run they describe.
+func notifyRunSummary(ctx context.Context, cfg notificationsConfig, summary runSummary) []error {
+	client := &http.Client{Timeout: notificationTimeout}
+	text := summary.text()
+
+	var errs []error
+	send := func(name, url string, payload any) {
+		if err := postJSON(ctx, client, url, payload); err != nil {
+			errs = append(errs, fmt.Errorf("%s notification: %w", name, err))
+		}
+	}
+	if cfg.Slack.Summary && cfg.Slack.URL != "" {
+		send("slack", cfg.Slack.URL, map[string]string{"text": text})
+	}
+	if cfg.Discord.Summary && cfg.Discord.URL != "" {
+		send("discord", cfg.Discord.URL, map[string]string{"content": text})
+	}
+	if cfg.Webhook.Summary && cfg.Webhook.URL != "" {
+		send("webhook", cfg.Webhook.URL, struct {
+			Event            string `json:"event"`
+			Text             string `json:"text"`
+			WallClockSeconds int64  `json:"wall_clock_seconds"`
+			runSummary
+		}{
+			Event:            "run.summary",
+			Text:             text,
+			WallClockSeconds: int64(summary.WallClock.Seconds()),
+			runSummary:       summary,
+		})
+	}
+	return errs
+}
+
+// jobMessage reads like "Video video_123 completed: a fox in snow, cost
+// $0.40, saved to ./video_123.mp4".
+func jobMessage(event ticketEvent) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Video %s %s", event.JobID, event.Status)
+	if event.Prompt != "" {
+		fmt.Fprintf(&b, ": %s", truncateText(event.Prompt, 120))
+	}
+	if event.EstimatedCost > 0 {
+		fmt.Fprintf(&b, ", cost $%.2f", event.EstimatedCost)
+	}
+	if event.OutputPath != "" {
+		fmt.Fprintf(&b, ", saved to %s", event.OutputPath)
+	}
+	if event.Error != "" {
+		fmt.Fprintf(&b, ". Error: %s", event.Error)
+	}
+	return b.String()
+}
+
+// jobThumbnail returns the thumbnail of a completed job: the one saved next
+// to the download when there is one, else a temporary copy that the caller
+// removes with cleanup.
+func jobThumbnail(ctx context.Context, client *sora.Client, event ticketEvent) (path string, cleanup func(), err error) {
+	cleanup = func() {}
+	if event.OutputPath != "" {
+		saved := filepath.Join(filepath.Dir(event.OutputPath), variantFilename(event.JobID, sora.VariantThumbnail))
+		if _, err := os.Stat(saved); err == nil {
+			return saved, cleanup, nil
+		}
+	}
+	dir, err := os.MkdirTemp("", "sora2cli-")
+	if err != nil {
+		return "", cleanup, err
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+	path = filepath.Join(dir, variantFilename(event.JobID, sora.VariantThumbnail))
+	if err := client.DownloadVariantFile(ctx, event.JobID, sora.VariantThumbnail, path); err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+	return path, cleanup, nil
+}
+
+// notifyJobOrWarn posts the outcome of one job to every channel with jobs
+// set. Like the run summary, a failed post is only a warning.
+func notifyJobOrWarn(client *sora.Client, cfg notificationsConfig, event ticketEvent) {
+	slack := cfg.Slack.Jobs && cfg.Slack.URL != ""
+	discord := cfg.Discord.Jobs && cfg.Discord.URL != ""
+	if event.JobID == "" || !slack && !discord {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+	defer cancel()
+	httpClient := &http.Client{Timeout: notificationTimeout}
+	text := jobMessage(event)
+	if slack {
+		// Incoming webhooks cannot carry files, so Slack gets the text.
+		if err := postJSON(ctx, httpClient, cfg.Slack.URL, map[string]string{"text": text}); err != nil {
+			logWarn("slack notification: %v", err)
+		}
+	}
+	if discord {
+		thumbnail := ""
+		if event.Status == "completed" && client != nil {
+			path, cleanup, err := jobThumbnail(ctx, client, event)
+			defer cleanup()
+			if err != nil {
+				logWarn("unable to fetch the thumbnail of %s for discord: %v", event.JobID, err)
+			}
+			thumbnail = path
+		}
+		if err := postDiscordMessage(ctx, httpClient, cfg.Discord.URL, text, thumbnail); err != nil {
+			logWarn("discord notification: %v", err)
+		}
+	}
+}
+
+// postDiscordMessage posts content to a Discord webhook, with the file at
+// attachment, if any, uploaded alongside so it shows inline.
+func postDiscordMessage(ctx context.Context, client *http.Client, url, content, attachment string) error {
+	if attachment == "" {
+		return postJSON(ctx, client, url, map[string]string{"content": content})
+	}
+	file, err := os.Open(attachment)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	payload, err := json.Marshal(map[string]string{"content": content})
+	if err != nil {
+		return err
+	}
+	if err := form.WriteField("payload_json", string(payload)); err != nil {
+		return err
+	}
+	part, err := form.CreateFormFile("files[0]", filepath.Base(attachment))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return err
+	}
+	if err := form.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, readAPIError(resp.Body))
+	}
+	return nil
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, payload any) error {
+	body := &bytes.Buffer{}
+	if err := json.NewEncoder(body).Encode(payload); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, readAPIError(resp.Body))
+	}
+	return nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func readAPIError(body io.Reader) string {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err.Error()
+	}
+	trimmed := strings.TrimSpace(string(data))
+	if trimmed == "" {
+		return "unknown error"
+	}
+	var parsed map[string]any
+	if err := json.Unmarshal(data, &parsed); err == nil {
+		if errBlock, ok := parsed["error"].(map[string]any); ok {
+			if msg, ok := errBlock["message"].(string); ok && msg != "" {
+				return msg
+			}
+		}
+	}
+	return trimmed
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// offPeakConfig is the daily low-traffic window used by
+// --defer-until-off-peak, as local HH:MM times. The window may wrap past
+// midnight.
+type offPeakConfig struct {
+	Start string `yaml:"start,omitempty" env:"SORA2_OFF_PEAK_START"`
+	End   string `yaml:"end,omitempty" env:"SORA2_OFF_PEAK_END"`
+}
+
+type offPeakWindow struct {
+	start, end time.Duration
+	label      string
+}
+
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q; use HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (c offPeakConfig) window() (offPeakWindow, error) {
+	start, err := parseClock(c.Start)
+	if err != nil {
+		return offPeakWindow{}, fmt.Errorf("off_peak.start: %w", err)
+	}
+	end, err := parseClock(c.End)
+	if err != nil {
+		return offPeakWindow{}, fmt.Errorf("off_peak.end: %w", err)
+	}
+	if start == end {
+		return offPeakWindow{}, fmt.Errorf("off_peak.start and off_peak.end must differ")
+	}
+	return offPeakWindow{start: start, end: end, label: c.Start + "-" + c.End}, nil
+}
+
+func sinceMidnight(t time.Time) time.Duration {
+	y, m, d := t.Date()
+	return t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+}
+
+func (w offPeakWindow) contains(t time.Time) bool {
+	now := sinceMidnight(t)
+	if w.start < w.end {
+		return now >= w.start && now < w.end
+	}
+	return now >= w.start || now < w.end
+}
+
+// next returns when the window next opens after t.
+func (w offPeakWindow) next(t time.Time) time.Time {
+	y, m, d := t.Date()
+	opens := time.Date(y, m, d, 0, 0, 0, 0, t.Location()).Add(w.start)
+	if !opens.After(t) {
+		opens = opens.AddDate(0, 0, 1)
+	}
+	return opens
+}
+
+// waitForOffPeak blocks until the window is open. It only prints when it
+// actually has to wait.
+func waitForOffPeak(ctx context.Context, w offPeakWindow, what string) error {
+	now := time.Now()
+	if w.contains(now) {
+		return nil
+	}
+	opens := w.next(now)
+	logInfo("Holding %s until the off-peak window %s opens at %s (in %s)", what, w.label, formatTimestamp(opens), opens.Sub(now).Round(time.Minute))
+	timer := time.NewTimer(time.Until(opens))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		logInfo("Off-peak window open; resuming submissions.")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
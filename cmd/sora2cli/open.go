@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// openCommand returns the command that opens path in the desktop's default
+// application for it.
+func openCommand(path string) *exec.Cmd {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", path)
+	case "windows":
+		// start treats its first quoted argument as the window title.
+		return exec.Command("cmd", "/c", "start", "", path)
+	default:
+		return exec.Command("xdg-open", path)
+	}
+}
+
+// desktopAvailable reports whether there is a desktop to open a player on:
+// not in a container, and on Linux and the BSDs only with a display.
+func desktopAvailable() bool {
+	if runningInContainer() {
+		return false
+	}
+	switch runtime.GOOS {
+	case "darwin", "windows":
+		return true
+	}
+	return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+}
+
+// openInPlayer launches the default player on path without waiting for it.
+func openInPlayer(path string) error {
+	cmd := openCommand(path)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("unable to open %s: %w", path, err)
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// offerToOpen opens a downloaded video in the default player: with --open
+// straight away, otherwise, in an interactive session on a desktop, after
+// asking. JSON output never asks, as a script is reading it.
+func offerToOpen(reader *bufio.Reader, out io.Writer, path string, open, interactive bool) {
+	if !open {
+		if !interactive || jsonStdout != nil || !stdinIsTerminal() || !desktopAvailable() {
+			return
+		}
+		if !promptConfirm(reader, out, "Open it in the default player?") {
+			return
+		}
+	}
+	if err := openInPlayer(path); err != nil {
+		logWarn("%v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+// jsonStdout holds the real stdout while --json is active. Everything printed
+// for humans goes to stderr instead, so stdout carries only JSON documents.
+var (
+	jsonStdout *os.File
+	jsonMu     sync.Mutex
+)
+
+func enableJSONOutput() {
+	if jsonStdout != nil {
+		return
+	}
+	jsonStdout = os.Stdout
+	os.Stdout = os.Stderr
+}
+
+// displayUTC shows human-readable timestamps in UTC instead of the local time
+// zone (--utc). JSON output keeps the API's Unix timestamps either way.
+var displayUTC bool
+
+const displayTimeLayout = "2006-01-02 15:04:05 MST"
+
+func formatTimestamp(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	if displayUTC {
+		return t.UTC().Format(displayTimeLayout)
+	}
+	return t.Local().Format(displayTimeLayout)
+}
+
+// formatUnixTimestamp formats the seconds-since-epoch values the API uses.
+func formatUnixTimestamp(sec int64) string {
+	if sec <= 0 {
+		return "-"
+	}
+	return formatTimestamp(time.Unix(sec, 0))
+}
+
+type videoResult struct {
+	*sora.Video
+	OutputPath string `json:"output_path,omitempty"`
+	// Variants maps the extra images saved with --with-thumbnail or
+	// --with-spritesheet, and the --sidecar file as "metadata", to their
+	// paths.
+	Variants map[string]string `json:"variants,omitempty"`
+}
+
+type jsonErrorBody struct {
+	Message string `json:"message"`
+	JobID   string `json:"job_id,omitempty"`
+	// RequestID is the x-request-id of a failed API call.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// emitJSON writes v to stdout as one line in the --format encoding, JSON
+// unless another was chosen. It does nothing unless --json was given.
+func emitJSON(v any) {
+	if jsonStdout == nil {
+		return
+	}
+	jsonMu.Lock()
+	defer jsonMu.Unlock()
+	writeStructured(jsonStdout, v)
+}
+
+func emitJSONError(err error, jobID string) {
+	body := jsonErrorBody{Message: err.Error(), JobID: jobID}
+	var apiErr *sora.APIError
+	if errors.As(err, &apiErr) {
+		body.RequestID = apiErr.RequestID
+	}
+	emitJSON(map[string]jsonErrorBody{"error": body})
+}
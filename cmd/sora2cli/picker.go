@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+const (
+	// pickerFetchLimit is how many recent videos the picker fetches, one
+	// page of the list endpoint.
+	pickerFetchLimit = 100
+	// pickerShown is how many matches are listed at a time; searching
+	// narrows the rest down.
+	pickerShown = 20
+)
+
+// pickRemixSource lists the account's recent completed videos and asks which
+// one to remix. The user picks by number, types text to search the prompts
+// and IDs, or pastes an ID. If the videos cannot be listed it falls back to
+// asking for the ID.
+func pickRemixSource(reader *bufio.Reader, out io.Writer, client *sora.Client) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	logInfo("Fetching recent videos...")
+	list, err := client.List(ctx, sora.ListParams{Limit: pickerFetchLimit, Order: "desc"})
+	if err != nil {
+		logWarn("unable to list videos: %v", err)
+		return promptRequired(reader, out, "Existing video ID to remix")
+	}
+	state, _ := loadHistory()
+	var videos []sora.Video
+	for _, video := range list.Data {
+		if video.Status != "completed" {
+			continue
+		}
+		if video.Prompt == "" {
+			if entry := state.find(video.ID); entry != nil {
+				video.Prompt = entry.Prompt
+			}
+		}
+		videos = append(videos, video)
+	}
+	if len(videos) == 0 {
+		logInfo("No completed videos found.")
+		return promptRequired(reader, out, "Existing video ID to remix")
+	}
+
+	matches, query := videos, ""
+	for {
+		fmt.Fprintln(out)
+		if query == "" {
+			fmt.Fprintln(out, "Recent completed videos:")
+		} else {
+			fmt.Fprintf(out, "Videos matching %q:\n", query)
+		}
+		shown := matches[:min(len(matches), pickerShown)]
+		for i, video := range shown {
+			prompt := video.Prompt
+			if prompt == "" {
+				prompt = "(no prompt)"
+			}
+			fmt.Fprintf(out, "  %2d) %s  %s  %-9s  %s\n", i+1, video.ID, formatUnixTimestamp(video.CreatedAt), video.Size, truncateText(prompt, 50))
+		}
+		if rest := len(matches) - len(shown); rest > 0 {
+			fmt.Fprintf(out, "  ... %d more; type to search\n", rest)
+		}
+		input := promptOptional(reader, out, fmt.Sprintf("Video to remix (1-%d, text to search, an ID, or empty to clear the search)", len(shown)))
+		if input == "" {
+			matches, query = videos, ""
+			continue
+		}
+		if idx, err := strconv.Atoi(input); err == nil {
+			if idx >= 1 && idx <= len(shown) {
+				return shown[idx-1].ID
+			}
+			fmt.Fprintln(out, "Invalid selection, please try again.")
+			continue
+		}
+		if strings.HasPrefix(input, "video_") && !strings.ContainsAny(input, " \t") {
+			return input
+		}
+		var found []sora.Video
+		needle := strings.ToLower(input)
+		for _, video := range videos {
+			if strings.Contains(strings.ToLower(video.Prompt), needle) || strings.Contains(strings.ToLower(video.ID), needle) {
+				found = append(found, video)
+			}
+		}
+		if len(found) == 0 {
+			fmt.Fprintf(out, "No videos match %q.\n", input)
+			continue
+		}
+		matches, query = found, input
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// posterCandidate is one frame offered by the poster picker.
+type posterCandidate struct {
+	At   time.Duration
+	Path string
+}
+
+const (
+	defaultPosterCandidates = 12
+	maxPosterCandidates     = 48
+	// posterPreviewWidth is the width the candidates are scaled to; the
+	// chosen frame is extracted again at full size.
+	posterPreviewWidth = 480
+)
+
+// imagePreview is how the terminal can show an image inline, if at all.
+type imagePreview int
+
+const (
+	previewNone imagePreview = iota
+	previewKitty
+	previewITerm
+)
+
+// detectImagePreview recognises terminals that show images inline: kitty's
+// graphics protocol (kitty, Ghostty) and iTerm2's (iTerm2, WezTerm).
+func detectImagePreview() imagePreview {
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "" || strings.Contains(os.Getenv("TERM"), "kitty") || os.Getenv("TERM_PROGRAM") == "ghostty":
+		return previewKitty
+	case os.Getenv("TERM_PROGRAM") == "iTerm.app" || os.Getenv("TERM_PROGRAM") == "WezTerm":
+		return previewITerm
+	}
+	return previewNone
+}
+
+// showImage draws the PNG at path in the terminal.
+func (p imagePreview) showImage(path string) error {
+	switch p {
+	case previewKitty:
+		// Drop the previous frame, then have the terminal read the file
+		// itself (t=f).
+		fmt.Printf("\x1b_Ga=d\x1b\\\x1b_Ga=T,f=100,t=f;%s\x1b\\", base64.StdEncoding.EncodeToString([]byte(path)))
+	case previewITerm:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\x1b]1337;File=inline=1;width=60%%;preserveAspectRatio=1;size=%d:%s\a", len(data), base64.StdEncoding.EncodeToString(data))
+	}
+	return nil
+}
+
+// extractPosterCandidates saves count frames spread evenly over the video
+// as numbered PNGs in dir.
+func extractPosterCandidates(ctx context.Context, ffmpeg, src, dir string, count int) ([]posterCandidate, error) {
+	duration, err := mp4Duration(src)
+	if err != nil {
+		return nil, fmt.Errorf("read duration: %w", err)
+	}
+	candidates := make([]posterCandidate, count)
+	for i := range candidates {
+		at := duration * time.Duration(2*i+1) / time.Duration(2*count)
+		path := filepath.Join(dir, fmt.Sprintf("%02d.png", i+1))
+		if err := runTool(ctx, ffmpeg, "-hide_banner", "-loglevel", "error", "-nostdin", "-y",
+			"-ss", formatSeconds(at), "-i", src, "-frames:v", "1",
+			"-vf", fmt.Sprintf("scale=%d:-2", posterPreviewWidth), path); err != nil {
+			return nil, err
+		}
+		candidates[i] = posterCandidate{At: at, Path: path}
+	}
+	return candidates, nil
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// pickPosterWithKeys steps through the candidates with the arrow keys while
+// showing each one inline. It returns the chosen index, or -1 to cancel.
+func pickPosterWithKeys(preview imagePreview, candidates []posterCandidate) (int, error) {
+	if err := enterRawTerminal(); err != nil {
+		return -1, err
+	}
+	defer restoreTerminal()
+	in := bufio.NewReader(os.Stdin)
+	current := 0
+	for {
+		fmt.Print("\x1b[2J\x1b[H")
+		if err := preview.showImage(candidates[current].Path); err != nil {
+			return -1, err
+		}
+		fmt.Printf("\r\nFrame %d/%d at %ss   ←/→ step, Enter choose, q cancel\r\n", current+1, len(candidates), formatSeconds(candidates[current].At))
+		key, err := in.ReadByte()
+		if err != nil {
+			return -1, err
+		}
+		switch key {
+		case '\r', '\n':
+			return current, nil
+		case 'q', 3:
+			return -1, nil
+		case 'h':
+			current = (current + len(candidates) - 1) % len(candidates)
+		case 'l', ' ':
+			current = (current + 1) % len(candidates)
+		case 0x1b:
+			// Arrow keys arrive as ESC [ C and ESC [ D.
+			if next, _ := in.ReadByte(); next != '[' {
+				continue
+			}
+			switch arrow, _ := in.ReadByte(); arrow {
+			case 'C', 'B':
+				current = (current + 1) % len(candidates)
+			case 'D', 'A':
+				current = (current + len(candidates) - 1) % len(candidates)
+			}
+		}
+	}
+}
+
+// pickPosterByNumber lists the candidate files for the user to open and
+// asks for a number. It returns the chosen index, or -1 to cancel.
+func pickPosterByNumber(reader *bufio.Reader, dir string, candidates []posterCandidate) int {
+	fmt.Printf("Candidate frames are in %s:\n", dir)
+	for i, c := range candidates {
+		fmt.Printf("  %2d) %ss  %s\n", i+1, formatSeconds(c.At), filepath.Base(c.Path))
+	}
+	for {
+		input := promptOptional(reader, os.Stdout, fmt.Sprintf("Choose a frame (1-%d, empty to cancel)", len(candidates)))
+		if input == "" {
+			return -1
+		}
+		if idx, err := strconv.Atoi(input); err == nil && idx >= 1 && idx <= len(candidates) {
+			return idx - 1
+		}
+		fmt.Println("Invalid selection, please try again.")
+	}
+}
+
+// savePoster extracts the frame at at from src in full size to dst.
+func savePoster(ctx context.Context, ffmpeg, src, dst string, at time.Duration) error {
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y", "-ss", formatSeconds(at), "-i", src, "-frames:v", "1"}
+	if strings.EqualFold(filepath.Ext(dst), ".jpg") {
+		args = append(args, "-q:v", "2")
+	}
+	tmpPath := strings.TrimSuffix(dst, filepath.Ext(dst)) + ".partial" + filepath.Ext(dst)
+	if err := runTool(ctx, ffmpeg, append(args, tmpPath)...); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, dst)
+}
+
+// runPosterCommand picks a poster frame for a downloaded video and saves it
+// next to the video as <id>_poster.jpg.
+func runPosterCommand(args []string) int {
+	fs := newCommandFlagSet("poster")
+	count := fs.Int("candidates", defaultPosterCandidates, "number of frames to choose from")
+	frame := fs.Int("frame", 0, "take candidate n without asking")
+	at := fs.Duration("at", -1, "take the frame at this offset, e.g. 3.5s, without asking")
+	format := fs.String("format", "jpg", "image format: jpg or png")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli poster [--candidates n] [--frame n | --at offset] [--format jpg|png] <video-id|file>")
+		return 2
+	}
+	if *count < 1 || *count > maxPosterCandidates {
+		logError("--candidates must be between 1 and %d", maxPosterCandidates)
+		return 2
+	}
+	if *frame < 0 || *frame > *count {
+		logError("--frame must be between 1 and %d", *count)
+		return 2
+	}
+	if *frame > 0 && *at >= 0 {
+		logError("--frame and --at cannot be combined")
+		return 2
+	}
+	if *format != "jpg" && *format != "png" {
+		logError("unknown --format %q; use jpg or png", *format)
+		return 2
+	}
+	if *frame == 0 && *at < 0 && !stdinIsTerminal() {
+		logError("choosing a frame needs a terminal; use --frame or --at")
+		return 2
+	}
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	src, jobID := fs.Arg(0), ""
+	if info, err := os.Stat(src); err != nil || info.IsDir() {
+		state, err := loadHistory()
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+		entry := state.find(fs.Arg(0))
+		if entry == nil || entry.OutputPath == "" {
+			logError("%s: no such file and no downloaded video in history", fs.Arg(0))
+			return 1
+		}
+		src, jobID = entry.OutputPath, entry.JobID
+	}
+	ffmpeg, err := lookupFFmpeg(cfg.Grade.FFmpeg, "grade.ffmpeg")
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	chosen := *at
+	if chosen < 0 {
+		dir, err := os.MkdirTemp("", "sora2cli-poster-")
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+		defer os.RemoveAll(dir)
+		logInfo("Extracting %d candidate frames...", *count)
+		candidates, err := extractPosterCandidates(ctx, ffmpeg, src, dir, *count)
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+		idx := *frame - 1
+		if idx < 0 {
+			if preview := detectImagePreview(); preview != previewNone {
+				idx, err = pickPosterWithKeys(preview, candidates)
+				if err != nil {
+					logError("%v", err)
+					return 1
+				}
+			} else {
+				idx = pickPosterByNumber(bufio.NewReader(os.Stdin), dir, candidates)
+			}
+			if idx < 0 {
+				logInfo("No poster chosen.")
+				return 0
+			}
+		}
+		chosen = candidates[idx].At
+	}
+
+	dst := strings.TrimSuffix(src, filepath.Ext(src)) + "_poster." + *format
+	if err := savePoster(ctx, ffmpeg, src, dst, chosen); err != nil {
+		logError("%v", err)
+		return 1
+	}
+	logInfo("Saved the frame at %ss as the poster: %s", formatSeconds(chosen), dst)
+	if jobID != "" {
+		recordDerived(jobID, "poster", dst)
+	}
+	return 0
+}
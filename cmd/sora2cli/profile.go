@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// profileConfig is one named account under profiles. Its values replace the
+// top-level ones when the profile is selected.
+type profileConfig struct {
+	APIKey      string `yaml:"api_key,omitempty" secret:"true"`
+	BaseURL     string `yaml:"base_url,omitempty"`
+	OrgID       string `yaml:"org_id,omitempty"`
+	ProjectID   string `yaml:"project_id,omitempty"`
+	Model       string `yaml:"model,omitempty"`
+	Destination string `yaml:"destination,omitempty"`
+}
+
+// selectedProfile is the --profile given on the command line. It wins over
+// SORA2_PROFILE and the profile key of the config files.
+var selectedProfile string
+
+func registerProfileFlag(fs *flag.FlagSet) {
+	// A Func flag leaves selectedProfile alone unless given, so a profile
+	// taken from before the command survives the command's own flag set.
+	fs.Func("profile", "use profile `name` from the config file (default: the profile key)", func(name string) error {
+		if name == "" {
+			return fmt.Errorf("profile name must not be empty")
+		}
+		selectedProfile = name
+		return nil
+	})
+}
+
+// splitProfileArg takes a leading --profile off args, so the profile can
+// also be given before the command and for the interactive menu.
+func splitProfileArg(args []string) ([]string, error) {
+	if len(args) == 0 {
+		return args, nil
+	}
+	for _, prefix := range []string{"--profile", "-profile"} {
+		switch {
+		case args[0] == prefix:
+			if len(args) < 2 || args[1] == "" {
+				return nil, fmt.Errorf("%s needs a profile name", prefix)
+			}
+			selectedProfile = args[1]
+			return args[2:], nil
+		case strings.HasPrefix(args[0], prefix+"="):
+			selectedProfile = strings.TrimPrefix(args[0], prefix+"=")
+			return args[1:], nil
+		}
+	}
+	return args, nil
+}
+
+// applyProfile overlays the selected profile. It runs after the environment
+// overrides: a profile is chosen for one run, while OPENAI_API_KEY and
+// friends usually come from a shell or .env that serves every account.
+func applyProfile(resolved *resolvedConfig) error {
+	name := selectedProfile
+	if name != "" {
+		resolved.Profile = name
+		resolved.Sources["profile"] = "--profile"
+	}
+	name = resolved.Profile
+	if name == "" {
+		return nil
+	}
+	profile, ok := resolved.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q; configured: %s", name, strings.Join(profileNames(resolved.Profiles), ", "))
+	}
+	source := "profile " + name
+	set := func(key string, dst *string, value string) {
+		if value != "" {
+			*dst = value
+			resolved.Sources[key] = source
+		}
+	}
+	set("api_key", &resolved.APIKey, profile.APIKey)
+	set("org_id", &resolved.OrgID, profile.OrgID)
+	set("project_id", &resolved.ProjectID, profile.ProjectID)
+	set("defaults.model", &resolved.Defaults.Model, profile.Model)
+	set("defaults.destination", &resolved.Defaults.Destination, profile.Destination)
+	if profile.BaseURL != "" {
+		// The failover list belongs to the account it was written for.
+		resolved.BaseURLs = nil
+		delete(resolved.Sources, "base_urls")
+		set("base_url", &resolved.BaseURL, profile.BaseURL)
+	}
+	return nil
+}
+
+func profileNames(profiles map[string]profileConfig) []string {
+	if len(profiles) == 0 {
+		return []string{"none"}
+	}
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
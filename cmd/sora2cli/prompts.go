@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const promptLibraryFileName = "prompts.json"
+
+const promptsUsage = "usage: sora2cli prompts <add|list|show|rm> ..."
+
+// savedPrompt is a favourite prompt kept in the library for reuse.
+type savedPrompt struct {
+	Name      string    `json:"name"`
+	Prompt    string    `json:"prompt"`
+	Tags      []string  `json:"tags,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// LastUsedAt is when the prompt was last picked in the interactive
+	// create flow.
+	LastUsedAt time.Time `json:"last_used_at,omitzero"`
+}
+
+type promptLibrary struct {
+	Prompts []*savedPrompt `json:"prompts"`
+}
+
+// promptNamePattern is what a saved prompt may be called: something that is
+// easy to type as an argument.
+var promptNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+func promptLibraryPath() (string, error) {
+	dir, err := resolveDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, promptLibraryFileName), nil
+}
+
+// loadPromptLibrary returns the saved prompts sorted by name.
+func loadPromptLibrary() (*promptLibrary, error) {
+	path, err := promptLibraryPath()
+	if err != nil {
+		return nil, err
+	}
+	library := &promptLibrary{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return library, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, library); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	sort.Slice(library.Prompts, func(i, j int) bool { return library.Prompts[i].Name < library.Prompts[j].Name })
+	return library, nil
+}
+
+// updatePromptLibrary applies fn to the library under its lock and saves
+// the result unless fn fails.
+func updatePromptLibrary(fn func(*promptLibrary) error) error {
+	path, err := promptLibraryPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	lock, err := lockFile(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+	library, err := loadPromptLibrary()
+	if err != nil {
+		return err
+	}
+	if err := fn(library); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(library, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func (l *promptLibrary) find(name string) *savedPrompt {
+	for _, p := range l.Prompts {
+		if strings.EqualFold(p.Name, name) {
+			return p
+		}
+	}
+	return nil
+}
+
+// defaultPromptName makes a name from the first words of prompt, numbered
+// if the library already has it.
+func (l *promptLibrary) defaultPromptName(prompt string) string {
+	words := strings.FieldsFunc(strings.ToLower(prompt), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	})
+	if len(words) > 4 {
+		words = words[:4]
+	}
+	base := strings.Join(words, "-")
+	if base == "" {
+		base = "prompt"
+	}
+	name := base
+	for n := 2; l.find(name) != nil; n++ {
+		name = base + "-" + strconv.Itoa(n)
+	}
+	return name
+}
+
+func runPromptsCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, promptsUsage)
+		return 2
+	}
+	if isHelpArg(args[0]) {
+		return runHelpCommand([]string{"prompts"})
+	}
+	switch args[0] {
+	case "add":
+		return runPromptsAdd(args[1:])
+	case "list":
+		return runPromptsList(args[1:])
+	case "show":
+		return runPromptsShow(args[1:])
+	case "rm":
+		return runPromptsRemove(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown prompts command %q\n", args[0])
+		fmt.Fprintln(os.Stderr, promptsUsage)
+		return 2
+	}
+}
+
+func promptsSubcommand(name string) func([]string) int {
+	return func(args []string) int { return runPromptsCommand(append([]string{name}, args...)) }
+}
+
+func runPromptsAdd(args []string) int {
+	fs := newCommandFlagSet("prompts add")
+	name := fs.String("name", "", "name to show and pick the prompt by (default: its first words)")
+	var tags []string
+	fs.Var((*stringList)(&tags), "tag", "label the prompt, e.g. product; repeat for several")
+	force := fs.Bool("force", false, "replace a saved prompt of the same name")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if *name != "" && !promptNamePattern.MatchString(*name) {
+		logError("invalid name %q; use letters, digits and _ . - (at most 64)", *name)
+		return 2
+	}
+	if err := validateTags(tags); err != nil {
+		logError("%v", err)
+		return 2
+	}
+
+	var prompt string
+	switch {
+	case fs.NArg() == 1 && fs.Arg(0) == "-":
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			logError("read prompt: %v", err)
+			return 1
+		}
+		prompt = string(data)
+	case fs.NArg() > 0:
+		prompt = strings.Join(fs.Args(), " ")
+	case stdinIsTerminal():
+		prompt = promptText(bufio.NewReader(os.Stdin), os.Stdout, "Prompt")
+	default:
+		fmt.Fprintln(os.Stderr, "usage: sora2cli prompts add [--name name] [--tag tag]... <prompt|->")
+		return 2
+	}
+	if prompt = strings.TrimSpace(prompt); prompt == "" {
+		logError("the prompt is empty")
+		return 2
+	}
+
+	var saved *savedPrompt
+	err := updatePromptLibrary(func(l *promptLibrary) error {
+		if *name == "" {
+			*name = l.defaultPromptName(prompt)
+		}
+		if existing := l.find(*name); existing != nil {
+			if !*force {
+				return fmt.Errorf("a prompt named %s is already saved; pass --force to replace it", existing.Name)
+			}
+			existing.Prompt, existing.Tags = prompt, tags
+			saved = existing
+			return nil
+		}
+		saved = &savedPrompt{Name: *name, Prompt: prompt, Tags: tags, CreatedAt: time.Now().UTC()}
+		l.Prompts = append(l.Prompts, saved)
+		return nil
+	})
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	logInfo("Saved prompt %s", saved.Name)
+	return 0
+}
+
+func runPromptsList(args []string) int {
+	fs := newCommandFlagSet("prompts list")
+	var tags []string
+	fs.Var((*stringList)(&tags), "tag", "only prompts with this tag; repeat to require several")
+	contains := fs.String("contains", "", "only prompts whose text contains this, ignoring case")
+	jsonOutput := fs.Bool("json", false, "print the prompts as JSON")
+	registerFormatFlag(fs, jsonOutput)
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		logError("unexpected argument %q", fs.Arg(0))
+		return 2
+	}
+	library, err := loadPromptLibrary()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	matches := []*savedPrompt{}
+	for _, p := range library.Prompts {
+		if p.hasTags(tags) && strings.Contains(strings.ToLower(p.Prompt), strings.ToLower(*contains)) {
+			matches = append(matches, p)
+		}
+	}
+	if *jsonOutput {
+		enableJSONOutput()
+		emitJSON(matches)
+		return 0
+	}
+	if len(matches) == 0 {
+		if len(library.Prompts) == 0 {
+			logInfo("No saved prompts. Add one with 'sora2cli prompts add'.")
+		} else {
+			logInfo("No saved prompts match.")
+		}
+		return 0
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTAGS\tPROMPT")
+	for _, p := range matches {
+		tagList := strings.Join(p.Tags, ",")
+		if tagList == "" {
+			tagList = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", p.Name, tagList, truncateText(p.Prompt, 60))
+	}
+	tw.Flush()
+	return 0
+}
+
+// hasTags reports whether the prompt carries every one of tags.
+func (p *savedPrompt) hasTags(tags []string) bool {
+	for _, want := range tags {
+		found := false
+		for _, tag := range p.Tags {
+			if tag == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// runPromptsShow prints a saved prompt's text alone, so it can be passed on
+// as in create --prompt "$(sora2cli prompts show hero)".
+func runPromptsShow(args []string) int {
+	fs := newCommandFlagSet("prompts show")
+	jsonOutput := fs.Bool("json", false, "print the prompt with its name, tags and dates as JSON")
+	registerFormatFlag(fs, jsonOutput)
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli prompts show [--json] <name>")
+		return 2
+	}
+	library, err := loadPromptLibrary()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	p := library.find(fs.Arg(0))
+	if p == nil {
+		logError("no saved prompt named %s", fs.Arg(0))
+		return 1
+	}
+	if *jsonOutput {
+		enableJSONOutput()
+		emitJSON(p)
+		return 0
+	}
+	fmt.Println(p.Prompt)
+	return 0
+}
+
+func runPromptsRemove(args []string) int {
+	fs := newCommandFlagSet("prompts rm")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli prompts rm <name>...")
+		return 2
+	}
+	var removed []string
+	err := updatePromptLibrary(func(l *promptLibrary) error {
+		for _, name := range fs.Args() {
+			if l.find(name) == nil {
+				return fmt.Errorf("no saved prompt named %s", name)
+			}
+		}
+		kept := l.Prompts[:0]
+		for _, p := range l.Prompts {
+			drop := false
+			for _, name := range fs.Args() {
+				drop = drop || strings.EqualFold(p.Name, name)
+			}
+			if drop {
+				removed = append(removed, p.Name)
+				continue
+			}
+			kept = append(kept, p)
+		}
+		l.Prompts = kept
+		return nil
+	})
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	for _, name := range removed {
+		logInfo("Removed prompt %s", name)
+	}
+	return 0
+}
+
+// promptLibraryChoice offers the saved prompts before the templates and a
+// free-form prompt. It returns "" when the user does not pick one.
+func promptLibraryChoice(reader *bufio.Reader, out io.Writer) string {
+	library, err := loadPromptLibrary()
+	if err != nil {
+		logWarn("unable to read saved prompts: %v", err)
+		return ""
+	}
+	if len(library.Prompts) == 0 {
+		return ""
+	}
+	for {
+		fmt.Fprintln(out, "Pick from saved prompts?")
+		fmt.Fprintln(out, "  0) No (default)")
+		for i, p := range library.Prompts {
+			fmt.Fprintf(out, "  %d) %s: %s\n", i+1, p.Name, truncateText(p.Prompt, 60))
+		}
+		fmt.Fprintf(out, "Enter choice (0-%d): ", len(library.Prompts))
+		input, err := reader.ReadString('\n')
+		if err != nil {
+			logError("input error: %v", err)
+			continue
+		}
+		input = strings.TrimSpace(input)
+		if input == "" || input == "0" {
+			return ""
+		}
+		picked := library.find(input)
+		if idx, convErr := strconv.Atoi(input); convErr == nil && idx >= 1 && idx <= len(library.Prompts) {
+			picked = library.Prompts[idx-1]
+		}
+		if picked == nil {
+			fmt.Fprintln(out, "Invalid selection, please try again.")
+			continue
+		}
+		name := picked.Name
+		if err := updatePromptLibrary(func(l *promptLibrary) error {
+			if p := l.find(name); p != nil {
+				p.LastUsedAt = time.Now().UTC()
+			}
+			return nil
+		}); err != nil {
+			logWarn("unable to update saved prompt %s: %v", name, err)
+		}
+		return picked.Prompt
+	}
+}
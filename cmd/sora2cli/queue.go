@@ -0,0 +1,525 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+const (
+	queueItemPending   = "pending"
+	queueItemApproved  = "approved"
+	queueItemRunning   = "running"
+	queueItemCompleted = "completed"
+	queueItemFailed    = "failed"
+)
+
+type queueItem struct {
+	ID            int       `json:"id"`
+	Prompt        string    `json:"prompt"`
+	Model         string    `json:"model"`
+	Seconds       int       `json:"seconds"`
+	Size          string    `json:"size"`
+	Reference     string    `json:"reference,omitempty"`
+	References    []string  `json:"references,omitempty"`
+	Ticket        string    `json:"ticket,omitempty"`
+	Status        string    `json:"status"`
+	EstimatedCost float64   `json:"estimated_cost"`
+	JobID         string    `json:"job_id,omitempty"`
+	OutputPath    string    `json:"output_path,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	AddedAt       time.Time `json:"added_at"`
+	FinishedAt    time.Time `json:"finished_at,omitzero"`
+	ExportedAt    time.Time `json:"exported_at,omitzero"`
+}
+
+type queueState struct {
+	NextID int          `json:"next_id"`
+	Items  []*queueItem `json:"items"`
+}
+
+type queueStore struct {
+	mu    sync.Mutex
+	path  string
+	state queueState
+}
+
+type queueSettings struct {
+	Name          string
+	Tickets       ticketsConfig
+	Notifications notificationsConfig
+	DAM           damConfig
+	Review        reviewConfig
+	Extras        extraOutputs
+	// OffPeak, if set, holds every submission until the window is open.
+	OffPeak *offPeakWindow
+	// Status, if set, checks the status page before every submission.
+	Status *statusGate
+	// Spend books every job against the configured spend caps.
+	Spend *budgetGuard
+	queueConfig
+}
+
+func isValidQueueName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveQueue returns the settings for a named queue, filling anything the
+// queue leaves blank from the global defaults. Queues that are not configured
+// still work with the defaults so ad-hoc names can be used.
+func resolveQueue(cfg *resolvedConfig, name string) (queueSettings, error) {
+	if !isValidQueueName(name) {
+		return queueSettings{}, fmt.Errorf("invalid queue name %q", name)
+	}
+	q := cfg.Queues[name]
+	if q.Model == "" {
+		q.Model = cfg.Defaults.Model
+	}
+	if q.Seconds == 0 {
+		q.Seconds = cfg.Defaults.Seconds
+	}
+	if q.Destination == "" {
+		q.Destination = cfg.Defaults.Destination
+	}
+	if q.Concurrency <= 0 {
+		q.Concurrency = 1
+	}
+	status, err := newStatusGate(cfg.Status, "")
+	if err != nil {
+		return queueSettings{}, err
+	}
+	return queueSettings{
+		Name:          name,
+		Tickets:       cfg.Tickets,
+		Notifications: cfg.Notifications,
+		DAM:           cfg.DAM,
+		Review:        cfg.Review,
+		Extras:        extraFlags{}.outputs(cfg),
+		Status:        status,
+		queueConfig:   q,
+	}, nil
+}
+
+func queueStorePath(name string) (string, error) {
+	dir, err := resolveDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "queues", name+".json"), nil
+}
+
+func openQueueStore(name string) (*queueStore, error) {
+	path, err := queueStorePath(name)
+	if err != nil {
+		return nil, err
+	}
+	store := &queueStore{path: path}
+	if err := store.reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// reload reads the state other processes may have saved. Items already
+// loaded keep their pointers, so callers holding them see the new values.
+func (s *queueStore) reload() error {
+	state := queueState{NextID: 1}
+	data, err := os.ReadFile(s.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("parse %s: %w", s.path, err)
+		}
+	}
+	loaded := make(map[int]*queueItem, len(s.state.Items))
+	for _, item := range s.state.Items {
+		loaded[item.ID] = item
+	}
+	for i, item := range state.Items {
+		if existing := loaded[item.ID]; existing != nil {
+			*existing = *item
+			state.Items[i] = existing
+		}
+	}
+	s.state = state
+	return nil
+}
+
+// modify applies fn to the current state and saves it, holding the store's
+// lock file so that changes made by other processes, such as an item added
+// while the queue runs, are neither lost nor overwritten.
+func (s *queueStore) modify(fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+// view calls fn with the current state, as saved by any process, without
+// changing it.
+func (s *queueStore) view(fn func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+	fn()
+	return nil
+}
+
+func (s *queueStore) save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+func (s *queueStore) add(item *queueItem) error {
+	return s.modify(func() error {
+		item.ID = s.state.NextID
+		s.state.NextID++
+		s.state.Items = append(s.state.Items, item)
+		return nil
+	})
+}
+
+func (s *queueStore) find(id int) *queueItem {
+	for _, item := range s.state.Items {
+		if item.ID == id {
+			return item
+		}
+	}
+	return nil
+}
+
+var (
+	// errQueueItemGone means the item was removed from the queue, usually by
+	// another process, since it was loaded.
+	errQueueItemGone = errors.New("no longer in the queue")
+	// errQueueItemClaimed means the item is no longer waiting to run.
+	errQueueItemClaimed = errors.New("no longer waiting to run")
+)
+
+func (s *queueStore) update(item *queueItem, apply func(*queueItem)) error {
+	return s.modify(func() error {
+		if s.find(item.ID) != item {
+			return fmt.Errorf("item #%d: %w", item.ID, errQueueItemGone)
+		}
+		apply(item)
+		return nil
+	})
+}
+
+// remove deletes the item with id unless keep, given its current state,
+// says otherwise. It reports whether the item was removed.
+func (s *queueStore) remove(id int, keep func(*queueItem) bool) (bool, error) {
+	removed := false
+	err := s.modify(func() error {
+		for i, item := range s.state.Items {
+			if item.ID == id {
+				if keep != nil && keep(item) {
+					return nil
+				}
+				s.state.Items = append(s.state.Items[:i], s.state.Items[i+1:]...)
+				removed = true
+				return nil
+			}
+		}
+		return nil
+	})
+	return removed, err
+}
+
+func (s *queueStore) spent() float64 {
+	total := 0.0
+	for _, item := range s.state.Items {
+		if item.Status == queueItemCompleted || item.Status == queueItemRunning {
+			total += item.EstimatedCost
+		}
+	}
+	return total
+}
+
+func (s *queueStore) counts() map[string]int {
+	counts := make(map[string]int)
+	for _, item := range s.state.Items {
+		counts[item.Status]++
+	}
+	return counts
+}
+
+func (s *queueStore) runnable(requireApproval bool) []*queueItem {
+	var items []*queueItem
+	for _, item := range s.state.Items {
+		switch item.Status {
+		case queueItemApproved:
+			items = append(items, item)
+		case queueItemPending:
+			if !requireApproval {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// assetRecord describes a completed item for the DAM. job may be nil when the
+// item is exported later from the queue state alone.
+func (item *queueItem) assetRecord(job *sora.Video) assetRecord {
+	record := assetRecord{
+		JobID:         item.JobID,
+		Status:        item.Status,
+		Prompt:        item.Prompt,
+		Model:         item.Model,
+		Seconds:       item.Seconds,
+		Size:          item.Size,
+		EstimatedCost: item.EstimatedCost,
+		OutputPath:    item.OutputPath,
+		CreatedAt:     item.AddedAt,
+		CompletedAt:   item.FinishedAt,
+		Ticket:        item.Ticket,
+	}
+	if job != nil {
+		record.JobID = job.ID
+		record.RemixedFrom = job.RemixedFromVideoID
+		if job.CreatedAt > 0 {
+			record.CreatedAt = time.Unix(job.CreatedAt, 0)
+		}
+	}
+	return record
+}
+
+// runResult tallies one queue or batch run.
+type runResult struct {
+	Completed     int
+	Failed        int
+	Skipped       int
+	EstimatedCost float64
+	OutputBytes   int64
+}
+
+// runQueue submits runnable items with at most q.Concurrency jobs in flight.
+// Items that would push the queue's spend over its budget, or the run over
+// the configured spend caps, stay pending.
+func runQueue(ctx context.Context, client *sora.Client, q queueSettings, store *queueStore) (runResult, error) {
+	var result runResult
+
+	destination, err := resolveOutputDir(q.Destination)
+	if err != nil {
+		return result, err
+	}
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, q.Concurrency)
+	)
+	spent := store.spent()
+	for _, item := range store.runnable(q.RequireApproval) {
+		if q.Budget > 0 && spent+item.EstimatedCost > q.Budget+1e-9 {
+			logWarn("[%s #%d] skipped: $%.2f would exceed the $%.2f budget ($%.2f spent)", q.Name, item.ID, item.EstimatedCost, q.Budget, spent)
+			result.Skipped++
+			continue
+		}
+		label := fmt.Sprintf("[%s #%d]", q.Name, item.ID)
+		if err := q.Spend.reserve(label+" ", item.EstimatedCost); err != nil {
+			logWarn("%s skipped: %v", label, err)
+			result.Skipped++
+			continue
+		}
+		spent += item.EstimatedCost
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return result, ctx.Err()
+		}
+		if ctx.Err() != nil {
+			<-sem
+			wg.Wait()
+			return result, ctx.Err()
+		}
+		if q.OffPeak != nil {
+			if err := waitForOffPeak(ctx, *q.OffPeak, fmt.Sprintf("[%s #%d]", q.Name, item.ID)); err != nil {
+				<-sem
+				wg.Wait()
+				return result, err
+			}
+		}
+		if err := q.Status.wait(ctx, label); err != nil {
+			<-sem
+			wg.Wait()
+			return result, err
+		}
+		wg.Add(1)
+		go func(item *queueItem) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer handleCrash()
+			job, err := runQueueItem(ctx, client, q, destination, store, item)
+			mu.Lock()
+			defer mu.Unlock()
+			if errors.Is(err, errQueueItemGone) || errors.Is(err, errQueueItemClaimed) {
+				logWarn("[%s #%d] skipped: %v", q.Name, item.ID, err)
+				q.Spend.release(item.EstimatedCost)
+				result.Skipped++
+				return
+			}
+			if errors.Is(err, errInterrupted) {
+				logWarn("[%s #%d] interrupted: %v", q.Name, item.ID, err)
+				return
+			}
+			if err != nil {
+				logError("[%s #%d] failed: %v", q.Name, item.ID, err)
+				if unbilled(item.JobID, job) {
+					q.Spend.release(item.EstimatedCost)
+				}
+				result.Failed++
+				return
+			}
+			result.Completed++
+			result.EstimatedCost += item.EstimatedCost
+			if info, err := os.Stat(item.OutputPath); err == nil {
+				result.OutputBytes += info.Size()
+			}
+		}(item)
+	}
+	wg.Wait()
+	return result, nil
+}
+
+// runQueueItem claims and renders one item. It returns the job as far as it
+// got, so a failure can be told apart from one that is paid for.
+func runQueueItem(ctx context.Context, client *sora.Client, q queueSettings, destination string, store *queueStore, item *queueItem) (*sora.Video, error) {
+	label := fmt.Sprintf("[%s #%d]", q.Name, item.ID)
+	event := ticketEvent{
+		Ticket:        item.Ticket,
+		Prompt:        item.Prompt,
+		Model:         item.Model,
+		Seconds:       item.Seconds,
+		EstimatedCost: item.EstimatedCost,
+	}
+	fail := func(err error) error {
+		event.JobID = item.JobID
+		event.Status = "failed"
+		event.Error = err.Error()
+		reportTicketOrWarn(q.Tickets, event)
+		notifyJobOrWarn(client, q.Notifications, event)
+		if saveErr := store.update(item, func(it *queueItem) {
+			it.Status = queueItemFailed
+			it.Error = err.Error()
+			it.FinishedAt = time.Now()
+		}); saveErr != nil {
+			logWarn("%s unable to save queue state: %v", label, saveErr)
+		}
+		return err
+	}
+
+	// Claim the item against the saved state, in case it was removed or
+	// changed since the run started.
+	claimed := false
+	var claimedFrom string
+	if err := store.update(item, func(it *queueItem) {
+		if it.Status != queueItemPending && it.Status != queueItemApproved {
+			return
+		}
+		claimedFrom = it.Status
+		it.Status = queueItemRunning
+		it.Error = ""
+		claimed = true
+	}); err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, fmt.Errorf("item #%d is %s, %w", item.ID, item.Status, errQueueItemClaimed)
+	}
+
+	spec := jobSpec{
+		Prompt:     item.Prompt,
+		Model:      item.Model,
+		Seconds:    item.Seconds,
+		Size:       item.Size,
+		Reference:  item.Reference,
+		References: item.References,
+		Ticket:     item.Ticket,
+	}
+	job, outputPath, err := renderJob(ctx, client, spec, destination, q.Extras, label, "queue "+q.Name, func(jobID string) {
+		if err := store.update(item, func(it *queueItem) { it.JobID = jobID }); err != nil {
+			logWarn("%s unable to save queue state: %v", label, err)
+		}
+	})
+	if errors.Is(err, errInterrupted) {
+		// A submitted job stays running for recover to settle; an item that
+		// was never submitted goes back to where it was.
+		if item.JobID == "" {
+			if saveErr := store.update(item, func(it *queueItem) { it.Status = claimedFrom }); saveErr != nil {
+				logWarn("%s unable to save queue state: %v", label, saveErr)
+			}
+		}
+		return job, err
+	}
+	if err != nil {
+		return job, fail(err)
+	}
+	event.JobID = job.ID
+	event.Status = "completed"
+	event.OutputPath = outputPath
+	reportTicketOrWarn(q.Tickets, event)
+	notifyJobOrWarn(client, q.Notifications, event)
+	if err := store.update(item, func(it *queueItem) {
+		it.Status = queueItemCompleted
+		it.OutputPath = outputPath
+		it.FinishedAt = time.Now()
+	}); err != nil {
+		return job, err
+	}
+	if exportAssetOrWarn(q.DAM, q.Review, item.assetRecord(job)) {
+		return job, store.update(item, func(it *queueItem) { it.ExportedAt = time.Now() })
+	}
+	return job, nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+// pendingJobWindow is how long a job that was submitted but never
+// downloaded is offered for recovery. The API keeps finished videos for a
+// limited time; older jobs are left to audit-remote.
+const pendingJobWindow = 24 * time.Hour
+
+// awaitDownload marks the entry as submitted from this machine and not yet
+// downloaded into destination. A session that ends before the download, by
+// a crash, a closed laptop lid or Ctrl+C, leaves the mark for recover.
+func (e *historyEntry) awaitDownload(destination string) {
+	e.Pending = true
+	e.Destination = destination
+}
+
+// watchJob takes the lock that tells other processes this one is polling or
+// downloading jobID, so they do not offer to recover it. The OS drops the
+// lock when the process exits, however it exits. It returns nil if another
+// process holds the lock already; unlock is safe on nil.
+func watchJob(jobID string) *fileLock {
+	path, err := runLockPath("job", jobID)
+	if err != nil {
+		return nil
+	}
+	lock, err := tryLockFile(path)
+	if err != nil {
+		return nil
+	}
+	return lock
+}
+
+// pendingJobs returns the jobs submitted from this machine that were never
+// downloaded and that no running process is watching, oldest first.
+func pendingJobs() ([]*historyEntry, error) {
+	state, err := loadHistory()
+	if err != nil {
+		return nil, err
+	}
+	var pending []*historyEntry
+	for _, entry := range state.Entries {
+		if !entry.Pending || !entry.DeletedAt.IsZero() || time.Since(entry.CreatedAt) > pendingJobWindow {
+			continue
+		}
+		lock := watchJob(entry.JobID)
+		if lock == nil {
+			continue
+		}
+		lock.unlock()
+		pending = append(pending, entry)
+	}
+	return pending, nil
+}
+
+func printPendingJobs(entries []*historyEntry) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOB\tSOURCE\tSUBMITTED\tLAST STATUS\tPROMPT")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", e.JobID, e.Source, formatTimestamp(e.CreatedAt), e.Status, truncateText(e.Prompt, 40))
+	}
+	tw.Flush()
+}
+
+// recoverJob picks up where an earlier session left the entry's job: it
+// polls the job until it finishes, downloads it into the destination the
+// session meant to use, and settles the queue item the job came from.
+func recoverJob(ctx context.Context, client *sora.Client, cfg *resolvedConfig, entry *historyEntry) error {
+	lock := watchJob(entry.JobID)
+	if lock == nil {
+		return fmt.Errorf("another process has picked up %s", entry.JobID)
+	}
+	defer lock.unlock()
+	label := fmt.Sprintf("[%s]", entry.JobID)
+
+	job, err := client.Get(ctx, entry.JobID)
+	if err != nil {
+		return fmt.Errorf("get video: %w", err)
+	}
+	if !job.Done() {
+		logInfo("%s %s", label, formatProgress(job))
+		if job, err = client.Wait(ctx, entry.JobID, func(job *sora.Video) {
+			logInfo("%s %s", label, formatProgress(job))
+		}); err != nil && (job == nil || interrupted(ctx)) {
+			return err
+		}
+	}
+	if job.Status != "completed" {
+		err := fmt.Errorf("job %s", job.Status)
+		if job.Error != nil && job.Error.Message != "" {
+			err = fmt.Errorf("job %s: %s", job.Status, job.Error.Message)
+		}
+		recordJobHistory(job, entry.Source, nil)
+		markHistoryFailed(job.ID, err)
+		settleQueueItem(entry, "", err)
+		return err
+	}
+
+	destination := entry.Destination
+	if destination == "" {
+		destination = cfg.Defaults.Destination
+	}
+	destination, err = prepareDestinationDirectory(destination)
+	if err != nil {
+		return err
+	}
+	extras := extraFlags{}.outputs(cfg)
+	outputPath, err := extras.outputPath(destination, job, entry.Tags)
+	if err != nil {
+		return err
+	}
+	if err := client.DownloadFile(ctx, job.ID, outputPath); err != nil {
+		return fmt.Errorf("download video: %w", err)
+	}
+	logInfo("%s saved to %s", label, outputPath)
+	downloadExtras(ctx, client, job, outputPath, extras)
+	markHistoryCompleted(job, entry.Source, outputPath)
+	settleQueueItem(entry, outputPath, nil)
+	return nil
+}
+
+// settleQueueItem marks the queue item that submitted the entry's job, if
+// any, as completed or failed, since the run that would have done so is gone.
+func settleQueueItem(entry *historyEntry, outputPath string, jobErr error) {
+	name, ok := strings.CutPrefix(entry.Source, "queue ")
+	if !ok {
+		return
+	}
+	store, err := openQueueStore(name)
+	if err == nil {
+		err = store.modify(func() error {
+			for _, item := range store.state.Items {
+				if item.JobID != entry.JobID {
+					continue
+				}
+				item.FinishedAt = time.Now()
+				if jobErr != nil {
+					item.Status, item.Error = queueItemFailed, jobErr.Error()
+				} else {
+					item.Status, item.OutputPath, item.Error = queueItemCompleted, outputPath, ""
+				}
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		logWarn("unable to update queue %s: %v", name, err)
+	}
+}
+
+// recoverJobs recovers entries one after the other and returns how many
+// failed. A signal stops it, leaving the rest pending.
+func recoverJobs(ctx context.Context, client *sora.Client, cfg *resolvedConfig, entries []*historyEntry) int {
+	ctx, stop := shutdownContext(ctx)
+	defer stop()
+	failed := 0
+	for i, entry := range entries {
+		if interrupted(ctx) {
+			logInfo("Interrupted; %d job(s) left for 'sora2cli recover'.", len(entries)-i)
+			return failed + len(entries) - i
+		}
+		if err := recoverJob(ctx, client, cfg, entry); err != nil {
+			logError("[%s] not recovered: %v", entry.JobID, err)
+			failed++
+		}
+	}
+	return failed
+}
+
+// offerRecovery asks at the start of an interactive session whether to
+// finish the jobs an earlier session left behind.
+func offerRecovery(reader *bufio.Reader, client *sora.Client, cfg *resolvedConfig) {
+	entries, err := pendingJobs()
+	if err != nil {
+		logWarn("unable to check for unfinished jobs: %v", err)
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+	fmt.Printf("%d job(s) from an earlier session were submitted but never downloaded:\n", len(entries))
+	printPendingJobs(entries)
+	if !promptConfirm(reader, os.Stdout, "Resume polling and download them now?") {
+		logInfo("Run 'sora2cli recover' to pick them up later.")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), maxWaitDuration)
+	defer cancel()
+	recoverJobs(ctx, client, cfg, entries)
+}
+
+// runRecoverCommand finishes the jobs earlier sessions submitted but never
+// downloaded.
+func runRecoverCommand(args []string) int {
+	fs := newCommandFlagSet("recover")
+	registerMaxWaitFlag(fs)
+	list := fs.Bool("list", false, "list the unfinished jobs without resuming them")
+	assumeYes := fs.Bool("yes", false, "skip the confirmation prompt")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli recover [--list] [--yes]")
+		return 2
+	}
+	entries, err := pendingJobs()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	if len(entries) == 0 {
+		logInfo("No unfinished jobs.")
+		return 0
+	}
+	printPendingJobs(entries)
+	if *list {
+		return 0
+	}
+	session, err := newAPISession(*assumeYes)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	if !*assumeYes {
+		if !stdinIsTerminal() {
+			logError("stdin is not a terminal; pass --yes to resume without confirmation")
+			return 2
+		}
+		if !promptConfirm(session.reader, os.Stdout, fmt.Sprintf("Resume polling and download %d job(s)?", len(entries))) {
+			logInfo("Aborted.")
+			return 1
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), maxWaitDuration)
+	defer cancel()
+	if failed := recoverJobs(ctx, session.client, session.cfg, entries); failed > 0 {
+		logError("%d of %d job(s) not recovered", failed, len(entries))
+		return 1
+	}
+	logInfo("Recovered %d job(s).", len(entries))
+	return 0
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// outputContainers are the containers a download can be converted to. MOV
+// and MKV take the MP4's streams as they are; WebM needs VP9 and Opus, so it
+// is a transcode.
+var outputContainers = []string{"mp4", "mov", "mkv", "webm"}
+
+func validateOutputContainer(name string) error {
+	for _, c := range outputContainers {
+		if name == c {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown container %q; use %s", name, strings.Join(outputContainers, ", "))
+}
+
+// remuxVideo writes a copy of the MP4 at path in container and returns
+// where it went, as in <id>.mov. An existing copy is replaced.
+func remuxVideo(ctx context.Context, ffmpeg, container, path string) (string, error) {
+	if err := validateOutputContainer(container); err != nil {
+		return "", err
+	}
+	ffmpeg, err := lookupFFmpeg(ffmpeg, "grade.ffmpeg")
+	if err != nil {
+		return "", err
+	}
+	outPath := strings.TrimSuffix(path, ".mp4") + "." + container
+	tmpPath := strings.TrimSuffix(path, ".mp4") + ".partial." + container
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y", "-i", path, "-map_metadata", "0", "-map_chapters", "0"}
+	switch container {
+	case "webm":
+		args = append(args, "-map", "0:v:0", "-map", "0:a?",
+			"-c:v", "libvpx-vp9", "-crf", "30", "-b:v", "0", "-row-mt", "1", "-c:a", "libopus")
+	case "mov":
+		args = append(args, "-map", "0", "-c", "copy", "-movflags", "+faststart")
+	default:
+		args = append(args, "-map", "0", "-c", "copy")
+	}
+	if err := runTool(ctx, ffmpeg, append(args, tmpPath)...); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return outPath, os.Rename(tmpPath, outPath)
+}
+
+// remuxDownload converts a fresh download and links the copy from the job's
+// history entry. The MP4 stays, since history and the other commands work
+// on it; a failure only warns.
+func remuxDownload(ctx context.Context, ffmpeg, container, jobID, path string) string {
+	outPath, err := remuxVideo(ctx, ffmpeg, container, path)
+	if err != nil {
+		logWarn("unable to convert %s to %s: %v", jobID, container, err)
+		return ""
+	}
+	logInfo("Saved %s to %s", container, outPath)
+	recordDerived(jobID, container, outPath)
+	return outPath
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Review statuses. A job nobody has reviewed has no status; filters call
+// that "none".
+const (
+	reviewPending  = "pending-review"
+	reviewApproved = "approved"
+	reviewRejected = "rejected"
+	reviewNone     = "none"
+)
+
+var reviewFilters = []string{reviewPending, reviewApproved, reviewRejected, reviewNone}
+
+// reviewConfig controls the local review workflow. Pending and rejected
+// clips are never exported to the DAM or encoded with a profile; with
+// Required, neither are clips nobody has approved yet.
+type reviewConfig struct {
+	Required bool `yaml:"required,omitempty" env:"SORA2_REVIEW_REQUIRED"`
+	// Reviewer is recorded with each decision (default: the login name).
+	Reviewer string `yaml:"reviewer,omitempty" env:"SORA2_REVIEWER"`
+}
+
+// jobReview is the latest review decision on a job.
+type jobReview struct {
+	Status   string    `json:"status"`
+	Reviewer string    `json:"reviewer,omitempty"`
+	Comment  string    `json:"comment,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+func (e *historyEntry) reviewStatus() string {
+	if e == nil || e.Review == nil {
+		return reviewNone
+	}
+	return e.Review.Status
+}
+
+func validateReviewFilter(status string) error {
+	for _, s := range reviewFilters {
+		if status == s {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown review status %q; use %s", status, strings.Join(reviewFilters, ", "))
+}
+
+// reviewFilter reports whether the job with entry (nil when history has no
+// record of it) has the review status given to a --review flag.
+func reviewFilter(status string, entry *historyEntry) bool {
+	return status == "" || entry.reviewStatus() == status
+}
+
+// approved returns an error explaining why the job may not be published or
+// packaged yet, or nil when it may.
+func (c reviewConfig) approved(entry *historyEntry, jobID string) error {
+	switch entry.reviewStatus() {
+	case reviewApproved:
+		return nil
+	case reviewRejected:
+		msg := fmt.Sprintf("%s was rejected", jobID)
+		if entry.Review.Reviewer != "" {
+			msg += " by " + entry.Review.Reviewer
+		}
+		if entry.Review.Comment != "" {
+			msg += ": " + entry.Review.Comment
+		}
+		return fmt.Errorf("%s", msg)
+	case reviewPending:
+		return fmt.Errorf("%s is pending review", jobID)
+	}
+	if c.Required {
+		return fmt.Errorf("%s has not been approved; run 'sora2cli review approve %s'", jobID, jobID)
+	}
+	return nil
+}
+
+// checkApproved looks the job up in history and applies approved.
+func (c reviewConfig) checkApproved(jobID string) error {
+	state, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	return c.approved(state.find(jobID), jobID)
+}
+
+func (c reviewConfig) reviewer() string {
+	if c.Reviewer != "" {
+		return c.Reviewer
+	}
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+const reviewUsage = "usage: sora2cli review <approve|reject|request|list> ..."
+
+func runReviewCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, reviewUsage)
+		return 2
+	}
+	if isHelpArg(args[0]) {
+		return runHelpCommand([]string{"review"})
+	}
+	switch args[0] {
+	case "approve":
+		return runReviewDecision("approve", reviewApproved, args[1:])
+	case "reject":
+		return runReviewDecision("reject", reviewRejected, args[1:])
+	case "request":
+		return runReviewDecision("request", reviewPending, args[1:])
+	case "list":
+		return runReviewList(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown review command %q\n", args[0])
+		fmt.Fprintln(os.Stderr, reviewUsage)
+		return 2
+	}
+}
+
+// runReviewDecision records status on each job with the reviewer and an
+// optional comment.
+func runReviewDecision(name, status string, args []string) int {
+	fs := newCommandFlagSet("review " + name)
+	comment := fs.String("comment", "", "note stored with the decision")
+	reviewer := fs.String("reviewer", "", "who decided (default review.reviewer or the login name)")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "usage: sora2cli review %s [--comment text] [--reviewer name] <video-id>...\n", name)
+		return 2
+	}
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	if *reviewer == "" {
+		*reviewer = cfg.Review.reviewer()
+	}
+	state, err := loadHistory()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	failed := 0
+	for _, jobID := range fs.Args() {
+		entry := state.find(jobID)
+		if entry == nil {
+			logError("%s is not in history; add videos made elsewhere with 'sora2cli audit-remote --import'", jobID)
+			failed++
+			continue
+		}
+		if status != reviewPending && entry.Status != "completed" {
+			logError("%s is %s; only completed renders can be reviewed", jobID, entry.Status)
+			failed++
+			continue
+		}
+		review := &jobReview{Status: status, Reviewer: *reviewer, Comment: *comment, At: time.Now().UTC()}
+		if err := updateHistory(entry.JobID, false, func(e *historyEntry) { e.Review = review }); err != nil {
+			logError("%s: %v", jobID, err)
+			failed++
+			continue
+		}
+		logInfo("%s: %s", entry.JobID, status)
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runReviewList lists the jobs in history with a review status. Without
+// --status it shows what awaits review: pending jobs and, with
+// review.required, completed jobs nobody has reviewed.
+func runReviewList(args []string) int {
+	fs := newCommandFlagSet("review list")
+	status := fs.String("status", "", "only jobs with this status: "+strings.Join(reviewFilters, ", "))
+	jsonOutput := fs.Bool("json", false, "print the jobs as JSON")
+	registerFormatFlag(fs, jsonOutput)
+	fs.BoolVar(&displayUTC, "utc", false, "show timestamps in UTC instead of the local time zone")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if *status != "" {
+		if err := validateReviewFilter(*status); err != nil {
+			logError("%v", err)
+			return 2
+		}
+	}
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	state, err := loadHistory()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	var entries []*historyEntry
+	for _, e := range state.Entries {
+		if e.Status != "completed" {
+			continue
+		}
+		switch got := e.reviewStatus(); {
+		case *status != "":
+			if got != *status {
+				continue
+			}
+		case got == reviewPending, got == reviewNone && cfg.Review.Required:
+		default:
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].CreatedAt.Before(entries[j].CreatedAt) })
+
+	if *jsonOutput {
+		if entries == nil {
+			entries = []*historyEntry{}
+		}
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+		fmt.Println(string(data))
+		return 0
+	}
+	if len(entries) == 0 {
+		fmt.Println("No jobs to show.")
+		return 0
+	}
+	for _, e := range entries {
+		fmt.Printf("%s  %-14s  %s  %s\n", e.JobID, e.reviewStatus(), formatTimestamp(e.CreatedAt), truncateText(e.Prompt, 60))
+		if e.Review != nil {
+			line := fmt.Sprintf("    %s by %s at %s", e.Review.Status, e.Review.Reviewer, formatTimestamp(e.Review.At))
+			if e.Review.Comment != "" {
+				line += ": " + e.Review.Comment
+			}
+			fmt.Println(line)
+		}
+	}
+	return 0
+}
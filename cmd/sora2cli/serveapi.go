@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+// serverConfig controls the REST API of "sora2cli serve", through which
+// internal tools submit jobs without holding the OpenAI API key.
+type serverConfig struct {
+	// Token is the bearer token clients send. The API is off without one.
+	Token string `yaml:"token,omitempty" env:"SORA2_SERVER_TOKEN" secret:"true"`
+	// Queue is the local queue that keeps submitted jobs; its settings,
+	// such as concurrency and budget, apply (default "api").
+	Queue string `yaml:"queue,omitempty" env:"SORA2_SERVER_QUEUE"`
+}
+
+const (
+	defaultServerQueue = "api"
+	apiPathPrefix      = "/v1/jobs"
+	// apiPollInterval is how often the worker looks for jobs that were not
+	// submitted through this server, such as ones approved with queue
+	// approve.
+	apiPollInterval = 15 * time.Second
+	// maxAPIRequestBytes bounds a job submission.
+	maxAPIRequestBytes = 64 << 10
+)
+
+func (c serverConfig) queue() string {
+	if c.Queue == "" {
+		return defaultServerQueue
+	}
+	return c.Queue
+}
+
+func validateServerConfig(c serverConfig) []configIssue {
+	if c.Queue != "" && !isValidQueueName(c.Queue) {
+		return []configIssue{{Key: "server.queue", Message: "use letters, digits, - and _ only"}}
+	}
+	return nil
+}
+
+// apiJobRequest is the body of POST /v1/jobs. Blank fields take the queue's
+// defaults. Reference files are not accepted, as they would be read from the
+// server's disk.
+type apiJobRequest struct {
+	Prompt  string `json:"prompt"`
+	Model   string `json:"model,omitempty"`
+	Seconds int    `json:"seconds,omitempty"`
+	Size    string `json:"size,omitempty"`
+	Ticket  string `json:"ticket,omitempty"`
+}
+
+// apiJob is a queue item as the API shows it.
+type apiJob struct {
+	ID            int       `json:"id"`
+	Status        string    `json:"status"`
+	Prompt        string    `json:"prompt"`
+	Model         string    `json:"model"`
+	Seconds       int       `json:"seconds"`
+	Size          string    `json:"size"`
+	Ticket        string    `json:"ticket,omitempty"`
+	EstimatedCost float64   `json:"estimated_cost"`
+	VideoID       string    `json:"video_id,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	AddedAt       time.Time `json:"added_at"`
+	FinishedAt    time.Time `json:"finished_at,omitzero"`
+	// ContentURL is where the video can be downloaded once completed.
+	ContentURL string `json:"content_url,omitempty"`
+}
+
+func newAPIJob(item queueItem) apiJob {
+	job := apiJob{
+		ID:            item.ID,
+		Status:        item.Status,
+		Prompt:        item.Prompt,
+		Model:         item.Model,
+		Seconds:       item.Seconds,
+		Size:          item.Size,
+		Ticket:        item.Ticket,
+		EstimatedCost: item.EstimatedCost,
+		VideoID:       item.JobID,
+		Error:         item.Error,
+		AddedAt:       item.AddedAt,
+		FinishedAt:    item.FinishedAt,
+	}
+	if item.Status == queueItemCompleted && item.OutputPath != "" {
+		job.ContentURL = fmt.Sprintf("%s/%d/content", apiPathPrefix, item.ID)
+	}
+	return job
+}
+
+// jobAPI serves the REST API. Submissions go into a queue store of their
+// own, kept apart from the worker's so requests never touch the items the
+// worker is running; both see each other's changes through the state file.
+type jobAPI struct {
+	token string
+	queue queueSettings
+	store *queueStore
+	// wake tells the worker a job was added.
+	wake chan struct{}
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIJSON(w, status, map[string]jsonErrorBody{"error": {Message: message}})
+}
+
+func (a *jobAPI) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+func (a *jobAPI) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+apiPathPrefix, a.submit)
+	mux.HandleFunc("GET "+apiPathPrefix, a.list)
+	mux.HandleFunc("GET "+apiPathPrefix+"/{id}", a.show)
+	mux.HandleFunc("GET "+apiPathPrefix+"/{id}/content", a.content)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorized(r) {
+			logWarn("%s %s %s: refused: bad or missing token", r.RemoteAddr, r.Method, r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sora2cli"`)
+			writeAPIError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (a *jobAPI) submit(w http.ResponseWriter, r *http.Request) {
+	var req apiJobRequest
+	decoder := json.NewDecoder(io.LimitReader(r.Body, maxAPIRequestBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	spec := jobSpec{Prompt: req.Prompt, Model: req.Model, Seconds: req.Seconds, Size: req.Size, Ticket: req.Ticket}
+	model, err := spec.resolve(defaultsConfig{Model: a.queue.Model, Seconds: a.queue.Seconds, Size: a.queue.Size})
+	if err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	item := &queueItem{
+		Prompt:        spec.Prompt,
+		Model:         spec.Model,
+		Seconds:       spec.Seconds,
+		Size:          spec.Size,
+		Ticket:        spec.Ticket,
+		Status:        queueItemPending,
+		EstimatedCost: model.RatePerSecond * float64(spec.Seconds),
+		AddedAt:       time.Now(),
+	}
+	if err := a.store.add(item); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "unable to save the queue")
+		return
+	}
+	logInfo("%s: added item #%d to queue %s (%s, %ds, %s, est. $%.2f)", r.RemoteAddr, item.ID, a.queue.Name, item.Model, item.Seconds, item.Size, item.EstimatedCost)
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s/%d", apiPathPrefix, item.ID))
+	writeAPIJSON(w, http.StatusAccepted, newAPIJob(*item))
+}
+
+func (a *jobAPI) list(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	jobs := []apiJob{}
+	err := a.store.view(func() {
+		for _, item := range a.store.state.Items {
+			if status == "" || item.Status == status {
+				jobs = append(jobs, newAPIJob(*item))
+			}
+		}
+	})
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "unable to read the queue")
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, map[string][]apiJob{"jobs": jobs})
+}
+
+// find returns a copy of the item named by the request's id.
+func (a *jobAPI) find(w http.ResponseWriter, r *http.Request) (queueItem, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "no such job")
+		return queueItem{}, false
+	}
+	var item *queueItem
+	err = a.store.view(func() {
+		if found := a.store.find(id); found != nil {
+			copied := *found
+			item = &copied
+		}
+	})
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "unable to read the queue")
+		return queueItem{}, false
+	}
+	if item == nil {
+		writeAPIError(w, http.StatusNotFound, "no such job")
+		return queueItem{}, false
+	}
+	return *item, true
+}
+
+func (a *jobAPI) show(w http.ResponseWriter, r *http.Request) {
+	if item, ok := a.find(w, r); ok {
+		writeAPIJSON(w, http.StatusOK, newAPIJob(item))
+	}
+}
+
+func (a *jobAPI) content(w http.ResponseWriter, r *http.Request) {
+	item, ok := a.find(w, r)
+	if !ok {
+		return
+	}
+	if item.Status != queueItemCompleted || item.OutputPath == "" {
+		writeAPIError(w, http.StatusConflict, fmt.Sprintf("job #%d is %s", item.ID, item.Status))
+		return
+	}
+	file, err := os.Open(item.OutputPath)
+	if err != nil {
+		writeAPIError(w, http.StatusGone, "the video is no longer on the server")
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		writeAPIError(w, http.StatusGone, "the video is no longer on the server")
+		return
+	}
+	name := filepath.Base(item.OutputPath)
+	if r.Header.Get("Range") == "" {
+		logInfo("%s: sending %s of item #%d", r.RemoteAddr, name, item.ID)
+	}
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
+
+// work runs the queue whenever it has runnable items, until ctx is done.
+// Runs are not tied to ctx: stopping the server does not cancel or fail the
+// jobs in flight, which keep rendering and can be fetched with wait.
+func (a *jobAPI) work(ctx context.Context, cfg *resolvedConfig, client *sora.Client) {
+	store, err := openQueueStore(a.queue.Name)
+	if err != nil {
+		logError("%v", err)
+		return
+	}
+	for {
+		runnable := 0
+		if err := store.view(func() { runnable = len(store.runnable(a.queue.RequireApproval)) }); err != nil {
+			logWarn("unable to read queue %s: %v", a.queue.Name, err)
+		}
+		if runnable > 0 {
+			q := a.queue
+			q.Spend = newBudgetGuard(cfg.Budget, 0)
+			result, err := runQueue(context.Background(), client, q, store)
+			if err != nil {
+				logError("queue %s: %v", q.Name, err)
+			}
+			if result.Completed+result.Failed > 0 {
+				logInfo("Queue %s: %d completed, %d failed, est. $%.2f", q.Name, result.Completed, result.Failed, result.EstimatedCost)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.wake:
+		case <-time.After(apiPollInterval):
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const sessionFileName = "session.json"
+
+// generationSession is a time-boxed stretch of work, such as a live
+// ideation session with a client. While one is active every job submitted
+// from this machine counts against its budget, and none is submitted once
+// its time is up.
+type generationSession struct {
+	Name      string    `json:"name"`
+	StartedAt time.Time `json:"started_at"`
+	// Until is when the session's time runs out; zero for no limit.
+	Until time.Time `json:"until,omitzero"`
+	// Budget caps the session's estimated spend in USD; zero means none.
+	Budget float64 `json:"budget,omitempty"`
+}
+
+func sessionPath() (string, error) {
+	dir, err := resolveDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, sessionFileName), nil
+}
+
+// loadSession returns the active session, or nil when there is none.
+func loadSession() (*generationSession, error) {
+	path, err := sessionPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s generationSession
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+func (s *generationSession) save() error {
+	path, err := sessionPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+func (s *generationSession) expired(now time.Time) bool {
+	return !s.Until.IsZero() && !now.Before(s.Until)
+}
+
+// entries returns the jobs history records as created during the session,
+// oldest first. Videos imported from the account are left out, since they
+// were not made here.
+func (s *generationSession) entries(end time.Time) ([]*historyEntry, error) {
+	state, err := loadHistory()
+	if err != nil {
+		return nil, err
+	}
+	var entries []*historyEntry
+	for _, e := range state.Entries {
+		if e.Source == "import" || e.CreatedAt.Before(s.StartedAt) || e.CreatedAt.After(end) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].CreatedAt.Before(entries[j].CreatedAt) })
+	return entries, nil
+}
+
+// spent is the estimated cost of the session's jobs that have not failed or
+// been cancelled.
+func (s *generationSession) spent() (float64, error) {
+	entries, err := s.entries(time.Now())
+	if err != nil {
+		return 0, err
+	}
+	var total float64
+	for _, e := range entries {
+		if countsAsSpend(e.Status) {
+			total += entryCost(e)
+		}
+	}
+	return total, nil
+}
+
+// sessionSummary is the wrap-up printed by session end and session status.
+type sessionSummary struct {
+	Name      string          `json:"name"`
+	StartedAt time.Time       `json:"started_at"`
+	EndedAt   time.Time       `json:"ended_at"`
+	Budget    float64         `json:"budget,omitempty"`
+	Spent     float64         `json:"spent"`
+	Jobs      int             `json:"jobs"`
+	Completed int             `json:"completed"`
+	Failed    int             `json:"failed"`
+	Running   int             `json:"running"`
+	Outputs   []string        `json:"outputs"`
+	Entries   []*historyEntry `json:"entries"`
+}
+
+func (s *generationSession) summary(end time.Time) (sessionSummary, error) {
+	entries, err := s.entries(end)
+	if err != nil {
+		return sessionSummary{}, err
+	}
+	summary := sessionSummary{Name: s.Name, StartedAt: s.StartedAt, EndedAt: end, Budget: s.Budget, Jobs: len(entries), Outputs: []string{}, Entries: entries}
+	if summary.Entries == nil {
+		summary.Entries = []*historyEntry{}
+	}
+	for _, e := range entries {
+		switch strings.ToLower(e.Status) {
+		case "completed":
+			summary.Completed++
+		case "failed", "cancelled":
+			summary.Failed++
+		default:
+			summary.Running++
+		}
+		if countsAsSpend(e.Status) {
+			summary.Spent += entryCost(e)
+		}
+		if e.OutputPath != "" {
+			summary.Outputs = append(summary.Outputs, e.OutputPath)
+		}
+	}
+	return summary, nil
+}
+
+func printSessionSummary(s sessionSummary) {
+	fmt.Printf("Session %s: %s to %s (%s)\n", s.Name, formatTimestamp(s.StartedAt), formatTimestamp(s.EndedAt), s.EndedAt.Sub(s.StartedAt).Round(time.Minute))
+	fmt.Printf("Jobs: %d, %d completed, %d failed", s.Jobs, s.Completed, s.Failed)
+	if s.Running > 0 {
+		fmt.Printf(", %d still running", s.Running)
+	}
+	fmt.Println()
+	if s.Budget > 0 {
+		fmt.Printf("Est. spend: $%.2f of $%.2f\n", s.Spent, s.Budget)
+	} else {
+		fmt.Printf("Est. spend: $%.2f\n", s.Spent)
+	}
+	if len(s.Entries) == 0 {
+		return
+	}
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOB\tSTATUS\tEST. COST\tPROMPT\tOUTPUT")
+	for _, e := range s.Entries {
+		output := e.OutputPath
+		if output == "" {
+			output = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t$%.2f\t%s\t%s\n", e.JobID, e.Status, entryCost(e), truncateText(e.Prompt, 40), output)
+	}
+	tw.Flush()
+}
+
+const sessionUsage = "usage: sora2cli session <start|status|end> ..."
+
+func runSessionCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, sessionUsage)
+		return 2
+	}
+	if isHelpArg(args[0]) {
+		return runHelpCommand([]string{"session"})
+	}
+	switch args[0] {
+	case "start":
+		return runSessionStart(args[1:])
+	case "status":
+		return runSessionReport("status", args[1:])
+	case "end":
+		return runSessionReport("end", args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown session command %q\n", args[0])
+		fmt.Fprintln(os.Stderr, sessionUsage)
+		return 2
+	}
+}
+
+func runSessionStart(args []string) int {
+	fs := newCommandFlagSet("session start")
+	name := fs.String("name", "", "name shown in the summary (default: the start time)")
+	budget := fs.Float64("budget", 0, "refuse jobs once the session's estimated spend would exceed this many USD (0 = no limit)")
+	duration := fs.Duration("duration", 0, "refuse jobs once the session has run this long, e.g. 2h (0 = no limit)")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli session start [--name text] [--budget usd] [--duration 2h]")
+		return 2
+	}
+	if *budget < 0 || *duration < 0 {
+		logError("--budget and --duration must not be negative")
+		return 2
+	}
+	active, err := loadSession()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	if active != nil {
+		logError("session %s has been running since %s; end it with 'sora2cli session end' first", active.Name, formatTimestamp(active.StartedAt))
+		return 1
+	}
+	now := time.Now()
+	s := &generationSession{Name: *name, StartedAt: now.UTC(), Budget: *budget}
+	if s.Name == "" {
+		s.Name = now.Format("2006-01-02 15:04")
+	}
+	if *duration > 0 {
+		s.Until = now.Add(*duration).UTC()
+	}
+	if err := s.save(); err != nil {
+		logError("%v", err)
+		return 1
+	}
+	message := fmt.Sprintf("Started session %s.", s.Name)
+	if s.Budget > 0 {
+		message += fmt.Sprintf(" Budget $%.2f.", s.Budget)
+	}
+	if !s.Until.IsZero() {
+		message += fmt.Sprintf(" Jobs are refused after %s.", formatTimestamp(s.Until))
+	}
+	logInfo("%s Run 'sora2cli session end' for the summary.", message)
+	return 0
+}
+
+// runSessionReport prints the summary of the active session. As session end
+// it also closes the session.
+func runSessionReport(name string, args []string) int {
+	fs := newCommandFlagSet("session " + name)
+	jsonOutput := fs.Bool("json", false, "print the summary as JSON")
+	registerFormatFlag(fs, jsonOutput)
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "usage: sora2cli session %s [--json]\n", name)
+		return 2
+	}
+	s, err := loadSession()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	if s == nil {
+		logError("no session is active; start one with 'sora2cli session start'")
+		return 1
+	}
+	end := time.Now()
+	if s.expired(end) && name == "end" {
+		// Jobs were refused after Until, so the session ended then.
+		end = s.Until
+	}
+	summary, err := s.summary(end)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	if name == "end" {
+		path, err := sessionPath()
+		if err == nil {
+			err = os.Remove(path)
+		}
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+	}
+	if *jsonOutput {
+		enableJSONOutput()
+		emitJSON(summary)
+		return 0
+	}
+	printSessionSummary(summary)
+	if name == "status" && !s.Until.IsZero() {
+		if left := time.Until(s.Until); left > 0 {
+			fmt.Printf("\n%s left.\n", left.Round(time.Minute))
+		} else {
+			fmt.Println("\nThe session's time is up; new jobs are refused.")
+		}
+	}
+	return 0
+}
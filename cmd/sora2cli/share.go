@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// shareConfig controls share links: signed, expiring URLs under which
+// "sora2cli serve" streams a downloaded render, so clients can review it
+// without an account or a file transfer.
+type shareConfig struct {
+	// BaseURL is where clients reach the server, e.g. a reverse proxy.
+	BaseURL string `yaml:"base_url,omitempty" env:"SORA2_SHARE_BASE_URL"`
+	// Listen is the address serve listens on.
+	Listen string `yaml:"listen,omitempty" env:"SORA2_SHARE_LISTEN"`
+	// Secret signs the links. Without it a random key is kept in the data
+	// directory. Changing either invalidates every link handed out.
+	Secret string `yaml:"secret,omitempty" env:"SORA2_SHARE_SECRET" secret:"true"`
+	// Expires is how long a link is valid unless share --expires says
+	// otherwise (default 72h).
+	Expires string `yaml:"expires,omitempty" env:"SORA2_SHARE_EXPIRES"`
+}
+
+const (
+	defaultShareListen  = ":8080"
+	defaultShareExpires = 72 * time.Hour
+	maxShareExpires     = 90 * 24 * time.Hour
+	shareKeyFileName    = "share.key"
+	sharePathPrefix     = "/share/"
+)
+
+func (c shareConfig) listen() string {
+	if c.Listen == "" {
+		return defaultShareListen
+	}
+	return c.Listen
+}
+
+func (c shareConfig) baseURL() string {
+	if c.BaseURL != "" {
+		return strings.TrimSuffix(c.BaseURL, "/")
+	}
+	host := c.listen()
+	if strings.HasPrefix(host, ":") {
+		host = "localhost" + host
+	}
+	return "http://" + host
+}
+
+func (c shareConfig) expires() (time.Duration, error) {
+	if c.Expires == "" {
+		return defaultShareExpires, nil
+	}
+	return parseShareExpiry(c.Expires)
+}
+
+func parseShareExpiry(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, e.g. 72h", value)
+	}
+	if d <= 0 || d > maxShareExpires {
+		return 0, fmt.Errorf("%s is outside 1s to %s", value, maxShareExpires)
+	}
+	return d, nil
+}
+
+func validateShareConfig(c shareConfig) []configIssue {
+	var issues []configIssue
+	if c.BaseURL != "" && !isHTTPURL(c.BaseURL) {
+		issues = append(issues, configIssue{Key: "share.base_url", Message: "not an absolute http(s) URL"})
+	}
+	if _, err := c.expires(); err != nil {
+		issues = append(issues, configIssue{Key: "share.expires", Message: err.Error()})
+	}
+	return issues
+}
+
+// key returns the signing key: the configured secret, or the key file in
+// the data directory, which is created on first use.
+func (c shareConfig) key() ([]byte, error) {
+	if c.Secret != "" {
+		return []byte(c.Secret), nil
+	}
+	dir, err := resolveDataDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, shareKeyFileName)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return hex.DecodeString(strings.TrimSpace(string(data)))
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func shareSignature(key []byte, jobID string, expires int64) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%d", jobID, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// shareLink returns the URL under which serve streams jobID until expires.
+func shareLink(base string, key []byte, jobID string, expires time.Time) string {
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("sig", shareSignature(key, jobID, expires.Unix()))
+	return base + sharePathPrefix + url.PathEscape(jobID) + "?" + q.Encode()
+}
+
+// verifyShareLink checks the signature and expiry of a request for jobID.
+func verifyShareLink(key []byte, jobID string, q url.Values, now time.Time) error {
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil {
+		return errors.New("malformed link")
+	}
+	want := shareSignature(key, jobID, expires)
+	if !hmac.Equal([]byte(q.Get("sig")), []byte(want)) {
+		return errors.New("invalid signature")
+	}
+	if now.Unix() >= expires {
+		return errors.New("link expired")
+	}
+	return nil
+}
+
+// runShareCommand prints a share link for each downloaded video.
+func runShareCommand(args []string) int {
+	fs := newCommandFlagSet("share")
+	expiresFlag := fs.String("expires", "", "how long the links stay valid, e.g. 24h (default share.expires or 72h)")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli share [--expires duration] <video-id>...")
+		return 2
+	}
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	validFor, err := cfg.Share.expires()
+	if *expiresFlag != "" {
+		validFor, err = parseShareExpiry(*expiresFlag)
+	}
+	if err != nil {
+		logError("--expires: %v", err)
+		return 2
+	}
+	key, err := cfg.Share.key()
+	if err != nil {
+		logError("unable to load the share key: %v", err)
+		return 1
+	}
+	state, err := loadHistory()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	expires := time.Now().Add(validFor).Truncate(time.Second)
+	failed := 0
+	for _, jobID := range fs.Args() {
+		entry := state.find(jobID)
+		if entry == nil || entry.OutputPath == "" {
+			logError("%s: no downloaded video in history", jobID)
+			failed++
+			continue
+		}
+		fmt.Println(shareLink(cfg.Share.baseURL(), key, entry.JobID, expires))
+	}
+	if failed > 0 {
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Valid until %s while 'sora2cli serve' is running.\n", formatTimestamp(expires))
+	return 0
+}
+
+// shareHandler streams the downloaded file of a job to holders of a valid
+// link. Range requests are honoured, so players can seek.
+func shareHandler(key []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		jobID, err := url.PathUnescape(strings.TrimPrefix(r.URL.Path, sharePathPrefix))
+		if err != nil || jobID == "" || strings.Contains(jobID, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		if err := verifyShareLink(key, jobID, r.URL.Query(), time.Now()); err != nil {
+			logWarn("%s %s: refused: %v", r.RemoteAddr, jobID, err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		state, err := loadHistory()
+		if err != nil {
+			http.Error(w, "history unavailable", http.StatusInternalServerError)
+			return
+		}
+		entry := state.find(jobID)
+		if entry == nil || entry.OutputPath == "" {
+			http.NotFound(w, r)
+			return
+		}
+		file, err := os.Open(entry.OutputPath)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Range") == "" {
+			logInfo("%s %s: streaming %s", r.RemoteAddr, jobID, filepath.Base(entry.OutputPath))
+		}
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", filepath.Base(entry.OutputPath)))
+		w.Header().Set("Cache-Control", "private, no-store")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		http.ServeContent(w, r, filepath.Base(entry.OutputPath), info.ModTime(), file)
+	})
+}
+
+// runServeCommand runs the HTTP server behind share links, and the job API
+// when server.token is set, until interrupted.
+func runServeCommand(args []string) int {
+	fs := newCommandFlagSet("serve")
+	listen := fs.String("listen", "", "address to listen on (default share.listen or "+defaultShareListen+")")
+	queueName := fs.String("queue", "", "queue that keeps jobs submitted through the API (default server.queue or "+defaultServerQueue+")")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		logError("unexpected argument %q", fs.Arg(0))
+		return 2
+	}
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	if *listen != "" {
+		cfg.Share.Listen = *listen
+	}
+	*listen = cfg.Share.listen()
+	if *queueName != "" {
+		cfg.Server.Queue = *queueName
+	}
+	key, err := cfg.Share.key()
+	if err != nil {
+		logError("unable to load the share key: %v", err)
+		return 1
+	}
+	mux := http.NewServeMux()
+	mux.Handle(sharePathPrefix, shareHandler(key))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var api *jobAPI
+	if cfg.Server.Token != "" {
+		if cfg.APIKey == "" {
+			logError("OPENAI_API_KEY not found in environment, OPENAI_API_KEY_FILE, .env or config file")
+			return 1
+		}
+		q, err := resolveQueue(cfg, cfg.Server.queue())
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+		lockPath, err := runLockPath("queue", q.Name)
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+		lock, err := tryLockFile(lockPath)
+		if err != nil {
+			logError("queue %s is already running: %v", q.Name, err)
+			return 1
+		}
+		defer lock.unlock()
+		store, err := openQueueStore(q.Name)
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+		api = &jobAPI{token: cfg.Server.Token, queue: q, store: store, wake: make(chan struct{}, 1)}
+		handler := api.handler()
+		mux.Handle(apiPathPrefix, handler)
+		mux.Handle(apiPathPrefix+"/", handler)
+	}
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- server.ListenAndServe() }()
+	if api != nil {
+		go func() {
+			defer handleCrash()
+			api.work(ctx, cfg, newAPIClient(cfg, cfg.APIKey))
+		}()
+	}
+	logInfo("Serving share links on %s (links point to %s). Press Ctrl+C to stop.", *listen, cfg.Share.baseURL())
+	if api != nil {
+		logInfo("Job API on %s%s, rendering through queue %s (concurrency %d)", *listen, apiPathPrefix, api.queue.Name, api.queue.Concurrency)
+	} else {
+		logInfo("Job API off; set server.token to turn it on.")
+	}
+	select {
+	case err := <-errc:
+		logError("%v", err)
+		return 1
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logError("%v", err)
+		return 1
+	}
+	logInfo("Stopped.")
+	if api != nil {
+		running := 0
+		api.store.view(func() { running = api.store.counts()[queueItemRunning] })
+		if running > 0 {
+			logInfo("%d job(s) of queue %s are still rendering; run 'sora2cli queue show %s' for their video IDs and 'sora2cli wait <video-id>' to download them.", running, api.queue.Name, api.queue.Name)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+// errInterrupted is the cause of a context cancelled by Ctrl+C or SIGTERM.
+var errInterrupted = errors.New("interrupted")
+
+// shutdownContext returns a context that the first Ctrl+C or SIGTERM
+// cancels, so polling loops stop and downloads in flight are aborted, which
+// removes their .tmp files. History is written as each job changes, so
+// nothing is lost. A second signal exits at once.
+func shutdownContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			logBlankLine()
+			logInfo("Received %s; stopping (again to exit at once)...", sig)
+			cancel(errInterrupted)
+		case <-done:
+			return
+		}
+		select {
+		case <-signals:
+			logBlankLine()
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(done)
+			cancel(context.Canceled)
+		})
+	}
+}
+
+// interrupted reports whether ctx, or a context it derives from, was
+// cancelled by a signal.
+func interrupted(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errInterrupted)
+}
+
+// reportInterrupted lists the jobs submitted since started that a signal
+// left rendering or undownloaded. They stay pending in history, so recover
+// picks them up.
+func reportInterrupted(started time.Time) {
+	entries, err := pendingJobs()
+	if err != nil {
+		logWarn("unable to list unfinished jobs: %v", err)
+		return
+	}
+	var left []*historyEntry
+	for _, e := range entries {
+		// CreatedAt is the server's clock; allow for some skew.
+		if e.CreatedAt.After(started.Add(-time.Minute)) {
+			left = append(left, e)
+		}
+	}
+	if len(left) == 0 {
+		logInfo("Interrupted; no submitted job was left unfinished.")
+		return
+	}
+	logInfo("Interrupted; %d job(s) keep rendering on the server and were not downloaded:", len(left))
+	printPendingJobs(left)
+	logInfo("Resume with 'sora2cli recover', or one at a time with 'sora2cli wait <video-id>'.")
+}
+
+// exitInterrupted tells how to resume jobID after a signal stopped its
+// download and exits.
+func exitInterrupted(jobID string) {
+	logInfo("Download of %s interrupted; the partial file was removed. Resume with 'sora2cli wait %s' or 'sora2cli recover'.", jobID, jobID)
+	os.Exit(130)
+}
+
+// downloadJobFile saves a completed job to path like DownloadFile. A Ctrl+C
+// or SIGTERM aborts the transfer and exits through exitInterrupted, leaving
+// the job pending in history rather than failed.
+func downloadJobFile(ctx context.Context, client *sora.Client, jobID, path string) error {
+	ctx, stop := shutdownContext(ctx)
+	defer stop()
+	err := client.DownloadFile(ctx, jobID, path)
+	if err != nil && interrupted(ctx) {
+		exitInterrupted(jobID)
+	}
+	return err
+}
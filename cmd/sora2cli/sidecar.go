@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+// videoSidecar is written next to a downloaded MP4 so the file stays
+// self-describing when it is moved to another machine.
+type videoSidecar struct {
+	Job           *sora.Video `json:"job"`
+	Prompt        string      `json:"prompt"`
+	EstimatedCost float64     `json:"estimated_cost"`
+	CLIVersion    string      `json:"cli_version"`
+	WrittenAt     time.Time   `json:"written_at"`
+}
+
+// sidecarPath is outputPath with its extension replaced by .json.
+func sidecarPath(outputPath string) string {
+	return strings.TrimSuffix(outputPath, ".mp4") + ".json"
+}
+
+// writeSidecar stores job's metadata next to outputPath. The API does not
+// always echo the prompt, so history fills it in where needed.
+func writeSidecar(job *sora.Video, outputPath string) (string, error) {
+	sidecar := videoSidecar{
+		Job:        job,
+		Prompt:     job.Prompt,
+		CLIVersion: cliVersion(),
+		WrittenAt:  time.Now().UTC(),
+	}
+	if seconds, err := strconv.Atoi(job.Seconds); err == nil {
+		sidecar.EstimatedCost = jobCostEstimate(job.Model, seconds)
+	}
+	if sidecar.Prompt == "" {
+		if state, err := loadHistory(); err == nil {
+			if entry := state.find(job.ID); entry != nil {
+				sidecar.Prompt = entry.Prompt
+			}
+		}
+	}
+	data, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := sidecarPath(outputPath)
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStatusURL      = "https://status.openai.com/api/v2/summary.json"
+	defaultStatusInterval = 5 * time.Minute
+	// statusCacheTTL spares the status page one request per submission in
+	// a busy batch.
+	statusCacheTTL = time.Minute
+)
+
+var defaultStatusComponents = []string{"sora", "video"}
+
+// statusConfig gates batch and queue submissions on the OpenAI status page,
+// so a large run does not spend its retries on a degraded service.
+type statusConfig struct {
+	// Gate is off (the default), warn or hold.
+	Gate string `yaml:"gate,omitempty" env:"SORA2_STATUS_GATE"`
+	// URL is a Statuspage summary.json; by default OpenAI's.
+	URL string `yaml:"url,omitempty" env:"SORA2_STATUS_URL"`
+	// Components are matched, case-insensitively, against the names of
+	// components and incidents (default sora and video).
+	Components []string `yaml:"components,omitempty"`
+	// Interval is how often a held run checks again (default 5m).
+	Interval string `yaml:"interval,omitempty" env:"SORA2_STATUS_INTERVAL"`
+}
+
+var statusGateModes = []string{"off", "warn", "hold"}
+
+func validateStatusConfig(c statusConfig) []configIssue {
+	var issues []configIssue
+	if c.Gate != "" && !containsString(statusGateModes, c.Gate) {
+		issues = append(issues, configIssue{Key: "status.gate", Message: fmt.Sprintf("unknown mode %q; use %s", c.Gate, strings.Join(statusGateModes, ", "))})
+	}
+	if c.URL != "" && !isHTTPURL(c.URL) {
+		issues = append(issues, configIssue{Key: "status.url", Message: "must be an http(s) URL"})
+	}
+	if _, err := c.interval(); err != nil {
+		issues = append(issues, configIssue{Key: "status.interval", Message: err.Error()})
+	}
+	return issues
+}
+
+func (c statusConfig) interval() (time.Duration, error) {
+	if c.Interval == "" {
+		return defaultStatusInterval, nil
+	}
+	d, err := time.ParseDuration(c.Interval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, e.g. 5m", c.Interval)
+	}
+	if d < 10*time.Second {
+		return 0, fmt.Errorf("%s is too short; use at least 10s", c.Interval)
+	}
+	return d, nil
+}
+
+// statusGate checks the status page before submissions. A nil gate lets
+// everything through.
+type statusGate struct {
+	hold       bool
+	url        string
+	components []string
+	interval   time.Duration
+	client     *http.Client
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	problems  []string
+	warned    string
+}
+
+// newStatusGate returns the gate for mode, or for status.gate when mode is
+// blank. It returns nil when gating is off.
+func newStatusGate(cfg statusConfig, mode string) (*statusGate, error) {
+	if mode == "" {
+		mode = cfg.Gate
+	}
+	switch mode {
+	case "", "off":
+		return nil, nil
+	case "warn", "hold":
+	default:
+		return nil, fmt.Errorf("unknown status gate %q; use %s", mode, strings.Join(statusGateModes, ", "))
+	}
+	interval, err := cfg.interval()
+	if err != nil {
+		return nil, fmt.Errorf("status.interval: %w", err)
+	}
+	g := &statusGate{
+		hold:       mode == "hold",
+		url:        cfg.URL,
+		components: cfg.Components,
+		interval:   interval,
+		client:     &http.Client{Timeout: notificationTimeout},
+	}
+	if g.url == "" {
+		g.url = defaultStatusURL
+	}
+	if len(g.components) == 0 {
+		g.components = defaultStatusComponents
+	}
+	return g, nil
+}
+
+// statusSummary is the part of a Statuspage summary.json the gate reads.
+type statusSummary struct {
+	Components []struct {
+		Name   string `json:"name"`
+		Status string `json:"status"`
+	} `json:"components"`
+	Incidents []struct {
+		Name       string `json:"name"`
+		Status     string `json:"status"`
+		Impact     string `json:"impact"`
+		Components []struct {
+			Name string `json:"name"`
+		} `json:"components"`
+	} `json:"incidents"`
+}
+
+func (g *statusGate) matches(name string) bool {
+	name = strings.ToLower(name)
+	for _, c := range g.components {
+		if c != "" && strings.Contains(name, strings.ToLower(c)) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetch reads the status page and describes every matching component that
+// is not operational and every unresolved incident that touches one.
+func (g *statusGate) fetch(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s returned %s", g.url, resp.Status)
+	}
+	var summary statusSummary
+	if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", g.url, err)
+	}
+
+	var problems []string
+	for _, c := range summary.Components {
+		if g.matches(c.Name) && c.Status != "" && c.Status != "operational" {
+			problems = append(problems, fmt.Sprintf("%s: %s", c.Name, strings.ReplaceAll(c.Status, "_", " ")))
+		}
+	}
+	for _, incident := range summary.Incidents {
+		if incident.Status == "resolved" || incident.Status == "postmortem" {
+			continue
+		}
+		affected := g.matches(incident.Name)
+		for _, c := range incident.Components {
+			affected = affected || g.matches(c.Name)
+		}
+		if affected {
+			problems = append(problems, fmt.Sprintf("incident %q (%s, %s impact)", incident.Name, incident.Status, incident.Impact))
+		}
+	}
+	return problems, nil
+}
+
+// check returns the current problems, from cache when it is fresh. When the
+// status page cannot be read it warns and reports none, since the page being
+// down says little about the video service.
+func (g *statusGate) check(ctx context.Context) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.checkedAt.IsZero() && time.Since(g.checkedAt) < min(statusCacheTTL, g.interval) {
+		return g.problems
+	}
+	problems, err := g.fetch(ctx)
+	if err != nil {
+		logWarn("unable to check the status page: %v", err)
+	}
+	g.problems, g.checkedAt = problems, time.Now()
+	return problems
+}
+
+// wait lets what through once the video service looks healthy. In warn mode
+// it only prints the problems, once for each change; in hold mode it blocks
+// and checks again every interval until they clear.
+func (g *statusGate) wait(ctx context.Context, what string) error {
+	if g == nil {
+		return nil
+	}
+	problems := g.check(ctx)
+	if len(problems) == 0 {
+		return nil
+	}
+	report := strings.Join(problems, "; ")
+	if !g.hold {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if report != g.warned {
+			logWarn("the status page reports %s; submitting anyway", report)
+			g.warned = report
+		}
+		return nil
+	}
+	logInfo("Holding %s: the status page reports %s. Checking again every %s.", what, report, g.interval)
+	for len(problems) > 0 {
+		timer := time.NewTimer(g.interval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		problems = g.check(ctx)
+	}
+	logInfo("The status page reports no problems; resuming submissions.")
+	return nil
+}
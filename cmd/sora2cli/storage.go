@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// storageConfig guards against batches whose downloads would not fit.
+type storageConfig struct {
+	// BatchLimit is the estimated output of one batch, such as 20GB, above
+	// which it is flagged; empty means no limit.
+	BatchLimit string `yaml:"batch_limit,omitempty" env:"SORA2_STORAGE_BATCH_LIMIT"`
+	// OnExceed is warn (the default), which warns and asks in a terminal, or
+	// refuse, which stops the batch before anything is submitted.
+	OnExceed string `yaml:"on_exceed,omitempty" env:"SORA2_STORAGE_ON_EXCEED"`
+}
+
+const (
+	storageWarn   = "warn"
+	storageRefuse = "refuse"
+)
+
+func validateStorageConfig(s storageConfig) []configIssue {
+	var issues []configIssue
+	if s.BatchLimit != "" {
+		if _, err := parseByteSize(s.BatchLimit); err != nil {
+			issues = append(issues, configIssue{Key: "storage.batch_limit", Message: err.Error()})
+		}
+	}
+	switch s.OnExceed {
+	case "", storageWarn, storageRefuse:
+	default:
+		issues = append(issues, configIssue{Key: "storage.on_exceed", Message: fmt.Sprintf("unknown value %q; use warn or refuse", s.OnExceed)})
+	}
+	return issues
+}
+
+// byteUnits are the suffixes parseByteSize accepts: decimal, as disks are
+// sold, and binary, as formatBytes prints.
+var byteUnits = map[string]float64{
+	"":    1,
+	"B":   1,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+	"TIB": 1 << 40,
+}
+
+// parseByteSize parses a size such as 20GB, 500 MiB or 1.5TB.
+func parseByteSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	unit, ok := byteUnits[strings.TrimSpace(s[i:])]
+	if err != nil || !ok || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q; use e.g. 20GB or 500MiB", value)
+	}
+	return int64(n * unit), nil
+}
+
+// defaultOutputBytesPerPixelSecond stands in for history when no job of a
+// resolution has been downloaded yet: about 600 KB per second at 1280x720.
+const defaultOutputBytesPerPixelSecond = 0.65
+
+// outputRate is how many bytes of MP4 the downloads of one model and size
+// took per second of video.
+type outputRate struct {
+	Model   string `json:"model"`
+	Size    string `json:"size"`
+	Jobs    int    `json:"jobs"`
+	Bytes   int64  `json:"bytes"`
+	Seconds int    `json:"seconds"`
+}
+
+func (r *outputRate) perSecond() float64 {
+	return float64(r.Bytes) / float64(r.Seconds)
+}
+
+// outputRates collects the bytes per second of the downloads in history,
+// by model and size.
+func outputRates(state historyState) []*outputRate {
+	byKey := make(map[string]*outputRate)
+	var rates []*outputRate
+	for _, entry := range state.Entries {
+		if entry.OutputBytes <= 0 || entry.Seconds <= 0 || entry.Model == "" || entry.Size == "" {
+			continue
+		}
+		key := entry.Model + " " + entry.Size
+		rate, ok := byKey[key]
+		if !ok {
+			rate = &outputRate{Model: entry.Model, Size: entry.Size}
+			byKey[key] = rate
+			rates = append(rates, rate)
+		}
+		rate.Jobs++
+		rate.Bytes += entry.OutputBytes
+		rate.Seconds += entry.Seconds
+	}
+	sort.Slice(rates, func(i, j int) bool {
+		if rates[i].Model != rates[j].Model {
+			return rates[i].Model < rates[j].Model
+		}
+		return rates[i].Size < rates[j].Size
+	})
+	return rates
+}
+
+// outputEstimator estimates the download size of a job from history.
+type outputEstimator struct {
+	rates []*outputRate
+}
+
+func newOutputEstimator() (outputEstimator, error) {
+	state, err := loadHistory()
+	if err != nil {
+		return outputEstimator{}, err
+	}
+	return outputEstimator{rates: outputRates(state)}, nil
+}
+
+// estimate returns the expected bytes of a job, and whether history had a
+// job of the same model and size to go by. Failing that, the same size from
+// another model is used, and then a rate per pixel.
+func (e outputEstimator) estimate(model, size string, seconds int) (int64, bool) {
+	var sameSize *outputRate
+	for _, rate := range e.rates {
+		if rate.Size != size {
+			continue
+		}
+		if rate.Model == model {
+			return int64(rate.perSecond() * float64(seconds)), true
+		}
+		if sameSize == nil || rate.Jobs > sameSize.Jobs {
+			sameSize = rate
+		}
+	}
+	if sameSize != nil {
+		return int64(sameSize.perSecond() * float64(seconds)), false
+	}
+	width, height, _ := strings.Cut(size, "x")
+	w, _ := strconv.Atoi(width)
+	h, _ := strconv.Atoi(height)
+	return int64(float64(w*h) * defaultOutputBytesPerPixelSecond * float64(seconds)), false
+}
+
+// total adds up the estimate of every spec, and counts the specs history
+// had no exact match for.
+func (e outputEstimator) total(specs []jobSpec) (int64, int) {
+	var bytes int64
+	guessed := 0
+	for _, spec := range specs {
+		n, known := e.estimate(spec.Model, spec.Size, spec.Seconds)
+		bytes += n
+		if !known {
+			guessed++
+		}
+	}
+	return bytes, guessed
+}
+
+// describeOutputEstimate reads like "~38.2 GiB", noting how many jobs had
+// no history to go by.
+func describeOutputEstimate(bytes int64, guessed, jobs int) string {
+	text := "~" + formatBytes(bytes)
+	if guessed > 0 {
+		text += fmt.Sprintf(" (%d of %d job(s) without a download of that model and size to go by)", guessed, jobs)
+	}
+	return text
+}
+
+// checkBatchStorage estimates the downloads of a batch, and compares them
+// with storage.batch_limit. It reports whether the batch may go ahead: over
+// the limit, warn asks in a terminal unless assumeYes, and refuse stops.
+func checkBatchStorage(cfg *resolvedConfig, specs []jobSpec, assumeYes bool) bool {
+	if len(specs) == 0 {
+		return true
+	}
+	estimator, err := newOutputEstimator()
+	if err != nil {
+		logWarn("unable to estimate the output size: %v", err)
+		return true
+	}
+	bytes, guessed := estimator.total(specs)
+	estimate := describeOutputEstimate(bytes, guessed, len(specs))
+	logInfo("Estimated output of %d job(s): %s", len(specs), estimate)
+	if cfg.Storage.BatchLimit == "" {
+		return true
+	}
+	limit, err := parseByteSize(cfg.Storage.BatchLimit)
+	if err != nil {
+		logWarn("storage.batch_limit: %v", err)
+		return true
+	}
+	if bytes <= limit {
+		return true
+	}
+	message := fmt.Sprintf("this batch will produce ~%s, over the storage.batch_limit of %s", formatBytes(bytes), formatBytes(limit))
+	if cfg.Storage.OnExceed == storageRefuse {
+		logError("%s", message)
+		return false
+	}
+	logWarn("%s", message)
+	if assumeYes || !stdinIsTerminal() {
+		return true
+	}
+	if !promptConfirm(bufio.NewReader(os.Stdin), os.Stdout, "Continue anyway?") {
+		logInfo("Aborted.")
+		return false
+	}
+	return true
+}
+
+// storageReport is the storage command's JSON.
+type storageReport struct {
+	Rates      []storageRate `json:"rates"`
+	BatchLimit int64         `json:"batch_limit_bytes,omitempty"`
+	OnExceed   string        `json:"on_exceed"`
+}
+
+type storageRate struct {
+	*outputRate
+	BytesPerSecond float64 `json:"bytes_per_second"`
+}
+
+func runStorageCommand(args []string) int {
+	fs := newCommandFlagSet("storage")
+	jsonOutput := fs.Bool("json", false, "print the report as JSON")
+	registerFormatFlag(fs, jsonOutput)
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		logError("unexpected argument %q", fs.Arg(0))
+		return 2
+	}
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	estimator, err := newOutputEstimator()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	report := storageReport{Rates: []storageRate{}, OnExceed: cfg.Storage.OnExceed}
+	if report.OnExceed == "" {
+		report.OnExceed = storageWarn
+	}
+	if cfg.Storage.BatchLimit != "" {
+		if report.BatchLimit, err = parseByteSize(cfg.Storage.BatchLimit); err != nil {
+			logError("storage.batch_limit: %v", err)
+			return 1
+		}
+	}
+	for _, rate := range estimator.rates {
+		report.Rates = append(report.Rates, storageRate{outputRate: rate, BytesPerSecond: rate.perSecond()})
+	}
+	if *jsonOutput {
+		enableJSONOutput()
+		emitJSON(report)
+		return 0
+	}
+
+	if len(report.Rates) == 0 {
+		fmt.Println("No downloads in history yet; estimates assume about 600 KB per second at 1280x720.")
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "MODEL\tSIZE\tJOBS\tPER SECOND\tPER 12S CLIP")
+		for _, rate := range report.Rates {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", rate.Model, rate.Size, rate.Jobs, formatBytes(int64(rate.perSecond())), formatBytes(int64(rate.perSecond()*12)))
+		}
+		tw.Flush()
+	}
+	if report.BatchLimit > 0 {
+		fmt.Printf("\nBatch limit: %s (%s)\n", formatBytes(report.BatchLimit), report.OnExceed)
+	} else {
+		fmt.Println("\nBatch limit: none")
+	}
+	return 0
+}
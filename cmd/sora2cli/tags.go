@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Tags label jobs in history, for example campaign:q3 or hero-shot, so they
+// can be selected later.
+var tagPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:/-]{0,63}$`)
+
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		if !tagPattern.MatchString(tag) {
+			return fmt.Errorf("invalid tag %q; use letters, digits and _ . : / - (at most 64)", tag)
+		}
+	}
+	return nil
+}
+
+// hasTags reports whether the entry carries every one of tags.
+func (e *historyEntry) hasTags(tags []string) bool {
+	for _, want := range tags {
+		found := false
+		for _, tag := range e.Tags {
+			if tag == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// retag returns tags with add appended, skipping ones already there, and
+// remove taken out. It never changes tags itself.
+func retag(tags, add, remove []string) []string {
+	var out []string
+	for _, tag := range append(slices.Clone(tags), add...) {
+		if !slices.Contains(remove, tag) && !slices.Contains(out, tag) {
+			out = append(out, tag)
+		}
+	}
+	return out
+}
+
+// runTagCommand adds tags to a job in history, removes them or sets its
+// note. With nothing to change it shows them.
+func runTagCommand(args []string) int {
+	fs := newCommandFlagSet("tag")
+	var remove []string
+	fs.Var((*stringList)(&remove), "remove", "take this tag off; repeat for several")
+	note := fs.String("note", "", "set the job's note, replacing any earlier one")
+	clearNote := fs.Bool("clear-note", false, "remove the job's note")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli tag [--remove tag]... [--note text | --clear-note] <video-id> [tag...]")
+		return 2
+	}
+	noteGiven := false
+	fs.Visit(func(f *flag.Flag) { noteGiven = noteGiven || f.Name == "note" })
+	if noteGiven && *clearNote {
+		logError("--note and --clear-note cannot be combined")
+		return 2
+	}
+	jobID, add := fs.Arg(0), fs.Args()[1:]
+	if err := validateTags(append(slices.Clone(add), remove...)); err != nil {
+		logError("%v", err)
+		return 2
+	}
+	state, err := loadHistory()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	entry := state.find(jobID)
+	if entry == nil {
+		logError("%s is not in history; add videos made elsewhere with 'sora2cli audit-remote --import'", jobID)
+		return 1
+	}
+	if len(add) > 0 || len(remove) > 0 || noteGiven || *clearNote {
+		err := updateHistory(jobID, false, func(e *historyEntry) {
+			e.Tags = retag(e.Tags, add, remove)
+			switch {
+			case noteGiven:
+				e.Note = strings.TrimSpace(*note)
+			case *clearNote:
+				e.Note = ""
+			}
+			entry = e
+		})
+		if err != nil {
+			logError("%v", err)
+			return 1
+		}
+	}
+	logInfo("%s: tags %s", entry.JobID, dashIfEmpty(strings.Join(entry.Tags, ", ")))
+	if entry.Note != "" {
+		logInfo("%s: note %s", entry.JobID, entry.Note)
+	}
+	return 0
+}
+
+// runTagsCommand lists the tags in history with how many jobs carry each.
+func runTagsCommand(args []string) int {
+	fs := newCommandFlagSet("tags")
+	jsonOutput := fs.Bool("json", false, "print the tags and their counts as a JSON object")
+	registerFormatFlag(fs, jsonOutput)
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		logError("unexpected argument %q", fs.Arg(0))
+		return 2
+	}
+	state, err := loadHistory()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	counts := map[string]int{}
+	for _, entry := range state.Entries {
+		for _, tag := range entry.Tags {
+			counts[tag]++
+		}
+	}
+	if *jsonOutput {
+		enableJSONOutput()
+		emitJSON(counts)
+		return 0
+	}
+	if len(counts) == 0 {
+		logInfo("No tagged jobs. Tag one with 'sora2cli tag <video-id> <tag>...'.")
+		return 0
+	}
+	tags := make([]string, 0, len(counts))
+	for tag := range counts {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TAG\tJOBS")
+	for _, tag := range tags {
+		fmt.Fprintf(tw, "%s\t%d\n", tag, counts[tag])
+	}
+	tw.Flush()
+	return 0
+}
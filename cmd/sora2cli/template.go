@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Prompt templates are text files in the templates directory, named
+// <name>.txt. {{product}} is replaced by the value of the variable product
+// and {{product|sneakers}} falls back to sneakers when it has none.
+var templateVarPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_-]*)\s*(?:\|([^}]*))?\}\}`)
+
+const templateExt = ".txt"
+
+type promptTemplate struct {
+	Name string
+	Path string
+	Text string
+}
+
+type templateVar struct {
+	Name       string
+	Default    string
+	HasDefault bool
+}
+
+// templatesDir is templates_dir, or templates/ in the config directory.
+func (c *resolvedConfig) templatesDir() (string, error) {
+	if c.TemplatesDir != "" {
+		return expandPath(c.TemplatesDir)
+	}
+	dir, err := resolveConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "templates"), nil
+}
+
+// listTemplates returns the templates in dir sorted by name. A missing
+// directory has none.
+func listTemplates(dir string) ([]promptTemplate, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var templates []promptTemplate
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), templateExt)
+		if !ok || entry.IsDir() || !isValidQueueName(name) {
+			continue
+		}
+		t, err := loadTemplate(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, nil
+}
+
+func loadTemplate(dir, name string) (promptTemplate, error) {
+	if !isValidQueueName(name) {
+		return promptTemplate{}, fmt.Errorf("invalid template name %q", name)
+	}
+	path := filepath.Join(dir, name+templateExt)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return promptTemplate{}, fmt.Errorf("no template %q in %s", name, dir)
+	}
+	if err != nil {
+		return promptTemplate{}, err
+	}
+	return promptTemplate{Name: name, Path: path, Text: strings.TrimSpace(string(data))}, nil
+}
+
+// variables lists the template's variables in order of first use.
+func (t promptTemplate) variables() []templateVar {
+	var vars []templateVar
+	seen := make(map[string]bool)
+	for _, m := range templateVarPattern.FindAllStringSubmatchIndex(t.Text, -1) {
+		name := t.Text[m[2]:m[3]]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		v := templateVar{Name: name}
+		if m[4] >= 0 {
+			v.Default, v.HasDefault = strings.TrimSpace(t.Text[m[4]:m[5]]), true
+		}
+		vars = append(vars, v)
+	}
+	return vars
+}
+
+// render substitutes vars into the template. It returns the names of the
+// variables that have neither a value nor a default.
+func (t promptTemplate) render(vars map[string]string) (string, []string) {
+	var missing []string
+	seen := make(map[string]bool)
+	out := templateVarPattern.ReplaceAllStringFunc(t.Text, func(match string) string {
+		m := templateVarPattern.FindStringSubmatch(match)
+		if value, ok := vars[m[1]]; ok {
+			return value
+		}
+		if strings.Contains(match, "|") {
+			return strings.TrimSpace(m[2])
+		}
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			missing = append(missing, m[1])
+		}
+		return match
+	})
+	return out, missing
+}
+
+// parseTemplateVars reads --var key=value flags.
+func parseTemplateVars(pairs []string) (map[string]string, error) {
+	vars := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid --var %q; use name=value", pair)
+		}
+		vars[key] = value
+	}
+	return vars, nil
+}
+
+// renderTemplate expands the named template for create. Variables without a
+// value are asked for interactively; with nonInteractive they are an error.
+func renderTemplate(reader *bufio.Reader, out io.Writer, cfg *resolvedConfig, name string, vars map[string]string, nonInteractive bool) (string, error) {
+	dir, err := cfg.templatesDir()
+	if err != nil {
+		return "", err
+	}
+	t, err := loadTemplate(dir, name)
+	if err != nil {
+		return "", err
+	}
+	return fillTemplate(reader, out, t, vars, nonInteractive)
+}
+
+func fillTemplate(reader *bufio.Reader, out io.Writer, t promptTemplate, vars map[string]string, nonInteractive bool) (string, error) {
+	used := make(map[string]bool)
+	for _, v := range t.variables() {
+		used[v.Name] = true
+	}
+	for name := range vars {
+		if !used[name] {
+			logWarn("template %s has no variable %q", t.Name, name)
+		}
+	}
+	if !nonInteractive {
+		for _, v := range t.variables() {
+			if _, ok := vars[v.Name]; ok {
+				continue
+			}
+			if v.HasDefault {
+				if value := promptOptional(reader, out, fmt.Sprintf("%s [%s]", v.Name, v.Default)); value != "" {
+					vars[v.Name] = value
+				}
+				continue
+			}
+			vars[v.Name] = promptRequired(reader, out, v.Name)
+		}
+	}
+	prompt, missing := t.render(vars)
+	if len(missing) > 0 {
+		return "", fmt.Errorf("template %s needs --var for: %s", t.Name, strings.Join(missing, ", "))
+	}
+	return prompt, nil
+}
+
+// promptTemplateChoice offers the templates before asking for a free-form
+// prompt. It returns nil when the user writes their own.
+func promptTemplateChoice(reader *bufio.Reader, out io.Writer, templates []promptTemplate) *promptTemplate {
+	for {
+		fmt.Fprintln(out, "Start from a prompt template?")
+		fmt.Fprintln(out, "  0) No, write a prompt (default)")
+		for i, t := range templates {
+			fmt.Fprintf(out, "  %d) %s\n", i+1, t.Name)
+		}
+		fmt.Fprintf(out, "Enter choice (0-%d): ", len(templates))
+		input, err := reader.ReadString('\n')
+		if err != nil {
+			logError("input error: %v", err)
+			continue
+		}
+		input = strings.TrimSpace(input)
+		if input == "" || input == "0" {
+			return nil
+		}
+		if idx, convErr := strconv.Atoi(input); convErr == nil && idx >= 1 && idx <= len(templates) {
+			return &templates[idx-1]
+		}
+		for i := range templates {
+			if strings.EqualFold(input, templates[i].Name) {
+				return &templates[i]
+			}
+		}
+		fmt.Fprintln(out, "Invalid selection, please try again.")
+	}
+}
+
+// runTemplatesCommand lists the prompt templates and their variables.
+func runTemplatesCommand(args []string) int {
+	fs := newCommandFlagSet("templates")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		logError("unexpected argument %q", fs.Arg(0))
+		return 2
+	}
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	dir, err := cfg.templatesDir()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	templates, err := listTemplates(dir)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	if len(templates) == 0 {
+		logInfo("No templates in %s. Add <name>%s files there.", dir, templateExt)
+		return 0
+	}
+	fmt.Printf("Templates in %s:\n", dir)
+	for _, t := range templates {
+		var names []string
+		for _, v := range t.variables() {
+			if v.HasDefault {
+				names = append(names, fmt.Sprintf("%s=%s", v.Name, v.Default))
+			} else {
+				names = append(names, v.Name)
+			}
+		}
+		fmt.Printf("  %s", t.Name)
+		if len(names) > 0 {
+			fmt.Printf(" (%s)", strings.Join(names, ", "))
+		}
+		fmt.Println()
+		fmt.Printf("    %s\n", truncateText(t.Text, 100))
+	}
+	return 0
+}
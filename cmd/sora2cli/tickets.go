@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const linearAPIURL = "https://api.linear.app/graphql"
+
+var (
+	ticketIssueKeyPattern   = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*-[0-9]+$`)
+	ticketProjectKeyPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+)
+
+type ticketsConfig struct {
+	Provider   string       `yaml:"provider,omitempty"`
+	PreviewURL string       `yaml:"preview_url,omitempty"`
+	Jira       jiraConfig   `yaml:"jira,omitempty"`
+	Linear     linearConfig `yaml:"linear,omitempty"`
+}
+
+type jiraConfig struct {
+	BaseURL   string `yaml:"base_url,omitempty"`
+	Email     string `yaml:"email,omitempty"`
+	APIToken  string `yaml:"api_token,omitempty" env:"JIRA_API_TOKEN" secret:"true"`
+	IssueType string `yaml:"issue_type,omitempty"`
+}
+
+type linearConfig struct {
+	APIKey string `yaml:"api_key,omitempty" env:"LINEAR_API_KEY" secret:"true"`
+}
+
+type ticketEvent struct {
+	Ticket        string
+	JobID         string
+	Status        string
+	Prompt        string
+	Model         string
+	Seconds       int
+	EstimatedCost float64
+	OutputPath    string
+	Error         string
+}
+
+func validateTicketKey(key string) error {
+	if key == "" || ticketIssueKeyPattern.MatchString(key) || ticketProjectKeyPattern.MatchString(key) {
+		return nil
+	}
+	return fmt.Errorf("invalid ticket key %q; expected an issue key like ABC-123 or a Jira project key like ABC", key)
+}
+
+func (e ticketEvent) summary() string {
+	if e.Status == "completed" {
+		return fmt.Sprintf("Sora render %s completed", e.JobID)
+	}
+	if e.JobID == "" {
+		return "Sora render failed to submit"
+	}
+	return fmt.Sprintf("Sora render %s failed", e.JobID)
+}
+
+func (e ticketEvent) body(previewTemplate string) string {
+	var b strings.Builder
+	b.WriteString(e.summary())
+	b.WriteString("\n\n")
+	if e.Prompt != "" {
+		fmt.Fprintf(&b, "Prompt: %s\n", e.Prompt)
+	}
+	if e.Model != "" {
+		fmt.Fprintf(&b, "Model: %s", e.Model)
+		if e.Seconds > 0 {
+			fmt.Fprintf(&b, ", %d seconds", e.Seconds)
+		}
+		b.WriteString("\n")
+	}
+	if e.EstimatedCost > 0 {
+		fmt.Fprintf(&b, "Estimated cost: $%.2f\n", e.EstimatedCost)
+	}
+	if previewTemplate != "" && e.JobID != "" {
+		fmt.Fprintf(&b, "Preview: %s\n", strings.ReplaceAll(previewTemplate, "{job_id}", e.JobID))
+	}
+	if e.OutputPath != "" {
+		fmt.Fprintf(&b, "Local file: %s\n", e.OutputPath)
+	}
+	if e.Error != "" {
+		fmt.Fprintf(&b, "Error: %s\n", e.Error)
+	}
+	return b.String()
+}
+
+// reportTicket comments on the tagged issue, or for a bare Jira project key
+// opens a new issue in that project. It is a no-op without a ticket key.
+func reportTicket(ctx context.Context, cfg ticketsConfig, event ticketEvent) error {
+	if event.Ticket == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, notificationTimeout)
+	defer cancel()
+	client := &http.Client{Timeout: notificationTimeout}
+	body := event.body(cfg.PreviewURL)
+
+	switch strings.ToLower(cfg.Provider) {
+	case "jira":
+		if ticketIssueKeyPattern.MatchString(event.Ticket) {
+			return jiraComment(ctx, client, cfg.Jira, event.Ticket, body)
+		}
+		return jiraCreateIssue(ctx, client, cfg.Jira, event.Ticket, event.summary(), body)
+	case "linear":
+		if !ticketIssueKeyPattern.MatchString(event.Ticket) {
+			return fmt.Errorf("linear needs an issue key like ENG-123, got %q", event.Ticket)
+		}
+		return linearComment(ctx, client, cfg.Linear, event.Ticket, body)
+	case "":
+		return errors.New("job has a ticket key but tickets.provider is not configured")
+	default:
+		return fmt.Errorf("unknown ticket provider %q", cfg.Provider)
+	}
+}
+
+func reportTicketOrWarn(cfg ticketsConfig, event ticketEvent) {
+	if event.Ticket == "" {
+		return
+	}
+	if err := reportTicket(context.Background(), cfg, event); err != nil {
+		logWarn("unable to update ticket %s: %v", event.Ticket, err)
+		return
+	}
+	logInfo("Updated ticket %s", event.Ticket)
+}
+
+func jiraComment(ctx context.Context, client *http.Client, cfg jiraConfig, issueKey, body string) error {
+	endpoint := fmt.Sprintf("%s/rest/api/2/issue/%s/comment", strings.TrimRight(cfg.BaseURL, "/"), issueKey)
+	return jiraPost(ctx, client, cfg, endpoint, map[string]string{"body": body})
+}
+
+func jiraCreateIssue(ctx context.Context, client *http.Client, cfg jiraConfig, projectKey, summary, body string) error {
+	issueType := cfg.IssueType
+	if issueType == "" {
+		issueType = "Task"
+	}
+	payload := map[string]any{
+		"fields": map[string]any{
+			"project":     map[string]string{"key": projectKey},
+			"summary":     summary,
+			"description": body,
+			"issuetype":   map[string]string{"name": issueType},
+		},
+	}
+	return jiraPost(ctx, client, cfg, strings.TrimRight(cfg.BaseURL, "/")+"/rest/api/2/issue", payload)
+}
+
+func jiraPost(ctx context.Context, client *http.Client, cfg jiraConfig, endpoint string, payload any) error {
+	if cfg.BaseURL == "" || cfg.Email == "" || cfg.APIToken == "" {
+		return errors.New("tickets.jira needs base_url, email and api_token")
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(cfg.Email, cfg.APIToken)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("jira API error (%d): %s", resp.StatusCode, readAPIError(resp.Body))
+	}
+	return nil
+}
+
+func linearComment(ctx context.Context, client *http.Client, cfg linearConfig, issueKey, body string) error {
+	if cfg.APIKey == "" {
+		return errors.New("tickets.linear needs api_key")
+	}
+	payload := map[string]any{
+		"query": "mutation($issueId: String!, $body: String!) { commentCreate(input: {issueId: $issueId, body: $body}) { success } }",
+		"variables": map[string]string{
+			"issueId": issueKey,
+			"body":    body,
+		},
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, linearAPIURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", cfg.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("linear API error (%d): %s", resp.StatusCode, readAPIError(resp.Body))
+	}
+	var result struct {
+		Data struct {
+			CommentCreate struct {
+				Success bool `json:"success"`
+			} `json:"commentCreate"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("linear API error: %s", result.Errors[0].Message)
+	}
+	if !result.Data.CommentCreate.Success {
+		return errors.New("linear did not accept the comment")
+	}
+	return nil
+}
+
+func jobCostEstimate(model string, seconds int) float64 {
+	if opt, ok := findModelOption(model); ok {
+		return opt.RatePerSecond * float64(seconds)
+	}
+	return 0
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+// trashConfig controls how long history records, downloads and queue items
+// removed through the CLI can be brought back with undo.
+type trashConfig struct {
+	// Keep is how long removed items stay recoverable (default 168h).
+	Keep string `yaml:"keep,omitempty" env:"SORA2_TRASH_KEEP"`
+}
+
+const defaultTrashKeep = 7 * 24 * time.Hour
+
+func (c trashConfig) keep() (time.Duration, error) {
+	if c.Keep == "" {
+		return defaultTrashKeep, nil
+	}
+	d, err := time.ParseDuration(c.Keep)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, e.g. 168h", c.Keep)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s must be positive", c.Keep)
+	}
+	return d, nil
+}
+
+func validateTrashConfig(c trashConfig) []configIssue {
+	if _, err := c.keep(); err != nil {
+		return []configIssue{{Key: "trash.keep", Message: err.Error()}}
+	}
+	return nil
+}
+
+// trashedFile is a file moved aside by a removal. It is renamed to a hidden
+// name next to where it was, so that large videos are never copied and the
+// rename cannot fail half-way.
+type trashedFile struct {
+	Path    string `json:"path"`
+	Trashed string `json:"trashed"`
+}
+
+// trashRecord is one removal that undo can take back: everything a single
+// command removed, kept in trash/<id>.json in the data directory.
+type trashRecord struct {
+	ID         string          `json:"id"`
+	Command    string          `json:"command"`
+	DeletedAt  time.Time       `json:"deleted_at"`
+	Files      []trashedFile   `json:"files,omitempty"`
+	History    []*historyEntry `json:"history,omitempty"`
+	Queue      string          `json:"queue,omitempty"`
+	QueueItems []*queueItem    `json:"queue_items,omitempty"`
+}
+
+func trashDir() (string, error) {
+	dir, err := resolveDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "trash"), nil
+}
+
+func newTrashRecord(command string) *trashRecord {
+	now := time.Now()
+	return &trashRecord{
+		ID:        fmt.Sprintf("%s-%03d", now.Format("20060102-150405"), now.Nanosecond()/int(time.Millisecond)),
+		Command:   command,
+		DeletedAt: now.UTC(),
+	}
+}
+
+func (r *trashRecord) empty() bool {
+	return len(r.Files) == 0 && len(r.History) == 0 && len(r.QueueItems) == 0
+}
+
+// summary says what the record holds, e.g. "2 record(s), 5 file(s)".
+func (r *trashRecord) summary() string {
+	var parts []string
+	if n := len(r.History); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d record(s)", n))
+	}
+	if n := len(r.Files); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d file(s)", n))
+	}
+	if n := len(r.QueueItems); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d item(s) of queue %s", n, r.Queue))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// moveFile renames the file at path aside and notes it in the record.
+func (r *trashRecord) moveFile(path string) error {
+	trashed := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".deleted-"+r.ID)
+	if err := os.Rename(path, trashed); err != nil {
+		return err
+	}
+	r.Files = append(r.Files, trashedFile{Path: path, Trashed: trashed})
+	return nil
+}
+
+func (r *trashRecord) save() error {
+	dir, err := trashDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, r.ID+".json"), append(data, '\n'), 0o600)
+}
+
+func (r *trashRecord) discard() error {
+	dir, err := trashDir()
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(dir, r.ID+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// restore puts everything in the record back where it was and reports what
+// could not be, such as a file whose name has been taken since. Files left
+// in the trash stay in the record, and so do history records and queue items
+// whose file could not be written; records and items that exist again are
+// dropped from it.
+func (r *trashRecord) restore() []error {
+	var problems []error
+	remaining := r.Files[:0]
+	for _, f := range r.Files {
+		if _, err := os.Stat(f.Path); err == nil {
+			problems = append(problems, fmt.Errorf("%s exists again; the removed copy stays in the trash", f.Path))
+			remaining = append(remaining, f)
+			continue
+		}
+		if err := os.Rename(f.Trashed, f.Path); err != nil {
+			problems = append(problems, err)
+			remaining = append(remaining, f)
+		}
+	}
+	r.Files = remaining
+	if len(r.History) > 0 {
+		skipped, err := restoreHistory(r.History)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("restore history: %w", err))
+		} else {
+			for _, entry := range skipped {
+				problems = append(problems, fmt.Errorf("%s has a history record again; kept that one", entry.JobID))
+			}
+			r.History = nil
+		}
+	}
+	if len(r.QueueItems) > 0 {
+		store, err := openQueueStore(r.Queue)
+		if err == nil {
+			err = store.modify(func() error {
+				for _, item := range r.QueueItems {
+					if store.find(item.ID) != nil {
+						problems = append(problems, fmt.Errorf("queue %s has an item #%d again", r.Queue, item.ID))
+						continue
+					}
+					store.state.Items = append(store.state.Items, item)
+				}
+				sort.SliceStable(store.state.Items, func(i, j int) bool { return store.state.Items[i].ID < store.state.Items[j].ID })
+				return nil
+			})
+		}
+		if err != nil {
+			problems = append(problems, fmt.Errorf("restore queue %s: %w", r.Queue, err))
+		} else {
+			r.QueueItems = nil
+		}
+	}
+	return problems
+}
+
+// purge removes the record and its files for good.
+func (r *trashRecord) purge() error {
+	for _, f := range r.Files {
+		if err := os.Remove(f.Trashed); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return r.discard()
+}
+
+// loadTrash returns the records in the trash, oldest first.
+func loadTrash() ([]*trashRecord, error) {
+	dir, err := trashDir()
+	if err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*trashRecord, 0, len(matches))
+	for _, path := range matches {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var record trashRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		records = append(records, &record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].DeletedAt.Before(records[j].DeletedAt) })
+	return records, nil
+}
+
+// purgeExpiredTrash removes what has been in the trash for longer than keep
+// and returns the records that remain. Commands that put things in the trash
+// call it, so nothing outlives the window by more than one removal.
+func purgeExpiredTrash(keep time.Duration) []*trashRecord {
+	records, err := loadTrash()
+	if err != nil {
+		logWarn("unable to read the trash: %v", err)
+		return nil
+	}
+	kept := records[:0]
+	for _, record := range records {
+		if time.Since(record.DeletedAt) < keep {
+			kept = append(kept, record)
+			continue
+		}
+		if err := record.purge(); err != nil {
+			logWarn("unable to empty %s from the trash: %v", record.ID, err)
+			kept = append(kept, record)
+		}
+	}
+	return kept
+}
+
+// localFiles lists the files on disk that belong to the entry's download:
+// the MP4, its sidecar, the thumbnail and spritesheet saved next to it and
+// derived copies.
+func (e *historyEntry) localFiles() []string {
+	if e.OutputPath == "" {
+		return nil
+	}
+	candidates := []string{e.OutputPath, sidecarPath(e.OutputPath)}
+	for _, variant := range sora.Variants {
+		if variant != sora.VariantVideo {
+			candidates = append(candidates, filepath.Join(filepath.Dir(e.OutputPath), variantFilename(e.JobID, variant)))
+		}
+	}
+	names := make([]string, 0, len(e.Derived))
+	for name := range e.Derived {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		candidates = append(candidates, e.Derived[name])
+	}
+	var files []string
+	seen := make(map[string]bool)
+	for _, path := range candidates {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// trashJobs moves the downloads and history records of jobIDs to the trash
+// as one record. If anything fails, what was moved is put back.
+func trashJobs(jobIDs []string, command string) (*trashRecord, error) {
+	state, err := loadHistory()
+	if err != nil {
+		return nil, err
+	}
+	record := newTrashRecord(command)
+	for _, jobID := range jobIDs {
+		entry := state.find(jobID)
+		if entry == nil {
+			return nil, fmt.Errorf("no local record of %s", jobID)
+		}
+		for _, path := range entry.localFiles() {
+			if err := record.moveFile(path); err != nil {
+				record.restore()
+				return nil, err
+			}
+		}
+	}
+	if record.History, err = removeHistory(jobIDs); err == nil {
+		err = record.save()
+	}
+	if err != nil {
+		record.restore()
+		return nil, err
+	}
+	return record, nil
+}
+
+// reportTrashed says how to take a removal back.
+func reportTrashed(record *trashRecord, keep time.Duration) {
+	logInfo("Run 'sora2cli undo' before %s to bring them back.", formatTimestamp(record.DeletedAt.Add(keep)))
+}
+
+// runUndoCommand restores the most recent removal, or the one given by ID.
+func runUndoCommand(args []string) int {
+	fs := newCommandFlagSet("undo")
+	list := fs.Bool("list", false, "list what is in the trash instead of restoring")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli undo [--list] [trash-id]")
+		return 2
+	}
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	keep, err := cfg.Trash.keep()
+	if err != nil {
+		logError("trash.keep: %v", err)
+		return 1
+	}
+	records := purgeExpiredTrash(keep)
+
+	if *list {
+		if len(records) == 0 {
+			logInfo("The trash is empty.")
+			return 0
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tREMOVED\tUNTIL\tCOMMAND\tCONTENTS")
+		for _, r := range records {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.ID, formatTimestamp(r.DeletedAt), formatTimestamp(r.DeletedAt.Add(keep)), truncateText(r.Command, 40), r.summary())
+		}
+		tw.Flush()
+		return 0
+	}
+
+	if len(records) == 0 {
+		logInfo("Nothing to undo.")
+		return 0
+	}
+	record := records[len(records)-1]
+	if id := fs.Arg(0); id != "" {
+		record = nil
+		for _, r := range records {
+			if r.ID == id {
+				record = r
+			}
+		}
+		if record == nil {
+			logError("%s is not in the trash; see 'sora2cli undo --list'", id)
+			return 1
+		}
+	}
+	contents := record.summary()
+	problems := record.restore()
+	for _, err := range problems {
+		logWarn("%v", err)
+	}
+	if record.empty() {
+		err = record.discard()
+	} else {
+		err = record.save()
+	}
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	if len(problems) > 0 {
+		logInfo("Restored part of %s removed by '%s' at %s.", contents, record.Command, formatTimestamp(record.DeletedAt))
+		if !record.empty() {
+			logInfo("%s stays in the trash as %s.", record.summary(), record.ID)
+		}
+		return 1
+	}
+	logInfo("Restored %s removed by '%s' at %s.", contents, record.Command, formatTimestamp(record.DeletedAt))
+	return 0
+}
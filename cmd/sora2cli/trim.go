@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// trimConfig cuts every downloaded MP4 to an in/out range, for clips whose
+// first half-second is always weak. The cut replaces the download.
+type trimConfig struct {
+	// Range is the part to keep as in:out in seconds, e.g. 0.5:3.8; either
+	// side may be left out, as in 0.5: to drop only the start.
+	Range string `yaml:"range,omitempty" env:"SORA2_TRIM"`
+	// Mode is reencode (the default), which cuts on the exact frame and
+	// re-encodes, or smart, which copies the streams untouched whenever the
+	// in-point falls on a keyframe and re-encodes only when it does not.
+	Mode string `yaml:"mode,omitempty" env:"SORA2_TRIM_MODE"`
+	// CRF is the x264 quality of the re-encode (default 16).
+	CRF int `yaml:"crf,omitempty"`
+	// KeepOriginal keeps the uncut file as <id>.untrimmed.mp4.
+	KeepOriginal bool `yaml:"keep_original,omitempty"`
+	// FFmpeg is the ffmpeg binary; by default grade.ffmpeg or the one on
+	// PATH.
+	FFmpeg string `yaml:"ffmpeg,omitempty"`
+}
+
+const (
+	trimModeReencode = "reencode"
+	trimModeSmart    = "smart"
+)
+
+// trimRange is a parsed in:out range. A zero Out means the end of the clip.
+type trimRange struct {
+	In, Out time.Duration
+}
+
+func parseTrimRange(value string) (trimRange, error) {
+	in, out, ok := strings.Cut(strings.TrimSpace(value), ":")
+	if !ok {
+		return trimRange{}, fmt.Errorf("invalid range %q; use in:out in seconds, e.g. 0.5:3.8", value)
+	}
+	var r trimRange
+	for _, side := range []struct {
+		text string
+		dst  *time.Duration
+	}{{in, &r.In}, {out, &r.Out}} {
+		if side.text = strings.TrimSpace(side.text); side.text == "" {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(side.text, 64)
+		if err != nil || seconds < 0 || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+			return trimRange{}, fmt.Errorf("invalid time %q in range %q; use seconds, e.g. 0.5", side.text, value)
+		}
+		*side.dst = time.Duration(seconds * float64(time.Second))
+	}
+	if r.In == 0 && r.Out == 0 {
+		return trimRange{}, fmt.Errorf("range %q keeps the whole clip", value)
+	}
+	if r.Out != 0 && r.Out <= r.In {
+		return trimRange{}, fmt.Errorf("range %q ends before it starts", value)
+	}
+	return r, nil
+}
+
+func (r trimRange) String() string {
+	format := func(d time.Duration) string { return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) }
+	if r.Out == 0 {
+		return format(r.In) + ":"
+	}
+	return format(r.In) + ":" + format(r.Out)
+}
+
+func validateTrimConfig(c trimConfig) []configIssue {
+	var issues []configIssue
+	if c.Range != "" {
+		if _, err := parseTrimRange(c.Range); err != nil {
+			issues = append(issues, configIssue{Key: "trim.range", Message: err.Error()})
+		}
+	}
+	switch c.Mode {
+	case "", trimModeReencode, trimModeSmart:
+	default:
+		issues = append(issues, configIssue{Key: "trim.mode", Message: fmt.Sprintf("unknown mode %q; use reencode or smart", c.Mode)})
+	}
+	if c.CRF < 0 || c.CRF > 51 {
+		issues = append(issues, configIssue{Key: "trim.crf", Message: "must be between 0 and 51"})
+	}
+	return issues
+}
+
+// trimming returns the trim settings with the ffmpeg binary taken from grade
+// when it is not set of its own.
+func (c *resolvedConfig) trimming() trimConfig {
+	trim := c.Trim
+	if trim.FFmpeg == "" {
+		trim.FFmpeg = c.Grade.FFmpeg
+	}
+	return trim
+}
+
+// onKeyframe reports whether at is within half a frame of a keyframe of the
+// MP4 at path.
+func onKeyframe(path string, at time.Duration) (bool, error) {
+	if at == 0 {
+		return true, nil
+	}
+	keyframes, err := mp4Keyframes(path)
+	if err != nil {
+		return false, err
+	}
+	if keyframes == nil {
+		return true, nil
+	}
+	rate, err := mp4FrameRate(path)
+	if err != nil {
+		return false, err
+	}
+	tolerance := time.Duration(float64(time.Second) / rate / 2)
+	for _, t := range keyframes {
+		if diff := t - at; diff > -tolerance && diff < tolerance {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// applyTrim cuts the MP4 at path to r, replacing it once ffmpeg has
+// succeeded. Cutting again starts from a kept original, so ranges do not
+// stack. It reports whether the streams were copied rather than re-encoded.
+func applyTrim(ctx context.Context, trim trimConfig, r trimRange, path string) (copied bool, err error) {
+	ffmpeg, err := lookupFFmpeg(trim.FFmpeg, "trim.ffmpeg")
+	if err != nil {
+		return false, err
+	}
+	source := path
+	original := strings.TrimSuffix(path, ".mp4") + ".untrimmed.mp4"
+	_, err = os.Stat(original)
+	haveOriginal := err == nil
+	if haveOriginal {
+		source = original
+	}
+	length, err := mp4Duration(source)
+	if err != nil {
+		return false, fmt.Errorf("read duration: %w", err)
+	}
+	if r.In >= length || r.Out > length {
+		return false, fmt.Errorf("range %s is past the end of the %ss clip", r, strconv.FormatFloat(length.Seconds(), 'f', -1, 64))
+	}
+	if trim.Mode == trimModeSmart {
+		if copied, err = onKeyframe(source, r.In); err != nil {
+			return false, fmt.Errorf("read keyframes: %w", err)
+		}
+	}
+	crf := trim.CRF
+	if crf == 0 {
+		crf = defaultGradeCRF
+	}
+
+	seconds := func(d time.Duration) string { return strconv.FormatFloat(d.Seconds(), 'f', 3, 64) }
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y"}
+	if copied {
+		// Seeking before the input lands on the keyframe exactly.
+		args = append(args, "-ss", seconds(r.In), "-i", source)
+	} else {
+		// Seeking after the input decodes up to the exact frame.
+		args = append(args, "-i", source, "-ss", seconds(r.In))
+	}
+	if r.Out > 0 {
+		args = append(args, "-t", seconds(r.Out-r.In))
+	}
+	args = append(args, "-map", "0", "-map_metadata", "0")
+	if copied {
+		args = append(args, "-c", "copy", "-avoid_negative_ts", "make_zero")
+	} else {
+		args = append(args, "-c:v", "libx264", "-crf", strconv.Itoa(crf), "-preset", "medium", "-pix_fmt", "yuv420p", "-c:a", "copy")
+	}
+	tmpPath := strings.TrimSuffix(path, ".mp4") + ".trimming.mp4"
+	args = append(args, "-movflags", "+faststart", tmpPath)
+	if err := runTool(ctx, ffmpeg, args...); err != nil {
+		os.Remove(tmpPath)
+		return false, err
+	}
+	if trim.KeepOriginal && !haveOriginal {
+		if err := os.Rename(path, original); err != nil {
+			os.Remove(tmpPath)
+			return false, err
+		}
+	}
+	return copied, os.Rename(tmpPath, path)
+}
+
+// trimDownload cuts a fresh download. A failed cut leaves the whole video in
+// place with a warning, since the render has been paid for.
+func trimDownload(ctx context.Context, trim trimConfig, r trimRange, jobID, path string) {
+	logInfo("Trimming %s to %s...", jobID, r)
+	copied, err := applyTrim(ctx, trim, r, path)
+	if err != nil {
+		logWarn("unable to trim %s, the whole video is kept: %v", jobID, err)
+		return
+	}
+	how := "re-encoded"
+	if copied {
+		how = "streams copied"
+	}
+	logInfo("Trimmed %s (%s)", path, how)
+}
+
+// runTrimCommand trims videos that are already downloaded.
+func runTrimCommand(args []string) int {
+	fs := newCommandFlagSet("trim")
+	rangeFlag := fs.String("range", "", "part to keep as in:out in seconds, e.g. 0.5:3.8 (default: trim.range)")
+	mode := fs.String("mode", "", "reencode or smart (default: trim.mode or reencode)")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli trim [--range in:out] [--mode reencode|smart] <video-id>...")
+		return 2
+	}
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	trim := cfg.trimming()
+	if *rangeFlag != "" {
+		trim.Range = *rangeFlag
+	}
+	if *mode != "" {
+		trim.Mode = *mode
+	}
+	if trim.Range == "" {
+		logError("no range given; pass --range or set trim.range")
+		return 2
+	}
+	if issues := validateTrimConfig(trim); len(issues) > 0 {
+		logError("%s: %s", issues[0].Key, issues[0].Message)
+		return 2
+	}
+	r, _ := parseTrimRange(trim.Range)
+	state, err := loadHistory()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	failed := 0
+	for _, jobID := range fs.Args() {
+		entry := state.find(jobID)
+		if entry == nil || entry.OutputPath == "" {
+			logError("%s: no downloaded video in history", jobID)
+			failed++
+			continue
+		}
+		if _, err := applyTrim(ctx, trim, r, entry.OutputPath); err != nil {
+			logError("%s: %v", jobID, err)
+			failed++
+			continue
+		}
+		logInfo("Trimmed %s to %s", entry.OutputPath, r)
+		if size, sum, err := fileDigest(entry.OutputPath); err == nil {
+			updateHistoryOrWarn(jobID, false, func(e *historyEntry) {
+				e.OutputBytes = size
+				e.SHA256 = sum
+			})
+		}
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
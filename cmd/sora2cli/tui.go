@@ -0,0 +1,805 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+	"golang.org/x/term"
+)
+
+const tuiRefreshInterval = 5 * time.Second
+
+// tuiKey is one key press: a named key such as "up", or a typed rune.
+type tuiKey struct {
+	name string
+	r    rune
+}
+
+// tuiJob is a job submitted from the composer. The TUI follows it to the
+// download, as the create command does.
+type tuiJob struct {
+	ID       string
+	Prompt   string
+	Status   string
+	Progress float64
+	Output   string
+	Err      string
+}
+
+func (j *tuiJob) done() bool {
+	return j.Output != "" || j.Err != ""
+}
+
+// Composer fields, in the order ↑ and ↓ step through them.
+const (
+	composerPrompt = iota
+	composerModel
+	composerSeconds
+	composerSize
+	composerFields
+)
+
+// tuiComposer is the prompt composer's form. The choices are indexes into
+// modelOptions, allowedDurations and the model's resolutions.
+type tuiComposer struct {
+	field   int
+	prompt  []rune
+	model   int
+	seconds int
+	size    int
+}
+
+// tui is the full-screen interface of the tui command: the library on the
+// left, the selected video and the active jobs on the right, and the prompt
+// composer at the bottom. Only the main loop touches it; background work
+// reports back through events.
+type tui struct {
+	client      *sora.Client
+	cfg         *resolvedConfig
+	budget      *budgetGuard
+	destination string
+	layout      extraOutputs
+
+	videos   []sora.Video
+	state    historyState
+	selected int
+	offset   int
+	updated  time.Time
+	loading  bool
+
+	composing bool
+	composer  tuiComposer
+	jobs      []*tuiJob
+	message   string
+	// quitting is set by a first q while jobs still render.
+	quitting bool
+
+	events chan func(*tui)
+}
+
+func runTUICommand(args []string) int {
+	fs := newCommandFlagSet("tui")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		logError("unexpected argument %q", fs.Arg(0))
+		return 2
+	}
+	if !stdinIsTerminal() || !term.IsTerminal(int(os.Stdout.Fd())) {
+		logError("the TUI needs a terminal; use list, create and get instead")
+		return 2
+	}
+	session, err := newAPISession(false)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	destination, err := prepareDestinationDirectory(session.cfg.Defaults.Destination)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	t := &tui{
+		client:      session.client,
+		cfg:         session.cfg,
+		budget:      newBudgetGuard(session.cfg.Budget, 0),
+		destination: destination,
+		layout:      extraOutputs{Layout: session.cfg.Defaults.Layout, Project: configProject(session.cfg)},
+		events:      make(chan func(*tui), 64),
+	}
+	if err := t.run(); err != nil {
+		logError("%v", err)
+		return 1
+	}
+	return 0
+}
+
+func (t *tui) run() error {
+	if err := enterRawTerminal(); err != nil {
+		return err
+	}
+	defer restoreTerminal()
+	// The alternate screen keeps the shell's scrollback intact.
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan tuiKey)
+	go readTUIKeys(keys)
+	ticker := time.NewTicker(tuiRefreshInterval)
+	defer ticker.Stop()
+
+	t.refresh()
+	for {
+		t.draw()
+		select {
+		case key, ok := <-keys:
+			if !ok || !t.handleKey(key) {
+				return nil
+			}
+		case fn := <-t.events:
+			fn(t)
+		case <-ticker.C:
+			t.refresh()
+		}
+	}
+}
+
+// post hands fn to the main loop.
+func (t *tui) post(fn func(*tui)) {
+	t.events <- fn
+}
+
+// readTUIKeys turns raw input into keys. A terminal sends an escape
+// sequence in one read, which tells the arrow keys apart from Esc.
+func readTUIKeys(keys chan<- tuiKey) {
+	defer close(keys)
+	buf := make([]byte, 64)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		chunk := string(buf[:n])
+		if name, ok := tuiEscapes[chunk]; ok {
+			keys <- tuiKey{name: name}
+			continue
+		}
+		if strings.HasPrefix(chunk, "\x1b") {
+			continue
+		}
+		for _, r := range chunk {
+			switch r {
+			case '\r', '\n':
+				keys <- tuiKey{name: "enter"}
+			case '\t':
+				keys <- tuiKey{name: "tab"}
+			case 0x7f, 0x08:
+				keys <- tuiKey{name: "backspace"}
+			case 0x03:
+				keys <- tuiKey{name: "ctrl-c"}
+			case 0x15:
+				keys <- tuiKey{name: "ctrl-u"}
+			default:
+				if r >= ' ' {
+					keys <- tuiKey{r: r}
+				}
+			}
+		}
+	}
+}
+
+var tuiEscapes = map[string]string{
+	"\x1b":    "esc",
+	"\x1b[A":  "up",
+	"\x1b[B":  "down",
+	"\x1b[C":  "right",
+	"\x1b[D":  "left",
+	"\x1bOA":  "up",
+	"\x1bOB":  "down",
+	"\x1bOC":  "right",
+	"\x1bOD":  "left",
+	"\x1b[5~": "pgup",
+	"\x1b[6~": "pgdown",
+	"\x1b[H":  "home",
+	"\x1b[F":  "end",
+}
+
+// refresh reloads the library in the background.
+func (t *tui) refresh() {
+	if t.loading {
+		return
+	}
+	t.loading = true
+	go func() {
+		state, err := loadHistory()
+		var list *sora.VideoList
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			list, err = listVideos(ctx, t.client, state, listOptions{}, 100, "desc")
+			cancel()
+		}
+		t.post(func(t *tui) {
+			t.loading = false
+			if err != nil {
+				t.message = fmt.Sprintf("Refresh failed: %v", err)
+				return
+			}
+			t.setVideos(list.Data, state)
+		})
+	}()
+}
+
+// setVideos replaces the library, keeping the same video selected.
+func (t *tui) setVideos(videos []sora.Video, state historyState) {
+	var selectedID string
+	if v := t.current(); v != nil {
+		selectedID = v.ID
+	}
+	t.videos, t.state, t.updated = videos, state, time.Now()
+	t.selected = 0
+	for i := range videos {
+		if videos[i].ID == selectedID {
+			t.selected = i
+			break
+		}
+	}
+}
+
+func (t *tui) current() *sora.Video {
+	if t.selected < 0 || t.selected >= len(t.videos) {
+		return nil
+	}
+	return &t.videos[t.selected]
+}
+
+// handleKey acts on a key and reports whether the TUI keeps running.
+func (t *tui) handleKey(key tuiKey) bool {
+	if t.composing {
+		t.composerKey(key)
+		return true
+	}
+	quitKey := key.r == 'q' || key.name == "ctrl-c"
+	if !quitKey {
+		t.quitting = false
+	}
+	t.message = ""
+	switch {
+	case key.name == "up" || key.r == 'k':
+		t.move(-1)
+	case key.name == "down" || key.r == 'j':
+		t.move(1)
+	case key.name == "pgup":
+		t.move(-t.listHeight())
+	case key.name == "pgdown":
+		t.move(t.listHeight())
+	case key.name == "home" || key.r == 'g':
+		t.move(-len(t.videos))
+	case key.name == "end" || key.r == 'G':
+		t.move(len(t.videos))
+	case key.r == 'c' || key.r == 'n':
+		t.openComposer()
+	case key.r == 'r':
+		t.message = "Refreshing..."
+		t.refresh()
+	case key.r == 'd':
+		t.download()
+	case quitKey:
+		return t.quit()
+	}
+	return true
+}
+
+// quit leaves at once unless jobs from the composer still render; those
+// take a second q (or Ctrl+C), as they then carry on unwatched.
+func (t *tui) quit() bool {
+	running := 0
+	for _, job := range t.jobs {
+		if !job.done() {
+			running++
+		}
+	}
+	if running == 0 || t.quitting {
+		return false
+	}
+	t.quitting = true
+	t.message = fmt.Sprintf("%d job(s) still rendering. Press q again to quit; collect them later with sora2cli wait.", running)
+	return true
+}
+
+func (t *tui) move(delta int) {
+	t.selected = max(0, min(len(t.videos)-1, t.selected+delta))
+}
+
+// download saves the selected completed video to the default destination.
+func (t *tui) download() {
+	v := t.current()
+	if v == nil {
+		return
+	}
+	if v.Status != "completed" {
+		t.message = fmt.Sprintf("%s is %s; only completed videos can be downloaded.", v.ID, v.Status)
+		return
+	}
+	video := *v
+	var tags []string
+	if entry := t.state.find(video.ID); entry != nil {
+		tags = entry.Tags
+	}
+	path, err := t.layout.outputPath(t.destination, &video, tags)
+	if err != nil {
+		t.message = fmt.Sprintf("Download of %s failed: %v", video.ID, err)
+		return
+	}
+	t.message = fmt.Sprintf("Downloading %s...", video.ID)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), maxWaitDuration)
+		defer cancel()
+		err := t.client.DownloadFile(ctx, video.ID, path)
+		if err == nil {
+			markHistoryCompleted(&video, "tui", path)
+		}
+		t.post(func(t *tui) {
+			if err != nil {
+				t.message = fmt.Sprintf("Download of %s failed: %v", video.ID, err)
+				return
+			}
+			t.message = fmt.Sprintf("Saved %s to %s", video.ID, path)
+		})
+	}()
+}
+
+// openComposer starts a new prompt with the configured defaults.
+func (t *tui) openComposer() {
+	defaults := t.cfg.Defaults
+	c := tuiComposer{}
+	for i, m := range modelOptions {
+		if m.Name == defaults.Model {
+			c.model = i
+		}
+	}
+	for i, s := range allowedDurations {
+		if s == defaults.Seconds {
+			c.seconds = i
+		}
+	}
+	for i, r := range modelOptions[c.model].Resolutions {
+		if r.Value == defaults.Size {
+			c.size = i
+		}
+	}
+	t.composer, t.composing = c, true
+	t.message = ""
+}
+
+func (t *tui) composerKey(key tuiKey) {
+	c := &t.composer
+	switch key.name {
+	case "esc", "ctrl-c":
+		t.composing = false
+		return
+	case "enter":
+		t.submit()
+		return
+	case "up":
+		c.field = (c.field + composerFields - 1) % composerFields
+		return
+	case "down", "tab":
+		c.field = (c.field + 1) % composerFields
+		return
+	}
+	if c.field == composerPrompt {
+		switch {
+		case key.name == "backspace" && len(c.prompt) > 0:
+			c.prompt = c.prompt[:len(c.prompt)-1]
+		case key.name == "ctrl-u":
+			c.prompt = nil
+		case key.name == "" && key.r != 0:
+			c.prompt = append(c.prompt, key.r)
+		}
+		return
+	}
+	step := 0
+	switch {
+	case key.name == "left" || key.r == 'h':
+		step = -1
+	case key.name == "right" || key.r == 'l' || key.r == ' ':
+		step = 1
+	}
+	cycle := func(i, n int) int { return (i + step + n) % n }
+	switch c.field {
+	case composerModel:
+		// Keep the size if the other model has it too.
+		size := modelOptions[c.model].Resolutions[c.size].Value
+		c.model = cycle(c.model, len(modelOptions))
+		c.size = 0
+		for i, r := range modelOptions[c.model].Resolutions {
+			if r.Value == size {
+				c.size = i
+			}
+		}
+	case composerSeconds:
+		c.seconds = cycle(c.seconds, len(allowedDurations))
+	case composerSize:
+		c.size = cycle(c.size, len(modelOptions[c.model].Resolutions))
+	}
+}
+
+func (c tuiComposer) spec() jobSpec {
+	model := modelOptions[c.model]
+	return jobSpec{
+		Prompt:  string(c.prompt),
+		Model:   model.Name,
+		Seconds: allowedDurations[c.seconds],
+		Size:    model.Resolutions[c.size].Value,
+	}
+}
+
+func (c tuiComposer) cost() float64 {
+	return modelOptions[c.model].RatePerSecond * float64(allowedDurations[c.seconds])
+}
+
+// submit sends the composed prompt and follows the job in the background
+// until its MP4 is downloaded.
+func (t *tui) submit() {
+	spec := t.composer.spec()
+	if _, err := spec.resolve(t.cfg.Defaults); err != nil {
+		t.message = fmt.Sprintf("Cannot submit: %v", err)
+		return
+	}
+	cost := t.composer.cost()
+	if err := t.budget.reserve("", cost); err != nil {
+		t.message = fmt.Sprintf("Over budget: %v", err)
+		return
+	}
+	t.composing = false
+	job := &tuiJob{Prompt: spec.Prompt, Status: "submitting"}
+	t.jobs = append(t.jobs, job)
+	t.message = fmt.Sprintf("Submitting (estimated $%.2f)...", cost)
+	go t.follow(job, spec, cost)
+}
+
+func (t *tui) follow(job *tuiJob, spec jobSpec, cost float64) {
+	ctx, cancel := context.WithTimeout(context.Background(), maxWaitDuration)
+	defer cancel()
+	fail := func(jobID string, err error) {
+		if jobID != "" {
+			markHistoryFailed(jobID, err)
+		}
+		t.post(func(t *tui) {
+			job.Status, job.Err = "failed", err.Error()
+			t.message = fmt.Sprintf("Job failed: %v", err)
+		})
+	}
+
+	video, err := t.client.Create(ctx, spec.createParams())
+	if err != nil {
+		t.budget.release(cost)
+		fail("", fmt.Errorf("create video job: %w", err))
+		return
+	}
+	recordJobHistory(video, "tui", func(e *historyEntry) { e.awaitDownload(t.destination) })
+	defer watchJob(video.ID).unlock()
+	queued := *video
+	t.post(func(t *tui) {
+		job.ID, job.Status = queued.ID, queued.Status
+		t.message = fmt.Sprintf("Job queued with ID %s", queued.ID)
+		t.refresh()
+	})
+
+	video, err = t.client.Wait(ctx, queued.ID, func(v *sora.Video) {
+		status, progress := v.Status, v.ProgressPercent()
+		t.post(func(*tui) { job.Status, job.Progress = status, progress })
+	})
+	if err != nil {
+		fail(queued.ID, err)
+		return
+	}
+	t.post(func(*tui) { job.Status, job.Progress = "downloading", 100 })
+	path, err := t.layout.outputPath(t.destination, video, nil)
+	if err != nil {
+		fail(video.ID, err)
+		return
+	}
+	if err := t.client.DownloadFile(ctx, video.ID, path); err != nil {
+		fail(video.ID, fmt.Errorf("download video: %w", err))
+		return
+	}
+	markHistoryCompleted(video, "tui", path)
+	t.post(func(t *tui) {
+		job.Status, job.Output = "completed", path
+		t.message = fmt.Sprintf("Saved %s to %s", job.ID, path)
+		t.refresh()
+	})
+}
+
+// Screen layout. The composer takes the bottom rows while it is open.
+const (
+	tuiComposerRows = 7
+	tuiMinWidth     = 60
+	tuiMinHeight    = 16
+)
+
+func (t *tui) size() (int, int) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return 80, 24
+	}
+	return width, height
+}
+
+// listHeight is the number of library rows on screen.
+func (t *tui) listHeight() int {
+	_, height := t.size()
+	rows := height - 3
+	if t.composing {
+		rows -= tuiComposerRows
+	}
+	return max(1, rows)
+}
+
+func (t *tui) draw() {
+	width, height := t.size()
+	var screen strings.Builder
+	screen.WriteString("\x1b[H")
+	if width < tuiMinWidth || height < tuiMinHeight {
+		screen.WriteString("\x1b[2J")
+		screen.WriteString(fmt.Sprintf("Enlarge the terminal to at least %dx%d.", tuiMinWidth, tuiMinHeight))
+		os.Stdout.WriteString(screen.String())
+		return
+	}
+
+	var lines []string
+	header := fmt.Sprintf(" sora2cli · %d video(s)", len(t.videos))
+	switch {
+	case t.loading && t.updated.IsZero():
+		header += " · loading..."
+	case !t.updated.IsZero():
+		header += " · updated " + t.updated.Format("15:04:05")
+	}
+	lines = append(lines, tuiReverse(tuiFit(header, width)))
+
+	bodyRows := t.listHeight() + 1
+	leftWidth := max(36, width*45/100)
+	rightWidth := width - leftWidth - 3
+	left := t.libraryLines(leftWidth, bodyRows)
+	right := t.detailLines(rightWidth, bodyRows)
+	for i := 0; i < bodyRows; i++ {
+		lines = append(lines, left[i]+" │ "+right[i])
+	}
+	if t.composing {
+		lines = append(lines, t.composerLines(width)...)
+	}
+
+	footer := t.message
+	switch {
+	case footer != "":
+	case t.composing:
+		footer = "Type the prompt · ↑/↓ field · ←/→ change · Enter submit · Esc cancel"
+	default:
+		footer = "↑/↓ select · c compose · d download · r refresh · q quit"
+	}
+	lines = append(lines, tuiReverse(tuiFit(" "+footer, width)))
+
+	for i, line := range lines {
+		if i > 0 {
+			screen.WriteString("\r\n")
+		}
+		screen.WriteString(line)
+		screen.WriteString("\x1b[K")
+	}
+	screen.WriteString("\x1b[J")
+	os.Stdout.WriteString(screen.String())
+}
+
+func (t *tui) libraryLines(width, rows int) []string {
+	lines := make([]string, 0, rows)
+	lines = append(lines, tuiBold(tuiFit(fmt.Sprintf("%-12s %-11s %5s  %s", "ID", "STATUS", "DONE", "PROMPT"), width)))
+	visible := rows - 1
+	if t.selected < t.offset {
+		t.offset = t.selected
+	}
+	if t.selected >= t.offset+visible {
+		t.offset = t.selected - visible + 1
+	}
+	rowsOf := newListRows(t.videos, t.state)
+	for i := t.offset; i < len(rowsOf) && len(lines) < rows; i++ {
+		row := rowsOf[i]
+		line := tuiFit(fmt.Sprintf("%-12s %-11s %5s  %s", row.video.ID, row.video.Status, row.progress(), strings.Join(strings.Fields(row.prompt), " ")), width)
+		if i == t.selected {
+			line = tuiReverse(line)
+		}
+		lines = append(lines, line)
+	}
+	if len(t.videos) == 0 && !t.loading {
+		lines = append(lines, tuiFit("No videos yet. Press c to compose one.", width))
+	}
+	for len(lines) < rows {
+		lines = append(lines, strings.Repeat(" ", width))
+	}
+	return lines
+}
+
+// detailLines shows the selected video, then the active jobs: the ones
+// submitted here, and any other video still queued or rendering.
+func (t *tui) detailLines(width, rows int) []string {
+	var lines []string
+	add := func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	if v := t.current(); v != nil {
+		row := newListRows([]sora.Video{*v}, t.state)[0]
+		lines = append(lines, tuiBold(v.ID))
+		add("Status     %s %s", v.Status, row.progress())
+		add("Model      %s, %ss, %s", dashIfEmpty(v.Model), dashIfEmpty(v.Seconds), dashIfEmpty(v.Size))
+		add("Created    %s", formatUnixTimestamp(v.CreatedAt))
+		if v.CompletedAt > 0 {
+			add("Completed  %s", formatUnixTimestamp(v.CompletedAt))
+		}
+		if v.ExpiresAt > 0 {
+			add("Expires    %s", formatUnixTimestamp(v.ExpiresAt))
+		}
+		if v.RemixedFromVideoID != "" {
+			add("Remix of   %s", v.RemixedFromVideoID)
+		}
+		if row.review != "" {
+			add("Review     %s", row.review)
+		}
+		if len(row.tags) > 0 {
+			add("Tags       %s", strings.Join(row.tags, ", "))
+		}
+		if row.note != "" {
+			add("Note       %s", row.note)
+		}
+		if entry := t.state.find(v.ID); entry != nil && entry.OutputPath != "" {
+			add("Saved to   %s", entry.OutputPath)
+		}
+		if msg := row.errorMessage(); msg != "" {
+			add("Error      %s", msg)
+		}
+		if row.prompt != "" {
+			lines = append(lines, "")
+			lines = append(lines, tuiWrap(row.prompt, width, 6)...)
+		}
+	}
+
+	active := t.activeLines(width)
+	if len(active) > 0 {
+		// Active jobs stay at the bottom; the detail gives way to them.
+		room := rows - len(active) - 2
+		if len(lines) > room {
+			lines = lines[:max(0, room)]
+		}
+		lines = append(lines, "", tuiBold("Active jobs"))
+		lines = append(lines, active...)
+	}
+	for i, line := range lines {
+		if !strings.Contains(line, "\x1b") {
+			lines[i] = tuiFit(line, width)
+		}
+	}
+	for len(lines) < rows {
+		lines = append(lines, "")
+	}
+	return lines[:rows]
+}
+
+func (t *tui) activeLines(width int) []string {
+	var lines []string
+	barWidth := min(20, max(5, width-40))
+	followed := make(map[string]bool)
+	for _, job := range t.jobs {
+		followed[job.ID] = true
+		label := job.ID
+		if label == "" {
+			label = "(new)"
+		}
+		var state string
+		switch {
+		case job.Err != "":
+			state = "failed: " + job.Err
+		case job.Output != "":
+			state = "saved " + job.Output
+		case job.Status == "in_progress":
+			state = fmt.Sprintf("%s %3.0f%%", tuiProgressBar(job.Progress, barWidth), job.Progress)
+		default:
+			state = job.Status
+		}
+		lines = append(lines, tuiFit(fmt.Sprintf("%-12s %s", label, state), width))
+	}
+	for i := range t.videos {
+		v := &t.videos[i]
+		if followed[v.ID] || (v.Status != "queued" && v.Status != "in_progress") {
+			continue
+		}
+		state := v.Status
+		if v.Status == "in_progress" {
+			state = fmt.Sprintf("%s %3.0f%%", tuiProgressBar(v.ProgressPercent(), barWidth), v.ProgressPercent())
+		}
+		lines = append(lines, tuiFit(fmt.Sprintf("%-12s %s", v.ID, state), width))
+	}
+	return lines
+}
+
+func (t *tui) composerLines(width int) []string {
+	c := t.composer
+	model := modelOptions[c.model]
+	res := model.Resolutions[c.size]
+	prompt := c.prompt
+	// Show the end of a prompt longer than the line, where typing goes.
+	if room := width - 13; len(prompt) > room {
+		prompt = append([]rune("…"), prompt[len(prompt)-room+1:]...)
+	}
+	fields := []string{
+		"Prompt   " + string(prompt),
+		"Model    ‹ " + model.Name + " ›",
+		fmt.Sprintf("Seconds  ‹ %d ›", allowedDurations[c.seconds]),
+		"Size     ‹ " + res.Label + " ›",
+	}
+	lines := []string{tuiBold("─ Compose " + strings.Repeat("─", width-10))}
+	for i, field := range fields {
+		line := "  " + field
+		if i == c.field {
+			line = "▸ " + field
+			if i == composerPrompt {
+				line += "█"
+			}
+		}
+		lines = append(lines, tuiFit(line, width))
+	}
+	lines = append(lines, tuiFit(fmt.Sprintf("  Estimated cost $%.2f · saved to %s", c.cost(), t.destination), width))
+	for len(lines) < tuiComposerRows {
+		lines = append(lines, "")
+	}
+	return lines
+}
+
+// tuiFit cuts or pads s to exactly width columns.
+func tuiFit(s string, width int) string {
+	n := utf8.RuneCountInString(s)
+	if n > width {
+		runes := []rune(s)
+		return string(runes[:max(0, width-1)]) + "…"
+	}
+	return s + strings.Repeat(" ", width-n)
+}
+
+// tuiWrap breaks text into lines of at most width columns, at most limit of
+// them.
+func tuiWrap(text string, width, limit int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		switch {
+		case line == "":
+			line = word
+		case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	if len(lines) > limit {
+		lines = lines[:limit]
+		lines[limit-1] = tuiFit(lines[limit-1]+" …", width)
+	}
+	return lines
+}
+
+func tuiProgressBar(percent float64, width int) string {
+	filled := max(0, min(width, int(percent/100*float64(width)+0.5)))
+	return "[" + strings.Repeat("#", filled) + strings.Repeat("-", width-filled) + "]"
+}
+
+func tuiReverse(s string) string { return "\x1b[7m" + s + "\x1b[0m" }
+
+func tuiBold(s string) string { return "\x1b[1m" + s + "\x1b[0m" }
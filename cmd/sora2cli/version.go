@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// version, commit and date are set at build time:
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse --short HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/sora2cli
+//
+// Left empty, the version and commit come from the module and VCS stamps Go
+// records in the binary, where there are any.
+var (
+	version string
+	commit  string
+	date    string
+)
+
+// buildInfo describes the binary for version, bug reports and crash logs.
+type buildInfo struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit,omitempty"`
+	BuildDate string   `json:"build_date,omitempty"`
+	GoVersion string   `json:"go_version"`
+	Platform  string   `json:"platform"`
+	BaseURL   string   `json:"base_url,omitempty"`
+	BaseURLs  []string `json:"base_urls,omitempty"`
+}
+
+func readBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:   strings.TrimPrefix(version, "v"),
+		Commit:    commit,
+		BuildDate: date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	stamped, ok := debug.ReadBuildInfo()
+	if !ok {
+		if info.Version == "" {
+			info.Version = "devel"
+		}
+		return info
+	}
+	if info.Version == "" && stamped.Main.Version != "" && stamped.Main.Version != "(devel)" {
+		info.Version = strings.TrimPrefix(stamped.Main.Version, "v")
+	}
+	if info.Version == "" {
+		info.Version = "devel"
+	}
+	if info.Commit == "" {
+		var revision string
+		var modified bool
+		for _, setting := range stamped.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				revision = setting.Value
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+		if len(revision) > 12 {
+			revision = revision[:12]
+		}
+		if revision != "" && modified {
+			revision += "-dirty"
+		}
+		info.Commit = revision
+	}
+	return info
+}
+
+// cliVersion is the version the binary was built as, or "devel".
+func cliVersion() string {
+	return readBuildInfo().Version
+}
+
+// runVersionCommand prints the build info and the base URL that API
+// requests go to with the current config.
+func runVersionCommand(args []string) int {
+	fs := newCommandFlagSet("version")
+	jsonOutput := fs.Bool("json", false, "print the build info as JSON")
+	registerFormatFlag(fs, jsonOutput)
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		logError("unexpected argument %q", fs.Arg(0))
+		return 2
+	}
+	info := readBuildInfo()
+	// A broken config must not hide the version, which bug reports need.
+	if cfg, err := loadCommandConfig(); err != nil {
+		logWarn("%v", err)
+		info.BaseURL = defaultBaseURL
+	} else {
+		baseURLs := resolveBaseURLs(cfg)
+		info.BaseURL = baseURLs[0]
+		if len(baseURLs) > 1 {
+			info.BaseURLs = baseURLs
+		}
+	}
+	if *jsonOutput {
+		enableJSONOutput()
+		emitJSON(info)
+		return 0
+	}
+
+	fmt.Printf("sora2cli %s\n", info.Version)
+	fmt.Printf("Commit:     %s\n", firstNonEmpty(info.Commit, "unknown"))
+	fmt.Printf("Built:      %s\n", firstNonEmpty(info.BuildDate, "unknown"))
+	fmt.Printf("Go:         %s %s\n", info.GoVersion, info.Platform)
+	if len(info.BaseURLs) > 1 {
+		fmt.Printf("Base URL:   %s (failover: %s)\n", info.BaseURL, strings.Join(info.BaseURLs[1:], ", "))
+	} else {
+		fmt.Printf("Base URL:   %s\n", info.BaseURL)
+	}
+	return 0
+}
+
+// isVersionArg reports whether arg asks for the version in place of a
+// command.
+func isVersionArg(arg string) bool {
+	return arg == "--version" || arg == "-version"
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+// wizardState names a step of the interactive wizard.
+type wizardState string
+
+const (
+	wizardMenu              wizardState = "menu"
+	wizardCreateModel       wizardState = "create-model"
+	wizardCreatePrompt      wizardState = "create-prompt"
+	wizardCreateEnhance     wizardState = "create-enhance"
+	wizardCreateDuration    wizardState = "create-duration"
+	wizardCreateSize        wizardState = "create-size"
+	wizardCreateReferences  wizardState = "create-references"
+	wizardCreateDestination wizardState = "create-destination"
+	wizardCreateTicket      wizardState = "create-ticket"
+	wizardCreateTags        wizardState = "create-tags"
+	wizardCreateRun         wizardState = "create-run"
+	wizardRemixSource       wizardState = "remix-source"
+	wizardRemixPrompt       wizardState = "remix-prompt"
+	wizardRemixDestination  wizardState = "remix-destination"
+	wizardRemixTicket       wizardState = "remix-ticket"
+	wizardRemixTags         wizardState = "remix-tags"
+	wizardRemixRun          wizardState = "remix-run"
+	wizardList              wizardState = "list"
+	wizardDone              wizardState = "done"
+)
+
+// wizardSteps maps each state to its step. A step asks at most one
+// question, stores the answer in the wizard and returns the next state.
+// Steps skip their question when the answer is already there, e.g. from a
+// flag, so a new question is one state and one entry here.
+var wizardSteps = map[wizardState]func(*wizard) wizardState{
+	wizardMenu:              (*wizard).menu,
+	wizardCreateModel:       (*wizard).createModel,
+	wizardCreatePrompt:      (*wizard).createPrompt,
+	wizardCreateEnhance:     (*wizard).createEnhance,
+	wizardCreateDuration:    (*wizard).createDuration,
+	wizardCreateSize:        (*wizard).createSize,
+	wizardCreateReferences:  (*wizard).createReferences,
+	wizardCreateDestination: (*wizard).createDestination,
+	wizardCreateTicket:      (*wizard).createTicket,
+	wizardCreateTags:        (*wizard).createTags,
+	wizardCreateRun:         (*wizard).createRun,
+	wizardRemixSource:       (*wizard).remixSource,
+	wizardRemixPrompt:       (*wizard).remixPrompt,
+	wizardRemixDestination:  (*wizard).remixDestination,
+	wizardRemixTicket:       (*wizard).remixTicket,
+	wizardRemixTags:         (*wizard).remixTags,
+	wizardRemixRun:          (*wizard).remixRun,
+	wizardList:              (*wizard).list,
+}
+
+// wizardStepLabels describe the question a saved wizard stopped at.
+var wizardStepLabels = map[wizardState]string{
+	wizardCreateModel:       "model",
+	wizardCreatePrompt:      "prompt",
+	wizardCreateEnhance:     "prompt enhancement",
+	wizardCreateDuration:    "duration",
+	wizardCreateSize:        "resolution",
+	wizardCreateReferences:  "reference images",
+	wizardCreateDestination: "destination",
+	wizardCreateTicket:      "ticket",
+	wizardCreateTags:        "tags",
+	wizardRemixSource:       "source video",
+	wizardRemixPrompt:       "remix prompt",
+	wizardRemixDestination:  "destination",
+	wizardRemixTicket:       "ticket",
+	wizardRemixTags:         "tags",
+}
+
+const wizardProgressFileName = "wizard.json"
+
+// wizard runs the interactive menu and the create and remix flows as a
+// state machine. Answers are read from in and questions written to out, so
+// a flow can be driven by any reader and writer. With a progress path the
+// answers so far are saved before each question, so a session that was
+// interrupted can resume where it stopped.
+type wizard struct {
+	in     *bufio.Reader
+	out    io.Writer
+	client *sora.Client
+	cfg    *resolvedConfig
+	// progress is the file the state is saved to, or "" not to save it.
+	progress string
+	// standalone ends the wizard after one flow, as the create and remix
+	// commands do, instead of returning to the menu.
+	standalone bool
+	// ok is whether the last flow succeeded, and usageFailed whether it
+	// failed on its options.
+	ok          bool
+	usageFailed bool
+	// answers pre-supplies answers to each flow (--answers).
+	answers *wizardAnswers
+	// headless means nobody is there to answer: questions without an
+	// answer take their defaults, and the wizard stops after one flow.
+	headless bool
+
+	State  wizardState   `json:"state"`
+	Create createOptions `json:"create"`
+	Remix  remixOptions  `json:"remix"`
+	// Answered holds the optional questions answered by the answers file,
+	// even if with nothing, so they are not asked again.
+	Answered map[wizardState]bool `json:"answered,omitempty"`
+	SavedAt  time.Time            `json:"saved_at"`
+}
+
+func newWizard(in *bufio.Reader, out io.Writer, client *sora.Client, cfg *resolvedConfig) *wizard {
+	return &wizard{in: in, out: out, client: client, cfg: cfg}
+}
+
+// asks reports whether the question of state is asked, rather than left
+// to its answer or default.
+func (w *wizard) asks(state wizardState) bool {
+	return !w.headless && !w.Answered[state]
+}
+
+// startCreate begins a create flow from the options given, with the
+// answers file filling in the rest.
+func (w *wizard) startCreate(opts createOptions) wizardState {
+	w.Create, w.Answered = opts, make(map[wizardState]bool)
+	if w.answers != nil {
+		w.answers.applyCreate(&w.Create, w.Answered)
+	}
+	return wizardCreateModel
+}
+
+// startRemix is startCreate for a remix.
+func (w *wizard) startRemix(opts remixOptions) wizardState {
+	w.Remix, w.Answered = opts, make(map[wizardState]bool)
+	if w.answers != nil {
+		w.answers.applyRemix(&w.Remix, w.Answered)
+	}
+	return wizardRemixSource
+}
+
+// run steps through the wizard from state until a step returns wizardDone.
+func (w *wizard) run(state wizardState) {
+	for state != wizardDone {
+		step, ok := wizardSteps[state]
+		if !ok {
+			state = wizardMenu
+			continue
+		}
+		w.State = state
+		w.save()
+		state = step(w)
+	}
+}
+
+// save records the wizard's answers while it waits on a question. Anywhere
+// else, such as while a job renders, there is nothing to resume and the
+// progress is cleared, so a resumed wizard never submits a job twice.
+func (w *wizard) save() {
+	if w.progress == "" {
+		return
+	}
+	if _, resumable := wizardStepLabels[w.State]; !resumable {
+		os.Remove(w.progress)
+		return
+	}
+	w.SavedAt = time.Now()
+	data, err := json.MarshalIndent(w, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(w.progress), 0o700)
+	}
+	if err == nil {
+		err = os.WriteFile(w.progress, data, 0o600)
+	}
+	if err != nil {
+		logWarn("unable to save the wizard's progress: %v", err)
+		w.progress = ""
+	}
+}
+
+// resume enables saving progress in the data directory and, if an earlier
+// session stopped halfway through a flow, offers to continue it. It returns
+// the state to start from.
+func (w *wizard) resume() wizardState {
+	dir, err := resolveDataDir()
+	if err != nil {
+		return wizardMenu
+	}
+	w.progress = filepath.Join(dir, wizardProgressFileName)
+	data, err := os.ReadFile(w.progress)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logWarn("%v", err)
+		}
+		return wizardMenu
+	}
+	var saved wizard
+	if err := json.Unmarshal(data, &saved); err != nil {
+		os.Remove(w.progress)
+		return wizardMenu
+	}
+	label, ok := wizardStepLabels[saved.State]
+	if !ok {
+		os.Remove(w.progress)
+		return wizardMenu
+	}
+	action := "create"
+	if strings.HasPrefix(string(saved.State), "remix-") {
+		action = "remix"
+	}
+	question := fmt.Sprintf("Resume the %s you were setting up on %s (next: %s)?", action, formatTimestamp(saved.SavedAt), label)
+	if !promptConfirm(w.in, w.out, question) {
+		os.Remove(w.progress)
+		return wizardMenu
+	}
+	w.Create, w.Remix, w.Answered = saved.Create, saved.Remix, saved.Answered
+	return saved.State
+}
+
+// completed reports the error a flow returned, unless the flow already has,
+// and returns whether it succeeded.
+func (w *wizard) completed(err error) bool {
+	w.usageFailed = false
+	if err == nil {
+		return true
+	}
+	var usage *usageError
+	if errors.As(err, &usage) {
+		logError("%v", err)
+		emitJSONError(err, "")
+		w.usageFailed = true
+	}
+	return false
+}
+
+// exitStatus is the exit status of a wizard that ran one flow.
+func (w *wizard) exitStatus() int {
+	switch {
+	case w.ok:
+		return 0
+	case w.usageFailed:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// finish ends a flow: after a success it asks question, after a failure
+// whether to try something else, and returns to the menu or stops.
+func (w *wizard) finish(question string) wizardState {
+	if w.standalone || w.headless {
+		return wizardDone
+	}
+	if !w.ok {
+		question = "Try another action?"
+	}
+	if !promptConfirm(w.in, w.out, question) {
+		if w.ok {
+			logInfo("Done.")
+		}
+		return wizardDone
+	}
+	fmt.Fprintln(w.out)
+	return wizardMenu
+}
+
+func (w *wizard) menu() wizardState {
+	// The answers file picks the first action only; later ones are chosen
+	// from the menu.
+	if w.answers != nil && w.answers.Action != "" {
+		action := w.answers.Action
+		w.answers.Action = ""
+		switch action {
+		case "create":
+			return w.startCreate(createOptions{})
+		case "remix":
+			return w.startRemix(remixOptions{Session: !w.headless})
+		case "list":
+			return wizardList
+		}
+	}
+	for {
+		fmt.Fprintln(w.out, "Select action:")
+		fmt.Fprintln(w.out, "  1) Create a new video")
+		fmt.Fprintln(w.out, "  2) Remix an existing video")
+		fmt.Fprintln(w.out, "  3) List recent videos")
+		fmt.Fprint(w.out, "Enter choice (1-3): ")
+		input, err := w.in.ReadString('\n')
+		if err != nil {
+			logError("input error: %v", err)
+			continue
+		}
+		input = strings.TrimSpace(input)
+		switch strings.ToLower(input) {
+		case "", "1", "create", "new", "c":
+			return w.startCreate(createOptions{})
+		case "2", "remix", "r":
+			// The menu's remix is always a session.
+			return w.startRemix(remixOptions{Session: true})
+		case "3", "list", "l":
+			return wizardList
+		default:
+			fmt.Fprintln(w.out, "Invalid selection, please try again.")
+		}
+	}
+}
+
+func (w *wizard) createModel() wizardState {
+	if w.Create.Model == "" && w.asks(wizardCreateModel) {
+		w.Create.Model = promptModel(w.in, w.out, w.cfg.Defaults.Model).Name
+	}
+	return wizardCreatePrompt
+}
+
+func (w *wizard) createPrompt() wizardState {
+	o := &w.Create
+	switch {
+	case o.Template != "":
+		vars, err := parseTemplateVars(o.Vars)
+		if err != nil {
+			w.ok = w.completed(usageErrorf("%v", err))
+			return w.finish("")
+		}
+		prompt, err := renderTemplate(w.in, w.out, w.cfg, o.Template, vars, w.headless)
+		if err != nil {
+			w.ok = w.completed(usageErrorf("%v", err))
+			return w.finish("")
+		}
+		logInfo("Prompt: %s", prompt)
+		o.Prompt, o.Template, o.Vars = prompt, "", nil
+	case strings.TrimSpace(o.Prompt) == "" && w.asks(wizardCreatePrompt):
+		o.Prompt = promptTemplatedPrompt(w.in, w.out, w.cfg)
+	}
+	return wizardCreateEnhance
+}
+
+func (w *wizard) createEnhance() wizardState {
+	o := &w.Create
+	if (o.Enhance || w.cfg.Enhance.Auto) && !o.Enhanced {
+		if o.DryRun {
+			logInfo("Dry run; the prompt is not enhanced.")
+		} else {
+			o.Prompt = reviewEnhancedPrompt(w.in, w.out, w.client, w.cfg.Enhance, o.Prompt, !o.AssumeYes && !w.headless)
+		}
+		o.Enhanced = true
+	}
+	return wizardCreateDuration
+}
+
+func (w *wizard) createDuration() wizardState {
+	if w.Create.Seconds == 0 && w.asks(wizardCreateDuration) {
+		_, w.Create.Seconds = promptDuration(w.in, w.out, w.cfg.Defaults.Seconds)
+	}
+	return wizardCreateSize
+}
+
+func (w *wizard) createSize() wizardState {
+	if w.Create.Size == "" && w.asks(wizardCreateSize) {
+		model, ok := findModelOption(w.Create.Model)
+		if !ok {
+			w.ok = w.completed(usageErrorf("unknown model %q; supported: %s", w.Create.Model, strings.Join(modelNames(), ", ")))
+			return w.finish("")
+		}
+		w.Create.Size = promptResolutionSelection(w.in, w.out, model.Resolutions, w.cfg.Defaults.Size).Value
+	}
+	return wizardCreateReferences
+}
+
+func (w *wizard) createReferences() wizardState {
+	if len(w.Create.References) == 0 && w.asks(wizardCreateReferences) {
+		w.Create.References = promptReferencePaths(w.in, w.out)
+	}
+	return wizardCreateDestination
+}
+
+func (w *wizard) createDestination() wizardState {
+	if w.Create.Destination == "" && w.asks(wizardCreateDestination) {
+		w.Create.Destination = promptDestinationDirectory(w.in, w.out, w.cfg.Defaults.Destination)
+	}
+	return wizardCreateTicket
+}
+
+func (w *wizard) createTicket() wizardState {
+	if w.Create.Ticket == "" && w.asks(wizardCreateTicket) {
+		w.Create.Ticket = promptTicketKey(w.in, w.out, w.cfg)
+	}
+	return wizardCreateTags
+}
+
+func (w *wizard) createTags() wizardState {
+	if len(w.Create.Tags) == 0 && w.asks(wizardCreateTags) {
+		w.Create.Tags = promptTags(w.in, w.out)
+	}
+	return wizardCreateRun
+}
+
+func (w *wizard) createRun() wizardState {
+	w.Create.NonInteractive = w.Create.NonInteractive || w.headless
+	w.ok = w.completed(executeCreate(w.in, w.out, w.client, w.cfg, w.Create))
+	return w.finish("Generate another video?")
+}
+
+func (w *wizard) remixSource() wizardState {
+	if w.Remix.VideoID == "" && w.asks(wizardRemixSource) {
+		if w.Remix.DryRun && w.cfg.APIKey == "" {
+			w.Remix.VideoID = promptRequired(w.in, w.out, "Existing video ID to remix")
+		} else {
+			w.Remix.VideoID = pickRemixSource(w.in, w.out, w.client)
+		}
+	}
+	return wizardRemixPrompt
+}
+
+func (w *wizard) remixPrompt() wizardState {
+	if strings.TrimSpace(w.Remix.Prompt) == "" && w.asks(wizardRemixPrompt) {
+		w.Remix.Prompt = promptText(w.in, w.out, "Remix prompt (describe the change)")
+	}
+	return wizardRemixDestination
+}
+
+func (w *wizard) remixDestination() wizardState {
+	if w.Remix.Destination == "" && w.asks(wizardRemixDestination) {
+		w.Remix.Destination = promptDestinationDirectory(w.in, w.out, w.cfg.Defaults.Destination)
+	}
+	return wizardRemixTicket
+}
+
+func (w *wizard) remixTicket() wizardState {
+	if w.Remix.Ticket == "" && w.asks(wizardRemixTicket) {
+		w.Remix.Ticket = promptTicketKey(w.in, w.out, w.cfg)
+	}
+	return wizardRemixTags
+}
+
+func (w *wizard) remixTags() wizardState {
+	if len(w.Remix.Tags) == 0 && w.asks(wizardRemixTags) {
+		w.Remix.Tags = promptTags(w.in, w.out)
+	}
+	return wizardRemixRun
+}
+
+func (w *wizard) remixRun() wizardState {
+	w.Remix.NonInteractive = w.Remix.NonInteractive || w.headless
+	w.ok = w.completed(executeRemix(w.in, w.out, w.client, w.cfg, w.Remix))
+	return w.finish("Perform another action?")
+}
+
+func (w *wizard) list() wizardState {
+	w.ok = w.completed(executeList(w.in, w.out, w.client, listOptions{NonInteractive: w.headless}))
+	return w.finish("Perform another action?")
+}
+
+// promptTicketKey asks for the ticket a job is tagged with. The question is
+// only asked when a ticket provider is configured.
+func promptTicketKey(reader *bufio.Reader, out io.Writer, cfg *resolvedConfig) string {
+	if cfg.Tickets.Provider == "" {
+		return ""
+	}
+	for {
+		ticket := promptOptional(reader, out, fmt.Sprintf("%s ticket key (optional)", cfg.Tickets.Provider))
+		if err := validateTicketKey(ticket); err != nil {
+			fmt.Fprintln(out, err)
+			continue
+		}
+		return ticket
+	}
+}
+
+// promptTags asks for optional tags, separated by commas or spaces.
+func promptTags(reader *bufio.Reader, out io.Writer) []string {
+	for {
+		tags := strings.FieldsFunc(promptOptional(reader, out, "Tags, e.g. campaign:q3 hero (optional)"), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		if err := validateTags(tags); err != nil {
+			logError("%v", err)
+			continue
+		}
+		return tags
+	}
+}
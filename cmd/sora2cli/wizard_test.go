@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora/soratest"
+)
+
+// TestWizardCreateFlow answers the menu's create flow from a script and
+// compares the questions asked, followed by the requests the server
+// received, with a golden file.
+func TestWizardCreateFlow(t *testing.T) {
+	// Keep history, locks and saved prompts out of the user's directories.
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	srv := soratest.NewServer()
+	defer srv.Close()
+	dest := t.TempDir()
+
+	answers := []string{
+		"1", // create a new video
+		"",  // default model
+		"A paper boat drifting down a rain gutter", // prompt
+		"2",  // 8 seconds
+		"",   // default resolution
+		"",   // no reference images
+		dest, // destination
+		"",   // no tags
+		"y",  // proceed with generation
+		"n",  // no other video
+	}
+	in := bufio.NewReader(strings.NewReader(strings.Join(answers, "\n") + "\n"))
+	var out bytes.Buffer
+	cfg := &resolvedConfig{config: defaultConfig(), Sources: map[string]string{}}
+	w := newWizard(in, &out, srv.NewClient(), cfg)
+	w.run(wizardMenu)
+	if !w.ok {
+		t.Fatalf("create flow failed; questions asked:\n%s", out.Bytes())
+	}
+
+	data, err := os.ReadFile(filepath.Join(dest, "video_0001.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := soratest.Content("video_0001", sora.VariantVideo); !bytes.Equal(data, want) {
+		t.Errorf("downloaded %q, want %q", data, want)
+	}
+	out.WriteString("\n-- requests\n")
+	out.Write(srv.Transcript())
+	soratest.Golden(t, "wizard_create", out.Bytes())
+}
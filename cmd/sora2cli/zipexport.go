@@ -0,0 +1,305 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// zipManifest is manifest.json in an export-zip archive: what was selected
+// and, for each job, the files that belong to it.
+type zipManifest struct {
+	CreatedAt  time.Time          `json:"created_at"`
+	CLIVersion string             `json:"cli_version"`
+	Selection  zipSelection       `json:"selection"`
+	Jobs       []zipManifestEntry `json:"jobs"`
+}
+
+type zipSelection struct {
+	JobIDs []string `json:"job_ids,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Review string   `json:"review,omitempty"`
+	Since  string   `json:"since,omitempty"`
+	Until  string   `json:"until,omitempty"`
+}
+
+type zipManifestEntry struct {
+	JobID         string            `json:"job_id"`
+	Prompt        string            `json:"prompt,omitempty"`
+	Model         string            `json:"model,omitempty"`
+	Seconds       int               `json:"seconds,omitempty"`
+	Size          string            `json:"size,omitempty"`
+	RemixedFrom   string            `json:"remixed_from,omitempty"`
+	Ticket        string            `json:"ticket,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+	Review        *jobReview        `json:"review,omitempty"`
+	EstimatedCost float64           `json:"estimated_cost,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+	File          string            `json:"file"`
+	Bytes         int64             `json:"bytes"`
+	SHA256        string            `json:"sha256"`
+	Sidecar       string            `json:"sidecar,omitempty"`
+	Derived       map[string]string `json:"derived,omitempty"`
+}
+
+// zipFile is one file to add to the archive under name.
+type zipFile struct {
+	name, path string
+}
+
+// addZipFile copies the file at path into the archive, returning its size
+// and SHA-256. Video is stored as it is, since it does not compress further.
+func addZipFile(zw *zip.Writer, name, path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, "", err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return 0, "", err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp4", ".mov", ".mkv", ".webm", ".jpg", ".png", ".webp", ".mp3":
+		header.Method = zip.Store
+	}
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return 0, "", err
+	}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, hash), file)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeExportZip streams the selected downloads, their sidecars and, with
+// derived, the copies made from them into a zip archive on out, followed by
+// the manifest. Nothing is staged on disk.
+func writeExportZip(out io.Writer, entries []*historyEntry, selection zipSelection, derived bool) (zipManifest, error) {
+	manifest := zipManifest{CreatedAt: time.Now().UTC(), CLIVersion: cliVersion(), Selection: selection, Jobs: []zipManifestEntry{}}
+	zw := zip.NewWriter(out)
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		item := zipManifestEntry{
+			JobID: e.JobID, Prompt: e.Prompt, Model: e.Model, Seconds: e.Seconds, Size: e.Size,
+			RemixedFrom: e.RemixedFrom, Ticket: e.Ticket, Tags: e.Tags, Review: e.Review,
+			EstimatedCost: e.EstimatedCost, CreatedAt: e.CreatedAt,
+			File: filepath.Base(e.OutputPath),
+		}
+		if seen[item.File] {
+			return manifest, fmt.Errorf("%s: another job's file is also named %s", e.JobID, item.File)
+		}
+		seen[item.File] = true
+		fmt.Fprintf(os.Stderr, "Adding %s...\n", item.File)
+		size, sum, err := addZipFile(zw, item.File, e.OutputPath)
+		if err != nil {
+			return manifest, fmt.Errorf("%s: %w", e.JobID, err)
+		}
+		item.Bytes, item.SHA256 = size, sum
+		if e.SHA256 != "" && e.SHA256 != sum {
+			logWarn("%s no longer matches the checksum recorded at download", e.OutputPath)
+		}
+
+		extra := []zipFile{}
+		if _, err := os.Stat(sidecarPath(e.OutputPath)); err == nil {
+			item.Sidecar = filepath.Base(sidecarPath(e.OutputPath))
+			extra = append(extra, zipFile{item.Sidecar, sidecarPath(e.OutputPath)})
+		}
+		if derived && len(e.Derived) > 0 {
+			item.Derived = make(map[string]string)
+			names := make([]string, 0, len(e.Derived))
+			for name := range e.Derived {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				path := e.Derived[name]
+				if _, err := os.Stat(path); err != nil {
+					logWarn("%s: %s is missing; skipped", e.JobID, path)
+					continue
+				}
+				item.Derived[name] = filepath.Base(path)
+				extra = append(extra, zipFile{filepath.Base(path), path})
+			}
+		}
+		for _, f := range extra {
+			if seen[f.name] {
+				continue
+			}
+			seen[f.name] = true
+			if _, _, err := addZipFile(zw, f.name, f.path); err != nil {
+				return manifest, fmt.Errorf("%s: %w", e.JobID, err)
+			}
+		}
+		manifest.Jobs = append(manifest.Jobs, item)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Deflate, Modified: manifest.CreatedAt})
+	if err != nil {
+		return manifest, err
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return manifest, err
+	}
+	return manifest, zw.Close()
+}
+
+// runExportZipCommand bundles downloaded videos selected by ID, tag, review
+// status or date into one zip archive for delivery.
+func runExportZipCommand(args []string) int {
+	fs := newCommandFlagSet("export-zip")
+	var tags []string
+	fs.Var((*stringList)(&tags), "tag", "only jobs with this tag; repeat to require several")
+	review := fs.String("review", "", "only jobs with this review status: "+strings.Join(reviewFilters, ", "))
+	since := fs.String("since", "", "only jobs created on or after this date (YYYY-MM-DD)")
+	until := fs.String("until", "", "only jobs created on or before this date (YYYY-MM-DD)")
+	derived := fs.Bool("derived", false, "also include the copies made from each video, such as encodes, posters and captions")
+	out := fs.String("out", "", "archive to write (- for stdout)")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return 2
+	}
+	if *out == "" {
+		fmt.Fprintln(os.Stderr, "usage: sora2cli export-zip --out file.zip [--tag tag]... [--review status] [--since YYYY-MM-DD] [--until YYYY-MM-DD] [--derived] [video-id...]")
+		return 2
+	}
+	if fs.NArg() == 0 && len(tags) == 0 && *review == "" && *since == "" && *until == "" {
+		logError("select jobs by ID, --tag, --review, --since or --until")
+		return 2
+	}
+	if err := validateTags(tags); err != nil {
+		logError("%v", err)
+		return 2
+	}
+	if *review != "" {
+		if err := validateReviewFilter(*review); err != nil {
+			logError("%v", err)
+			return 2
+		}
+	}
+	var from, to time.Time
+	var err error
+	if *since != "" {
+		if from, err = parseReportDate(*since); err != nil {
+			logError("--since: %v", err)
+			return 2
+		}
+	}
+	if *until != "" {
+		if to, err = parseReportDate(*until); err != nil {
+			logError("--until: %v", err)
+			return 2
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+
+	cfg, err := loadCommandConfig()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	state, err := loadHistory()
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	ids := make(map[string]bool)
+	for _, id := range fs.Args() {
+		entry := state.find(id)
+		if entry == nil {
+			logError("%s is not in history", id)
+			return 1
+		}
+		ids[entry.JobID] = true
+	}
+	// A delivery is all or nothing: a missing file or a clip the review
+	// holds back stops the export before anything is written.
+	var entries []*historyEntry
+	missing := 0
+	for _, e := range state.Entries {
+		switch {
+		case len(ids) > 0 && !ids[e.JobID],
+			e.Status != "completed" || e.OutputPath == "",
+			!e.hasTags(tags),
+			!reviewFilter(*review, e),
+			!from.IsZero() && e.CreatedAt.Before(from),
+			!to.IsZero() && !e.CreatedAt.Before(to):
+			continue
+		}
+		if err := cfg.Review.approved(e, e.JobID); err != nil {
+			logError("%v", err)
+			missing++
+			continue
+		}
+		if _, err := os.Stat(e.OutputPath); err != nil {
+			logError("%s: %v", e.JobID, err)
+			missing++
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if missing > 0 {
+		logError("%d selected video(s) cannot be delivered; nothing was written", missing)
+		return 1
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(os.Stderr, "No downloaded videos match.")
+		return 1
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].CreatedAt.Before(entries[j].CreatedAt) })
+	selection := zipSelection{JobIDs: fs.Args(), Tags: tags, Review: *review, Since: *since, Until: *until}
+
+	if *out == "-" {
+		if _, err := writeExportZip(os.Stdout, entries, selection, *derived); err != nil {
+			logError("%v", err)
+			return 1
+		}
+		return 0
+	}
+	path, err := expandPath(*out)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	tmpPath := path + ".partial"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		logError("%v", err)
+		return 1
+	}
+	manifest, err := writeExportZip(file, entries, selection, *derived)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		logError("%v", err)
+		return 1
+	}
+	info, _ := os.Stat(path)
+	logInfo("Wrote %d video(s) to %s (%s)", len(manifest.Jobs), path, formatBytes(info.Size()))
+	return 0
+}
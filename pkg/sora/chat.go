@@ -0,0 +1,51 @@
+package sora
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const chatPath = "/v1/chat/completions"
+
+// ChatMessage is one message of a chat completion: Role is system, user or
+// assistant.
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Chat sends messages to a chat model with the client's credentials and
+// returns the reply. It exists for small helper passes such as expanding a
+// prompt before it is rendered.
+func (c *Client) Chat(ctx context.Context, model string, messages []ChatMessage) (string, error) {
+	payload, err := json.Marshal(map[string]any{"model": model, "messages": messages})
+	if err != nil {
+		return "", err
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, chatPath, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var resp struct {
+		Choices []struct {
+			Message ChatMessage `json:"message"`
+		} `json:"choices"`
+	}
+	if err := c.do(req, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.New("response has no choices")
+	}
+	reply := strings.TrimSpace(resp.Choices[0].Message.Content)
+	if reply == "" {
+		return "", errors.New("empty reply")
+	}
+	return reply, nil
+}
@@ -0,0 +1,265 @@
+// Package sora is a client for the OpenAI Sora video generation API.
+//
+//	client := sora.NewClient(os.Getenv("OPENAI_API_KEY"))
+//	video, err := client.Create(ctx, sora.CreateParams{Prompt: "A paper boat on a rainy street", Model: "sora-2"})
+//	video, err = client.Wait(ctx, video.ID, nil)
+//	err = client.DownloadFile(ctx, video.ID, video.ID+".mp4")
+package sora
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultBaseURL is the public OpenAI API endpoint.
+	DefaultBaseURL = "https://api.openai.com"
+	// DefaultPollInterval is how often Wait checks a job's status.
+	DefaultPollInterval = 5 * time.Second
+	// DefaultMaxAttempts is how often a request is sent before a transient
+	// error is returned to the caller.
+	DefaultMaxAttempts = 4
+
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+
+	videosPath = "/v1/videos"
+)
+
+// Client calls the video endpoints. The zero value is not usable; create one
+// with NewClient and adjust the exported fields before first use.
+type Client struct {
+	// HTTPClient sends the requests. Set it to add timeouts, proxies or a
+	// custom transport.
+	HTTPClient *http.Client
+	// BaseURL is the API root without the /v1 suffix.
+	BaseURL string
+	APIKey  string
+	// Organization and Project are sent as the OpenAI-Organization and
+	// OpenAI-Project headers when set.
+	Organization string
+	Project      string
+	// PollInterval is used by Wait; zero means DefaultPollInterval.
+	PollInterval time.Duration
+	// MaxPollInterval lets Wait poll less often while a job's status and
+	// progress stay the same: the interval doubles after each unchanged poll
+	// up to MaxPollInterval and drops back to PollInterval on a change. Zero
+	// keeps the interval fixed.
+	MaxPollInterval time.Duration
+	// ProgressScale tells Video.ProgressPercent how to read the progress the
+	// endpoint reports; empty means ProgressScaleAuto.
+	ProgressScale ProgressScale
+	// MaxAttempts bounds how often a request that fails with 429, 500, 502
+	// or 503 is sent; zero means DefaultMaxAttempts and 1 disables retries.
+	// Retries wait for Retry-After when the API sends it and back off
+	// exponentially with jitter otherwise. A POST, such as Create or Remix,
+	// is only retried on 429 or on 503 with Retry-After, as after a 500 or
+	// 502 the job may already have been started and billed.
+	MaxAttempts int
+	// OnRetry, if set, is called before each retry with the error, the
+	// attempt that failed and how long the client will wait.
+	OnRetry func(err *APIError, attempt int, wait time.Duration)
+	// OnError, if set, is called with every API error the client returns,
+	// after any retries.
+	OnError func(err *APIError)
+}
+
+// NewClient returns a client for the public API using http.DefaultClient.
+func NewClient(apiKey string) *Client {
+	return &Client{
+		HTTPClient: http.DefaultClient,
+		BaseURL:    DefaultBaseURL,
+		APIKey:     apiKey,
+	}
+}
+
+// APIError is returned when the API answers with a non-2xx status.
+type APIError struct {
+	StatusCode int
+	Message    string
+	// Method and Path identify the request, and RequestID is the
+	// x-request-id the API answered with, which OpenAI support asks for.
+	Method    string
+	Path      string
+	RequestID string
+	// Attempts is how often the request was sent.
+	Attempts int
+}
+
+// Error includes the request ID, when the API sent one, so it can be quoted
+// in a support ticket.
+func (e *APIError) Error() string {
+	if e.RequestID == "" {
+		return fmt.Sprintf("API error (%d): %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("API error (%d): %s (request ID %s)", e.StatusCode, e.Message, e.RequestID)
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.BaseURL, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	req.Header.Set("Accept", "application/json")
+	if org := strings.TrimSpace(c.Organization); org != "" {
+		req.Header.Set("OpenAI-Organization", org)
+	}
+	if project := strings.TrimSpace(c.Project); project != "" {
+		req.Header.Set("OpenAI-Project", project)
+	}
+	return req, nil
+}
+
+// do sends req and decodes a JSON response into out, which may be nil.
+func (c *Client) do(req *http.Request, out any) error {
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send performs req, retrying transient API errors, and returns the first
+// successful response. The caller must close its body.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	maxAttempts := c.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 300 || resp.StatusCode == http.StatusNotModified && req.Header.Get("If-None-Match") != "" {
+			return resp, nil
+		}
+		apiErr := &APIError{
+			StatusCode: resp.StatusCode,
+			Message:    readErrorMessage(resp.Body),
+			Method:     req.Method,
+			Path:       req.URL.Path,
+			RequestID:  resp.Header.Get("X-Request-Id"),
+			Attempts:   attempt,
+		}
+		resp.Body.Close()
+		if attempt >= maxAttempts || !retryable(req.Method, resp) {
+			return nil, c.failed(apiErr)
+		}
+		next, err := rewind(req)
+		if err != nil {
+			return nil, c.failed(apiErr)
+		}
+		wait := retryDelay(resp.Header.Get("Retry-After"), attempt)
+		if c.OnRetry != nil {
+			c.OnRetry(apiErr, attempt, wait)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, c.failed(apiErr)
+		}
+		req = next
+	}
+}
+
+// failed reports err to OnError and returns it.
+func (c *Client) failed(err *APIError) *APIError {
+	if c.OnError != nil {
+		c.OnError(err)
+	}
+	return err
+}
+
+// retryable reports whether a request that got resp may be sent again. A
+// POST is not idempotent, so it is only retried when the API says it did not
+// act on it: 429, or 503 with Retry-After.
+func retryable(method string, resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusServiceUnavailable:
+		return method != http.MethodPost || resp.Header.Get("Retry-After") != ""
+	case http.StatusInternalServerError, http.StatusBadGateway:
+		return method != http.MethodPost
+	}
+	return false
+}
+
+// rewind returns a copy of req that can be sent again.
+func rewind(req *http.Request) (*http.Request, error) {
+	next := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return next, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("request body cannot be replayed")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	next.Body = body
+	return next, nil
+}
+
+// retryDelay honours a Retry-After header in seconds or as an HTTP date and
+// otherwise doubles the delay per attempt, picking a random point in its
+// upper half so that parallel workers do not retry in lockstep.
+func retryDelay(retryAfter string, attempt int) time.Duration {
+	if retryAfter != "" {
+		if seconds, err := strconv.Atoi(strings.TrimSpace(retryAfter)); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if at, err := http.ParseTime(retryAfter); err == nil {
+			if wait := time.Until(at); wait > 0 {
+				return wait
+			}
+			return 0
+		}
+	}
+	delay := retryBaseDelay << (attempt - 1)
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func readErrorMessage(body io.Reader) string {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err.Error()
+	}
+	trimmed := strings.TrimSpace(string(data))
+	if trimmed == "" {
+		return "unknown error"
+	}
+	var parsed struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &parsed); err == nil && parsed.Error.Message != "" {
+		return parsed.Error.Message
+	}
+	return trimmed
+}
@@ -0,0 +1,71 @@
+package sora
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SupportedReferenceMIMEs lists the input reference types the API accepts.
+var SupportedReferenceMIMEs = []string{
+	"image/jpeg",
+	"image/png",
+	"image/webp",
+	"video/mp4",
+}
+
+var referenceMIMECandidates = map[string]string{
+	"image/jpeg":  "image/jpeg",
+	"image/jpg":   "image/jpeg",
+	"image/pjpeg": "image/jpeg",
+	"image/png":   "image/png",
+	"image/x-png": "image/png",
+	"image/webp":  "image/webp",
+	"video/mp4":   "video/mp4",
+}
+
+// DetectReferenceMIME sniffs the type of a reference file, falling back to
+// its extension, and rewinds the file afterwards.
+func DetectReferenceMIME(file *os.File) (string, error) {
+	buf := make([]byte, 512)
+	n, err := file.Read(buf)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("read reference header: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("rewind reference header: %w", err)
+	}
+
+	if n > 0 {
+		if mimeType, ok := canonicalizeReferenceMIME(http.DetectContentType(buf[:n])); ok {
+			return mimeType, nil
+		}
+	}
+
+	ext := strings.ToLower(filepath.Ext(file.Name()))
+	if ext != "" {
+		if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+			if canonical, ok := canonicalizeReferenceMIME(mimeType); ok {
+				return canonical, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("unsupported reference file type; supported types: %s", strings.Join(SupportedReferenceMIMEs, ", "))
+}
+
+func canonicalizeReferenceMIME(mimeType string) (string, bool) {
+	mimeType = strings.TrimSpace(strings.ToLower(mimeType))
+	if mimeType == "" {
+		return "", false
+	}
+	if idx := strings.Index(mimeType, ";"); idx != -1 {
+		mimeType = mimeType[:idx]
+	}
+	canonical, ok := referenceMIMECandidates[mimeType]
+	return canonical, ok
+}
@@ -0,0 +1,314 @@
+package sora_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora/soratest"
+)
+
+// flowLog collects what a flow saw, followed by the requests the server
+// received, for comparison with a golden file.
+type flowLog struct {
+	bytes.Buffer
+}
+
+func (l *flowLog) video(label string, v *sora.Video) {
+	fmt.Fprintf(&l.Buffer, "%s: %s %s model=%s seconds=%s size=%s progress=%g", label, v.ID, v.Status, v.Model, v.Seconds, v.Size, v.ProgressPercent())
+	if v.RemixedFromVideoID != "" {
+		fmt.Fprintf(&l.Buffer, " remixed_from=%s", v.RemixedFromVideoID)
+	}
+	if v.Error != nil {
+		fmt.Fprintf(&l.Buffer, " error=%q", v.Error.Message)
+	}
+	l.WriteByte('\n')
+}
+
+func (l *flowLog) golden(t *testing.T, name string, srv *soratest.Server) {
+	t.Helper()
+	l.WriteString("-- requests\n")
+	l.Write(srv.Transcript())
+	soratest.Golden(t, name, l.Bytes())
+}
+
+func TestCreateFlow(t *testing.T) {
+	srv := soratest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+	ctx := context.Background()
+	var log flowLog
+
+	video, err := client.Create(ctx, sora.CreateParams{Prompt: "A paper boat drifting down a rain gutter", Model: "sora-2", Seconds: "8", Size: "1280x720"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.video("created", video)
+	video, err = client.Wait(ctx, video.ID, func(v *sora.Video) { log.video("progress", v) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.video("finished", video)
+
+	path := filepath.Join(t.TempDir(), video.ID+".mp4")
+	if err := client.DownloadFile(ctx, video.ID, path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := soratest.Content(video.ID, sora.VariantVideo); !bytes.Equal(data, want) {
+		t.Errorf("downloaded %q, want %q", data, want)
+	}
+	log.golden(t, "create", srv)
+}
+
+func TestCreateWithReferenceFlow(t *testing.T) {
+	srv := soratest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+	ctx := context.Background()
+	var log flowLog
+
+	ref := filepath.Join(t.TempDir(), "frame.png")
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x02\x00\x00\x00")
+	if err := os.WriteFile(ref, png, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	video, err := client.Create(ctx, sora.CreateParams{Prompt: "The frame comes to life", InputReference: ref})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.video("created", video)
+	log.golden(t, "create_reference", srv)
+}
+
+func TestRemixFlow(t *testing.T) {
+	srv := soratest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+	ctx := context.Background()
+	var log flowLog
+
+	source, err := client.Create(ctx, sora.CreateParams{Prompt: "A lighthouse at dusk", Seconds: "4", Size: "720x1280"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Remix(ctx, source.ID, "Make it snow"); err == nil {
+		t.Fatal("remixing a queued video succeeded")
+	} else {
+		var apiErr *sora.APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != 400 {
+			t.Fatalf("remixing a queued video: %v, want a 400 API error", err)
+		}
+		fmt.Fprintf(&log, "remix before completion: %v\n", err)
+	}
+	if source, err = client.Wait(ctx, source.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	log.video("source", source)
+
+	remix, err := client.Remix(ctx, source.ID, "Make it snow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.video("remix", remix)
+	if remix, err = client.Wait(ctx, remix.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	log.video("finished", remix)
+	log.golden(t, "remix", srv)
+}
+
+func TestFailedJobFlow(t *testing.T) {
+	srv := soratest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+	ctx := context.Background()
+	var log flowLog
+
+	srv.FailNext("The prompt was flagged by the moderation system.")
+	video, err := client.Create(ctx, sora.CreateParams{Prompt: "Something the moderator dislikes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err = client.Wait(ctx, video.ID, nil)
+	if err == nil {
+		t.Fatal("Wait succeeded for a failed job")
+	}
+	log.video("finished", video)
+	fmt.Fprintf(&log, "wait: %v\n", err)
+	if err := client.DownloadFile(ctx, video.ID, filepath.Join(t.TempDir(), "out.mp4")); err == nil {
+		t.Error("downloading a failed job succeeded")
+	} else {
+		fmt.Fprintf(&log, "download: %v\n", err)
+	}
+	log.golden(t, "failed", srv)
+}
+
+func TestListFlow(t *testing.T) {
+	srv := soratest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+	ctx := context.Background()
+	var log flowLog
+
+	for _, prompt := range []string{"First", "Second", "Third", "Fourth", "Fifth"} {
+		if _, err := client.Create(ctx, sora.CreateParams{Prompt: prompt}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, order := range []string{"", "asc"} {
+		params := sora.ListParams{Limit: 2, Order: order}
+		for page := 1; ; page++ {
+			list, err := client.List(ctx, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintf(&log, "order=%q page %d has_more=%t\n", order, page, list.HasMore)
+			for i := range list.Data {
+				log.video("  listed", &list.Data[i])
+			}
+			params.After = list.Cursor()
+			if !list.HasMore || params.After == "" {
+				break
+			}
+		}
+	}
+	if err := client.Delete(ctx, "video_0003"); err != nil {
+		t.Fatal(err)
+	}
+	list, err := client.List(ctx, sora.ListParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(&log, "after delete: %d video(s)\n", len(list.Data))
+	log.golden(t, "list", srv)
+}
+
+func TestDownloadVariantsFlow(t *testing.T) {
+	srv := soratest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+	ctx := context.Background()
+	var log flowLog
+
+	video, err := client.Create(ctx, sora.CreateParams{Prompt: "A hummingbird in slow motion"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DownloadFile(ctx, video.ID, filepath.Join(t.TempDir(), "early.mp4")); err == nil {
+		t.Error("downloading a queued video succeeded")
+	} else {
+		fmt.Fprintf(&log, "download before completion: %v\n", err)
+	}
+	if video, err = client.Wait(ctx, video.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, variant := range sora.Variants {
+		path := filepath.Join(dir, video.ID+variant.Extension())
+		if err := client.DownloadVariantFile(ctx, video.ID, variant, path); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&log, "%s -> %s: %q\n", variant, filepath.Base(path), data)
+	}
+	log.golden(t, "download", srv)
+}
+
+func TestUnauthorized(t *testing.T) {
+	srv := soratest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+	client.APIKey = "sk-wrong"
+
+	_, err := client.List(context.Background(), sora.ListParams{})
+	var apiErr *sora.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 401 || apiErr.RequestID != "req_soratest" {
+		t.Fatalf("List with a wrong key: %v, want a 401 with request ID req_soratest", err)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	create := func(c *sora.Client) error {
+		_, err := c.Create(context.Background(), sora.CreateParams{Prompt: "A paper boat", Model: "sora-2"})
+		return err
+	}
+	get := func(c *sora.Client) error {
+		_, err := c.Get(context.Background(), "video_1")
+		return err
+	}
+	for _, tc := range []struct {
+		name       string
+		call       func(*sora.Client) error
+		status     int
+		retryAfter string
+		want       int
+	}{
+		{"create 429", create, http.StatusTooManyRequests, "0", 2},
+		{"create 503 with Retry-After", create, http.StatusServiceUnavailable, "0", 2},
+		{"create 503", create, http.StatusServiceUnavailable, "", 1},
+		{"create 500", create, http.StatusInternalServerError, "", 1},
+		{"create 502", create, http.StatusBadGateway, "", 1},
+		{"get 500", get, http.StatusInternalServerError, "0", 2},
+	} {
+		sent := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(io.Discard, r.Body)
+			sent++
+			if sent == 1 {
+				if tc.retryAfter != "" {
+					w.Header().Set("Retry-After", tc.retryAfter)
+				}
+				w.WriteHeader(tc.status)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"id":"video_1","object":"video","status":"queued"}`)
+		}))
+		client := sora.NewClient("sk-test")
+		client.HTTPClient = srv.Client()
+		client.BaseURL = srv.URL
+		client.MaxAttempts = 2
+		err := tc.call(client)
+		srv.Close()
+		if sent != tc.want {
+			t.Errorf("%s: sent %d request(s), want %d (err %v)", tc.name, sent, tc.want, err)
+		}
+		if wantErr := tc.want == 1; (err != nil) != wantErr {
+			t.Errorf("%s: err = %v, want an error: %v", tc.name, err, wantErr)
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	page := []sora.Video{{ID: "video_1"}, {ID: "video_2"}}
+	for _, tc := range []struct {
+		name string
+		list sora.VideoList
+		want string
+	}{
+		{"next", sora.VideoList{Data: page, HasMore: true, Next: "cur_a"}, "cur_a"},
+		{"next_cursor", sora.VideoList{Data: page, HasMore: true, NextCursor: "cur_b"}, "cur_b"},
+		{"last ID", sora.VideoList{Data: page, HasMore: true}, "video_2"},
+		{"last page", sora.VideoList{Data: page}, ""},
+		{"empty", sora.VideoList{HasMore: true}, ""},
+	} {
+		if got := tc.list.Cursor(); got != tc.want {
+			t.Errorf("%s: Cursor() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
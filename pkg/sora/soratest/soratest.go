@@ -0,0 +1,372 @@
+// Package soratest runs an in-memory fake of the video endpoints, so whole
+// create, remix, list and download flows of package sora, and of programs
+// built on it, can be exercised without an API key. Jobs move one step each
+// time their status is fetched, IDs and timestamps are deterministic, and
+// every request is recorded for comparison with a golden file:
+//
+//	srv := soratest.NewServer()
+//	defer srv.Close()
+//	client := srv.NewClient()
+//	video, err := client.Create(ctx, sora.CreateParams{Prompt: "A paper boat", Model: "sora-2"})
+//	video, err = client.Wait(ctx, video.ID, nil)
+//	err = client.DownloadFile(ctx, video.ID, filepath.Join(t.TempDir(), "out.mp4"))
+//	soratest.Golden(t, "create", srv.Transcript())
+package soratest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dr_sabijan/sora2-cli-tool/pkg/sora"
+)
+
+// APIKey is the only key the server accepts.
+const APIKey = "sk-soratest"
+
+// Epoch is the created_at of the first job; each later job is a second
+// newer.
+const Epoch int64 = 1735689600
+
+// steps are the states a job passes through, one per status request.
+var steps = []struct {
+	status   string
+	progress float64
+}{{"queued", 0}, {"in_progress", 50}, {"completed", 100}}
+
+// Request is one request as the server saw it. Fields holds the form fields
+// of a create and the JSON body of a remix; reference uploads appear as
+// input_reference with their file name.
+type Request struct {
+	Method string
+	Path   string
+	Fields map[string]string
+}
+
+func (r Request) String() string {
+	line := r.Method + " " + r.Path
+	keys := make([]string, 0, len(r.Fields))
+	for key := range r.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		line += " " + key + "=" + strconv.Quote(r.Fields[key])
+	}
+	return line
+}
+
+type job struct {
+	video sora.Video
+	step  int
+	// failure, if set, is the error message the job fails with instead of
+	// completing.
+	failure string
+}
+
+// Server fakes the /v1/videos endpoints. Its zero value is not usable; start
+// one with NewServer.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	jobs     map[string]*job
+	order    []string
+	next     int
+	failNext string
+	requests []Request
+}
+
+// NewServer starts a server with no videos.
+func NewServer() *Server {
+	s := &Server{jobs: map[string]*job{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// NewClient returns a client for the server that polls every millisecond.
+func (s *Server) NewClient() *sora.Client {
+	client := sora.NewClient(APIKey)
+	client.HTTPClient = s.Client()
+	client.BaseURL = s.URL
+	client.PollInterval = time.Millisecond
+	client.MaxAttempts = 1
+	return client
+}
+
+// FailNext makes the next job created or remixed fail with message where it
+// would otherwise complete.
+func (s *Server) FailNext(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failNext = message
+}
+
+// Requests returns the requests received so far, oldest first.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Transcript returns the requests received so far, one per line, in the
+// form Golden compares.
+func (s *Server) Transcript() []byte {
+	var b bytes.Buffer
+	for _, r := range s.Requests() {
+		b.WriteString(r.String())
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// Content is the body served for a variant of a video: a short text that
+// names both, which is enough to tell downloads apart.
+func Content(videoID string, variant sora.Variant) []byte {
+	if variant == "" {
+		variant = sora.VariantVideo
+	}
+	return []byte(fmt.Sprintf("soratest %s %s\n", variant, videoID))
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := Request{Method: r.Method, Path: r.URL.RequestURI()}
+	defer func() { s.requests = append(s.requests, record) }()
+	if r.Header.Get("Authorization") != "Bearer "+APIKey {
+		writeError(w, http.StatusUnauthorized, "Incorrect API key provided.")
+		return
+	}
+
+	rest, ok := strings.CutPrefix(r.URL.Path, "/v1/videos")
+	if !ok {
+		writeError(w, http.StatusNotFound, "Unknown path "+r.URL.Path)
+		return
+	}
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+	switch {
+	case rest == "" && r.Method == http.MethodPost:
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		record.Fields = map[string]string{}
+		for key, values := range r.MultipartForm.Value {
+			record.Fields[key] = strings.Join(values, ",")
+		}
+		var names []string
+		for _, file := range r.MultipartForm.File["input_reference"] {
+			names = append(names, file.Filename)
+		}
+		if len(names) > 0 {
+			record.Fields["input_reference"] = strings.Join(names, ",")
+		}
+		if record.Fields["prompt"] == "" {
+			writeError(w, http.StatusBadRequest, "Missing required parameter: 'prompt'.")
+			return
+		}
+		writeJSON(w, s.create(record.Fields, ""))
+	case rest == "" && r.Method == http.MethodGet:
+		s.list(w, r)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		j := s.find(w, parts[0])
+		if j == nil {
+			return
+		}
+		s.advance(j)
+		writeJSON(w, j.video)
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		if s.find(w, parts[0]) == nil {
+			return
+		}
+		delete(s.jobs, parts[0])
+		writeJSON(w, map[string]any{"id": parts[0], "object": "video.deleted", "deleted": true})
+	case len(parts) == 2 && parts[1] == "remix" && r.Method == http.MethodPost:
+		record.Fields = map[string]string{}
+		if err := json.NewDecoder(r.Body).Decode(&record.Fields); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON body.")
+			return
+		}
+		source := s.find(w, parts[0])
+		if source == nil {
+			return
+		}
+		if source.video.Status != "completed" {
+			writeError(w, http.StatusBadRequest, "Video "+parts[0]+" is not completed.")
+			return
+		}
+		fields := map[string]string{"prompt": record.Fields["prompt"], "model": source.video.Model, "seconds": source.video.Seconds, "size": source.video.Size}
+		writeJSON(w, s.create(fields, parts[0]))
+	case len(parts) == 2 && parts[1] == "content" && r.Method == http.MethodGet:
+		j := s.find(w, parts[0])
+		if j == nil {
+			return
+		}
+		if j.video.Status != "completed" {
+			writeError(w, http.StatusBadRequest, "Video "+parts[0]+" is not completed.")
+			return
+		}
+		variant, err := sora.ParseVariant(r.URL.Query().Get("variant"))
+		if r.URL.Query().Get("variant") == "" {
+			variant, err = sora.VariantVideo, nil
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", map[sora.Variant]string{sora.VariantVideo: "video/mp4", sora.VariantThumbnail: "image/webp", sora.VariantSpritesheet: "image/jpeg"}[variant])
+		w.Write(Content(j.video.ID, variant))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Unknown route %s %s", r.Method, r.URL.Path))
+	}
+}
+
+// create adds a queued job; the caller holds s.mu.
+func (s *Server) create(fields map[string]string, remixedFrom string) sora.Video {
+	s.next++
+	video := sora.Video{
+		ID:                 fmt.Sprintf("video_%04d", s.next),
+		Object:             "video",
+		Model:              fields["model"],
+		Status:             steps[0].status,
+		CreatedAt:          Epoch + int64(s.next-1),
+		Size:               fields["size"],
+		Seconds:            fields["seconds"],
+		Prompt:             fields["prompt"],
+		RemixedFromVideoID: remixedFrom,
+	}
+	if video.Model == "" {
+		video.Model = "sora-2"
+	}
+	if video.Seconds == "" {
+		video.Seconds = "4"
+	}
+	if video.Size == "" {
+		video.Size = "720x1280"
+	}
+	s.jobs[video.ID] = &job{video: video, failure: s.failNext}
+	s.failNext = ""
+	s.order = append(s.order, video.ID)
+	return video
+}
+
+// advance moves a job one step on; the caller holds s.mu.
+func (s *Server) advance(j *job) {
+	if j.step >= len(steps)-1 || j.video.Error != nil {
+		return
+	}
+	j.step++
+	if j.step == len(steps)-1 && j.failure != "" {
+		j.video.Status = "failed"
+		j.video.Error = &sora.VideoError{Message: j.failure, Type: "video_generation_failed"}
+		return
+	}
+	j.video.Status = steps[j.step].status
+	j.video.Progress = steps[j.step].progress
+	if j.video.Status == "completed" {
+		j.video.CompletedAt = j.video.CreatedAt + 60
+		j.video.ExpiresAt = j.video.CreatedAt + 3600
+	}
+}
+
+// list pages through the videos newest first, or oldest first with
+// order=asc; the caller holds s.mu.
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := 20
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 100 {
+			writeError(w, http.StatusBadRequest, "Invalid limit "+value)
+			return
+		}
+		limit = n
+	}
+	var ids []string
+	for _, id := range s.order {
+		if _, ok := s.jobs[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	if query.Get("order") != "asc" {
+		for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+			ids[i], ids[j] = ids[j], ids[i]
+		}
+	}
+	if after := query.Get("after"); after != "" {
+		for i, id := range ids {
+			if id == after {
+				ids = ids[i+1:]
+				break
+			}
+		}
+	}
+	list := sora.VideoList{Object: "list", Data: []sora.Video{}}
+	for i, id := range ids {
+		if i == limit {
+			list.HasMore = true
+			list.Next = list.Data[len(list.Data)-1].ID
+			break
+		}
+		list.Data = append(list.Data, s.jobs[id].video)
+	}
+	writeJSON(w, list)
+}
+
+// find returns the job with id, or writes a 404 and returns nil; the caller
+// holds s.mu.
+func (s *Server) find(w http.ResponseWriter, id string) *job {
+	j, ok := s.jobs[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Video "+id+" not found.")
+	}
+	return j
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("x-request-id", "req_soratest")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{"message": message, "type": "invalid_request_error"}})
+}
+
+// Golden compares got with testdata/<name>.golden next to the calling test.
+// With UPDATE_GOLDEN=1 in the environment it writes got to the file
+// instead, which is how golden files are created and refreshed.
+func Golden(tb testing.TB, name string, got []byte) {
+	tb.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if os.Getenv("UPDATE_GOLDEN") == "1" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			tb.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			tb.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		tb.Fatalf("%v; run with UPDATE_GOLDEN=1 to create it", err)
+	}
+	if !bytes.Equal(got, want) {
+		tb.Errorf("%s differs from the golden file; run with UPDATE_GOLDEN=1 to accept\n--- want\n%s--- got\n%s", path, want, got)
+	}
+}
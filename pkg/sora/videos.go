@@ -0,0 +1,653 @@
+package sora
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Video is a generation job and, once completed, the video it produced.
+type Video struct {
+	ID                 string      `json:"id"`
+	Object             string      `json:"object"`
+	Model              string      `json:"model"`
+	Status             string      `json:"status"`
+	Progress           float64     `json:"progress"`
+	CreatedAt          int64       `json:"created_at"`
+	CompletedAt        int64       `json:"completed_at"`
+	ExpiresAt          int64       `json:"expires_at"`
+	Size               string      `json:"size"`
+	Seconds            string      `json:"seconds"`
+	Quality            string      `json:"quality"`
+	Prompt             string      `json:"prompt"`
+	RemixedFromVideoID string      `json:"remixed_from_video_id"`
+	Error              *VideoError `json:"error"`
+
+	// scale is the client's ProgressScale when the video was fetched.
+	scale ProgressScale
+}
+
+// ProgressScale says how the API reports Video.Progress.
+type ProgressScale string
+
+const (
+	// ProgressScaleAuto treats values up to 1 as a fraction and larger ones
+	// as a percentage. It misreads 1% as done, so set the scale explicitly
+	// when the endpoint is known.
+	ProgressScaleAuto     ProgressScale = "auto"
+	ProgressScaleFraction ProgressScale = "fraction"
+	ProgressScalePercent  ProgressScale = "percent"
+)
+
+// VideoError explains why a job failed.
+type VideoError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+}
+
+// VideoList is one page of List results.
+type VideoList struct {
+	Object     string  `json:"object"`
+	Data       []Video `json:"data"`
+	HasMore    bool    `json:"has_more"`
+	Next       string  `json:"next"`
+	NextCursor string  `json:"next_cursor"`
+}
+
+// Cursor returns the value to pass as ListParams.After for the next page:
+// the cursor the API returned or, when it returned none but has more, the ID
+// of the last video on this page, which is what After takes. It is "" after
+// the last page.
+func (l *VideoList) Cursor() string {
+	if l.Next != "" {
+		return l.Next
+	}
+	if l.NextCursor != "" {
+		return l.NextCursor
+	}
+	if l.HasMore && len(l.Data) > 0 {
+		return l.Data[len(l.Data)-1].ID
+	}
+	return ""
+}
+
+// ProgressPercent returns the job's progress on a 0-100 scale, converting
+// Progress according to the client's ProgressScale. Progress itself keeps the
+// raw value from the API.
+func (v *Video) ProgressPercent() float64 {
+	switch v.scale {
+	case ProgressScaleFraction:
+		return v.Progress * 100
+	case ProgressScalePercent:
+		return v.Progress
+	}
+	if v.Progress <= 1 && v.Progress >= 0 {
+		return v.Progress * 100
+	}
+	return v.Progress
+}
+
+func (c *Client) adopt(videos ...*Video) {
+	for _, v := range videos {
+		v.scale = c.ProgressScale
+	}
+}
+
+// Done reports whether the job has reached a final status.
+func (v *Video) Done() bool {
+	switch strings.ToLower(v.Status) {
+	case "completed", "failed", "canceled", "cancelled", "rejected", "expired":
+		return true
+	}
+	return false
+}
+
+// CreateParams describes a new generation. Empty fields are left to the API
+// defaults.
+type CreateParams struct {
+	Prompt  string
+	Model   string
+	Seconds string
+	Size    string
+	// InputReference is the path of an image or video that steers the
+	// generation.
+	InputReference string
+	// InputReferences are further reference files, sent after
+	// InputReference, for generations anchored on several visuals.
+	InputReferences []string
+	// OnUpload, if set, is called as the request body is sent with the
+	// bytes sent so far and the body's total size. A retried request
+	// starts again from zero. It runs on the goroutine writing the body.
+	OnUpload func(sent, total int64)
+}
+
+// References returns every reference file of p in the order they are sent.
+func (p CreateParams) References() []string {
+	var refs []string
+	if p.InputReference != "" {
+		refs = append(refs, p.InputReference)
+	}
+	for _, ref := range p.InputReferences {
+		if ref != "" {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// Create submits a new generation job. The multipart body is streamed from
+// the reference files rather than built in memory, so large video references
+// cost no more memory than small images.
+func (c *Client) Create(ctx context.Context, params CreateParams) (*Video, error) {
+	body, err := prepareCreate(params)
+	if err != nil {
+		return nil, err
+	}
+
+	newBody := func() io.ReadCloser {
+		pr, pw := io.Pipe()
+		go func() {
+			w := io.Writer(pw)
+			if params.OnUpload != nil {
+				w = &uploadProgress{w: pw, total: body.size, report: params.OnUpload}
+			}
+			pw.CloseWithError(writeCreateBody(w, body.boundary, body.fields, body.refs, copyReference))
+		}()
+		return pr
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, videosPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = newBody()
+	req.GetBody = func() (io.ReadCloser, error) { return newBody(), nil }
+	req.ContentLength = body.size
+	req.Header.Set("Content-Type", "multipart/form-data; boundary="+body.boundary)
+
+	var video Video
+	if err := c.do(req, &video); err != nil {
+		return nil, err
+	}
+	if video.ID == "" {
+		return nil, errors.New("response missing job ID")
+	}
+	c.adopt(&video)
+	return &video, nil
+}
+
+// createBody is the multipart form of a create request, measured but not
+// yet read from the reference files.
+type createBody struct {
+	fields   [][2]string
+	refs     []referencePart
+	boundary string
+	size     int64
+}
+
+func prepareCreate(params CreateParams) (createBody, error) {
+	body := createBody{fields: [][2]string{{"prompt", params.Prompt}}}
+	for _, field := range [][2]string{{"model", params.Model}, {"seconds", params.Seconds}, {"size", params.Size}} {
+		if field[1] != "" {
+			body.fields = append(body.fields, field)
+		}
+	}
+	// Every reference is checked before anything is sent.
+	for _, path := range params.References() {
+		ref, err := inspectReference(path)
+		if err != nil {
+			return createBody{}, err
+		}
+		body.refs = append(body.refs, ref)
+	}
+
+	body.boundary = multipart.NewWriter(io.Discard).Boundary()
+	// Measure the body with the file contents left out, then add their
+	// sizes, so the request has a Content-Length without reading the files.
+	var total byteCounter
+	err := writeCreateBody(&total, body.boundary, body.fields, body.refs, func(_ io.Writer, ref referencePart) error {
+		total += byteCounter(ref.Size)
+		return nil
+	})
+	if err != nil {
+		return createBody{}, err
+	}
+	body.size = int64(total)
+	return body, nil
+}
+
+// RequestPlan describes a request as Create or Remix would send it, for
+// dry runs that check the inputs without calling the API.
+type RequestPlan struct {
+	Method      string
+	URL         string
+	ContentType string
+	// ContentLength is the size of the body in bytes, reference files
+	// included.
+	ContentLength int64
+	// Fields are the form or JSON fields in the order they are sent.
+	Fields []RequestField
+	// References are the files uploaded as input_reference parts.
+	References []ReferenceFile
+}
+
+// RequestField is one named value of a request body.
+type RequestField struct {
+	Name  string
+	Value string
+}
+
+// ReferenceFile is a reference as it would be uploaded.
+type ReferenceFile struct {
+	Path     string
+	MIMEType string
+	Size     int64
+}
+
+// PlanCreate checks params, including the type of every reference file, and
+// describes the request Create would send. Nothing is sent.
+func (c *Client) PlanCreate(params CreateParams) (*RequestPlan, error) {
+	body, err := prepareCreate(params)
+	if err != nil {
+		return nil, err
+	}
+	plan := &RequestPlan{
+		Method:        http.MethodPost,
+		URL:           strings.TrimRight(c.BaseURL, "/") + videosPath,
+		ContentType:   "multipart/form-data",
+		ContentLength: body.size,
+	}
+	for _, field := range body.fields {
+		plan.Fields = append(plan.Fields, RequestField{Name: field[0], Value: field[1]})
+	}
+	for _, ref := range body.refs {
+		plan.References = append(plan.References, ReferenceFile(ref))
+	}
+	return plan, nil
+}
+
+// PlanRemix describes the request Remix would send. Nothing is sent.
+func (c *Client) PlanRemix(videoID, prompt string) (*RequestPlan, error) {
+	payload, err := json.Marshal(map[string]string{"prompt": prompt})
+	if err != nil {
+		return nil, err
+	}
+	return &RequestPlan{
+		Method:        http.MethodPost,
+		URL:           strings.TrimRight(c.BaseURL, "/") + videosPath + "/" + url.PathEscape(videoID) + "/remix",
+		ContentType:   "application/json",
+		ContentLength: int64(len(payload)),
+		Fields:        []RequestField{{Name: "prompt", Value: prompt}},
+	}, nil
+}
+
+// referencePart is a reference file as it will be uploaded.
+type referencePart struct {
+	Path     string
+	MIMEType string
+	Size     int64
+}
+
+// inspectReference detects the type and size of the reference at path.
+func inspectReference(path string) (referencePart, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return referencePart{}, fmt.Errorf("open reference: %w", err)
+	}
+	defer file.Close()
+
+	mimeType, err := DetectReferenceMIME(file)
+	if err != nil {
+		return referencePart{}, fmt.Errorf("reference file %s: %w", filepath.Base(path), err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return referencePart{}, fmt.Errorf("reference file %s: %w", filepath.Base(path), err)
+	}
+	return referencePart{Path: path, MIMEType: mimeType, Size: info.Size()}, nil
+}
+
+// writeCreateBody writes the multipart form of a create request to w, with
+// one input_reference part per reference whose content copyFile supplies.
+func writeCreateBody(w io.Writer, boundary string, fields [][2]string, refs []referencePart, copyFile func(io.Writer, referencePart) error) error {
+	writer := multipart.NewWriter(w)
+	if err := writer.SetBoundary(boundary); err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if err := writer.WriteField(field[0], field[1]); err != nil {
+			return err
+		}
+	}
+	for _, ref := range refs {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf("form-data; name=%q; filename=%q", "input_reference", filepath.Base(ref.Path)))
+		header.Set("Content-Type", ref.MIMEType)
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if err := copyFile(part, ref); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// copyReference copies exactly the size measured for ref, so a file that
+// changes during the upload fails the request instead of corrupting it.
+func copyReference(w io.Writer, ref referencePart) error {
+	file, err := os.Open(ref.Path)
+	if err != nil {
+		return fmt.Errorf("open reference: %w", err)
+	}
+	defer file.Close()
+	if _, err := io.CopyN(w, file, ref.Size); err != nil {
+		return fmt.Errorf("copy reference %s: %w", filepath.Base(ref.Path), err)
+	}
+	return nil
+}
+
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// uploadProgress reports the bytes written through it.
+type uploadProgress struct {
+	w      io.Writer
+	sent   int64
+	total  int64
+	report func(sent, total int64)
+}
+
+func (u *uploadProgress) Write(p []byte) (int, error) {
+	n, err := u.w.Write(p)
+	u.sent += int64(n)
+	u.report(u.sent, u.total)
+	return n, err
+}
+
+// Remix starts a new job that changes a completed video according to prompt.
+func (c *Client) Remix(ctx context.Context, videoID, prompt string) (*Video, error) {
+	payload, err := json.Marshal(map[string]string{"prompt": prompt})
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, videosPath+"/"+url.PathEscape(videoID)+"/remix", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var video Video
+	if err := c.do(req, &video); err != nil {
+		return nil, err
+	}
+	if video.ID == "" {
+		return nil, errors.New("response missing job ID")
+	}
+	c.adopt(&video)
+	return &video, nil
+}
+
+// ListParams selects a page of videos. Zero values use the API defaults.
+type ListParams struct {
+	Limit int
+	After string
+	// Order is "asc" or "desc" by creation time.
+	Order string
+}
+
+// List returns one page of the account's videos.
+func (c *Client) List(ctx context.Context, params ListParams) (*VideoList, error) {
+	query := url.Values{}
+	if params.Limit > 0 {
+		query.Set("limit", strconv.Itoa(params.Limit))
+	}
+	if params.After != "" {
+		query.Set("after", params.After)
+	}
+	if params.Order != "" {
+		query.Set("order", params.Order)
+	}
+	path := videosPath
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	var list VideoList
+	if err := c.do(req, &list); err != nil {
+		return nil, err
+	}
+	for i := range list.Data {
+		c.adopt(&list.Data[i])
+	}
+	return &list, nil
+}
+
+// Get returns the current state of a job.
+func (c *Client) Get(ctx context.Context, videoID string) (*Video, error) {
+	video, _, err := c.getIfChanged(ctx, videoID, "")
+	return video, err
+}
+
+// getIfChanged is Get with If-None-Match set to etag, when there is one. It
+// returns a nil video if the API answers 304 Not Modified, and the ETag of
+// the response, if any, for the next call.
+func (c *Client) getIfChanged(ctx context.Context, videoID, etag string) (*Video, string, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, videosPath+"/"+url.PathEscape(videoID), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, etag, nil
+	}
+	var video Video
+	if err := json.NewDecoder(resp.Body).Decode(&video); err != nil {
+		return nil, "", err
+	}
+	c.adopt(&video)
+	return &video, resp.Header.Get("ETag"), nil
+}
+
+// Delete removes a video. Deleting a job that has not finished cancels it.
+func (c *Client) Delete(ctx context.Context, videoID string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, videosPath+"/"+url.PathEscape(videoID), nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, nil)
+}
+
+// Wait polls a job until it completes, calling report whenever its status or
+// progress changes. A job that ends in any other final status is returned
+// together with an error describing why. The API has no event stream for
+// jobs, so Wait polls conditionally: when a response carries an ETag, the
+// next poll sends it as If-None-Match and a 304 counts as unchanged.
+func (c *Client) Wait(ctx context.Context, videoID string, report func(*Video)) (*Video, error) {
+	base := c.PollInterval
+	if base <= 0 {
+		base = DefaultPollInterval
+	}
+	interval := base
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	var last *Video
+	var etag string
+	var lastStatus string
+	var lastProgress float64 = -1
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			video, nextETag, err := c.getIfChanged(ctx, videoID, etag)
+			if err != nil {
+				return nil, err
+			}
+			etag = nextETag
+			if video == nil {
+				video = last
+			}
+			last = video
+			progress := video.ProgressPercent()
+			changed := video.Status != lastStatus || progress != lastProgress
+			if report != nil && changed {
+				report(video)
+			}
+			lastStatus = video.Status
+			lastProgress = progress
+			if changed || c.MaxPollInterval <= base {
+				interval = base
+			} else {
+				interval = min(interval*2, c.MaxPollInterval)
+			}
+			timer.Reset(interval)
+
+			if !video.Done() {
+				continue
+			}
+			if strings.EqualFold(video.Status, "completed") {
+				return video, nil
+			}
+			if video.Error != nil {
+				return video, fmt.Errorf("job %s: %s", video.Status, video.Error.Message)
+			}
+			return video, fmt.Errorf("job %s", video.Status)
+		}
+	}
+}
+
+// Variant selects which asset of a completed video to download.
+type Variant string
+
+const (
+	VariantVideo       Variant = "video"
+	VariantThumbnail   Variant = "thumbnail"
+	VariantSpritesheet Variant = "spritesheet"
+)
+
+// Variants lists the downloadable variants in display order.
+var Variants = []Variant{VariantVideo, VariantThumbnail, VariantSpritesheet}
+
+// ParseVariant returns the variant called name.
+func ParseVariant(name string) (Variant, error) {
+	for _, variant := range Variants {
+		if strings.EqualFold(name, string(variant)) {
+			return variant, nil
+		}
+	}
+	return "", fmt.Errorf("unknown variant %q; supported: video, thumbnail, spritesheet", name)
+}
+
+// Extension returns the file extension of the variant's content.
+func (v Variant) Extension() string {
+	switch v {
+	case VariantThumbnail:
+		return ".webp"
+	case VariantSpritesheet:
+		return ".jpg"
+	default:
+		return ".mp4"
+	}
+}
+
+func (v Variant) contentType() string {
+	switch v {
+	case VariantThumbnail:
+		return "image/webp"
+	case VariantSpritesheet:
+		return "image/jpeg"
+	default:
+		return "video/mp4"
+	}
+}
+
+// Download streams the MP4 of a completed video to w.
+func (c *Client) Download(ctx context.Context, videoID string, w io.Writer) error {
+	return c.DownloadVariant(ctx, videoID, VariantVideo, w)
+}
+
+// DownloadVariant streams one variant of a completed video to w.
+func (c *Client) DownloadVariant(ctx context.Context, videoID string, variant Variant, w io.Writer) error {
+	path := videosPath + "/" + url.PathEscape(videoID) + "/content"
+	if variant != "" && variant != VariantVideo {
+		path += "?" + url.Values{"variant": {string(variant)}}.Encode()
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", variant.contentType())
+
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// DownloadFile saves the MP4 of a completed video to path. The data is
+// written to a temporary file first so path never holds a partial video.
+func (c *Client) DownloadFile(ctx context.Context, videoID, path string) error {
+	return c.DownloadVariantFile(ctx, videoID, VariantVideo, path)
+}
+
+// DownloadVariantFile saves one variant of a completed video to path, going
+// through a temporary file like DownloadFile.
+func (c *Client) DownloadVariantFile(ctx context.Context, videoID string, variant Variant, path string) error {
+	tmpPath := path + ".tmp"
+	outFile, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	if err = c.DownloadVariant(ctx, videoID, variant, outFile); err != nil {
+		outFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err = outFile.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err = os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}